@@ -5,15 +5,35 @@ import (
 	"os"
 
 	"furr/internal/db"
+	"furr/internal/engine"
+	_ "furr/internal/handlers" // REGSCRIPT, RUNSCRIPT and EVAL
 	"furr/internal/repl"
 	"furr/internal/server"
 )
 
 func main() {
-	if _, err := os.Stat("dump.rdb"); err == nil {
+	replMode, appendOnly := false, false
+	for _, arg := range os.Args[1:] {
+		switch arg {
+		case "--repl":
+			replMode = true
+		case "--appendonly":
+			appendOnly = true
+		}
+	}
+	if appendOnly {
+		// The log holds every write since it was started, so it is
+		// replayed instead of the snapshot rather than on top of it.
+		if err := engine.Load(); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "AOF load error: %v\n", err)
+			os.Exit(1)
+		}
+		db.AppendOnly = engine.Log
+	} else if _, err := os.Stat("dump.rdb"); err == nil {
 		db.LoadSnapshot("dump.rdb")
 	}
-	if len(os.Args) > 1 && os.Args[1] == "--repl" {
+	db.StartScheduler()
+	if replMode {
 		repl.Start()
 		return
 	}
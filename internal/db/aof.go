@@ -0,0 +1,160 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// AppendOnly, if set, receives every command that changed the keyspace, in
+// the order the changes were made and in a form that replays to the same
+// state through Commands. It is called with the store lock held and must not
+// call back into the store. Set it before serving commands.
+var AppendOnly func(args []string)
+
+// Replaying is set while the append-only log is loaded. Commands then accept
+// the arguments only the log writes, such as the NOW time of queue commands,
+// which clients cannot send.
+var Replaying atomic.Bool
+
+// ErrUnknownCommand is returned by Exec for names not in Commands.
+var ErrUnknownCommand = errors.New("unknown command")
+
+// aofMu is held from the start of a top-level command until its log records
+// are written and the triggers it set off have run, so that the log follows
+// the order in which writes happened even though handlers take the store
+// lock themselves.
+var aofMu sync.Mutex
+
+// commandLog collects what the running command appends to the log and the
+// triggers it sets off.
+type commandLog struct {
+	active   bool       // a top-level command is running
+	cmd      []string   // the command as received, nil to log only effects
+	dirty    int64      // Store.dirty when the command started
+	replaced bool       // forms stand in for cmd
+	forms    [][]string // time-independent equivalent of cmd
+	expired  [][]string // DELs for keys that expired while it ran
+	effects  [][]string // changes made on behalf of other clients
+	trigger  string     // the trigger being run, which does not set itself off
+	triggers []triggerRun
+}
+
+// Exec runs the named command and, if it changed the keyspace, hands it to
+// AppendOnly. It then runs the triggers the command set off. Connection
+// handlers run non-blocking commands through it.
+func Exec(name string, args []string) (string, error) {
+	h, ok := Commands[name]
+	if !ok {
+		return "", ErrUnknownCommand
+	}
+	s := DefaultStore
+	aofMu.Lock()
+	defer aofMu.Unlock()
+	s.mu.Lock()
+	s.beginLog(append([]string{name}, args...))
+	s.mu.Unlock()
+	reply, err := h(args)
+	s.mu.Lock()
+	runs := s.endLog()
+	s.mu.Unlock()
+	s.runTriggers(runs)
+	return reply, err
+}
+
+// lockLogged takes the write lock for a command that does not run through
+// Exec, such as a blocking command serving its own client, and logs the
+// effects it records with propagate. Nested commands, which are logged by
+// whatever runs them, pass top=false. The returned function releases the
+// lock and runs any triggers that were set off.
+func (s *Store) lockLogged(top bool) func() {
+	if !top {
+		s.mu.Lock()
+		return s.mu.Unlock
+	}
+	aofMu.Lock()
+	s.mu.Lock()
+	s.beginLog(nil)
+	return func() {
+		runs := s.endLog()
+		s.mu.Unlock()
+		s.runTriggers(runs)
+		aofMu.Unlock()
+	}
+}
+
+// beginLog starts collecting log records for cmd. The caller must hold
+// aofMu and the write lock.
+func (s *Store) beginLog(cmd []string) {
+	s.log = commandLog{active: true, cmd: cmd, dirty: s.dirty}
+}
+
+// endLog writes out what the running command logged and returns the
+// triggers it set off. The caller must hold aofMu and the write lock.
+func (s *Store) endLog() []triggerRun {
+	l := s.log
+	s.log = commandLog{}
+	// Keys expire before the command looks at them, so their DELs come
+	// first.
+	records := l.expired
+	switch {
+	case l.replaced:
+		records = append(records, l.forms...)
+	case l.cmd != nil && s.dirty != l.dirty:
+		records = append(records, l.cmd)
+	}
+	records = append(records, l.effects...)
+	// The TTL sweep has nothing to log, so it does not read AppendOnly
+	// while it is being set.
+	if len(records) > 0 && AppendOnly != nil {
+		for _, r := range records {
+			AppendOnly(r)
+		}
+	}
+	return l.triggers
+}
+
+// Changed counts a change the running command made outside the keyspace,
+// such as registering a script, so that Exec logs the command.
+func Changed() {
+	DefaultStore.mu.Lock()
+	DefaultStore.dirty++
+	DefaultStore.mu.Unlock()
+}
+
+// logAs records forms to be logged instead of the running command, for
+// commands whose effect depends on when they run, such as XADD with an
+// automatic ID. It does nothing unless name is the command being logged, so
+// commands run from scripts are logged as the script. The caller must hold
+// the write lock.
+func (s *Store) logAs(name string, forms ...[]string) {
+	if AppendOnly == nil || len(s.log.cmd) == 0 || !strings.EqualFold(s.log.cmd[0], name) {
+		return
+	}
+	s.log.replaced = true
+	s.log.forms = append(s.log.forms, forms...)
+}
+
+// expireKey removes key once its TTL has passed and logs a DEL for it. The
+// expiry does not count as a change made by the running command, so a read
+// that expires a key is not logged itself. The caller must hold the write
+// lock.
+func (s *Store) expireKey(key string) {
+	dirty := s.dirty
+	s.deleteKey(key)
+	s.notify(notifyExpired, "expired", key)
+	if s.log.active {
+		s.log.dirty += s.dirty - dirty
+		s.log.expired = append(s.log.expired, []string{"DEL", key})
+	}
+}
+
+// propagate logs a change the running command made for someone else, such
+// as serving a client blocked on a list it pushed to. The caller must hold
+// the write lock.
+func (s *Store) propagate(args ...string) {
+	if AppendOnly != nil {
+		s.log.effects = append(s.log.effects, args)
+	}
+}
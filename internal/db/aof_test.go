@@ -0,0 +1,195 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// captureLog sets AppendOnly to record into the returned slice for the rest
+// of the test.
+func captureLog(t *testing.T) *[][]string {
+	var logged [][]string
+	AppendOnly = func(args []string) {
+		logged = append(logged, append([]string(nil), args...))
+	}
+	t.Cleanup(func() { AppendOnly = nil })
+	return &logged
+}
+
+// replay runs logged commands against a fresh store, as AOF loading does.
+func replay(t *testing.T, logged [][]string) {
+	t.Helper()
+	AppendOnly = nil
+	DefaultStore = NewStore()
+	Replaying.Store(true)
+	defer Replaying.Store(false)
+	for _, cmd := range logged {
+		if _, err := Commands[cmd[0]](cmd[1:]); err != nil {
+			t.Fatalf("replaying %v: %v", cmd, err)
+		}
+	}
+}
+
+func TestExecLogsWrites(t *testing.T) {
+	DefaultStore = NewStore()
+	logged := captureLog(t)
+	for _, cmd := range [][]string{
+		{"SET", "a", "1"}, {"GET", "a"}, {"SET", "b", "2", "NX"}, {"SET", "b", "3", "NX"},
+		{"LPUSH", "l", "x"}, {"LRANGE", "l", "0", "-1"}, {"DEL", "missing"},
+	} {
+		_, _ = Exec(cmd[0], cmd[1:])
+	}
+	want := [][]string{{"SET", "a", "1"}, {"SET", "b", "2", "NX"}, {"LPUSH", "l", "x"}}
+	if !reflect.DeepEqual(*logged, want) {
+		t.Errorf("expected %v, got %v", want, *logged)
+	}
+	if _, err := Exec("NOPE", nil); err != ErrUnknownCommand {
+		t.Errorf("expected ErrUnknownCommand, got %v", err)
+	}
+}
+
+func TestExecLogsServedBlockedPop(t *testing.T) {
+	DefaultStore = NewStore()
+	logged := captureLog(t)
+	got := make(chan string, 1)
+	go func() {
+		out, _ := blpopHandler(testContext(t), []string{"q", "0"})
+		got <- out
+	}()
+	waitForWaiters(t, "q", 1)
+	_, _ = Exec("RPUSH", []string{"q", "a", "b"})
+	<-got
+	want := [][]string{{"RPUSH", "q", "a", "b"}, {"LPOP", "q"}}
+	if !reflect.DeepEqual(*logged, want) {
+		t.Errorf("expected %v, got %v", want, *logged)
+	}
+	replay(t, *logged)
+	if out, _ := lrangeHandler([]string{"q", "0", "-1"}); out != "b" {
+		t.Errorf("expected replay to leave b, got %s", out)
+	}
+}
+
+func TestExecLogsStreamsDeterministically(t *testing.T) {
+	DefaultStore = NewStore()
+	logged := captureLog(t)
+	exec := func(args ...string) string {
+		t.Helper()
+		out, err := Exec(args[0], args[1:])
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		return out
+	}
+	exec("XGROUP", "CREATE", "s", "g", "$", "MKSTREAM")
+	first := exec("XADD", "s", "*", "n", "1")
+	exec("XADD", "s", "MAXLEN", "5", "*", "n", "2")
+	exec("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">")
+	time.Sleep(5 * time.Millisecond)
+	exec("XCLAIM", "s", "g", "bob", "1", first)
+	exec("XAUTOCLAIM", "s", "g", "carol", "1", "0")
+
+	ranged := exec("XRANGE", "s", "-", "+")
+	pending, _ := xpendingHandler([]string{"s", "g"})
+	if logged := *logged; logged[1][2] != first {
+		t.Errorf("expected XADD to be logged with its ID %s, got %v", first, logged[1])
+	}
+	replay(t, *logged)
+	if out, _ := xrangeHandler([]string{"s", "-", "+"}); out != ranged {
+		t.Errorf("expected replayed entries %s, got %s", ranged, out)
+	}
+	if out, _ := xpendingHandler([]string{"s", "g"}); out != pending {
+		t.Errorf("expected replayed PEL %s, got %s", pending, out)
+	}
+}
+
+func TestExecLogsServedXReadGroup(t *testing.T) {
+	DefaultStore = NewStore()
+	logged := captureLog(t)
+	_, _ = Exec("XGROUP", []string{"CREATE", "s", "g", "$", "MKSTREAM"})
+	got := make(chan string, 1)
+	go func() {
+		out, _ := xreadgroupHandler(context.Background(), []string{"GROUP", "g", "c", "BLOCK", "0", "STREAMS", "s", ">"})
+		got <- out
+	}()
+	waitForStreamWaiters(t, "s", 1)
+	_, _ = Exec("XADD", []string{"s", "1-0", "f", "v"})
+	<-got
+	replay(t, *logged)
+	if out, _ := xpendingHandler([]string{"s", "g"}); out != "1,1-0,1-0,c,1" {
+		t.Errorf("expected the served read to replay, got %s", out)
+	}
+}
+
+func TestExecLogsAbsoluteExpiries(t *testing.T) {
+	DefaultStore = NewStore()
+	logged := captureLog(t)
+	before := time.Now().UnixMilli()
+	for _, cmd := range [][]string{
+		{"SET", "a", "1", "EX", "100"}, {"SET", "b", "2"}, {"EXPIRE", "b", "100"},
+		{"GETEX", "a", "PX", "5000"}, {"GETEX", "a"},
+	} {
+		if _, err := Exec(cmd[0], cmd[1:]); err != nil {
+			t.Fatalf("%v: %v", cmd, err)
+		}
+	}
+	after := time.Now().UnixMilli()
+	want := [][]string{{"SET", "a", "1", "PXAT"}, {"SET", "b", "2"}, {"PEXPIREAT", "b"}, {"GETEX", "a", "PXAT"}}
+	offsets := []int64{100000, 0, 100000, 5000}
+	if len(*logged) != len(want) {
+		t.Fatalf("expected %d records, got %v", len(want), *logged)
+	}
+	for i, r := range *logged {
+		if !reflect.DeepEqual(r[:len(want[i])], want[i]) {
+			t.Errorf("expected record %d to start %v, got %v", i, want[i], r)
+			continue
+		}
+		if offsets[i] == 0 {
+			continue
+		}
+		at, err := strconv.ParseInt(r[len(r)-1], 10, 64)
+		if err != nil || at < before+offsets[i] || at > after+offsets[i] {
+			t.Errorf("expected record %d to expire %dms from now, got %v", i, offsets[i], r)
+		}
+	}
+	at := DefaultStore.ttl["b"]
+	replay(t, *logged)
+	if got := DefaultStore.ttl["b"]; got != at {
+		t.Errorf("expected replayed expiry %d, got %d", at, got)
+	}
+}
+
+func TestExecLogsPoppedMembers(t *testing.T) {
+	DefaultStore = NewStore()
+	logged := captureLog(t)
+	_, _ = Exec("SADD", []string{"s", "a", "b", "c", "d"})
+	_, _ = Exec("SPOP", []string{"s", "2"})
+	_, _ = Exec("SPOP", []string{"missing"})
+	if n := len(*logged); n != 2 || (*logged)[1][0] != "SREM" || len((*logged)[1]) != 4 {
+		t.Fatalf("expected SPOP to be logged as SREM of 2 members, got %v", *logged)
+	}
+	members, _ := smembersHandler([]string{"s"})
+	replay(t, *logged)
+	if out, _ := smembersHandler([]string{"s"}); out != members {
+		t.Errorf("expected replayed members %s, got %s", members, out)
+	}
+}
+
+func TestExecLogsLazyExpiryAsDel(t *testing.T) {
+	DefaultStore = NewStore()
+	logged := captureLog(t)
+	_, _ = Exec("SET", []string{"k", "v", "PX", "1"})
+	_, _ = Exec("SET", []string{"j", "v", "PX", "1"})
+	time.Sleep(5 * time.Millisecond)
+	*logged = nil
+	if out, _ := Exec("GET", []string{"k"}); out != nilReply {
+		t.Fatalf("expected k to have expired, got %q", out)
+	}
+	_, _ = Exec("SET", []string{"j", "w", "NX"})
+	want := [][]string{{"DEL", "k"}, {"DEL", "j"}, {"SET", "j", "w", "NX"}}
+	if !reflect.DeepEqual(*logged, want) {
+		t.Errorf("expected %v, got %v", want, *logged)
+	}
+}
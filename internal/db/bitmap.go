@@ -0,0 +1,505 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// maxBitOffset is the largest bit offset SETBIT and BITFIELD accept, keeping
+// bitmaps within maxStringSize.
+const maxBitOffset = maxStringSize*8 - 1
+
+var (
+	errBitOffset = fmt.Errorf("bit offset is not an integer or out of range")
+	errBitValue  = fmt.Errorf("bit is not an integer or out of range")
+)
+
+func parseBitOffset(s string) (uint64, error) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n > maxBitOffset {
+		return 0, errBitOffset
+	}
+	return n, nil
+}
+
+// growBitmap returns buf extended with zero bytes so that bit offset+width-1
+// is addressable.
+func growBitmap(buf []byte, offset uint64, width int) []byte {
+	need := int((offset + uint64(width) + 7) / 8)
+	if need > len(buf) {
+		buf = append(buf, make([]byte, need-len(buf))...)
+	}
+	return buf
+}
+
+// readBits reads width bits starting at offset, most significant bit first.
+// Bits past the end of buf read as zero.
+func readBits(buf []byte, offset uint64, width int) uint64 {
+	var v uint64
+	for i := 0; i < width; i++ {
+		pos := offset + uint64(i)
+		byteIdx := pos / 8
+		bit := uint64(0)
+		if byteIdx < uint64(len(buf)) {
+			bit = uint64(buf[byteIdx]>>(7-pos%8)) & 1
+		}
+		v = v<<1 | bit
+	}
+	return v
+}
+
+// writeBits writes the low width bits of v starting at offset. buf must
+// already be large enough.
+func writeBits(buf []byte, offset uint64, width int, v uint64) {
+	for i := 0; i < width; i++ {
+		pos := offset + uint64(i)
+		bit := byte(v>>(width-1-i)) & 1
+		mask := byte(1) << (7 - pos%8)
+		if bit == 1 {
+			buf[pos/8] |= mask
+		} else {
+			buf[pos/8] &^= mask
+		}
+	}
+}
+
+func setbitHandler(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("missing argument for SETBIT")
+	}
+	key := args[0]
+	offset, err := parseBitOffset(args[1])
+	if err != nil {
+		return "", err
+	}
+	if args[2] != "0" && args[2] != "1" {
+		return "", errBitValue
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	buf, _, err := DefaultStore.getBytes(key)
+	if err != nil {
+		return "", err
+	}
+	buf = growBitmap(buf, offset, 1)
+	old := readBits(buf, offset, 1)
+	writeBits(buf, offset, 1, uint64(args[2][0]-'0'))
+	DefaultStore.setValue(key, StringType, buf)
+	DefaultStore.notify(notifyString, "setbit", key)
+	return strconv.FormatUint(old, 10), nil
+}
+
+func getbitHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for GETBIT")
+	}
+	offset, err := parseBitOffset(args[1])
+	if err != nil {
+		return "", err
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	buf, _, err := DefaultStore.getBytes(args[0])
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(readBits(buf, offset, 1), 10), nil
+}
+
+// bitRange parses the optional "start end [BYTE|BIT]" arguments shared by
+// BITCOUNT and BITPOS and returns the inclusive bit range they select in a
+// bitmap of n bytes. hasEnd reports whether an explicit end was given.
+func bitRange(args []string, n int) (first, last int64, hasEnd, ok bool, err error) {
+	if len(args) == 0 {
+		return 0, int64(n)*8 - 1, false, n > 0, nil
+	}
+	if len(args) > 3 {
+		return 0, 0, false, false, fmt.Errorf("syntax error")
+	}
+	start, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return 0, 0, false, false, errNotInteger
+	}
+	end := int64(math.MaxInt64)
+	if len(args) > 1 {
+		hasEnd = true
+		if end, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			return 0, 0, false, false, errNotInteger
+		}
+	}
+	unitBits := false
+	if len(args) == 3 {
+		switch strings.ToUpper(args[2]) {
+		case "BYTE":
+		case "BIT":
+			unitBits = true
+		default:
+			return 0, 0, false, false, fmt.Errorf("syntax error")
+		}
+	}
+	size := int64(n)
+	if unitBits {
+		size *= 8
+	}
+	if start < 0 {
+		start += size
+	}
+	if end < 0 {
+		end += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if start > end || size == 0 {
+		return 0, 0, hasEnd, false, nil
+	}
+	if unitBits {
+		return start, end, hasEnd, true, nil
+	}
+	return start * 8, end*8 + 7, hasEnd, true, nil
+}
+
+func bitcountHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for BITCOUNT")
+	}
+	if len(args) == 2 {
+		return "", fmt.Errorf("syntax error")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	val, _, err := DefaultStore.getBytes(args[0])
+	if err != nil {
+		return "", err
+	}
+	first, last, _, ok, err := bitRange(args[1:], len(val))
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "0", nil
+	}
+	count := 0
+	for pos := first; pos <= last; {
+		if pos%8 == 0 && pos+7 <= last {
+			count += bits.OnesCount8(val[pos/8])
+			pos += 8
+			continue
+		}
+		count += int(val[pos/8]>>(7-pos%8)) & 1
+		pos++
+	}
+	return strconv.Itoa(count), nil
+}
+
+func bitposHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for BITPOS")
+	}
+	if args[1] != "0" && args[1] != "1" {
+		return "", fmt.Errorf("the bit argument must be 1 or 0")
+	}
+	want := args[1][0] - '0'
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	val, exists, err := DefaultStore.getBytes(args[0])
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		if want == 0 {
+			return "0", nil
+		}
+		return "-1", nil
+	}
+	first, last, hasEnd, ok, err := bitRange(args[2:], len(val))
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "-1", nil
+	}
+	for pos := first; pos <= last; pos++ {
+		if (val[pos/8]>>(7-pos%8))&1 == want {
+			return strconv.FormatInt(pos, 10), nil
+		}
+	}
+	// Looking for a clear bit without an explicit end treats the string as
+	// padded with zeros on the right.
+	if want == 0 && !hasEnd {
+		return strconv.FormatInt(last+1, 10), nil
+	}
+	return "-1", nil
+}
+
+func bitopHandler(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("missing argument for BITOP")
+	}
+	op := strings.ToUpper(args[0])
+	dest, srcKeys := args[1], args[2:]
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(srcKeys) != 1 {
+			return "", fmt.Errorf("BITOP NOT must be called with a single source key")
+		}
+	default:
+		return "", fmt.Errorf("syntax error")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	srcs := make([][]byte, len(srcKeys))
+	maxLen := 0
+	for i, k := range srcKeys {
+		val, _, err := DefaultStore.getBytes(k)
+		if err != nil {
+			return "", err
+		}
+		srcs[i] = val
+		maxLen = max(maxLen, len(srcs[i]))
+	}
+	res := make([]byte, maxLen)
+	for i := range res {
+		var b byte
+		for j, src := range srcs {
+			var c byte
+			if i < len(src) {
+				c = src[i]
+			}
+			switch {
+			case op == "NOT":
+				b = ^c
+			case j == 0:
+				b = c
+			case op == "AND":
+				b &= c
+			case op == "OR":
+				b |= c
+			case op == "XOR":
+				b ^= c
+			}
+		}
+		res[i] = b
+	}
+	if len(res) == 0 {
+		DefaultStore.removeEmpty(dest)
+		return "0", nil
+	}
+	DefaultStore.setValue(dest, StringType, res)
+	delete(DefaultStore.ttl, dest)
+	DefaultStore.notify(notifyString, "set", dest)
+	return strconv.Itoa(len(res)), nil
+}
+
+type bitfieldOverflow int
+
+const (
+	overflowWrap bitfieldOverflow = iota
+	overflowSat
+	overflowFail
+)
+
+type bitfieldType struct {
+	signed bool
+	width  int
+}
+
+func parseBitfieldType(s string) (bitfieldType, error) {
+	if len(s) >= 2 {
+		w, err := strconv.Atoi(s[1:])
+		switch {
+		case err != nil:
+		case (s[0] == 'i' || s[0] == 'I') && w >= 1 && w <= 64:
+			return bitfieldType{signed: true, width: w}, nil
+		case (s[0] == 'u' || s[0] == 'U') && w >= 1 && w <= 63:
+			return bitfieldType{width: w}, nil
+		}
+	}
+	return bitfieldType{}, fmt.Errorf("invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is")
+}
+
+// parseBitfieldOffset parses an absolute bit offset or a "#n" offset that is
+// multiplied by the field width.
+func parseBitfieldOffset(s string, t bitfieldType) (uint64, error) {
+	mul := uint64(1)
+	if strings.HasPrefix(s, "#") {
+		mul = uint64(t.width)
+		s = s[1:]
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n > maxBitOffset/mul || n*mul+uint64(t.width)-1 > maxBitOffset {
+		return 0, errBitOffset
+	}
+	return n * mul, nil
+}
+
+func (t bitfieldType) decode(raw uint64) int64 {
+	if t.signed && t.width < 64 && raw&(1<<(t.width-1)) != 0 {
+		raw |= ^uint64(0) << t.width
+	}
+	return int64(raw)
+}
+
+// add computes value+incr for a field of type t under the given overflow
+// policy, mirroring Redis semantics. ok is false if the FAIL policy rejected
+// the operation.
+func (t bitfieldType) add(value, incr int64, ow bitfieldOverflow) (int64, bool) {
+	if t.signed {
+		maxV := int64(math.MaxInt64)
+		if t.width < 64 {
+			maxV = int64(1)<<(t.width-1) - 1
+		}
+		minV := -maxV - 1
+		maxIncr, minIncr := maxV-value, minV-value
+		var limit int64
+		switch {
+		case value > maxV || (t.width != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr):
+			limit = maxV
+		case value < minV || (t.width != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr):
+			limit = minV
+		default:
+			return value + incr, true
+		}
+		switch ow {
+		case overflowSat:
+			return limit, true
+		case overflowFail:
+			return 0, false
+		}
+		return t.decode(uint64(value+incr) & (^uint64(0) >> (64 - t.width))), true
+	}
+	maxV := uint64(1)<<t.width - 1
+	uval := uint64(value)
+	var limit uint64
+	switch {
+	case uval > maxV || (incr > 0 && uint64(incr) > maxV-uval):
+		limit = maxV
+	case incr < 0 && uint64(-incr) > uval:
+		limit = 0
+	default:
+		return int64(uval + uint64(incr)), true
+	}
+	switch ow {
+	case overflowSat:
+		return int64(limit), true
+	case overflowFail:
+		return 0, false
+	}
+	return int64((uval + uint64(incr)) & maxV), true
+}
+
+// bitfieldHandler implements BITFIELD key [GET type offset] [SET type offset
+// value] [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL]... and
+// replies with one comma-separated result per GET/SET/INCRBY, empty where an
+// OVERFLOW FAIL operation was skipped.
+func bitfieldHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for BITFIELD")
+	}
+	key := args[0]
+	type bitfieldOp struct {
+		name   string
+		typ    bitfieldType
+		offset uint64
+		arg    int64
+		ow     bitfieldOverflow
+	}
+	var ops []bitfieldOp
+	ow := overflowWrap
+	writes := false
+	for i := 1; i < len(args); {
+		name := strings.ToUpper(args[i])
+		if name == "OVERFLOW" {
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("syntax error")
+			}
+			switch strings.ToUpper(args[i+1]) {
+			case "WRAP":
+				ow = overflowWrap
+			case "SAT":
+				ow = overflowSat
+			case "FAIL":
+				ow = overflowFail
+			default:
+				return "", fmt.Errorf("invalid OVERFLOW type specified")
+			}
+			i += 2
+			continue
+		}
+		nargs := 3
+		switch name {
+		case "GET":
+			nargs = 2
+		case "SET", "INCRBY":
+			writes = true
+		default:
+			return "", fmt.Errorf("syntax error")
+		}
+		if i+nargs >= len(args) {
+			return "", fmt.Errorf("syntax error")
+		}
+		typ, err := parseBitfieldType(args[i+1])
+		if err != nil {
+			return "", err
+		}
+		offset, err := parseBitfieldOffset(args[i+2], typ)
+		if err != nil {
+			return "", err
+		}
+		op := bitfieldOp{name: name, typ: typ, offset: offset, ow: ow}
+		if nargs == 3 {
+			if op.arg, err = strconv.ParseInt(args[i+3], 10, 64); err != nil {
+				return "", errNotInteger
+			}
+		}
+		ops = append(ops, op)
+		i += nargs + 1
+	}
+
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	buf, _, err := DefaultStore.getBytes(key)
+	if err != nil {
+		return "", err
+	}
+	mask := func(t bitfieldType) uint64 { return ^uint64(0) >> (64 - t.width) }
+	results := make([]string, len(ops))
+	for i, op := range ops {
+		old := op.typ.decode(readBits(buf, op.offset, op.typ.width))
+		switch op.name {
+		case "GET":
+			results[i] = strconv.FormatInt(old, 10)
+			continue
+		case "SET":
+			next, ok := op.typ.add(op.arg, 0, op.ow)
+			if !ok {
+				results[i] = nilReply
+				continue
+			}
+			buf = growBitmap(buf, op.offset, op.typ.width)
+			writeBits(buf, op.offset, op.typ.width, uint64(next)&mask(op.typ))
+			results[i] = strconv.FormatInt(old, 10)
+		case "INCRBY":
+			next, ok := op.typ.add(old, op.arg, op.ow)
+			if !ok {
+				results[i] = nilReply
+				continue
+			}
+			buf = growBitmap(buf, op.offset, op.typ.width)
+			writeBits(buf, op.offset, op.typ.width, uint64(next)&mask(op.typ))
+			results[i] = strconv.FormatInt(next, 10)
+		}
+	}
+	if writes && len(buf) > 0 {
+		DefaultStore.setValue(key, StringType, buf)
+		DefaultStore.notify(notifyString, "setbit", key)
+	}
+	return strings.Join(results, ","), nil
+}
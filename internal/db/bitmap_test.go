@@ -0,0 +1,131 @@
+package db
+
+import "testing"
+
+func TestSetGetBit(t *testing.T) {
+	DefaultStore = NewStore()
+	if old, _ := setbitHandler([]string{"dau", "7", "1"}); old != "0" {
+		t.Errorf("expected old bit 0, got %s", old)
+	}
+	if old, _ := setbitHandler([]string{"dau", "7", "0"}); old != "1" {
+		t.Errorf("expected old bit 1, got %s", old)
+	}
+	_, _ = setbitHandler([]string{"dau", "100", "1"})
+	if bit, _ := getbitHandler([]string{"dau", "100"}); bit != "1" {
+		t.Errorf("expected bit 100 set, got %s", bit)
+	}
+	if bit, _ := getbitHandler([]string{"dau", "1000"}); bit != "0" {
+		t.Errorf("expected bit past end to read 0, got %s", bit)
+	}
+	if n, _ := strlenHandler([]string{"dau"}); n != "13" {
+		t.Errorf("expected bitmap of 13 bytes, got %s", n)
+	}
+	buf := DefaultStore.data["dau"].([]byte)
+	_, _ = setbitHandler([]string{"dau", "9", "1"})
+	if &DefaultStore.data["dau"].([]byte)[0] != &buf[0] || buf[1] != 0x40 {
+		t.Error("expected SETBIT within the bitmap to edit it in place")
+	}
+	if _, err := setbitHandler([]string{"dau", "-1", "1"}); err != errBitOffset {
+		t.Errorf("expected offset error, got %v", err)
+	}
+	if _, err := setbitHandler([]string{"dau", "1", "2"}); err != errBitValue {
+		t.Errorf("expected bit value error, got %v", err)
+	}
+}
+
+func TestBitCountAndPos(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = setHandler([]string{"b", "foobar"})
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"b"}, "26"},
+		{[]string{"b", "0", "0"}, "4"},
+		{[]string{"b", "1", "1"}, "6"},
+		{[]string{"b", "1", "1", "BYTE"}, "6"},
+		{[]string{"b", "5", "30", "BIT"}, "17"},
+		{[]string{"b", "-2", "-1"}, "7"},
+		{[]string{"missing"}, "0"},
+	}
+	for _, c := range cases {
+		if got, _ := bitcountHandler(c.args); got != c.want {
+			t.Errorf("BITCOUNT %v: expected %s, got %s", c.args, c.want, got)
+		}
+	}
+
+	_, _ = setHandler([]string{"p", "\xff\xf0\x00"})
+	pos := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"p", "0"}, "12"},
+		{[]string{"p", "1", "2"}, "-1"},
+		{[]string{"p", "1", "7", "15", "BIT"}, "7"},
+		{[]string{"p", "0", "0", "0"}, "-1"},
+	}
+	for _, c := range pos {
+		if got, _ := bitposHandler(c.args); got != c.want {
+			t.Errorf("BITPOS %v: expected %s, got %s", c.args, c.want, got)
+		}
+	}
+	_, _ = setHandler([]string{"ones", "\xff\xff"})
+	if got, _ := bitposHandler([]string{"ones", "0"}); got != "16" {
+		t.Errorf("expected clear bit past end of all-ones string, got %s", got)
+	}
+	if got, _ := bitposHandler([]string{"ones", "0", "0", "-1"}); got != "-1" {
+		t.Errorf("expected -1 with explicit end, got %s", got)
+	}
+}
+
+func TestBitOp(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = setHandler([]string{"a", "\x0f\xff"})
+	_, _ = setHandler([]string{"b", "\xf0"})
+	ops := map[string]string{
+		"AND": "\x00\x00",
+		"OR":  "\xff\xff",
+		"XOR": "\xff\xff",
+	}
+	for op, want := range ops {
+		if n, _ := bitopHandler([]string{op, "dest", "a", "b"}); n != "2" {
+			t.Errorf("BITOP %s: expected length 2, got %s", op, n)
+		}
+		if got, _ := getHandler([]string{"dest"}); got != want {
+			t.Errorf("BITOP %s: expected %q, got %q", op, want, got)
+		}
+	}
+	_, _ = bitopHandler([]string{"NOT", "dest", "b"})
+	if got, _ := getHandler([]string{"dest"}); got != "\x0f" {
+		t.Errorf("BITOP NOT: expected \\x0f, got %q", got)
+	}
+	if _, err := bitopHandler([]string{"NOT", "dest", "a", "b"}); err == nil {
+		t.Error("expected error for BITOP NOT with two sources")
+	}
+}
+
+func TestBitField(t *testing.T) {
+	DefaultStore = NewStore()
+	if out, _ := bitfieldHandler([]string{"bf", "SET", "i8", "0", "100", "GET", "i8", "0"}); out != "0,100" {
+		t.Errorf("expected 0,100, got %s", out)
+	}
+	if out, _ := bitfieldHandler([]string{"bf", "INCRBY", "i8", "0", "30"}); out != "-126" {
+		t.Errorf("expected wrap to -126, got %s", out)
+	}
+	_, _ = bitfieldHandler([]string{"bf", "SET", "u8", "#1", "250"})
+	if out, _ := bitfieldHandler([]string{"bf", "OVERFLOW", "SAT", "INCRBY", "u8", "#1", "10"}); out != "255" {
+		t.Errorf("expected saturation at 255, got %s", out)
+	}
+	if out, _ := bitfieldHandler([]string{"bf", "OVERFLOW", "FAIL", "INCRBY", "u8", "#1", "1", "GET", "u8", "8"}); out != ",255" {
+		t.Errorf("expected failed increment and unchanged value, got %s", out)
+	}
+	if out, _ := bitfieldHandler([]string{"bf", "INCRBY", "u2", "100", "1", "INCRBY", "u2", "100", "1", "INCRBY", "u2", "100", "1", "INCRBY", "u2", "100", "1"}); out != "1,2,3,0" {
+		t.Errorf("expected 1,2,3,0, got %s", out)
+	}
+	if out, _ := bitfieldHandler([]string{"bf", "OVERFLOW", "SAT", "INCRBY", "i64", "200", "-9223372036854775807", "INCRBY", "i64", "200", "-100"}); out != "-9223372036854775807,-9223372036854775808" {
+		t.Errorf("expected i64 saturation at min, got %s", out)
+	}
+	if _, err := bitfieldHandler([]string{"bf", "GET", "u64", "0"}); err == nil {
+		t.Error("expected error for u64")
+	}
+}
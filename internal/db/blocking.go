@@ -0,0 +1,321 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BlockingHandlerFunc is a command that may park the calling client until it
+// can be served. ctx is cancelled when the client goes away.
+type BlockingHandlerFunc func(ctx context.Context, args []string) (string, error)
+
+// BlockingCommands holds the blocking variants of commands. Connection
+// handlers should prefer these over Commands; the entries in Commands for the
+// same names never block and behave as if the timeout expired immediately,
+// which is what scripts and AOF replay need.
+var BlockingCommands = map[string]BlockingHandlerFunc{
+	"BLPOP":      blpopHandler,
+	"BRPOP":      brpopHandler,
+	"BLMOVE":     blmoveHandler,
+	"BRPOPLPUSH": brpoplpushHandler,
+	"XREAD":      xreadHandler,
+	"XREADGROUP": xreadgroupHandler,
+}
+
+// listWaiter is a client parked on one or more list keys. Waiters are served
+// in the order they blocked.
+type listWaiter struct {
+	keys []string
+	// serve pops from the now non-empty list at key and returns the reply.
+	// It runs with the store lock held.
+	serve func(key string) (string, error)
+	// effect is the non-blocking command that does what serve(key) did,
+	// for the append-only log.
+	effect func(key string) []string
+	// undo puts back what serve took, for a client that disconnected after
+	// being served but before reading its reply.
+	undo   func()
+	result chan blockedReply
+	served bool
+}
+
+// blockedReply is what a parked client is woken with.
+type blockedReply struct {
+	val string
+	err error
+}
+
+// blockOnLists registers w on each of its keys. The caller must hold the
+// write lock.
+func (s *Store) blockOnLists(w *listWaiter) {
+	for _, k := range w.keys {
+		s.listWaiters[k] = append(s.listWaiters[k], w)
+	}
+}
+
+// unblockLists removes w from every key it waits on. The caller must hold the
+// write lock.
+func (s *Store) unblockLists(w *listWaiter) {
+	for _, k := range w.keys {
+		queue := s.listWaiters[k]
+		for i, other := range queue {
+			if other == w {
+				queue = append(queue[:i:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(s.listWaiters, k)
+		} else {
+			s.listWaiters[k] = queue
+		}
+	}
+}
+
+// serveBlockedLists hands elements of lists that received pushes to the
+// clients blocked on them, oldest first. Commands that can make a list
+// non-empty call it before releasing the write lock.
+func (s *Store) serveBlockedLists() {
+	for len(s.readyLists) > 0 {
+		key := s.readyLists[0]
+		s.readyLists = s.readyLists[1:]
+		for len(s.listWaiters[key]) > 0 {
+			lst, ok, _ := s.getList(key)
+			if !ok || lst.Len() == 0 {
+				break
+			}
+			w := s.listWaiters[key][0]
+			s.unblockLists(w)
+			w.served = true
+			val, err := w.serve(key)
+			if err == nil {
+				s.propagate(w.effect(key)...)
+			}
+			w.result <- blockedReply{val, err}
+		}
+	}
+	s.readyLists = nil
+}
+
+// parseBlockTimeout parses a timeout in seconds, where 0 blocks forever.
+func parseBlockTimeout(s string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) || secs > math.MaxInt64/float64(time.Second) {
+		return 0, fmt.Errorf("timeout is not a float or out of range")
+	}
+	if secs < 0 {
+		return 0, fmt.Errorf("timeout is negative")
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// blockingListOp serves w from the first non-empty key right away, or parks
+// the client until another command pushes to one of its keys. It never blocks
+// if ctx is already done. A zero timeout blocks until ctx is done.
+func blockingListOp(ctx context.Context, w *listWaiter, timeout time.Duration) (string, error) {
+	s := DefaultStore
+	// Without a live ctx this runs inside another command, which is logged
+	// as a whole.
+	top := ctx.Err() == nil
+	unlock := s.lockLogged(top)
+	for _, k := range w.keys {
+		lst, ok, err := s.getList(k)
+		if err != nil {
+			unlock()
+			return "", err
+		}
+		if ok && lst.Len() > 0 {
+			reply, err := w.serve(k)
+			if err == nil && top {
+				s.propagate(w.effect(k)...)
+				servedFrom(ctx, w)
+			}
+			s.serveBlockedLists()
+			unlock()
+			return reply, err
+		}
+	}
+	if !top {
+		unlock()
+		return nilReply, nil
+	}
+	w.result = make(chan blockedReply, 1)
+	s.blockOnLists(w)
+	unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case reply := <-w.result:
+		if reply.err == nil {
+			servedFrom(ctx, w)
+		}
+		return reply.val, reply.err
+	case <-expired:
+	case <-ctx.Done():
+	}
+	defer s.lockLogged(true)()
+	if !w.served {
+		s.unblockLists(w)
+		return nilReply, nil
+	}
+	// Served while the timeout fired or the client left.
+	reply := <-w.result
+	if ctx.Err() != nil && reply.err == nil {
+		w.undo()
+		s.serveBlockedLists()
+		return nilReply, nil
+	}
+	if reply.err == nil {
+		servedFrom(ctx, w)
+	}
+	return reply.val, reply.err
+}
+
+// blockingPop implements BLPOP/BRPOP key [key ...] timeout, replying with
+// "key,element".
+func blockingPop(ctx context.Context, args []string, head bool) (string, error) {
+	keys := args[:len(args)-1]
+	timeout, err := parseBlockTimeout(args[len(args)-1])
+	if err != nil {
+		return "", err
+	}
+	s := DefaultStore
+	var servedKey, popped string
+	w := &listWaiter{keys: keys}
+	w.serve = func(key string) (string, error) {
+		servedKey = key
+		lst, _, _ := s.getList(key)
+		if head {
+			popped, _ = lst.PopFront()
+		} else {
+			popped, _ = lst.PopBack()
+		}
+		s.notify(notifyList, popEvent(head), key)
+		s.setList(key, lst)
+		return key + "," + popped, nil
+	}
+	w.undo = func() {
+		lst, _, _ := s.getList(servedKey)
+		if head {
+			lst.PushFront(popped)
+		} else {
+			lst.PushBack(popped)
+		}
+		s.setList(servedKey, lst)
+		s.notify(notifyList, pushEvent(head), servedKey)
+		s.propagate(strings.ToUpper(pushEvent(head)), servedKey, popped)
+	}
+	w.effect = func(key string) []string {
+		return []string{strings.ToUpper(popEvent(head)), key}
+	}
+	return blockingListOp(ctx, w, timeout)
+}
+
+func blpopHandler(ctx context.Context, args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for BLPOP")
+	}
+	return blockingPop(ctx, args, true)
+}
+
+func brpopHandler(ctx context.Context, args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for BRPOP")
+	}
+	return blockingPop(ctx, args, false)
+}
+
+// blockingMove implements BLMOVE once its arguments are parsed. The moved
+// element stays in dst even if the client disconnects before the reply.
+func blockingMove(ctx context.Context, src, dst string, fromLeft, toLeft bool, timeoutArg string) (string, error) {
+	timeout, err := parseBlockTimeout(timeoutArg)
+	if err != nil {
+		return "", err
+	}
+	s := DefaultStore
+	w := &listWaiter{keys: []string{src}}
+	w.serve = func(string) (string, error) {
+		val, _, err := s.moveList(src, dst, fromLeft, toLeft)
+		return val, err
+	}
+	w.undo = func() {}
+	w.effect = func(string) []string {
+		return []string{"LMOVE", src, dst, listSideName(fromLeft), listSideName(toLeft)}
+	}
+	return blockingListOp(ctx, w, timeout)
+}
+
+func blmoveHandler(ctx context.Context, args []string) (string, error) {
+	if len(args) < 5 {
+		return "", fmt.Errorf("missing argument for BLMOVE")
+	}
+	fromLeft, err := parseListSide(args[2])
+	if err != nil {
+		return "", err
+	}
+	toLeft, err := parseListSide(args[3])
+	if err != nil {
+		return "", err
+	}
+	return blockingMove(ctx, args[0], args[1], fromLeft, toLeft, args[4])
+}
+
+func brpoplpushHandler(ctx context.Context, args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("missing argument for BRPOPLPUSH")
+	}
+	return blockingMove(ctx, args[0], args[1], false, true, args[2])
+}
+
+type undoKey struct{}
+
+// WithUndo returns ctx for running a blocking command and a function that
+// takes back what the command served, such as a popped element, for a
+// connection handler that could not deliver the reply. The function does
+// nothing if nothing was served.
+func WithUndo(ctx context.Context) (context.Context, func()) {
+	var undo func()
+	return context.WithValue(ctx, undoKey{}, &undo), func() {
+		if undo != nil {
+			undo()
+		}
+	}
+}
+
+// servedFrom records in ctx, for WithUndo, how to take back what serving w
+// took.
+func servedFrom(ctx context.Context, w *listWaiter) {
+	if slot, ok := ctx.Value(undoKey{}).(*func()); ok {
+		*slot = func() {
+			s := DefaultStore
+			defer s.lockLogged(true)()
+			w.undo()
+			s.serveBlockedLists()
+		}
+	}
+}
+
+// nonBlocking adapts a blocking command for Commands, where it must reply
+// immediately, by running it with a context that is already done.
+func nonBlocking(h BlockingHandlerFunc) HandlerFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return func(args []string) (string, error) {
+		return h(ctx, args)
+	}
+}
+
+func init() {
+	for name, h := range BlockingCommands {
+		Commands[name] = nonBlocking(h)
+	}
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBlockingPopImmediate(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = rpushHandler([]string{"b", "x", "y"})
+	out, err := blpopHandler(context.Background(), []string{"a", "b", "1"})
+	if err != nil || out != "b,x" {
+		t.Errorf("expected b,x, got %s (%v)", out, err)
+	}
+	out, _ = brpopHandler(context.Background(), []string{"a", "b", "1"})
+	if out != "b,y" {
+		t.Errorf("expected b,y, got %s", out)
+	}
+	// The Commands entry never blocks.
+	start := time.Now()
+	if out, _ := Commands["BLPOP"]([]string{"b", "0"}); out != nilReply {
+		t.Errorf("expected nil from non-blocking BLPOP, got %s", out)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("expected Commands BLPOP not to block")
+	}
+	if _, err := blpopHandler(context.Background(), []string{"b", "-1"}); err == nil {
+		t.Error("expected error for negative timeout")
+	}
+}
+
+func TestBlockingPopTimeout(t *testing.T) {
+	DefaultStore = NewStore()
+	start := time.Now()
+	out, _ := blpopHandler(context.Background(), []string{"q", "0.1"})
+	if out != nilReply {
+		t.Errorf("expected nil after timeout, got %s", out)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected to block for the timeout, returned after %v", elapsed)
+	}
+	if len(DefaultStore.listWaiters) != 0 {
+		t.Error("expected timed out waiter to be unregistered")
+	}
+}
+
+// waitForWaiters blocks until n clients are parked on key.
+func waitForWaiters(t *testing.T, key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		DefaultStore.mu.Lock()
+		got := len(DefaultStore.listWaiters[key])
+		DefaultStore.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d waiters on %s", n, key)
+}
+
+func TestBlockingPopFIFOWakeup(t *testing.T) {
+	DefaultStore = NewStore()
+	results := make([]chan string, 3)
+	for i := range results {
+		results[i] = make(chan string, 1)
+		go func(ch chan string) {
+			out, _ := blpopHandler(context.Background(), []string{"other", "q", "5"})
+			ch <- out
+		}(results[i])
+		waitForWaiters(t, "q", i+1)
+	}
+	if n, _ := rpushHandler([]string{"q", "a", "b"}); n != "2" {
+		t.Errorf("expected RPUSH to report length 2, got %s", n)
+	}
+	if out := <-results[0]; out != "q,a" {
+		t.Errorf("expected first waiter to get q,a, got %s", out)
+	}
+	if out := <-results[1]; out != "q,b" {
+		t.Errorf("expected second waiter to get q,b, got %s", out)
+	}
+	_, _ = lpushHandler([]string{"other", "c"})
+	if out := <-results[2]; out != "other,c" {
+		t.Errorf("expected third waiter to get other,c, got %s", out)
+	}
+	if exists, _ := existsHandler([]string{"q"}); exists != "0" {
+		t.Error("expected list drained by waiters to be removed")
+	}
+}
+
+func TestBlockingMoveWakeup(t *testing.T) {
+	DefaultStore = NewStore()
+	moved := make(chan string, 1)
+	popped := make(chan string, 1)
+	go func() {
+		out, _ := blmoveHandler(context.Background(), []string{"src", "dst", "LEFT", "RIGHT", "5"})
+		moved <- out
+	}()
+	waitForWaiters(t, "src", 1)
+	go func() {
+		out, _ := brpopHandler(context.Background(), []string{"dst", "5"})
+		popped <- out
+	}()
+	waitForWaiters(t, "dst", 1)
+	_, _ = rpushHandler([]string{"src", "job"})
+	if out := <-moved; out != "job" {
+		t.Errorf("expected BLMOVE to return job, got %s", out)
+	}
+	// The move into dst in turn wakes the client blocked on dst.
+	if out := <-popped; out != "dst,job" {
+		t.Errorf("expected BRPOP on dst to get dst,job, got %s", out)
+	}
+}
+
+func TestBlockingPopCancelled(t *testing.T) {
+	DefaultStore = NewStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan string, 1)
+	go func() {
+		out, _ := blpopHandler(ctx, []string{"q", "0"})
+		done <- out
+	}()
+	waitForWaiters(t, "q", 1)
+	cancel()
+	if out := <-done; out != nilReply {
+		t.Errorf("expected nil after disconnect, got %s", out)
+	}
+	_, _ = rpushHandler([]string{"q", "kept"})
+	if n, _ := llenHandler([]string{"q"}); n != "1" {
+		t.Errorf("expected element to stay in list after waiter left, got length %s", n)
+	}
+}
+
+func TestBlockingPopUndo(t *testing.T) {
+	DefaultStore = NewStore()
+	ctx, undo := WithUndo(testContext(t))
+	done := make(chan string, 1)
+	go func() {
+		out, _ := blpopHandler(ctx, []string{"q", "0"})
+		done <- out
+	}()
+	waitForWaiters(t, "q", 1)
+	_, _ = rpushHandler([]string{"q", "a", "b"})
+	if out := <-done; out != "q,a" {
+		t.Fatalf("expected q,a, got %s", out)
+	}
+	// The reply could not be written, so a goes back for the next client.
+	undo()
+	if out, _ := lrangeHandler([]string{"q", "0", "-1"}); out != "a,b" {
+		t.Errorf("expected a to be put back, got %s", out)
+	}
+
+	_, undo = WithUndo(testContext(t))
+	undo()
+	if out, _ := lrangeHandler([]string{"q", "0", "-1"}); out != "a,b" {
+		t.Errorf("expected an undo with nothing served to do nothing, got %s", out)
+	}
+}
+
+// testContext returns a context cancelled when the test ends.
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return ctx
+}
@@ -0,0 +1,332 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Filters BF.ADD creates on its own use these settings.
+const (
+	defaultBloomErrorRate = 0.01
+	defaultBloomCapacity  = 100
+	defaultBloomExpansion = 2
+)
+
+// bloomLayer is one fixed-size Bloom filter of a scalable filter.
+type bloomLayer struct {
+	Bits     []byte
+	Hashes   int
+	Capacity int64
+	Count    int64
+}
+
+// bloomLayerBytes returns how many bytes of bits a layer needs to hold
+// capacity items at errorRate.
+func bloomLayerBytes(capacity int64, errorRate float64) float64 {
+	bits := math.Ceil(float64(capacity) * -math.Log(errorRate) / (math.Ln2 * math.Ln2))
+	return math.Ceil(max(bits, 64) / 8)
+}
+
+func newBloomLayer(capacity int64, errorRate float64) *bloomLayer {
+	return &bloomLayer{
+		Bits:     make([]byte, int64(bloomLayerBytes(capacity, errorRate))),
+		Hashes:   max(1, int(math.Ceil(-math.Log2(errorRate)))),
+		Capacity: capacity,
+	}
+}
+
+// positions calls f with each bit item maps to, stopping if f returns false.
+// The bits come from two hashes combined as h1 + i*h2.
+func (l *bloomLayer) positions(h1, h2 uint64, f func(bit uint64) bool) bool {
+	n := uint64(len(l.Bits)) * 8
+	for i := range uint64(l.Hashes) {
+		if !f((h1 + i*h2) % n) {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *bloomLayer) contains(h1, h2 uint64) bool {
+	return l.positions(h1, h2, func(bit uint64) bool { return l.Bits[bit/8]&(1<<(bit%8)) != 0 })
+}
+
+func (l *bloomLayer) add(h1, h2 uint64) {
+	l.positions(h1, h2, func(bit uint64) bool {
+		l.Bits[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+	l.Count++
+}
+
+// bloomFilter is a scalable Bloom filter: once its newest layer holds as
+// many items as it was sized for, it adds a layer Expansion times larger.
+// The layers' error rates are ErrorRate/2, ErrorRate/4 and so on, so however
+// far it grows the overall rate stays under ErrorRate. Its fields are
+// exported so snapshots can store it as is.
+type bloomFilter struct {
+	Layers     []*bloomLayer
+	ErrorRate  float64
+	Expansion  int64
+	NonScaling bool
+}
+
+var errBloomFull = fmt.Errorf("non scaling filter is full")
+
+func newBloomFilter(errorRate float64, capacity, expansion int64, nonScaling bool) (*bloomFilter, error) {
+	if bloomLayerBytes(capacity, errorRate/2) > maxStringSize {
+		return nil, fmt.Errorf("filter is too large")
+	}
+	return &bloomFilter{
+		Layers:     []*bloomLayer{newBloomLayer(capacity, errorRate/2)},
+		ErrorRate:  errorRate,
+		Expansion:  expansion,
+		NonScaling: nonScaling,
+	}, nil
+}
+
+func bloomHashes(item string) (uint64, uint64) {
+	return murmurHash64A(item, 0xc0ffee), murmurHash64A(item, 0xbadf00d) | 1
+}
+
+func (bf *bloomFilter) contains(item string) bool {
+	h1, h2 := bloomHashes(item)
+	for _, l := range bf.Layers {
+		if l.contains(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds item, reporting false if it may have been added already.
+func (bf *bloomFilter) add(item string) (bool, error) {
+	if bf.contains(item) {
+		return false, nil
+	}
+	last := bf.Layers[len(bf.Layers)-1]
+	if last.Count >= last.Capacity {
+		if bf.NonScaling {
+			return false, errBloomFull
+		}
+		capacity := float64(last.Capacity) * float64(bf.Expansion)
+		rate := bf.ErrorRate * math.Pow(0.5, float64(len(bf.Layers)+1))
+		if capacity > math.MaxInt64/2 || float64(bf.size())+bloomLayerBytes(int64(capacity), rate) > maxStringSize {
+			return false, fmt.Errorf("filter is too large to grow")
+		}
+		last = newBloomLayer(int64(capacity), rate)
+		bf.Layers = append(bf.Layers, last)
+	}
+	h1, h2 := bloomHashes(item)
+	last.add(h1, h2)
+	return true, nil
+}
+
+// size returns the bytes used by the filter's bits.
+func (bf *bloomFilter) size() int64 {
+	var n int64
+	for _, l := range bf.Layers {
+		n += int64(len(l.Bits))
+	}
+	return n
+}
+
+func copyBloom(bf *bloomFilter) *bloomFilter {
+	cp := *bf
+	cp.Layers = make([]*bloomLayer, len(bf.Layers))
+	for i, l := range bf.Layers {
+		c := *l
+		c.Bits = append([]byte(nil), l.Bits...)
+		cp.Layers[i] = &c
+	}
+	return &cp
+}
+
+// getBloom returns the Bloom filter at key. The caller must hold the write
+// lock.
+func (s *Store) getBloom(key string) (*bloomFilter, bool, error) {
+	exists, err := s.checkType(key, BloomType)
+	if !exists {
+		return nil, false, err
+	}
+	return s.data[key].(*bloomFilter), true, nil
+}
+
+// bfReserveHandler implements BF.RESERVE key error_rate capacity
+// [EXPANSION n] [NONSCALING].
+func bfReserveHandler(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("missing argument for BF.RESERVE")
+	}
+	errorRate, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || !(errorRate > 0 && errorRate < 1) {
+		return "", fmt.Errorf("error rate must be between 0 and 1")
+	}
+	capacity, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || capacity < 1 {
+		return "", fmt.Errorf("capacity must be a positive integer")
+	}
+	expansion := int64(defaultBloomExpansion)
+	nonScaling := false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "EXPANSION":
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("syntax error")
+			}
+			expansion, err = strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || expansion < 1 {
+				return "", fmt.Errorf("expansion must be a positive integer")
+			}
+			i++
+		case "NONSCALING":
+			nonScaling = true
+		default:
+			return "", fmt.Errorf("syntax error")
+		}
+	}
+	bf, err := newBloomFilter(errorRate, capacity, expansion, nonScaling)
+	if err != nil {
+		return "", err
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireIfNeeded(args[0])
+	if _, exists := s.data[args[0]]; exists {
+		return "", fmt.Errorf("item exists")
+	}
+	s.setValue(args[0], BloomType, bf)
+	s.notify(notifyModule, "bf.reserve", args[0])
+	return "OK", nil
+}
+
+// bloomAdd adds items to the filter at key, creating it with the default
+// settings if needed, and replies with 1 for each item that was new.
+func bloomAdd(key string, items []string) (string, error) {
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bf, exists, err := s.getBloom(key)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		bf, _ = newBloomFilter(defaultBloomErrorRate, defaultBloomCapacity, defaultBloomExpansion, false)
+		s.setValue(key, BloomType, bf)
+	}
+	out := make([]string, len(items))
+	added := false
+	for i, item := range items {
+		ok, err := bf.add(item)
+		if err != nil {
+			if added {
+				s.notify(notifyModule, "bf.add", key)
+			}
+			return "", err
+		}
+		out[i] = "0"
+		if ok {
+			out[i] = "1"
+			added = true
+		}
+	}
+	if added {
+		s.notify(notifyModule, "bf.add", key)
+	}
+	return strings.Join(out, ","), nil
+}
+
+// bloomExists replies with 1 for each item that may be in the filter at key.
+func bloomExists(key string, items []string) (string, error) {
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bf, exists, err := s.getBloom(key)
+	if err != nil {
+		return "", err
+	}
+	out := make([]string, len(items))
+	for i, item := range items {
+		out[i] = "0"
+		if exists && bf.contains(item) {
+			out[i] = "1"
+		}
+	}
+	return strings.Join(out, ","), nil
+}
+
+// bfAddHandler implements BF.ADD key item.
+func bfAddHandler(args []string) (string, error) {
+	if len(args) != 2 {
+		return "", fmt.Errorf("wrong number of arguments for BF.ADD")
+	}
+	return bloomAdd(args[0], args[1:])
+}
+
+// bfMAddHandler implements BF.MADD key item [item ...].
+func bfMAddHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for BF.MADD")
+	}
+	return bloomAdd(args[0], args[1:])
+}
+
+// bfExistsHandler implements BF.EXISTS key item.
+func bfExistsHandler(args []string) (string, error) {
+	if len(args) != 2 {
+		return "", fmt.Errorf("wrong number of arguments for BF.EXISTS")
+	}
+	return bloomExists(args[0], args[1:])
+}
+
+// bfMExistsHandler implements BF.MEXISTS key item [item ...].
+func bfMExistsHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for BF.MEXISTS")
+	}
+	return bloomExists(args[0], args[1:])
+}
+
+// bfInfoHandler implements BF.INFO key, replying with name,value pairs.
+func bfInfoHandler(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("wrong number of arguments for BF.INFO")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bf, exists, err := s.getBloom(args[0])
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("not found")
+	}
+	var capacity, items int64
+	for _, l := range bf.Layers {
+		capacity += l.Capacity
+		items += l.Count
+	}
+	expansion := bf.Expansion
+	if bf.NonScaling {
+		expansion = 0
+	}
+	stats := []struct {
+		name string
+		n    int64
+	}{
+		{"capacity", capacity},
+		{"size", bf.size()},
+		{"filters", int64(len(bf.Layers))},
+		{"items", items},
+		{"expansion", expansion},
+	}
+	out := make([]string, 0, 2*len(stats))
+	for _, st := range stats {
+		out = append(out, st.name, strconv.FormatInt(st.n, 10))
+	}
+	return strings.Join(out, ","), nil
+}
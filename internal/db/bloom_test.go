@@ -0,0 +1,111 @@
+package db
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBloomAddExists(t *testing.T) {
+	DefaultStore = NewStore()
+	if out, err := bfAddHandler([]string{"seen", "a"}); err != nil || out != "1" {
+		t.Fatalf("expected 1, got %s (%v)", out, err)
+	}
+	if out, _ := bfAddHandler([]string{"seen", "a"}); out != "0" {
+		t.Errorf("expected re-adding to reply 0, got %s", out)
+	}
+	if out, _ := bfMAddHandler([]string{"seen", "b", "a", "c"}); out != "1,0,1" {
+		t.Errorf("expected 1,0,1, got %s", out)
+	}
+	if out, _ := bfMExistsHandler([]string{"seen", "a", "b", "c", "zzz"}); out != "1,1,1,0" {
+		t.Errorf("expected 1,1,1,0, got %s", out)
+	}
+	if out, _ := bfExistsHandler([]string{"missing", "a"}); out != "0" {
+		t.Errorf("expected 0 for a missing key, got %s", out)
+	}
+	if out, _ := bfInfoHandler([]string{"seen"}); !strings.HasPrefix(out, "capacity,100,") || !strings.Contains(out, "items,3,") {
+		t.Errorf("unexpected info %s", out)
+	}
+	if _, err := bfReserveHandler([]string{"seen", "0.01", "10"}); err == nil {
+		t.Error("expected BF.RESERVE on an existing key to fail")
+	}
+	for _, bad := range [][]string{
+		{"f", "0", "10"}, {"f", "1", "10"}, {"f", "0.1", "0"}, {"f", "0.1", "10", "EXPANSION", "0"},
+		{"f", "0.1", "10", "BOGUS"}, {"f", "0.001", "1000000000000"},
+	} {
+		if _, err := bfReserveHandler(bad); err == nil {
+			t.Errorf("expected BF.RESERVE %v to fail", bad)
+		}
+	}
+}
+
+func TestBloomScaling(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = bfReserveHandler([]string{"ids", "0.01", "1000", "EXPANSION", "2"})
+	for i := range 10000 {
+		if _, err := bfAddHandler([]string{"ids", "id:" + strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range 10000 {
+		if out, _ := bfExistsHandler([]string{"ids", "id:" + strconv.Itoa(i)}); out != "1" {
+			t.Fatalf("expected id:%d to be found", i)
+		}
+	}
+	falsePositives := 0
+	for i := range 10000 {
+		if out, _ := bfExistsHandler([]string{"ids", "other:" + strconv.Itoa(i)}); out == "1" {
+			falsePositives++
+		}
+	}
+	// The overall rate stays under the requested 1%; allow for chance.
+	if falsePositives > 150 {
+		t.Errorf("expected a false positive rate near 1%%, got %d in 10000", falsePositives)
+	}
+	if bf := DefaultStore.data["ids"].(*bloomFilter); len(bf.Layers) < 3 {
+		t.Errorf("expected the filter to grow, it has %d layers", len(bf.Layers))
+	}
+
+	_, _ = bfReserveHandler([]string{"fixed", "0.01", "2", "NONSCALING"})
+	if out, err := bfMAddHandler([]string{"fixed", "a", "b"}); err != nil || out != "1,1" {
+		t.Fatalf("expected 1,1, got %s (%v)", out, err)
+	}
+	if _, err := bfAddHandler([]string{"fixed", "c"}); err == nil {
+		t.Error("expected a full non-scaling filter to refuse new items")
+	}
+}
+
+func TestBloomPersistence(t *testing.T) {
+	DefaultStore = NewStore()
+	logged := captureLog(t)
+	for _, cmd := range [][]string{
+		{"BF.RESERVE", "f", "0.001", "50"}, {"BF.MADD", "f", "a", "b"}, {"BF.EXISTS", "f", "a"}, {"BF.ADD", "f", "a"},
+	} {
+		_, _ = Exec(cmd[0], cmd[1:])
+	}
+	want := [][]string{{"BF.RESERVE", "f", "0.001", "50"}, {"BF.MADD", "f", "a", "b"}}
+	if !reflect.DeepEqual(*logged, want) {
+		t.Errorf("expected %v, got %v", want, *logged)
+	}
+	replay(t, *logged)
+	info, _ := bfInfoHandler([]string{"f"})
+	if err := SaveSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(TEST_FILE)
+	DefaultStore = NewStore()
+	if err := LoadSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := bfMExistsHandler([]string{"f", "a", "b", "c"}); out != "1,1,0" {
+		t.Errorf("expected 1,1,0 after reload, got %s", out)
+	}
+	if out, _ := bfInfoHandler([]string{"f"}); out != info {
+		t.Errorf("expected info %s after reload, got %s", info, out)
+	}
+	if typ, _ := typeHandler([]string{"f"}); typ != "bloom" {
+		t.Errorf("expected TYPE bloom, got %s", typ)
+	}
+}
@@ -0,0 +1,264 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxSketchCounters bounds how many counters a sketch may have, keeping it
+// within maxStringSize.
+const maxSketchCounters = maxStringSize / 8
+
+// countMinSketch estimates item frequencies in Depth rows of Width counters.
+// An item adds to one counter per row and its estimate is the smallest of
+// them, which is never below its true count and exceeds it by more than
+// Total*e/Width with probability at most e^-Depth. Its fields are exported
+// so snapshots can store it as is.
+type countMinSketch struct {
+	Width, Depth int64
+	Counts       []int64 // row after row
+	Total        int64
+}
+
+func newCountMinSketch(width, depth int64) (*countMinSketch, error) {
+	if width < 1 || depth < 1 {
+		return nil, fmt.Errorf("width and depth must be positive integers")
+	}
+	if width > maxSketchCounters/depth {
+		return nil, fmt.Errorf("sketch is too large")
+	}
+	return &countMinSketch{Width: width, Depth: depth, Counts: make([]int64, width*depth)}, nil
+}
+
+// sketchColumns calls f with item's counter in each of depth rows of width
+// counters, taken from two hashes combined as h1 + row*h2.
+func sketchColumns(item string, width, depth int64, f func(row, col int64)) {
+	h1, h2 := murmurHash64A(item, 0x5eed), murmurHash64A(item, 0xc0de)|1
+	for row := range depth {
+		f(row, int64((h1+uint64(row)*h2)%uint64(width)))
+	}
+}
+
+func (c *countMinSketch) query(item string) int64 {
+	est := int64(math.MaxInt64)
+	sketchColumns(item, c.Width, c.Depth, func(row, col int64) {
+		est = min(est, c.Counts[row*c.Width+col])
+	})
+	return est
+}
+
+func (c *countMinSketch) incr(item string, n int64) {
+	sketchColumns(item, c.Width, c.Depth, func(row, col int64) {
+		c.Counts[row*c.Width+col] += n
+	})
+	c.Total += n
+}
+
+func copySketch(c *countMinSketch) *countMinSketch {
+	cp := *c
+	cp.Counts = append([]int64(nil), c.Counts...)
+	return &cp
+}
+
+// getSketch returns the sketch at key. The caller must hold the write lock.
+func (s *Store) getSketch(key string) (*countMinSketch, bool, error) {
+	exists, err := s.checkType(key, CMSType)
+	if !exists {
+		return nil, false, err
+	}
+	return s.data[key].(*countMinSketch), true, nil
+}
+
+// initSketch stores a new sketch at key, which must not exist yet.
+func initSketch(event, key string, width, depth int64) (string, error) {
+	c, err := newCountMinSketch(width, depth)
+	if err != nil {
+		return "", err
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireIfNeeded(key)
+	if _, exists := s.data[key]; exists {
+		return "", fmt.Errorf("key already exists")
+	}
+	s.setValue(key, CMSType, c)
+	s.notify(notifyModule, event, key)
+	return "OK", nil
+}
+
+// cmsInitByDimHandler implements CMS.INITBYDIM key width depth.
+func cmsInitByDimHandler(args []string) (string, error) {
+	if len(args) != 3 {
+		return "", fmt.Errorf("wrong number of arguments for CMS.INITBYDIM")
+	}
+	width, err1 := strconv.ParseInt(args[1], 10, 64)
+	depth, err2 := strconv.ParseInt(args[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		return "", fmt.Errorf("width and depth must be positive integers")
+	}
+	return initSketch("cms.initbydim", args[0], width, depth)
+}
+
+// cmsInitByProbHandler implements CMS.INITBYPROB key error probability,
+// sizing the sketch so an estimate overshoots by more than error times the
+// total count with at most the given probability.
+func cmsInitByProbHandler(args []string) (string, error) {
+	if len(args) != 3 {
+		return "", fmt.Errorf("wrong number of arguments for CMS.INITBYPROB")
+	}
+	errRate, err1 := strconv.ParseFloat(args[1], 64)
+	prob, err2 := strconv.ParseFloat(args[2], 64)
+	if err1 != nil || err2 != nil || !(errRate > 0 && errRate < 1) || !(prob > 0 && prob < 1) {
+		return "", fmt.Errorf("error and probability must be between 0 and 1")
+	}
+	width := math.Ceil(math.E / errRate)
+	depth := math.Ceil(math.Log(1 / prob))
+	if width > maxSketchCounters {
+		return "", fmt.Errorf("sketch is too large")
+	}
+	return initSketch("cms.initbyprob", args[0], int64(width), int64(depth))
+}
+
+// cmsIncrByHandler implements CMS.INCRBY key item increment [item increment
+// ...], replying with each item's new estimate.
+func cmsIncrByHandler(args []string) (string, error) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return "", fmt.Errorf("wrong number of arguments for CMS.INCRBY")
+	}
+	incrs := make([]int64, 0, len(args)/2)
+	var sum int64
+	for i := 2; i < len(args); i += 2 {
+		n, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil || n < 0 || n > math.MaxInt64-sum {
+			return "", fmt.Errorf("increment must be a non-negative integer")
+		}
+		incrs = append(incrs, n)
+		sum += n
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, exists, err := s.getSketch(args[0])
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("key does not exist")
+	}
+	if sum > math.MaxInt64-c.Total {
+		return "", fmt.Errorf("increment would overflow")
+	}
+	out := make([]string, len(incrs))
+	for i, n := range incrs {
+		c.incr(args[1+2*i], n)
+	}
+	// Estimates are read once all increments are in, so an item given twice
+	// reports the same total both times.
+	for i := range incrs {
+		out[i] = strconv.FormatInt(c.query(args[1+2*i]), 10)
+	}
+	s.notify(notifyModule, "cms.incrby", args[0])
+	return strings.Join(out, ","), nil
+}
+
+// cmsQueryHandler implements CMS.QUERY key item [item ...].
+func cmsQueryHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for CMS.QUERY")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, exists, err := s.getSketch(args[0])
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("key does not exist")
+	}
+	out := make([]string, len(args)-1)
+	for i, item := range args[1:] {
+		out[i] = strconv.FormatInt(c.query(item), 10)
+	}
+	return strings.Join(out, ","), nil
+}
+
+// cmsMergeHandler implements CMS.MERGE dest numkeys source [source ...]
+// [WEIGHTS weight [weight ...]], replacing dest's counts with the weighted
+// sum of the sources'. All the sketches must have the same dimensions.
+func cmsMergeHandler(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("missing argument for CMS.MERGE")
+	}
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil || numKeys < 1 || numKeys > len(args)-2 {
+		return "", fmt.Errorf("invalid numkeys")
+	}
+	keys, rest := args[2:2+numKeys], args[2+numKeys:]
+	weights := make([]int64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	if len(rest) > 0 {
+		if strings.ToUpper(rest[0]) != "WEIGHTS" || len(rest)-1 != numKeys {
+			return "", fmt.Errorf("syntax error")
+		}
+		for i, w := range rest[1:] {
+			if weights[i], err = strconv.ParseInt(w, 10, 64); err != nil {
+				return "", fmt.Errorf("weight is not an integer")
+			}
+		}
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dest, exists, err := s.getSketch(args[0])
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("key does not exist")
+	}
+	counts := make([]int64, len(dest.Counts))
+	var total int64
+	for i, key := range keys {
+		src, exists, err := s.getSketch(key)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return "", fmt.Errorf("key does not exist")
+		}
+		if src.Width != dest.Width || src.Depth != dest.Depth {
+			return "", fmt.Errorf("width and depth must match")
+		}
+		for j, n := range src.Counts {
+			counts[j] += n * weights[i]
+		}
+		total += src.Total * weights[i]
+	}
+	dest.Counts, dest.Total = counts, total
+	s.notify(notifyModule, "cms.merge", args[0])
+	return "OK", nil
+}
+
+// cmsInfoHandler implements CMS.INFO key, replying with name,value pairs.
+func cmsInfoHandler(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("wrong number of arguments for CMS.INFO")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, exists, err := s.getSketch(args[0])
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("key does not exist")
+	}
+	return fmt.Sprintf("width,%d,depth,%d,count,%d", c.Width, c.Depth, c.Total), nil
+}
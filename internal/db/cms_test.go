@@ -0,0 +1,104 @@
+package db
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCMSIncrQuery(t *testing.T) {
+	DefaultStore = NewStore()
+	if out, err := cmsInitByDimHandler([]string{"hits", "2000", "5"}); err != nil || out != "OK" {
+		t.Fatalf("expected OK, got %s (%v)", out, err)
+	}
+	if out, _ := cmsIncrByHandler([]string{"hits", "a", "3", "b", "1", "a", "2"}); out != "5,1,5" {
+		t.Errorf("expected 5,1,5, got %s", out)
+	}
+	if out, _ := cmsQueryHandler([]string{"hits", "a", "b", "c"}); out != "5,1,0" {
+		t.Errorf("expected 5,1,0, got %s", out)
+	}
+	// Estimates never fall below the true counts and overshoot by little.
+	for i := range 1000 {
+		_, _ = cmsIncrByHandler([]string{"hits", "k" + strconv.Itoa(i), strconv.Itoa(i%10 + 1)})
+	}
+	for i := range 1000 {
+		out, _ := cmsQueryHandler([]string{"hits", "k" + strconv.Itoa(i)})
+		n, _ := strconv.Atoi(out)
+		if want := i%10 + 1; n < want || n > want+20 {
+			t.Errorf("k%d: expected about %d, got %d", i, want, n)
+		}
+	}
+	if out, _ := cmsInfoHandler([]string{"hits"}); out != "width,2000,depth,5,count,5506" {
+		t.Errorf("unexpected info %s", out)
+	}
+
+	if out, err := cmsInitByProbHandler([]string{"prob", "0.001", "0.01"}); err != nil || out != "OK" {
+		t.Fatalf("expected OK, got %s (%v)", out, err)
+	}
+	if out, _ := cmsInfoHandler([]string{"prob"}); out != "width,2719,depth,5,count,0" {
+		t.Errorf("unexpected info %s", out)
+	}
+	for _, bad := range [][]string{
+		{"CMS.INITBYDIM", "hits", "10", "2"}, {"CMS.INITBYDIM", "x", "0", "2"},
+		{"CMS.INITBYDIM", "x", "1000000000", "1000"}, {"CMS.INITBYPROB", "x", "1", "0.5"},
+		{"CMS.INCRBY", "missing", "a", "1"}, {"CMS.INCRBY", "hits", "a", "-1"}, {"CMS.INCRBY", "hits", "a"},
+		{"CMS.QUERY", "missing", "a"},
+	} {
+		if _, err := Commands[bad[0]](bad[1:]); err == nil {
+			t.Errorf("expected %v to fail", bad)
+		}
+	}
+}
+
+func TestCMSMerge(t *testing.T) {
+	DefaultStore = NewStore()
+	for _, key := range []string{"a", "b", "dest"} {
+		_, _ = cmsInitByDimHandler([]string{key, "100", "3"})
+	}
+	_, _ = cmsInitByDimHandler([]string{"small", "10", "3"})
+	_, _ = cmsIncrByHandler([]string{"a", "x", "2", "y", "1"})
+	_, _ = cmsIncrByHandler([]string{"b", "x", "5"})
+	if out, err := cmsMergeHandler([]string{"dest", "2", "a", "b", "WEIGHTS", "1", "3"}); err != nil || out != "OK" {
+		t.Fatalf("expected OK, got %s (%v)", out, err)
+	}
+	if out, _ := cmsQueryHandler([]string{"dest", "x", "y"}); out != "17,1" {
+		t.Errorf("expected 17,1, got %s", out)
+	}
+	if out, _ := cmsInfoHandler([]string{"dest"}); !strings.HasSuffix(out, "count,18") {
+		t.Errorf("unexpected info %s", out)
+	}
+	// The destination may be one of the sources.
+	_, _ = cmsMergeHandler([]string{"a", "2", "a", "a"})
+	if out, _ := cmsQueryHandler([]string{"a", "x"}); out != "4" {
+		t.Errorf("expected 4, got %s", out)
+	}
+	for _, bad := range [][]string{
+		{"dest", "1", "small"}, {"dest", "1", "missing"}, {"dest", "3", "a", "b"},
+		{"dest", "2", "a", "b", "WEIGHTS", "1"},
+	} {
+		if _, err := cmsMergeHandler(bad); err == nil {
+			t.Errorf("expected CMS.MERGE %v to fail", bad)
+		}
+	}
+}
+
+func TestCMSSnapshot(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = cmsInitByDimHandler([]string{"hits", "50", "4"})
+	_, _ = cmsIncrByHandler([]string{"hits", "a", "7"})
+	if err := SaveSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(TEST_FILE)
+	DefaultStore = NewStore()
+	if err := LoadSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := cmsQueryHandler([]string{"hits", "a"}); out != "7" {
+		t.Errorf("expected 7 after reload, got %s", out)
+	}
+	if typ, _ := typeHandler([]string{"hits"}); typ != "cms" {
+		t.Errorf("expected TYPE cms, got %s", typ)
+	}
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	errNotInteger = fmt.Errorf("value is not an integer or out of range")
+	errNotFloat   = fmt.Errorf("value is not a valid float")
+)
+
+// incrBy adds delta to the integer stored at key, treating a missing key as 0.
+func incrBy(key string, delta int64) (string, error) {
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	val, exists, err := DefaultStore.getString(key)
+	if err != nil {
+		return "", err
+	}
+	var cur int64
+	if exists {
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return "", errNotInteger
+		}
+		cur = n
+	}
+	if (delta > 0 && cur > math.MaxInt64-delta) || (delta < 0 && cur < math.MinInt64-delta) {
+		return "", fmt.Errorf("increment or decrement would overflow")
+	}
+	cur += delta
+	out := strconv.FormatInt(cur, 10)
+	DefaultStore.setValue(key, StringType, out)
+	DefaultStore.notify(notifyString, "incrby", key)
+	return out, nil
+}
+
+func incrHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for INCR")
+	}
+	return incrBy(args[0], 1)
+}
+
+func decrHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for DECR")
+	}
+	return incrBy(args[0], -1)
+}
+
+func incrbyHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for INCRBY")
+	}
+	delta, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return "", errNotInteger
+	}
+	return incrBy(args[0], delta)
+}
+
+func decrbyHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for DECRBY")
+	}
+	delta, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || delta == math.MinInt64 {
+		return "", errNotInteger
+	}
+	return incrBy(args[0], -delta)
+}
+
+func incrbyfloatHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for INCRBYFLOAT")
+	}
+	key := args[0]
+	delta, err := parseFloat(args[1])
+	if err != nil {
+		return "", errNotFloat
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	val, exists, err := DefaultStore.getString(key)
+	if err != nil {
+		return "", err
+	}
+	var cur float64
+	if exists {
+		if cur, err = parseFloat(val); err != nil {
+			return "", errNotFloat
+		}
+	}
+	cur += delta
+	if math.IsNaN(cur) || math.IsInf(cur, 0) {
+		return "", fmt.Errorf("increment would produce NaN or Infinity")
+	}
+	out := strconv.FormatFloat(cur, 'f', -1, 64)
+	DefaultStore.setValue(key, StringType, out)
+	DefaultStore.notify(notifyString, "incrbyfloat", key)
+	return out, nil
+}
+
+// parseFloat parses a finite float, rejecting NaN, infinities and padding.
+func parseFloat(s string) (float64, error) {
+	if s != strings.TrimSpace(s) {
+		return 0, errNotFloat
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, errNotFloat
+	}
+	return f, nil
+}
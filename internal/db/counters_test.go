@@ -0,0 +1,64 @@
+package db
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestIncrDecrCommands(t *testing.T) {
+	DefaultStore = NewStore()
+	if v, _ := incrHandler([]string{"hits"}); v != "1" {
+		t.Errorf("expected 1 from INCR on missing key, got %s", v)
+	}
+	if v, _ := incrbyHandler([]string{"hits", "10"}); v != "11" {
+		t.Errorf("expected 11 from INCRBY, got %s", v)
+	}
+	if v, _ := decrHandler([]string{"hits"}); v != "10" {
+		t.Errorf("expected 10 from DECR, got %s", v)
+	}
+	if v, _ := decrbyHandler([]string{"hits", "15"}); v != "-5" {
+		t.Errorf("expected -5 from DECRBY, got %s", v)
+	}
+
+	_, _ = setHandler([]string{"word", "abc"})
+	if _, err := incrHandler([]string{"word"}); err != errNotInteger {
+		t.Errorf("expected not-an-integer error, got %v", err)
+	}
+	if _, err := incrbyHandler([]string{"hits", "1.5"}); err != errNotInteger {
+		t.Errorf("expected not-an-integer error for float increment, got %v", err)
+	}
+
+	_, _ = setHandler([]string{"big", strconv.FormatInt(math.MaxInt64, 10)})
+	if _, err := incrHandler([]string{"big"}); err == nil {
+		t.Error("expected overflow error")
+	}
+	if v, _ := getHandler([]string{"big"}); v != strconv.FormatInt(math.MaxInt64, 10) {
+		t.Errorf("expected value unchanged after overflow, got %s", v)
+	}
+
+	_, _ = setHandler([]string{"ttlkey", "1", "EX", "100"})
+	_, _ = incrHandler([]string{"ttlkey"})
+	if ttl, _ := ttlHandler([]string{"ttlkey"}); ttl != "100" {
+		t.Errorf("expected INCR to keep TTL, got %s", ttl)
+	}
+}
+
+func TestIncrByFloat(t *testing.T) {
+	DefaultStore = NewStore()
+	if v, _ := incrbyfloatHandler([]string{"f", "10.5"}); v != "10.5" {
+		t.Errorf("expected 10.5, got %s", v)
+	}
+	if v, _ := incrbyfloatHandler([]string{"f", "0.1"}); v != "10.6" {
+		t.Errorf("expected 10.6, got %s", v)
+	}
+	if v, _ := incrbyfloatHandler([]string{"f", "-5.6"}); v != "5" {
+		t.Errorf("expected 5, got %s", v)
+	}
+	if _, err := incrbyfloatHandler([]string{"f", "inf"}); err == nil {
+		t.Error("expected error for infinite increment")
+	}
+	if v, _ := incrHandler([]string{"f"}); v != "6" {
+		t.Errorf("expected integer-looking float to INCR to 6, got %s", v)
+	}
+}
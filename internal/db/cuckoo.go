@@ -0,0 +1,432 @@
+package db
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Filters CF.ADD creates on its own use these settings.
+const (
+	defaultCuckooCapacity      = 1024
+	defaultCuckooBucketSize    = 2
+	defaultCuckooMaxIterations = 20
+	defaultCuckooExpansion     = 1
+)
+
+// cuckooTable is one cuckoo hash table of 16-bit fingerprints. Slots holds
+// NumBuckets buckets of the filter's bucket size, with 0 for an empty slot.
+type cuckooTable struct {
+	Slots      []uint16
+	NumBuckets uint64
+}
+
+// cuckooFilter is a cuckoo filter: like a Bloom filter it answers "maybe"
+// or "no", but it stores a fingerprint per item, so items can be deleted and
+// counted. When an item does not fit in the newest table even after moving
+// MaxIterations others aside, a table Expansion times larger is added; with
+// Expansion 0 the filter is full instead. Its fields are exported so
+// snapshots can store it as is.
+type cuckooFilter struct {
+	Tables        []*cuckooTable
+	BucketSize    int
+	MaxIterations int
+	Expansion     int64
+	Items         int64
+	Deleted       int64
+}
+
+// cuckooBuckets returns how many buckets hold capacity items, rounded up to
+// a power of two so the alternate bucket can be found by XOR.
+func cuckooBuckets(capacity int64, bucketSize int) uint64 {
+	n := uint64((capacity + int64(bucketSize) - 1) / int64(bucketSize))
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len64(n-1)
+}
+
+func newCuckooTable(numBuckets uint64, bucketSize int) *cuckooTable {
+	return &cuckooTable{Slots: make([]uint16, numBuckets*uint64(bucketSize)), NumBuckets: numBuckets}
+}
+
+// cuckooHash returns item's fingerprint and the index its first bucket is
+// taken from.
+func cuckooHash(item string) (uint16, uint64) {
+	h := murmurHash64A(item, 0xc0c0a)
+	fp := uint16(h >> 48)
+	if fp == 0 {
+		fp = 1
+	}
+	return fp, h
+}
+
+// altBucket returns the other bucket fp may live in. Applied to either of an
+// item's buckets it returns the other one.
+func (t *cuckooTable) altBucket(i uint64, fp uint16) uint64 {
+	return (i ^ uint64(fp)*0x5bd1e995) & (t.NumBuckets - 1)
+}
+
+func (t *cuckooTable) buckets(fp uint16, h uint64) (uint64, uint64) {
+	i := h & (t.NumBuckets - 1)
+	return i, t.altBucket(i, fp)
+}
+
+func (t *cuckooTable) bucket(i uint64, size int) []uint16 {
+	return t.Slots[i*uint64(size) : (i+1)*uint64(size)]
+}
+
+// count returns how many times fp is stored in buckets i and j.
+func (t *cuckooTable) count(fp uint16, i, j uint64, size int) int64 {
+	var n int64
+	for _, b := range []uint64{i, j} {
+		for _, slot := range t.bucket(b, size) {
+			if slot == fp {
+				n++
+			}
+		}
+		if i == j {
+			break
+		}
+	}
+	return n
+}
+
+// place stores fp in an empty slot of bucket i, reporting whether there was
+// one.
+func (t *cuckooTable) place(fp uint16, i uint64, size int) bool {
+	b := t.bucket(i, size)
+	for k, slot := range b {
+		if slot == 0 {
+			b[k] = fp
+			return true
+		}
+	}
+	return false
+}
+
+// remove clears one slot holding fp in bucket i or j.
+func (t *cuckooTable) remove(fp uint16, i, j uint64, size int) bool {
+	for _, b := range []uint64{i, j} {
+		bucket := t.bucket(b, size)
+		for k, slot := range bucket {
+			if slot == fp {
+				bucket[k] = 0
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// count returns how many times item may have been added.
+func (cf *cuckooFilter) count(item string) int64 {
+	fp, h := cuckooHash(item)
+	var n int64
+	for _, t := range cf.Tables {
+		i, j := t.buckets(fp, h)
+		n += t.count(fp, i, j, cf.BucketSize)
+	}
+	return n
+}
+
+// add stores item's fingerprint.
+func (cf *cuckooFilter) add(item string) error {
+	fp, h := cuckooHash(item)
+	for _, t := range cf.Tables {
+		i, j := t.buckets(fp, h)
+		if t.place(fp, i, cf.BucketSize) || t.place(fp, j, cf.BucketSize) {
+			cf.Items++
+			return nil
+		}
+	}
+	last := cf.Tables[len(cf.Tables)-1]
+	if cf.kick(last, fp, h) {
+		cf.Items++
+		return nil
+	}
+	if cf.Expansion == 0 {
+		return fmt.Errorf("filter is full")
+	}
+	numBuckets := last.NumBuckets * uint64(cf.Expansion)
+	if cf.slots()+numBuckets*uint64(cf.BucketSize) > maxStringSize/2 {
+		return fmt.Errorf("filter is too large to grow")
+	}
+	// Expansion need not be a power of two, but the bucket count must be.
+	next := newCuckooTable(1<<(bits.Len64(numBuckets)-1), cf.BucketSize)
+	i, _ := next.buckets(fp, h)
+	next.place(fp, i, cf.BucketSize)
+	cf.Tables = append(cf.Tables, next)
+	cf.Items++
+	return nil
+}
+
+// kick makes room for fp in t by moving fingerprints to their other
+// buckets, at most MaxIterations times, and undoes the moves if that fails.
+// The slot to evict is picked in turn rather than at random, so replaying
+// the same adds builds the same filter.
+func (cf *cuckooFilter) kick(t *cuckooTable, fp uint16, h uint64) bool {
+	type move struct {
+		slot *uint16
+		old  uint16
+	}
+	var moves []move
+	i, _ := t.buckets(fp, h)
+	for n := range cf.MaxIterations {
+		slot := &t.bucket(i, cf.BucketSize)[n%cf.BucketSize]
+		moves = append(moves, move{slot, *slot})
+		fp, *slot = *slot, fp
+		i = t.altBucket(i, fp)
+		if t.place(fp, i, cf.BucketSize) {
+			return true
+		}
+	}
+	for k := len(moves) - 1; k >= 0; k-- {
+		*moves[k].slot = moves[k].old
+	}
+	return false
+}
+
+// del removes one copy of item's fingerprint, newest table first.
+func (cf *cuckooFilter) del(item string) bool {
+	fp, h := cuckooHash(item)
+	for k := len(cf.Tables) - 1; k >= 0; k-- {
+		t := cf.Tables[k]
+		i, j := t.buckets(fp, h)
+		if t.remove(fp, i, j, cf.BucketSize) {
+			cf.Items--
+			cf.Deleted++
+			return true
+		}
+	}
+	return false
+}
+
+// slots returns how many slots the filter's tables have.
+func (cf *cuckooFilter) slots() uint64 {
+	var n uint64
+	for _, t := range cf.Tables {
+		n += uint64(len(t.Slots))
+	}
+	return n
+}
+
+func copyCuckoo(cf *cuckooFilter) *cuckooFilter {
+	cp := *cf
+	cp.Tables = make([]*cuckooTable, len(cf.Tables))
+	for i, t := range cf.Tables {
+		cp.Tables[i] = &cuckooTable{Slots: append([]uint16(nil), t.Slots...), NumBuckets: t.NumBuckets}
+	}
+	return &cp
+}
+
+// getCuckoo returns the cuckoo filter at key. The caller must hold the
+// write lock.
+func (s *Store) getCuckoo(key string) (*cuckooFilter, bool, error) {
+	exists, err := s.checkType(key, CuckooType)
+	if !exists {
+		return nil, false, err
+	}
+	return s.data[key].(*cuckooFilter), true, nil
+}
+
+// cfReserveHandler implements CF.RESERVE key capacity [BUCKETSIZE n]
+// [MAXITERATIONS n] [EXPANSION n].
+func cfReserveHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for CF.RESERVE")
+	}
+	capacity, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || capacity < 1 {
+		return "", fmt.Errorf("capacity must be a positive integer")
+	}
+	bucketSize, maxIterations := int64(defaultCuckooBucketSize), int64(defaultCuckooMaxIterations)
+	expansion := int64(defaultCuckooExpansion)
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return "", fmt.Errorf("syntax error")
+		}
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "BUCKETSIZE" && err == nil && n >= 1 && n <= 255:
+			bucketSize = n
+		case opt == "MAXITERATIONS" && err == nil && n >= 1 && n <= 65535:
+			maxIterations = n
+		case opt == "EXPANSION" && err == nil && n >= 0 && n <= 32768:
+			expansion = n
+		case opt == "BUCKETSIZE" || opt == "MAXITERATIONS" || opt == "EXPANSION":
+			return "", fmt.Errorf("%s is out of range", strings.ToLower(opt))
+		default:
+			return "", fmt.Errorf("syntax error")
+		}
+	}
+	if capacity > maxStringSize/4 {
+		return "", fmt.Errorf("filter is too large")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireIfNeeded(args[0])
+	if _, exists := s.data[args[0]]; exists {
+		return "", fmt.Errorf("item exists")
+	}
+	s.setValue(args[0], CuckooType, newCuckooFilter(capacity, int(bucketSize), int(maxIterations), expansion))
+	s.notify(notifyModule, "cf.reserve", args[0])
+	return "OK", nil
+}
+
+func newCuckooFilter(capacity int64, bucketSize, maxIterations int, expansion int64) *cuckooFilter {
+	return &cuckooFilter{
+		Tables:        []*cuckooTable{newCuckooTable(cuckooBuckets(capacity, bucketSize), bucketSize)},
+		BucketSize:    bucketSize,
+		MaxIterations: maxIterations,
+		Expansion:     expansion,
+	}
+}
+
+// cuckooAdd implements CF.ADD and, with nx set, CF.ADDNX, which adds
+// nothing and replies 0 if item may already be in the filter.
+func cuckooAdd(name string, args []string, nx bool) (string, error) {
+	if len(args) != 2 {
+		return "", fmt.Errorf("wrong number of arguments for %s", name)
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cf, exists, err := s.getCuckoo(args[0])
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		cf = newCuckooFilter(defaultCuckooCapacity, defaultCuckooBucketSize, defaultCuckooMaxIterations, defaultCuckooExpansion)
+		s.setValue(args[0], CuckooType, cf)
+	}
+	if nx && cf.count(args[1]) > 0 {
+		return "0", nil
+	}
+	if err := cf.add(args[1]); err != nil {
+		return "", err
+	}
+	s.notify(notifyModule, "cf.add", args[0])
+	return "1", nil
+}
+
+func cfAddHandler(args []string) (string, error) {
+	return cuckooAdd("CF.ADD", args, false)
+}
+
+func cfAddNXHandler(args []string) (string, error) {
+	return cuckooAdd("CF.ADDNX", args, true)
+}
+
+// cuckooExists replies with 1 for each item that may be in the filter at
+// key.
+func cuckooExists(key string, items []string) (string, error) {
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cf, exists, err := s.getCuckoo(key)
+	if err != nil {
+		return "", err
+	}
+	out := make([]string, len(items))
+	for i, item := range items {
+		out[i] = "0"
+		if exists && cf.count(item) > 0 {
+			out[i] = "1"
+		}
+	}
+	return strings.Join(out, ","), nil
+}
+
+// cfExistsHandler implements CF.EXISTS key item.
+func cfExistsHandler(args []string) (string, error) {
+	if len(args) != 2 {
+		return "", fmt.Errorf("wrong number of arguments for CF.EXISTS")
+	}
+	return cuckooExists(args[0], args[1:])
+}
+
+// cfMExistsHandler implements CF.MEXISTS key item [item ...].
+func cfMExistsHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for CF.MEXISTS")
+	}
+	return cuckooExists(args[0], args[1:])
+}
+
+// cfDelHandler implements CF.DEL key item, removing one copy of item.
+// Deleting an item that was never added may remove another item that shares
+// its fingerprint.
+func cfDelHandler(args []string) (string, error) {
+	if len(args) != 2 {
+		return "", fmt.Errorf("wrong number of arguments for CF.DEL")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cf, exists, err := s.getCuckoo(args[0])
+	if !exists || err != nil {
+		return "0", err
+	}
+	if !cf.del(args[1]) {
+		return "0", nil
+	}
+	s.notify(notifyModule, "cf.del", args[0])
+	return "1", nil
+}
+
+// cfCountHandler implements CF.COUNT key item.
+func cfCountHandler(args []string) (string, error) {
+	if len(args) != 2 {
+		return "", fmt.Errorf("wrong number of arguments for CF.COUNT")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cf, exists, err := s.getCuckoo(args[0])
+	if !exists || err != nil {
+		return "0", err
+	}
+	return strconv.FormatInt(cf.count(args[1]), 10), nil
+}
+
+// cfInfoHandler implements CF.INFO key, replying with name,value pairs.
+func cfInfoHandler(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("wrong number of arguments for CF.INFO")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cf, exists, err := s.getCuckoo(args[0])
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("not found")
+	}
+	var buckets uint64
+	for _, t := range cf.Tables {
+		buckets += t.NumBuckets
+	}
+	stats := []struct {
+		name string
+		n    int64
+	}{
+		{"size", int64(cf.slots()) * 2},
+		{"buckets", int64(buckets)},
+		{"filters", int64(len(cf.Tables))},
+		{"items", cf.Items},
+		{"deleted", cf.Deleted},
+		{"bucketsize", int64(cf.BucketSize)},
+		{"expansion", cf.Expansion},
+		{"maxiterations", int64(cf.MaxIterations)},
+	}
+	out := make([]string, 0, 2*len(stats))
+	for _, st := range stats {
+		out = append(out, st.name, strconv.FormatInt(st.n, 10))
+	}
+	return strings.Join(out, ","), nil
+}
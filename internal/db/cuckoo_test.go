@@ -0,0 +1,120 @@
+package db
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCuckooAddDelete(t *testing.T) {
+	DefaultStore = NewStore()
+	for _, cmd := range [][]string{{"seen", "a"}, {"seen", "a"}, {"seen", "b"}} {
+		if out, err := cfAddHandler(cmd); err != nil || out != "1" {
+			t.Fatalf("CF.ADD %v: expected 1, got %s (%v)", cmd, out, err)
+		}
+	}
+	if out, _ := cfAddNXHandler([]string{"seen", "b"}); out != "0" {
+		t.Errorf("expected CF.ADDNX of a present item to reply 0, got %s", out)
+	}
+	if out, _ := cfCountHandler([]string{"seen", "a"}); out != "2" {
+		t.Errorf("expected a to be counted twice, got %s", out)
+	}
+	if out, _ := cfMExistsHandler([]string{"seen", "a", "b", "c"}); out != "1,1,0" {
+		t.Errorf("expected 1,1,0, got %s", out)
+	}
+	for _, want := range []string{"1", "1", "0"} {
+		if out, _ := cfDelHandler([]string{"seen", "a"}); out != want {
+			t.Errorf("CF.DEL: expected %s, got %s", want, out)
+		}
+	}
+	if out, _ := cfExistsHandler([]string{"seen", "a"}); out != "0" {
+		t.Errorf("expected a to be gone, got %s", out)
+	}
+	if out, _ := cfInfoHandler([]string{"seen"}); !strings.Contains(out, "items,1,deleted,2,") {
+		t.Errorf("unexpected info %s", out)
+	}
+	if out, _ := cfDelHandler([]string{"missing", "a"}); out != "0" {
+		t.Errorf("expected 0 for a missing key, got %s", out)
+	}
+	for _, bad := range [][]string{
+		{"seen", "10"}, {"f", "0"}, {"f", "10", "BUCKETSIZE", "0"}, {"f", "10", "MAXITERATIONS"},
+		{"f", "10", "BOGUS", "1"}, {"f", "1000000000000"},
+	} {
+		if _, err := cfReserveHandler(bad); err == nil {
+			t.Errorf("expected CF.RESERVE %v to fail", bad)
+		}
+	}
+}
+
+func TestCuckooGrowth(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = cfReserveHandler([]string{"ids", "1000", "BUCKETSIZE", "4", "EXPANSION", "2"})
+	for i := range 5000 {
+		if _, err := cfAddHandler([]string{"ids", "id:" + strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cf := DefaultStore.data["ids"].(*cuckooFilter)
+	if len(cf.Tables) < 2 {
+		t.Errorf("expected the filter to grow, it has %d tables", len(cf.Tables))
+	}
+	for i := range 5000 {
+		if out, _ := cfExistsHandler([]string{"ids", "id:" + strconv.Itoa(i)}); out != "1" {
+			t.Fatalf("expected id:%d to be found", i)
+		}
+	}
+	falsePositives := 0
+	for i := range 10000 {
+		if out, _ := cfExistsHandler([]string{"ids", "other:" + strconv.Itoa(i)}); out == "1" {
+			falsePositives++
+		}
+	}
+	if falsePositives > 20 {
+		t.Errorf("expected few false positives, got %d in 10000", falsePositives)
+	}
+	for i := range 5000 {
+		if out, _ := cfDelHandler([]string{"ids", "id:" + strconv.Itoa(i)}); out != "1" {
+			t.Fatalf("expected id:%d to be deleted", i)
+		}
+	}
+	if cf.slots() == 0 || cf.Items != 0 {
+		t.Errorf("expected an empty filter, %d items left", cf.Items)
+	}
+
+	_, _ = cfReserveHandler([]string{"fixed", "4", "BUCKETSIZE", "1", "EXPANSION", "0"})
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		_, err = cfAddHandler([]string{"fixed", strconv.Itoa(i)})
+	}
+	if err == nil {
+		t.Error("expected a filter with EXPANSION 0 to fill up")
+	}
+}
+
+func TestCuckooSnapshot(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = cfReserveHandler([]string{"f", "100"})
+	for _, item := range []string{"a", "b", "b"} {
+		_, _ = cfAddHandler([]string{"f", item})
+	}
+	_, _ = cfDelHandler([]string{"f", "a"})
+	info, _ := cfInfoHandler([]string{"f"})
+	if err := SaveSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(TEST_FILE)
+	DefaultStore = NewStore()
+	if err := LoadSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := cfCountHandler([]string{"f", "b"}); out != "2" {
+		t.Errorf("expected b twice after reload, got %s", out)
+	}
+	if out, _ := cfInfoHandler([]string{"f"}); out != info {
+		t.Errorf("expected info %s after reload, got %s", info, out)
+	}
+	if typ, _ := typeHandler([]string{"f"}); typ != "cuckoo" {
+		t.Errorf("expected TYPE cuckoo, got %s", typ)
+	}
+}
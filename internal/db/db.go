@@ -2,9 +2,12 @@ package db
 
 import (
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -16,20 +19,69 @@ const (
 	StringType valueType = iota
 	ListType
 	SetType
+	StreamType
+	QueueType
+	JSONType
+	HLLType
+	BloomType
+	CuckooType
+	CMSType
+	TopKType
+	GeoType
+	TimeSeriesType
+	VectorType
 )
 
+// ErrWrongType is returned when a command is used against a key holding a
+// value of another type. Its message carries its own error code, so replies
+// send it without the usual ERR prefix.
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
 type Store struct {
 	mu    sync.RWMutex
 	data  map[string]any
 	types map[string]valueType
-	ttl   map[string]int64 // key -> unix expiration, 0 means no expiry
+	ttl   map[string]int64 // key -> unix expiration in milliseconds, 0 means no expiry
+
+	slots [scanSlots]map[string]struct{} // keys grouped by hash slot for SCAN
+
+	memberOrders map[string]memberOrder // set key -> members in SSCAN order, while scanned
+
+	listWaiters map[string][]*listWaiter // key -> clients blocked on it, oldest first
+	readyLists  []string                 // keys pushed to since the last serveBlockedLists
+
+	streamWaiters map[string][]*streamWaiter // key -> clients blocked on it, oldest first
+
+	events eventClass // keyspace notifications to publish
+
+	schedules       map[uint64]*schedule
+	nextScheduleID  uint64
+	scheduleWake    chan struct{} // signalled when schedules change
+	scheduleStarted int64         // unix ms the scheduler started, 0 if not running
+
+	triggers map[string]*trigger // name -> trigger
+
+	vectorIndexes map[string]*vectorIndex // name -> index
+
+	dirty int64      // count of changes, to tell writes from reads
+	log   commandLog // what the running command appends to the log
 }
 
 func NewStore() *Store {
 	store := &Store{
-		data:  make(map[string]any),
-		types: make(map[string]valueType),
-		ttl:   make(map[string]int64),
+		data:        make(map[string]any),
+		types:       make(map[string]valueType),
+		ttl:         make(map[string]int64),
+		listWaiters: make(map[string][]*listWaiter),
+
+		streamWaiters: make(map[string][]*streamWaiter),
+
+		schedules:    make(map[uint64]*schedule),
+		scheduleWake: make(chan struct{}, 1),
+
+		triggers: make(map[string]*trigger),
+
+		vectorIndexes: make(map[string]*vectorIndex),
 	}
 	go store.ttlCleaner()
 	return store
@@ -40,39 +92,186 @@ var DefaultStore = NewStore()
 type HandlerFunc func(args []string) (string, error)
 
 var Commands = map[string]HandlerFunc{
-	"SET":      setHandler,
-	"GET":      getHandler,
-	"DEL":      delHandler,
-	"EXISTS":   existsHandler,
-	"LPUSH":    lpushHandler,
-	"RPUSH":    rpushHandler,
-	"LPOP":     lpopHandler,
-	"RPOP":     rpopHandler,
-	"LRANGE":   lrangeHandler,
-	"SADD":     saddHandler,
-	"SREM":     sremHandler,
-	"SMEMBERS": smembersHandler,
-	"KEYS":     keysHandler,
-	"FLUSHDB":  flushdbHandler,
-	"INFO":     infoHandler,
-	"EXPIRE":   expireHandler,
-	"TTL":      ttlHandler,
-	"SAVE":     snapshotHandler,
+	"SET":         setHandler,
+	"GET":         getHandler,
+	"DEL":         delHandler,
+	"EXISTS":      existsHandler,
+	"LPUSH":       lpushHandler,
+	"RPUSH":       rpushHandler,
+	"LPOP":        lpopHandler,
+	"RPOP":        rpopHandler,
+	"LRANGE":      lrangeHandler,
+	"SADD":        saddHandler,
+	"SREM":        sremHandler,
+	"SMEMBERS":    smembersHandler,
+	"KEYS":        keysHandler,
+	"FLUSHDB":     flushdbHandler,
+	"INFO":        infoHandler,
+	"EXPIRE":      expireHandler,
+	"PEXPIREAT":   pexpireatHandler,
+	"TTL":         ttlHandler,
+	"SAVE":        snapshotHandler,
+	"SETNX":       setnxHandler,
+	"GETSET":      getsetHandler,
+	"GETDEL":      getdelHandler,
+	"GETEX":       getexHandler,
+	"MSET":        msetHandler,
+	"MSETNX":      msetnxHandler,
+	"MGET":        mgetHandler,
+	"INCR":        incrHandler,
+	"DECR":        decrHandler,
+	"INCRBY":      incrbyHandler,
+	"DECRBY":      decrbyHandler,
+	"INCRBYFLOAT": incrbyfloatHandler,
+	"APPEND":      appendHandler,
+	"STRLEN":      strlenHandler,
+	"GETRANGE":    getrangeHandler,
+	"SETRANGE":    setrangeHandler,
+	"LCS":         lcsHandler,
+	"SETBIT":      setbitHandler,
+	"GETBIT":      getbitHandler,
+	"BITCOUNT":    bitcountHandler,
+	"BITPOS":      bitposHandler,
+	"BITOP":       bitopHandler,
+	"BITFIELD":    bitfieldHandler,
+	"LPUSHX":      lpushxHandler,
+	"RPUSHX":      rpushxHandler,
+	"LLEN":        llenHandler,
+	"LINDEX":      lindexHandler,
+	"LSET":        lsetHandler,
+	"LINSERT":     linsertHandler,
+	"LREM":        lremHandler,
+	"LTRIM":       ltrimHandler,
+	"LPOS":        lposHandler,
+	"LMOVE":       lmoveHandler,
+	"RPOPLPUSH":   rpoplpushHandler,
+	"SISMEMBER":   sismemberHandler,
+	"SMISMEMBER":  smismemberHandler,
+	"SCARD":       scardHandler,
+	"SPOP":        spopHandler,
+	"SRANDMEMBER": srandmemberHandler,
+	"SMOVE":       smoveHandler,
+	"SUNION":      sunionHandler,
+	"SINTER":      sinterHandler,
+	"SDIFF":       sdiffHandler,
+	"SUNIONSTORE": sunionstoreHandler,
+	"SINTERSTORE": sinterstoreHandler,
+	"SDIFFSTORE":  sdiffstoreHandler,
+	"SINTERCARD":  sintercardHandler,
+	"SCAN":        scanHandler,
+	"SSCAN":       sscanHandler,
+	"TYPE":        typeHandler,
+	"RENAME":      renameHandler,
+	"RENAMENX":    renamenxHandler,
+	"COPY":        copyHandler,
+	"TOUCH":       touchHandler,
+	"RANDOMKEY":   randomkeyHandler,
+	"DBSIZE":      dbsizeHandler,
+	"UNLINK":      unlinkHandler,
+	"SORT":        sortHandler,
+	"SORT_RO":     sortROHandler,
+	"PUBLISH":     publishHandler,
+	"PUBSUB":      pubsubHandler,
+	"CONFIG":      configHandler,
+	"XADD":        xaddHandler,
+	"XTRIM":       xtrimHandler,
+	"XLEN":        xlenHandler,
+	"XRANGE":      xrangeHandler,
+	"XREVRANGE":   xrevrangeHandler,
+	"XGROUP":      xgroupHandler,
+	"XACK":        xackHandler,
+	"XPENDING":    xpendingHandler,
+	"XCLAIM":      xclaimHandler,
+	"XAUTOCLAIM":  xautoclaimHandler,
+	"QADD":        qaddHandler,
+	"QRESERVE":    qreserveHandler,
+	"QACK":        qackHandler,
+	"QNACK":       qnackHandler,
+	"QDEAD":       qdeadHandler,
+	"QREQUEUE":    qrequeueHandler,
+	"QSTATS":      qstatsHandler,
+	"TRIGGER":     triggerHandler,
+
+	"JSON.SET":       jsonSetHandler,
+	"JSON.GET":       jsonGetHandler,
+	"JSON.DEL":       jsonDelHandler,
+	"JSON.TYPE":      jsonTypeHandler,
+	"JSON.ARRAPPEND": jsonArrAppendHandler,
+	"JSON.NUMINCRBY": jsonNumIncrByHandler,
+	"JSON.OBJKEYS":   jsonObjKeysHandler,
+
+	"PFADD":   pfaddHandler,
+	"PFCOUNT": pfcountHandler,
+	"PFMERGE": pfmergeHandler,
+
+	"BF.RESERVE": bfReserveHandler,
+	"BF.ADD":     bfAddHandler,
+	"BF.MADD":    bfMAddHandler,
+	"BF.EXISTS":  bfExistsHandler,
+	"BF.MEXISTS": bfMExistsHandler,
+	"BF.INFO":    bfInfoHandler,
+	"CF.RESERVE": cfReserveHandler,
+	"CF.ADD":     cfAddHandler,
+	"CF.ADDNX":   cfAddNXHandler,
+	"CF.EXISTS":  cfExistsHandler,
+	"CF.MEXISTS": cfMExistsHandler,
+	"CF.DEL":     cfDelHandler,
+	"CF.COUNT":   cfCountHandler,
+	"CF.INFO":    cfInfoHandler,
+
+	"CMS.INITBYDIM":  cmsInitByDimHandler,
+	"CMS.INITBYPROB": cmsInitByProbHandler,
+	"CMS.INCRBY":     cmsIncrByHandler,
+	"CMS.QUERY":      cmsQueryHandler,
+	"CMS.MERGE":      cmsMergeHandler,
+	"CMS.INFO":       cmsInfoHandler,
+	"TOPK.RESERVE":   topkReserveHandler,
+	"TOPK.ADD":       topkAddHandler,
+	"TOPK.QUERY":     topkQueryHandler,
+	"TOPK.LIST":      topkListHandler,
+	"TOPK.INFO":      topkInfoHandler,
+
+	"GEOADD":         geoaddHandler,
+	"GEOPOS":         geoposHandler,
+	"GEODIST":        geodistHandler,
+	"GEOHASH":        geohashHandler,
+	"GEOSEARCH":      geosearchHandler,
+	"GEOSEARCHSTORE": geosearchstoreHandler,
+
+	"TS.CREATE":     tsCreateHandler,
+	"TS.ADD":        tsAddHandler,
+	"TS.MADD":       tsMaddHandler,
+	"TS.GET":        tsGetHandler,
+	"TS.RANGE":      tsRangeHandler,
+	"TS.MRANGE":     tsMrangeHandler,
+	"TS.CREATERULE": tsCreateRuleHandler,
+	"TS.DELETERULE": tsDeleteRuleHandler,
+	"TS.INFO":       tsInfoHandler,
+
+	"VEC.CREATE": vecCreateHandler,
+	"VEC.DROP":   vecDropHandler,
+	"VEC.LIST":   vecListHandler,
+	"VEC.INFO":   vecInfoHandler,
+	"VEC.SET":    vecSetHandler,
+	"VEC.GET":    vecGetHandler,
+	"VEC.TAGS":   vecTagsHandler,
+	"VEC.SEARCH": vecSearchHandler,
 }
 
 func (s *Store) ttlCleaner() {
 	for {
 		time.Sleep(1 * time.Second)
-		s.mu.Lock()
-		now := time.Now().Unix()
+		unlock := s.lockLogged(true)
+		now := time.Now().UnixMilli()
 		for k, exp := range s.ttl {
+			// Expiry times are logged as absolute times, so replaying the
+			// log expires these keys by itself.
 			if exp > 0 && exp <= now {
-				delete(s.data, k)
-				delete(s.types, k)
-				delete(s.ttl, k)
+				s.deleteKey(k)
+				s.notify(notifyExpired, "expired", k)
 			}
 		}
-		s.mu.Unlock()
+		unlock()
 	}
 }
 
@@ -81,7 +280,79 @@ func isExpired(s *Store, key string) bool {
 	if !ok || exp == 0 {
 		return false
 	}
-	return exp <= time.Now().Unix()
+	return exp <= time.Now().UnixMilli()
+}
+
+// expireIfNeeded lazily removes key if its TTL has passed. The caller must
+// hold the write lock.
+func (s *Store) expireIfNeeded(key string) bool {
+	if !isExpired(s, key) {
+		return false
+	}
+	s.expireKey(key)
+	return true
+}
+
+// checkType expires key if needed and reports whether it exists, failing
+// with ErrWrongType if it holds something other than t. The caller must hold
+// the write lock.
+func (s *Store) checkType(key string, t valueType) (bool, error) {
+	s.expireIfNeeded(key)
+	if _, exists := s.data[key]; !exists {
+		return false, nil
+	}
+	if s.types[key] != t {
+		return false, ErrWrongType
+	}
+	return true, nil
+}
+
+// setValue stores v as the value of key with type t, keeping any TTL. The
+// caller must hold the write lock.
+func (s *Store) setValue(key string, t valueType, v any) {
+	_, exists := s.data[key]
+	s.data[key] = v
+	s.types[key] = t
+	s.indexVectors(key, v)
+	if !exists {
+		s.indexKey(key)
+		s.notify(notifyNew, "new", key)
+	}
+}
+
+// deleteKey removes key and all of its metadata. The caller must hold the
+// write lock.
+func (s *Store) deleteKey(key string) {
+	delete(s.data, key)
+	delete(s.types, key)
+	delete(s.ttl, key)
+	delete(s.slots[keySlot(key)], key)
+	s.indexVectors(key, nil)
+	delete(s.memberOrders, key)
+}
+
+// removeEmpty deletes key when a command leaves it holding an empty value,
+// publishing a del event if it existed. The caller must hold the write lock.
+func (s *Store) removeEmpty(key string) {
+	if _, exists := s.data[key]; exists {
+		s.deleteKey(key)
+		s.notify(notifyGeneric, "del", key)
+	}
+}
+
+// resetKeyspace replaces the keyspace wholesale, as FLUSHDB and snapshot
+// loading do. The caller must hold the write lock.
+func (s *Store) resetKeyspace(data map[string]any, types map[string]valueType, ttl map[string]int64) {
+	s.data, s.types, s.ttl = data, types, ttl
+	s.dirty++
+	s.slots = [scanSlots]map[string]struct{}{}
+	s.memberOrders = nil
+	for k := range data {
+		s.indexKey(k)
+	}
+	for _, x := range s.vectorIndexes {
+		s.fill(x)
+	}
 }
 
 // String commands
@@ -90,11 +361,36 @@ func setHandler(args []string) (string, error) {
 		return "", fmt.Errorf("missing argument for SET")
 	}
 	key, value := args[0], args[1]
+	opts, err := parseSetOptions(args[2:])
+	if err != nil {
+		return "", err
+	}
 	DefaultStore.mu.Lock()
 	defer DefaultStore.mu.Unlock()
-	DefaultStore.data[key] = value
-	DefaultStore.types[key] = StringType
-	return "OK", nil
+	DefaultStore.expireIfNeeded(key)
+	_, present := DefaultStore.data[key]
+	reply := "OK"
+	if opts.get {
+		// SET replaces values of any type, but GET can only return a string.
+		if reply, _, err = DefaultStore.getString(key); err != nil {
+			return "", err
+		}
+	}
+	if (opts.nx && present) || (opts.xx && !present) {
+		if opts.get {
+			return reply, nil
+		}
+		return nilReply, nil
+	}
+	DefaultStore.setValue(key, StringType, value)
+	DefaultStore.applyExpiry(key, opts.expiry)
+	DefaultStore.notify(notifyString, "set", key)
+	if opts.expiry.mode == expiryAt {
+		DefaultStore.notify(notifyGeneric, "expire", key)
+		// EX and PX are relative to now, so log the time they came to.
+		DefaultStore.logAs("SET", []string{"SET", key, value, "PXAT", strconv.FormatInt(opts.expiry.at, 10)})
+	}
+	return reply, nil
 }
 
 func getHandler(args []string) (string, error) {
@@ -104,20 +400,8 @@ func getHandler(args []string) (string, error) {
 	key := args[0]
 	DefaultStore.mu.Lock()
 	defer DefaultStore.mu.Unlock()
-	if isExpired(DefaultStore, key) {
-		delete(DefaultStore.data, key)
-		delete(DefaultStore.types, key)
-		delete(DefaultStore.ttl, key)
-		return "", nil
-	}
-	if DefaultStore.types[key] != StringType {
-		return "", nil
-	}
-	val, ok := DefaultStore.data[key].(string)
-	if !ok {
-		return "", nil
-	}
-	return val, nil
+	val, _, err := DefaultStore.getString(key)
+	return val, err
 }
 
 // List commands
@@ -125,78 +409,28 @@ func lpushHandler(args []string) (string, error) {
 	if len(args) < 2 {
 		return "", fmt.Errorf("missing argument for LPUSH")
 	}
-	key := args[0]
-	vals := args[1:]
-	// Reverse vals for Redis-like LPUSH
-	for i, j := 0, len(vals)-1; i < j; i, j = i+1, j-1 {
-		vals[i], vals[j] = vals[j], vals[i]
-	}
-	DefaultStore.mu.Lock()
-	defer DefaultStore.mu.Unlock()
-	if DefaultStore.types[key] != ListType {
-		DefaultStore.data[key] = []string{}
-		DefaultStore.types[key] = ListType
-	}
-	lst := DefaultStore.data[key].([]string)
-	lst = append(vals, lst...)
-	DefaultStore.data[key] = lst
-	return fmt.Sprintf("%d", len(lst)), nil
+	return pushList(args[0], args[1:], true, false)
 }
 
 func rpushHandler(args []string) (string, error) {
 	if len(args) < 2 {
 		return "", fmt.Errorf("missing argument for RPUSH")
 	}
-	key := args[0]
-	vals := args[1:]
-	DefaultStore.mu.Lock()
-	defer DefaultStore.mu.Unlock()
-	if DefaultStore.types[key] != ListType {
-		DefaultStore.data[key] = []string{}
-		DefaultStore.types[key] = ListType
-	}
-	lst := DefaultStore.data[key].([]string)
-	lst = append(lst, vals...)
-	DefaultStore.data[key] = lst
-	return fmt.Sprintf("%d", len(lst)), nil
+	return pushList(args[0], args[1:], false, false)
 }
 
 func lpopHandler(args []string) (string, error) {
 	if len(args) < 1 {
 		return "", fmt.Errorf("missing argument for LPOP")
 	}
-	key := args[0]
-	DefaultStore.mu.Lock()
-	defer DefaultStore.mu.Unlock()
-	if DefaultStore.types[key] != ListType {
-		return "", nil
-	}
-	lst := DefaultStore.data[key].([]string)
-	if len(lst) == 0 {
-		return "", nil
-	}
-	val := lst[0]
-	DefaultStore.data[key] = lst[1:]
-	return val, nil
+	return popList(args, true)
 }
 
 func rpopHandler(args []string) (string, error) {
 	if len(args) < 1 {
 		return "", fmt.Errorf("missing argument for RPOP")
 	}
-	key := args[0]
-	DefaultStore.mu.Lock()
-	defer DefaultStore.mu.Unlock()
-	if DefaultStore.types[key] != ListType {
-		return "", nil
-	}
-	lst := DefaultStore.data[key].([]string)
-	if len(lst) == 0 {
-		return "", nil
-	}
-	val := lst[len(lst)-1]
-	DefaultStore.data[key] = lst[:len(lst)-1]
-	return val, nil
+	return popList(args, false)
 }
 
 func lrangeHandler(args []string) (string, error) {
@@ -204,24 +438,22 @@ func lrangeHandler(args []string) (string, error) {
 		return "", fmt.Errorf("missing argument for LRANGE")
 	}
 	key := args[0]
-	start := parseInt(args[1])
-	end := parseInt(args[2])
-	DefaultStore.mu.RLock()
-	defer DefaultStore.mu.RUnlock()
-	if DefaultStore.types[key] != ListType {
-		return "", nil
+	start, err1 := strconv.Atoi(args[1])
+	end, err2 := strconv.Atoi(args[2])
+	if err1 != nil || err2 != nil {
+		return "", errNotInteger
 	}
-	lst := DefaultStore.data[key].([]string)
-	if start < 0 {
-		start = 0
-	}
-	if end >= len(lst) {
-		end = len(lst) - 1
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	lst, _, err := DefaultStore.getList(key)
+	if err != nil {
+		return "", err
 	}
-	if start > end || start >= len(lst) {
+	start, end, ok := normalizeRange(start, end, lst.Len())
+	if !ok {
 		return "", nil
 	}
-	return strings.Join(lst[start:end+1], ","), nil
+	return strings.Join(lst.Range(start, end), ","), nil
 }
 
 // Set commands
@@ -233,11 +465,10 @@ func saddHandler(args []string) (string, error) {
 	vals := args[1:]
 	DefaultStore.mu.Lock()
 	defer DefaultStore.mu.Unlock()
-	if DefaultStore.types[key] != SetType {
-		DefaultStore.data[key] = map[string]struct{}{}
-		DefaultStore.types[key] = SetType
+	set, _, err := DefaultStore.getSet(key)
+	if err != nil {
+		return "", err
 	}
-	set := DefaultStore.data[key].(map[string]struct{})
 	added := 0
 	for _, v := range vals {
 		if _, exists := set[v]; !exists {
@@ -245,6 +476,10 @@ func saddHandler(args []string) (string, error) {
 			added++
 		}
 	}
+	DefaultStore.setSet(key, set)
+	if added > 0 {
+		DefaultStore.notify(notifySet, "sadd", key)
+	}
 	return fmt.Sprintf("%d", added), nil
 }
 
@@ -256,10 +491,10 @@ func sremHandler(args []string) (string, error) {
 	vals := args[1:]
 	DefaultStore.mu.Lock()
 	defer DefaultStore.mu.Unlock()
-	if DefaultStore.types[key] != SetType {
-		return "0", nil
+	set, exists, err := DefaultStore.getSet(key)
+	if !exists {
+		return "0", err
 	}
-	set := DefaultStore.data[key].(map[string]struct{})
 	removed := 0
 	for _, v := range vals {
 		if _, exists := set[v]; exists {
@@ -267,6 +502,10 @@ func sremHandler(args []string) (string, error) {
 			removed++
 		}
 	}
+	if removed > 0 {
+		DefaultStore.notify(notifySet, "srem", key)
+	}
+	DefaultStore.setSet(key, set)
 	return fmt.Sprintf("%d", removed), nil
 }
 
@@ -274,19 +513,13 @@ func smembersHandler(args []string) (string, error) {
 	if len(args) < 1 {
 		return "", fmt.Errorf("missing argument for SMEMBERS")
 	}
-	key := args[0]
-	DefaultStore.mu.RLock()
-	defer DefaultStore.mu.RUnlock()
-	if DefaultStore.types[key] != SetType {
-		return "", nil
-	}
-	set := DefaultStore.data[key].(map[string]struct{})
-	members := make([]string, 0, len(set))
-	for v := range set {
-		members = append(members, v)
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	set, _, err := DefaultStore.getSet(args[0])
+	if err != nil {
+		return "", err
 	}
-	sort.Strings(members)
-	return strings.Join(members, ","), nil
+	return strings.Join(sortedMembers(set), ","), nil
 }
 
 // Meta commands
@@ -304,8 +537,7 @@ func keysHandler(args []string) (string, error) {
 func flushdbHandler(args []string) (string, error) {
 	DefaultStore.mu.Lock()
 	defer DefaultStore.mu.Unlock()
-	DefaultStore.data = make(map[string]any)
-	DefaultStore.types = make(map[string]valueType)
+	DefaultStore.resetKeyspace(make(map[string]any), make(map[string]valueType), make(map[string]int64))
 	return "OK", nil
 }
 
@@ -331,37 +563,40 @@ func delHandler(args []string) (string, error) {
 	if len(args) < 1 {
 		return "0", fmt.Errorf("missing argument for DEL")
 	}
-	key := args[0]
 	DefaultStore.mu.Lock()
 	defer DefaultStore.mu.Unlock()
-	_, existed := DefaultStore.data[key]
-	delete(DefaultStore.data, key)
-	delete(DefaultStore.types, key)
-	delete(DefaultStore.ttl, key)
-	if existed {
-		return "1", nil
+	deleted := 0
+	for _, key := range args {
+		if DefaultStore.expireIfNeeded(key) {
+			continue
+		}
+		if _, existed := DefaultStore.data[key]; existed {
+			DefaultStore.deleteKey(key)
+			DefaultStore.notify(notifyGeneric, "del", key)
+			deleted++
+		}
 	}
-	return "0", nil
+	return strconv.Itoa(deleted), nil
 }
 
+// existsHandler counts how many of the given keys exist; a key named twice
+// counts twice.
 func existsHandler(args []string) (string, error) {
 	if len(args) < 1 {
 		return "0", fmt.Errorf("missing argument for EXISTS")
 	}
-	key := args[0]
 	DefaultStore.mu.Lock()
 	defer DefaultStore.mu.Unlock()
-	if isExpired(DefaultStore, key) {
-		delete(DefaultStore.data, key)
-		delete(DefaultStore.types, key)
-		delete(DefaultStore.ttl, key)
-		return "0", nil
-	}
-	_, ok := DefaultStore.data[key]
-	if ok {
-		return "1", nil
+	found := 0
+	for _, key := range args {
+		if DefaultStore.expireIfNeeded(key) {
+			continue
+		}
+		if _, ok := DefaultStore.data[key]; ok {
+			found++
+		}
 	}
-	return "0", nil
+	return strconv.Itoa(found), nil
 }
 
 func expireHandler(args []string) (string, error) {
@@ -375,7 +610,30 @@ func expireHandler(args []string) (string, error) {
 	if _, ok := DefaultStore.data[key]; !ok || isExpired(DefaultStore, key) {
 		return "0", nil
 	}
-	DefaultStore.ttl[key] = time.Now().Unix() + int64(secs)
+	at := time.Now().UnixMilli() + int64(secs)*1000
+	DefaultStore.ttl[key] = at
+	DefaultStore.notify(notifyGeneric, "expire", key)
+	DefaultStore.logAs("EXPIRE", []string{"PEXPIREAT", key, strconv.FormatInt(at, 10)})
+	return "1", nil
+}
+
+// pexpireatHandler implements PEXPIREAT key unix-ms, which is also how
+// EXPIRE is logged.
+func pexpireatHandler(args []string) (string, error) {
+	if len(args) != 2 {
+		return "", fmt.Errorf("wrong number of arguments for PEXPIREAT")
+	}
+	at, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return "", errNotInteger
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	if _, ok := DefaultStore.data[args[0]]; !ok || isExpired(DefaultStore, args[0]) {
+		return "0", nil
+	}
+	DefaultStore.ttl[args[0]] = at
+	DefaultStore.notify(notifyGeneric, "expire", args[0])
 	return "1", nil
 }
 
@@ -393,11 +651,37 @@ func ttlHandler(args []string) (string, error) {
 	if exp == 0 {
 		return "-1", nil
 	}
-	rem := exp - time.Now().Unix()
+	rem := exp - time.Now().UnixMilli()
 	if rem < 0 {
 		return "-2", nil
 	}
-	return fmt.Sprintf("%d", rem), nil
+	return fmt.Sprintf("%d", (rem+500)/1000), nil
+}
+
+// SnapshotScripts and RestoreScripts, if set, let snapshots carry the
+// registered scripts, which triggers and schedules run by hash. The script
+// package sets them.
+var (
+	SnapshotScripts func() map[string]string
+	RestoreScripts  func(scripts map[string]string)
+)
+
+// snapshot is the on-disk layout written by SaveSnapshot. TTL holds
+// second-resolution expirations from older dumps and is only read; new dumps
+// store millisecond expirations in TTLMillis.
+type snapshot struct {
+	Data      map[string]any
+	Types     map[string]valueType
+	TTL       map[string]int64
+	TTLMillis map[string]int64
+
+	Schedules      map[uint64]*schedule
+	NextScheduleID uint64
+
+	Triggers map[string]*trigger
+	Scripts  map[string]string // hash -> script
+
+	VectorIndexes map[string]*vectorIndex
 }
 
 func SaveSnapshot(filename string) error {
@@ -408,12 +692,88 @@ func SaveSnapshot(filename string) error {
 		return err
 	}
 	defer f.Close()
+	data := make(map[string]any, len(DefaultStore.data))
+	for k, v := range DefaultStore.data {
+		data[k] = encodeValue(v)
+	}
+	var scripts map[string]string
+	if SnapshotScripts != nil {
+		scripts = SnapshotScripts()
+	}
 	enc := gob.NewEncoder(f)
-	return enc.Encode(struct {
-		Data  map[string]any
-		Types map[string]valueType
-		TTL   map[string]int64
-	}{DefaultStore.data, DefaultStore.types, DefaultStore.ttl})
+	return enc.Encode(snapshot{
+		Data:      data,
+		Types:     DefaultStore.types,
+		TTLMillis: DefaultStore.ttl,
+
+		Schedules:      DefaultStore.schedules,
+		NextScheduleID: DefaultStore.nextScheduleID,
+
+		Triggers: DefaultStore.triggers,
+		Scripts:  scripts,
+
+		VectorIndexes: DefaultStore.vectorIndexes,
+	})
+}
+
+// encodeValue converts an in-memory value to the form stored in snapshots.
+func encodeValue(v any) any {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case *quicklist:
+		return v.Values()
+	case *jsonDoc:
+		return formatJSON(v.root)
+	case *hll:
+		return v.encode()
+	case *sortedSet:
+		return maps.Clone(v.scores)
+	}
+	return v
+}
+
+// decodeValue converts a snapshot value back to its in-memory form.
+func decodeValue(t valueType, v any) any {
+	switch t {
+	case ListType:
+		if lst, ok := v.([]string); ok {
+			return newQuicklist(lst...)
+		}
+	case StreamType:
+		if st, ok := v.(*stream); ok {
+			return restoreStream(st)
+		}
+	case QueueType:
+		if q, ok := v.(*queue); ok {
+			return restoreQueue(q)
+		}
+	case JSONType:
+		if text, ok := v.(string); ok {
+			if root, err := parseJSON(text); err == nil {
+				return &jsonDoc{root: root}
+			}
+		}
+	case GeoType:
+		if scores, ok := v.(map[string]float64); ok {
+			set := newSortedSet()
+			for member, score := range scores {
+				set.add(member, score)
+			}
+			return set
+		}
+	case TopKType:
+		if t, ok := v.(*topK); ok {
+			return restoreTopK(t)
+		}
+	case HLLType:
+		if buf, ok := v.([]byte); ok {
+			if h, err := decodeHLL(buf); err == nil {
+				return h
+			}
+		}
+	}
+	return v
 }
 
 func LoadSnapshot(filename string) error {
@@ -422,20 +782,37 @@ func LoadSnapshot(filename string) error {
 		return err
 	}
 	defer f.Close()
-	var snap struct {
-		Data  map[string]any
-		Types map[string]valueType
-		TTL   map[string]int64
-	}
+	var snap snapshot
 	dec := gob.NewDecoder(f)
 	if err := dec.Decode(&snap); err != nil {
 		return err
 	}
+	if snap.Data == nil {
+		snap.Data = make(map[string]any)
+	}
+	if snap.Types == nil {
+		snap.Types = make(map[string]valueType)
+	}
+	for k, v := range snap.Data {
+		snap.Data[k] = decodeValue(snap.Types[k], v)
+	}
+	if snap.TTLMillis == nil {
+		snap.TTLMillis = make(map[string]int64, len(snap.TTL))
+		for k, exp := range snap.TTL {
+			snap.TTLMillis[k] = exp * 1000
+		}
+	}
+	if RestoreScripts != nil && len(snap.Scripts) > 0 {
+		RestoreScripts(snap.Scripts)
+	}
 	DefaultStore.mu.Lock()
 	defer DefaultStore.mu.Unlock()
-	DefaultStore.data = snap.Data
-	DefaultStore.types = snap.Types
-	DefaultStore.ttl = snap.TTL
+	DefaultStore.restoreVectorIndexes(snap.VectorIndexes)
+	DefaultStore.resetKeyspace(snap.Data, snap.Types, snap.TTLMillis)
+	DefaultStore.restoreSchedules(snap.Schedules, snap.NextScheduleID)
+	if DefaultStore.triggers = snap.Triggers; snap.Triggers == nil {
+		DefaultStore.triggers = make(map[string]*trigger)
+	}
 	return nil
 }
 
@@ -455,4 +832,14 @@ func init() {
 	gob.Register(map[string]struct{}{})
 	gob.Register([]string{})
 	gob.Register("")
+	gob.Register([]byte{})
+	gob.Register(&stream{})
+	gob.Register(&queue{})
+	gob.Register(&bloomFilter{})
+	gob.Register(&cuckooFilter{})
+	gob.Register(&countMinSketch{})
+	gob.Register(&topK{})
+	gob.Register(map[string]float64{})
+	gob.Register(&timeSeries{})
+	gob.Register(&vectorValue{})
 }
@@ -130,6 +130,7 @@ func TestSnapshotSaveLoad(t *testing.T) {
 	_, _ = setHandler([]string{"snapkey", "snapval"})
 	_, _ = saddHandler([]string{"snapset", "a", "b"})
 	_, _ = expireHandler([]string{"snapkey", "10"})
+	_, _ = appendHandler([]string{"snapbytes", "xy"})
 
 	err := SaveSnapshot(TEST_FILE)
 	if err != nil {
@@ -155,5 +156,8 @@ func TestSnapshotSaveLoad(t *testing.T) {
 	if !(strings.Contains(members, "a") && strings.Contains(members, "b")) {
 		t.Errorf("expected set members a and b, got %s", members)
 	}
+	if val, _ = getHandler([]string{"snapbytes"}); val != "xy" {
+		t.Errorf("expected an appended value to survive the snapshot, got %s", val)
+	}
 	_ = os.Remove(TEST_FILE)
 }
@@ -0,0 +1,592 @@
+package db
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"furr/internal/protocol"
+)
+
+const (
+	// geoStep is the bits of precision per coordinate in a score, 52 in all.
+	geoStep = 26
+	// Latitudes are limited to what Web Mercator maps, like other geo
+	// indexes, so cells stay roughly square.
+	geoLatMin, geoLatMax = -85.05112878, 85.05112878
+	geoLonMin, geoLonMax = -180.0, 180.0
+	// earthRadius is the radius, in meters, distances are measured on.
+	earthRadius = 6372797.560856
+)
+
+// geoUnits maps distance units to meters.
+var geoUnits = map[string]float64{"m": 1, "km": 1000, "mi": 1609.34, "ft": 0.3048}
+
+func parseGeoUnit(s string) (float64, error) {
+	if f, ok := geoUnits[strings.ToLower(s)]; ok {
+		return f, nil
+	}
+	return 0, fmt.Errorf("unsupported unit provided. please use M, KM, FT, MI")
+}
+
+// geoInterleave spreads the low step bits of lat over the even bits of the
+// result and those of lon over the odd bits, so a prefix of the result
+// names a cell at a coarser step.
+func geoInterleave(lat, lon uint64, step uint) uint64 {
+	var h uint64
+	for i := range step {
+		h |= (lat>>i&1)<<(2*i) | (lon>>i&1)<<(2*i+1)
+	}
+	return h
+}
+
+func geoDeinterleave(h uint64, step uint) (lat, lon uint64) {
+	for i := range step {
+		lat |= (h >> (2 * i) & 1) << i
+		lon |= (h >> (2*i + 1) & 1) << i
+	}
+	return lat, lon
+}
+
+// geoCell returns the indexes of the cells holding lat and lon at step,
+// with latitudes spread over latMin to latMax.
+func geoCell(lon, lat, latMin, latMax float64, step uint) (uint64, uint64) {
+	cells := float64(uint64(1) << step)
+	latIdx := uint64(min((lat-latMin)/(latMax-latMin)*cells, cells-1))
+	lonIdx := uint64(min((lon-geoLonMin)/(geoLonMax-geoLonMin)*cells, cells-1))
+	return latIdx, lonIdx
+}
+
+// geoEncode returns the score of a position.
+func geoEncode(lon, lat float64) uint64 {
+	latIdx, lonIdx := geoCell(lon, lat, geoLatMin, geoLatMax, geoStep)
+	return geoInterleave(latIdx, lonIdx, geoStep)
+}
+
+// geoDecode returns the center of the cell a score names.
+func geoDecode(score uint64) (lon, lat float64) {
+	latIdx, lonIdx := geoDeinterleave(score, geoStep)
+	const cells = 1 << geoStep
+	lat = geoLatMin + (float64(latIdx)+0.5)*(geoLatMax-geoLatMin)/cells
+	lon = geoLonMin + (float64(lonIdx)+0.5)*(geoLonMax-geoLonMin)/cells
+	return lon, lat
+}
+
+// geohashString returns the standard 11 character geohash of a position,
+// which spreads latitudes over the full -90 to 90.
+func geohashString(lon, lat float64) string {
+	const alphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+	latIdx, lonIdx := geoCell(lon, lat, -90, 90, geoStep)
+	h := geoInterleave(latIdx, lonIdx, geoStep)
+	var b [11]byte
+	for i := range 10 {
+		b[i] = alphabet[h>>(52-5*(i+1))&0x1f]
+	}
+	// 52 bits fill ten characters. Like Redis, the two bits left over are
+	// dropped and the last character is always 0.
+	b[10] = alphabet[0]
+	return string(b[:])
+}
+
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// geoDistance returns the great-circle distance in meters between two
+// positions, by the haversine formula.
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	u := math.Sin(toRadians(lat2-lat1) / 2)
+	v := math.Sin(toRadians(lon2-lon1) / 2)
+	a := u*u + math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*v*v
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+func parseLonLat(lonArg, latArg string) (float64, float64, error) {
+	lon, err1 := strconv.ParseFloat(lonArg, 64)
+	lat, err2 := strconv.ParseFloat(latArg, 64)
+	if err1 != nil || err2 != nil || !(lon >= geoLonMin && lon <= geoLonMax) || !(lat >= geoLatMin && lat <= geoLatMax) {
+		return 0, 0, fmt.Errorf("invalid longitude,latitude pair %s,%s", lonArg, latArg)
+	}
+	return lon, lat, nil
+}
+
+func formatCoord(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// getGeo returns the geo set at key. The caller must hold the write lock.
+func (s *Store) getGeo(key string) (*sortedSet, bool, error) {
+	exists, err := s.checkType(key, GeoType)
+	if !exists {
+		return nil, false, err
+	}
+	return s.data[key].(*sortedSet), true, nil
+}
+
+// geoaddHandler implements GEOADD key [NX|XX] [CH] longitude latitude member
+// [longitude latitude member ...], replying with how many members were
+// added, or with CH, added or moved.
+func geoaddHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for GEOADD")
+	}
+	var nx, xx, ch bool
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+			continue
+		case "XX":
+			xx = true
+			continue
+		case "CH":
+			ch = true
+			continue
+		}
+		break
+	}
+	if nx && xx {
+		return "", fmt.Errorf("XX and NX options at the same time are not compatible")
+	}
+	rest := args[i:]
+	if len(rest) == 0 || len(rest)%3 != 0 {
+		return "", fmt.Errorf("wrong number of arguments for GEOADD")
+	}
+	scores := make([]float64, len(rest)/3)
+	for j := range scores {
+		lon, lat, err := parseLonLat(rest[3*j], rest[3*j+1])
+		if err != nil {
+			return "", err
+		}
+		scores[j] = float64(geoEncode(lon, lat))
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set, exists, err := s.getGeo(args[0])
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		set = newSortedSet()
+	}
+	added, changed := 0, 0
+	for j, score := range scores {
+		member := rest[3*j+2]
+		old, had := set.scores[member]
+		if had && nx || !had && xx || had && old == score {
+			continue
+		}
+		set.add(member, score)
+		if had {
+			changed++
+		} else {
+			added++
+		}
+	}
+	if added+changed > 0 {
+		if !exists {
+			s.setValue(args[0], GeoType, set)
+		}
+		s.notify(notifyModule, "geoadd", args[0])
+	}
+	if ch {
+		return strconv.Itoa(added + changed), nil
+	}
+	return strconv.Itoa(added), nil
+}
+
+// geoposHandler implements GEOPOS key member [member ...], replying with each
+// member's longitude and latitude, or nil.
+func geoposHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for GEOPOS")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set, _, err := s.getGeo(args[0])
+	if err != nil {
+		return "", err
+	}
+	out := make([]string, len(args)-1)
+	for i, member := range args[1:] {
+		out[i] = nilReply
+		if score, ok := set.score(member); ok {
+			lon, lat := geoDecode(uint64(score))
+			out[i] = protocol.QuoteArgs([]string{formatCoord(lon), formatCoord(lat)})
+		}
+	}
+	return strings.Join(out, ","), nil
+}
+
+// geodistHandler implements GEODIST key member1 member2 [M|KM|FT|MI].
+func geodistHandler(args []string) (string, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return "", fmt.Errorf("wrong number of arguments for GEODIST")
+	}
+	unit := 1.0
+	if len(args) == 4 {
+		var err error
+		if unit, err = parseGeoUnit(args[3]); err != nil {
+			return "", err
+		}
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set, _, err := s.getGeo(args[0])
+	if err != nil {
+		return "", err
+	}
+	score1, ok1 := set.score(args[1])
+	score2, ok2 := set.score(args[2])
+	if !ok1 || !ok2 {
+		return nilReply, nil
+	}
+	lon1, lat1 := geoDecode(uint64(score1))
+	lon2, lat2 := geoDecode(uint64(score2))
+	return strconv.FormatFloat(geoDistance(lon1, lat1, lon2, lat2)/unit, 'f', 4, 64), nil
+}
+
+// geohashHandler implements GEOHASH key member [member ...].
+func geohashHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for GEOHASH")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set, _, err := s.getGeo(args[0])
+	if err != nil {
+		return "", err
+	}
+	out := make([]string, len(args)-1)
+	for i, member := range args[1:] {
+		out[i] = nilReply
+		if score, ok := set.score(member); ok {
+			out[i] = geohashString(geoDecode(uint64(score)))
+		}
+	}
+	return strings.Join(out, ","), nil
+}
+
+// geoShape is the area a search covers: a circle of radius meters, or a
+// box of width by height meters, around a center.
+type geoShape struct {
+	lon, lat      float64
+	radius        float64
+	width, height float64
+	box           bool
+}
+
+// distance returns how far a position is from the center, if it is inside.
+func (sh *geoShape) distance(lon, lat float64) (float64, bool) {
+	d := geoDistance(sh.lon, sh.lat, lon, lat)
+	if !sh.box {
+		return d, d <= sh.radius
+	}
+	if geoDistance(sh.lon, sh.lat, sh.lon, lat) > sh.height/2 || geoDistance(sh.lon, lat, lon, lat) > sh.width/2 {
+		return 0, false
+	}
+	return d, true
+}
+
+// scoreRanges returns the score ranges, each [start, end), that hold every
+// position inside the shape: the cell around the center and its eight
+// neighbors, at the finest step whose cells are at least as large as the
+// shape's reach from the center.
+func (sh *geoShape) scoreRanges() [][2]float64 {
+	reachLat, reachLon := sh.radius, sh.radius
+	if sh.box {
+		reachLat, reachLon = sh.height/2, sh.width/2
+	}
+	latDelta := reachLat / earthRadius * 180 / math.Pi
+	lonDelta := 360.0
+	// Degrees of longitude are shortest at the latitude farthest from the
+	// equator that the shape reaches.
+	if c := math.Cos(toRadians(min(90, math.Abs(sh.lat)+latDelta))); c > 1e-9 {
+		lonDelta = reachLon / (earthRadius * c) * 180 / math.Pi
+	}
+	step := uint(geoStep)
+	for ; step > 0; step-- {
+		cells := float64(uint64(1) << step)
+		if (geoLatMax-geoLatMin)/cells >= latDelta && (geoLonMax-geoLonMin)/cells >= lonDelta {
+			break
+		}
+	}
+	if step == 0 {
+		return [][2]float64{{0, 1 << (2 * geoStep)}}
+	}
+	latIdx, lonIdx := geoCell(sh.lon, sh.lat, geoLatMin, geoLatMax, step)
+	cells := uint64(1) << step
+	shift := 2 * (geoStep - step)
+	var ranges [][2]float64
+	for dy := -1; dy <= 1; dy++ {
+		y := latIdx + uint64(dy)
+		if y >= cells { // off either pole, as unsigned arithmetic wraps
+			continue
+		}
+		for dx := -1; dx <= 1; dx++ {
+			x := (lonIdx + uint64(dx)) & (cells - 1) // wraps at the antimeridian
+			h := geoInterleave(y, x, step)
+			ranges = append(ranges, [2]float64{float64(h << shift), float64((h + 1) << shift)})
+		}
+	}
+	slices.SortFunc(ranges, func(a, b [2]float64) int { return cmp.Compare(a[0], b[0]) })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		if last := &merged[len(merged)-1]; r[0] <= last[1] {
+			last[1] = max(last[1], r[1])
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
+
+// geoQuery is a parsed GEOSEARCH.
+type geoQuery struct {
+	fromMember                    string
+	byMember                      bool
+	shape                         geoShape
+	unit                          float64
+	order                         int // 1 for ASC, -1 for DESC, 0 if not given
+	count                         int
+	any                           bool
+	withCoord, withDist, withHash bool
+}
+
+// parseGeoSearch parses GEOSEARCH's options. GEOSEARCHSTORE passes store,
+// which allows STOREDIST but no WITH options.
+func parseGeoSearch(args []string, store bool) (*geoQuery, error) {
+	q := &geoQuery{unit: 1}
+	var hasFrom, hasBy bool
+	need := func(i, n int) error {
+		if i+n >= len(args) {
+			return fmt.Errorf("syntax error")
+		}
+		return nil
+	}
+	for i := 0; i < len(args); i++ {
+		var err error
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "FROMMEMBER":
+			if err = need(i, 1); err == nil && !hasFrom {
+				q.fromMember, q.byMember, hasFrom = args[i+1], true, true
+				i++
+				continue
+			}
+		case "FROMLONLAT":
+			if err = need(i, 2); err == nil && !hasFrom {
+				if q.shape.lon, q.shape.lat, err = parseLonLat(args[i+1], args[i+2]); err != nil {
+					return nil, err
+				}
+				hasFrom = true
+				i += 2
+				continue
+			}
+		case "BYRADIUS":
+			if err = need(i, 2); err == nil && !hasBy {
+				r, perr := strconv.ParseFloat(args[i+1], 64)
+				if q.unit, err = parseGeoUnit(args[i+2]); err != nil {
+					return nil, err
+				}
+				if perr != nil || r < 0 {
+					return nil, fmt.Errorf("radius must be a non-negative number")
+				}
+				q.shape.radius, hasBy = r*q.unit, true
+				i += 2
+				continue
+			}
+		case "BYBOX":
+			if err = need(i, 3); err == nil && !hasBy {
+				w, werr := strconv.ParseFloat(args[i+1], 64)
+				h, herr := strconv.ParseFloat(args[i+2], 64)
+				if q.unit, err = parseGeoUnit(args[i+3]); err != nil {
+					return nil, err
+				}
+				if werr != nil || herr != nil || w < 0 || h < 0 {
+					return nil, fmt.Errorf("width and height must be non-negative numbers")
+				}
+				q.shape.width, q.shape.height, q.shape.box, hasBy = w*q.unit, h*q.unit, true, true
+				i += 3
+				continue
+			}
+		case "ASC", "DESC":
+			q.order = map[string]int{"ASC": 1, "DESC": -1}[opt]
+			continue
+		case "COUNT":
+			if err = need(i, 1); err == nil {
+				n, perr := strconv.Atoi(args[i+1])
+				if perr != nil || n < 1 {
+					return nil, fmt.Errorf("COUNT must be > 0")
+				}
+				q.count = n
+				i++
+				if i+1 < len(args) && strings.ToUpper(args[i+1]) == "ANY" {
+					q.any = true
+					i++
+				}
+				continue
+			}
+		case "WITHCOORD", "WITHDIST", "WITHHASH":
+			if !store {
+				q.withCoord = q.withCoord || opt == "WITHCOORD"
+				q.withDist = q.withDist || opt == "WITHDIST"
+				q.withHash = q.withHash || opt == "WITHHASH"
+				continue
+			}
+		case "STOREDIST":
+			if store {
+				return nil, fmt.Errorf("STOREDIST is not supported, as the destination must be a geo set")
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("syntax error")
+	}
+	if !hasFrom {
+		return nil, fmt.Errorf("exactly one of FROMMEMBER or FROMLONLAT can be specified")
+	}
+	if !hasBy {
+		return nil, fmt.Errorf("exactly one of BYRADIUS and BYBOX can be specified")
+	}
+	return q, nil
+}
+
+type geoResult struct {
+	member   string
+	score    float64
+	dist     float64
+	lon, lat float64
+}
+
+// search runs q against set, which may be nil for a missing key.
+func (q *geoQuery) search(set *sortedSet) ([]geoResult, error) {
+	if set == nil {
+		return nil, nil
+	}
+	if q.byMember {
+		score, ok := set.scores[q.fromMember]
+		if !ok {
+			return nil, fmt.Errorf("could not decode requested member")
+		}
+		q.shape.lon, q.shape.lat = geoDecode(uint64(score))
+	}
+	var results []geoResult
+	for _, r := range q.shape.scoreRanges() {
+		more := set.scanRange(r[0], r[1], func(member string, score float64) bool {
+			lon, lat := geoDecode(uint64(score))
+			if d, ok := q.shape.distance(lon, lat); ok {
+				results = append(results, geoResult{member, score, d, lon, lat})
+			}
+			return !q.any || len(results) < q.count
+		})
+		if !more {
+			break
+		}
+	}
+	// Without ANY, COUNT keeps the nearest matches.
+	if q.order == 0 && q.count > 0 && !q.any {
+		q.order = 1
+	}
+	if q.order != 0 {
+		slices.SortFunc(results, func(a, b geoResult) int {
+			if c := cmp.Compare(a.dist, b.dist); c != 0 {
+				return c * q.order
+			}
+			return strings.Compare(a.member, b.member)
+		})
+	}
+	if q.count > 0 && len(results) > q.count {
+		results = results[:q.count]
+	}
+	return results, nil
+}
+
+// geosearchHandler implements GEOSEARCH key FROMMEMBER member|FROMLONLAT lon
+// lat BYRADIUS radius unit|BYBOX width height unit [ASC|DESC] [COUNT n
+// [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]. With any WITH option each match
+// is replied as its member followed by the distance, score and longitude
+// and latitude asked for.
+func geosearchHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for GEOSEARCH")
+	}
+	q, err := parseGeoSearch(args[1:], false)
+	if err != nil {
+		return "", err
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set, _, err := s.getGeo(args[0])
+	if err != nil {
+		return "", err
+	}
+	results, err := q.search(set)
+	if err != nil {
+		return "", err
+	}
+	out := make([]string, len(results))
+	for i, r := range results {
+		if !q.withCoord && !q.withDist && !q.withHash {
+			out[i] = r.member
+			continue
+		}
+		fields := []string{r.member}
+		if q.withDist {
+			fields = append(fields, strconv.FormatFloat(r.dist/q.unit, 'f', 4, 64))
+		}
+		if q.withHash {
+			fields = append(fields, strconv.FormatUint(uint64(r.score), 10))
+		}
+		if q.withCoord {
+			fields = append(fields, formatCoord(r.lon), formatCoord(r.lat))
+		}
+		out[i] = protocol.QuoteArgs(fields)
+	}
+	return strings.Join(out, ","), nil
+}
+
+// geosearchstoreHandler implements GEOSEARCHSTORE destination source with
+// GEOSEARCH's options but no WITH ones, storing the matches at destination
+// and replying with how many there were.
+func geosearchstoreHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for GEOSEARCHSTORE")
+	}
+	q, err := parseGeoSearch(args[2:], true)
+	if err != nil {
+		return "", err
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set, _, err := s.getGeo(args[1])
+	if err != nil {
+		return "", err
+	}
+	results, err := q.search(set)
+	if err != nil {
+		return "", err
+	}
+	delete(s.ttl, args[0])
+	if len(results) == 0 {
+		s.removeEmpty(args[0])
+		return "0", nil
+	}
+	dest := newSortedSet()
+	for _, r := range results {
+		dest.add(r.member, r.score)
+	}
+	s.setValue(args[0], GeoType, dest)
+	s.notify(notifyModule, "geosearchstore", args[0])
+	return strconv.Itoa(len(results)), nil
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func seedSicily(t *testing.T) {
+	t.Helper()
+	DefaultStore = NewStore()
+	out, err := geoaddHandler([]string{"Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"})
+	if err != nil || out != "2" {
+		t.Fatalf("expected 2, got %s (%v)", out, err)
+	}
+}
+
+func TestGeoAddPosDist(t *testing.T) {
+	seedSicily(t)
+	for _, tc := range []struct {
+		cmd  []string
+		want string
+	}{
+		{[]string{"GEODIST", "Sicily", "Palermo", "Catania"}, "166274.1516"},
+		{[]string{"GEODIST", "Sicily", "Palermo", "Catania", "km"}, "166.2742"},
+		{[]string{"GEODIST", "Sicily", "Palermo", "Catania", "MI"}, "103.3182"},
+		{[]string{"GEODIST", "Sicily", "Palermo", "Nowhere"}, nilReply},
+		{[]string{"GEOPOS", "Sicily", "Palermo", "Nowhere"}, "13.361389338970184 38.1155563954963,"},
+		{[]string{"GEOHASH", "Sicily", "Palermo", "Catania", "Nowhere"}, "sqc8b49rny0,sqdtr74hyu0,"},
+		{[]string{"GEOPOS", "missing", "a"}, nilReply},
+		{[]string{"GEOADD", "Sicily", "NX", "13", "38", "Palermo", "13", "38", "Agrigento"}, "1"},
+		{[]string{"GEOADD", "Sicily", "XX", "CH", "13.5", "38", "Palermo", "13", "38", "Trapani"}, "1"},
+		{[]string{"GEOADD", "Sicily", "CH", "13.5", "38", "Palermo"}, "0"},
+	} {
+		if out, err := Commands[tc.cmd[0]](tc.cmd[1:]); err != nil || out != tc.want {
+			t.Errorf("%v: expected %q, got %q (%v)", tc.cmd, tc.want, out, err)
+		}
+	}
+	if n, _ := existsHandler([]string{"Trapani"}); n != "0" {
+		t.Error("expected XX not to add members")
+	}
+	for _, bad := range [][]string{
+		{"Sicily", "181", "0", "x"}, {"Sicily", "0", "86", "x"}, {"Sicily", "1", "2"},
+		{"Sicily", "NX", "XX", "1", "2", "x"}, {"Sicily", "a", "2", "x"},
+	} {
+		if _, err := geoaddHandler(bad); err == nil {
+			t.Errorf("expected GEOADD %v to fail", bad)
+		}
+	}
+	if _, err := geodistHandler([]string{"Sicily", "Palermo", "Catania", "parsecs"}); err == nil {
+		t.Error("expected an unknown unit to fail")
+	}
+	if typ, _ := typeHandler([]string{"Sicily"}); typ != "geo" {
+		t.Errorf("expected TYPE geo, got %s", typ)
+	}
+}
+
+func TestGeoSearch(t *testing.T) {
+	seedSicily(t)
+	_, _ = geoaddHandler([]string{"Sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2"})
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC"}, "Catania,Palermo"},
+		{[]string{"FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "DESC", "WITHDIST"}, "Palermo 190.4424,Catania 56.4413"},
+		{[]string{"FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC", "WITHCOORD", "WITHDIST", "WITHHASH"},
+			"Catania 56.4413 3479447370796909 15.087267458438873 37.50266842333161," +
+				"Palermo 190.4424 3479099956230698 13.361389338970184 38.1155563954963," +
+				"edge2 279.7403 3481342659049484 17.241510450839996 38.78813451624225," +
+				"edge1 279.7405 3479273021651468 12.75848776102066 38.78813451624225"},
+		{[]string{"FROMMEMBER", "Palermo", "BYRADIUS", "100", "km"}, "Palermo,edge1"},
+		{[]string{"FROMLONLAT", "15", "37", "BYRADIUS", "1000", "km", "COUNT", "1"}, "Catania"},
+		{[]string{"FROMLONLAT", "15", "37", "BYRADIUS", "1000", "km", "COUNT", "3", "DESC"}, "edge1,edge2,Palermo"},
+		{[]string{"FROMLONLAT", "0", "0", "BYRADIUS", "10", "km"}, ""},
+	} {
+		out, err := geosearchHandler(append([]string{"Sicily"}, tc.args...))
+		if err != nil || out != tc.want {
+			t.Errorf("GEOSEARCH %v: expected %q, got %q (%v)", tc.args, tc.want, out, err)
+		}
+	}
+	out, _ := geosearchHandler([]string{"Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1000", "km", "COUNT", "2", "ANY"})
+	if len(strings.Split(out, ",")) != 2 {
+		t.Errorf("expected COUNT 2 ANY to return two members, got %s", out)
+	}
+	if out, _ := geosearchHandler([]string{"missing", "FROMMEMBER", "x", "BYRADIUS", "1", "m"}); out != "" {
+		t.Errorf("expected nothing for a missing key, got %s", out)
+	}
+	for _, bad := range [][]string{
+		{"Sicily", "BYRADIUS", "1", "km"}, {"Sicily", "FROMLONLAT", "15", "37"},
+		{"Sicily", "FROMMEMBER", "Nowhere", "BYRADIUS", "1", "km"},
+		{"Sicily", "FROMLONLAT", "15", "37", "FROMMEMBER", "Palermo", "BYRADIUS", "1", "km"},
+		{"Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "-1", "km"},
+		{"Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "COUNT", "0"},
+		{"Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "STOREDIST"},
+	} {
+		if _, err := geosearchHandler(bad); err == nil {
+			t.Errorf("expected GEOSEARCH %v to fail", bad)
+		}
+	}
+}
+
+// TestGeoSearchMatchesBruteForce checks searches against testing every
+// member, including across the antimeridian and far from the equator.
+func TestGeoSearchMatchesBruteForce(t *testing.T) {
+	DefaultStore = NewStore()
+	r := rand.New(rand.NewPCG(1, 2))
+	args := []string{"pts"}
+	for i := range 3000 {
+		lon := r.Float64()*360 - 180
+		lat := r.Float64()*170 - 85
+		args = append(args, strconv.FormatFloat(lon, 'f', 6, 64), strconv.FormatFloat(lat, 'f', 6, 64), "p"+strconv.Itoa(i))
+	}
+	if _, err := geoaddHandler(args); err != nil {
+		t.Fatal(err)
+	}
+	set := DefaultStore.data["pts"].(*sortedSet)
+	centers := [][2]float64{{179.9, 0}, {-179.9, 40}, {0, 84}, {10, -80}, {0, 0}}
+	for range 20 {
+		centers = append(centers, [2]float64{r.Float64()*360 - 180, r.Float64()*170 - 85})
+	}
+	for _, c := range centers {
+		for _, radius := range []float64{10, 300, 2000, 8000} {
+			for _, box := range []bool{false, true} {
+				sh := geoShape{lon: c[0], lat: c[1], radius: radius * 1000, width: radius * 2000, height: radius * 1000, box: box}
+				var want []string
+				for member, score := range set.scores {
+					if _, ok := sh.distance(geoDecode(uint64(score))); ok {
+						want = append(want, member)
+					}
+				}
+				q := &geoQuery{shape: sh, unit: 1}
+				results, _ := q.search(set)
+				var got []string
+				for _, res := range results {
+					got = append(got, res.member)
+				}
+				slices.Sort(want)
+				slices.Sort(got)
+				if !slices.Equal(got, want) {
+					t.Errorf("center %v, %v km, box %v: expected %d matches, got %d", c, radius, box, len(want), len(got))
+				}
+			}
+		}
+	}
+}
+
+func TestGeoSearchStore(t *testing.T) {
+	seedSicily(t)
+	out, err := geosearchstoreHandler([]string{"near", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "100", "km"})
+	if err != nil || out != "1" {
+		t.Fatalf("expected 1, got %s (%v)", out, err)
+	}
+	if out, _ := geoposHandler([]string{"near", "Catania"}); out != "15.087267458438873 37.50266842333161" {
+		t.Errorf("expected Catania's position to be kept, got %s", out)
+	}
+	if _, err := geosearchstoreHandler([]string{"near", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "WITHDIST"}); err == nil {
+		t.Error("expected GEOSEARCHSTORE to refuse WITHDIST")
+	}
+	out, _ = geosearchstoreHandler([]string{"near", "Sicily", "FROMLONLAT", "0", "0", "BYRADIUS", "1", "km"})
+	if n, _ := existsHandler([]string{"near"}); out != "0" || n != "0" {
+		t.Errorf("expected an empty result to delete the destination, got %s", out)
+	}
+
+	if err := SaveSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(TEST_FILE)
+	DefaultStore = NewStore()
+	if err := LoadSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := geodistHandler([]string{"Sicily", "Palermo", "Catania", "km"}); out != "166.2742" {
+		t.Errorf("expected 166.2742 after reload, got %s", out)
+	}
+	if out, _ := geosearchHandler([]string{"Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC"}); out != "Catania,Palermo" {
+		t.Errorf("expected Catania,Palermo after reload, got %s", out)
+	}
+}
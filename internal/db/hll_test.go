@@ -0,0 +1,115 @@
+package db
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestPFAddCount(t *testing.T) {
+	DefaultStore = NewStore()
+	if out, _ := pfaddHandler([]string{"hll"}); out != "1" {
+		t.Errorf("expected creating an empty HyperLogLog to reply 1, got %s", out)
+	}
+	if out, _ := pfaddHandler([]string{"hll", "a", "b", "c", "a"}); out != "1" {
+		t.Errorf("expected 1, got %s", out)
+	}
+	if out, _ := pfaddHandler([]string{"hll", "b", "c"}); out != "0" {
+		t.Errorf("expected re-adding elements to reply 0, got %s", out)
+	}
+	if out, _ := pfcountHandler([]string{"hll"}); out != "3" {
+		t.Errorf("expected 3, got %s", out)
+	}
+	if out, _ := pfcountHandler([]string{"missing"}); out != "0" {
+		t.Errorf("expected 0 for a missing key, got %s", out)
+	}
+	if typ, _ := typeHandler([]string{"hll"}); typ != "hyperloglog" {
+		t.Errorf("expected TYPE hyperloglog, got %s", typ)
+	}
+}
+
+func TestPFCountAccuracy(t *testing.T) {
+	for _, n := range []int{100, 1000, 5000, 20000, 200000} {
+		h := &hll{}
+		for i := range n {
+			h.add("visitor:" + strconv.Itoa(i))
+		}
+		if n > hllSparseMax && h.dense == nil {
+			t.Errorf("expected %d elements to make the HyperLogLog dense", n)
+		}
+		// Five standard errors, so the check is all but certain to hold.
+		got := float64(h.count())
+		if diff := math.Abs(got-float64(n)) / float64(n); diff > 5*0.0081 {
+			t.Errorf("estimated %v for %d elements, off by %.2f%%", got, n, diff*100)
+		}
+	}
+}
+
+func TestPFMerge(t *testing.T) {
+	DefaultStore = NewStore()
+	var a, b []string
+	for i := range 3000 {
+		a = append(a, "x"+strconv.Itoa(i))
+		b = append(b, "x"+strconv.Itoa(i+2000))
+	}
+	_, _ = pfaddHandler(append([]string{"a"}, a...))
+	_, _ = pfaddHandler(append([]string{"b"}, b...))
+	union, _ := pfcountHandler([]string{"a", "b", "missing"})
+	if out, err := pfmergeHandler([]string{"dest", "a", "b"}); err != nil || out != "OK" {
+		t.Fatalf("expected OK, got %s (%v)", out, err)
+	}
+	if out, _ := pfcountHandler([]string{"dest"}); out != union {
+		t.Errorf("expected the merged count to match PFCOUNT's union %s, got %s", union, out)
+	}
+	if n, _ := strconv.Atoi(union); n < 4800 || n > 5200 {
+		t.Errorf("expected about 5000 in the union, got %d", n)
+	}
+	// The destination's own registers take part in the merge.
+	_, _ = pfaddHandler([]string{"c", "new"})
+	_, _ = pfmergeHandler([]string{"c", "a"})
+	if out, _ := pfcountHandler([]string{"c"}); out == "0" || out == "1" {
+		t.Errorf("expected the merged count to include both, got %s", out)
+	}
+	_, _ = pfmergeHandler([]string{"empty"})
+	if out, _ := pfcountHandler([]string{"empty"}); out != "0" {
+		t.Errorf("expected an empty merge to create an empty HyperLogLog, got %s", out)
+	}
+	if n, _ := existsHandler([]string{"empty"}); n != "1" {
+		t.Error("expected PFMERGE to create the destination")
+	}
+}
+
+func TestHLLSnapshot(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = pfaddHandler([]string{"sparse", "a", "b", "c"})
+	var elems []string
+	for i := range 10000 {
+		elems = append(elems, strconv.Itoa(i))
+	}
+	_, _ = pfaddHandler(append([]string{"dense"}, elems...))
+	sparse, _ := DefaultStore.data["sparse"].(*hll)
+	if len(sparse.encode()) > 16 {
+		t.Errorf("expected a small sparse encoding, got %d bytes", len(sparse.encode()))
+	}
+	want, _ := pfcountHandler([]string{"dense"})
+	if err := SaveSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(TEST_FILE)
+	DefaultStore = NewStore()
+	if err := LoadSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := pfcountHandler([]string{"sparse"}); out != "3" {
+		t.Errorf("expected 3 after reload, got %s", out)
+	}
+	if out, _ := pfcountHandler([]string{"dense"}); out != want {
+		t.Errorf("expected %s after reload, got %s", want, out)
+	}
+	for _, bad := range [][]byte{nil, {'X'}, {'D', 1}, {'S', 5}, {'S', 0, 0}, {'S', 1, 1, 0, 1}} {
+		if _, err := decodeHLL(bad); err == nil {
+			t.Errorf("expected %v to be rejected", bad)
+		}
+	}
+}
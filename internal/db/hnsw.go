@@ -0,0 +1,265 @@
+package db
+
+import (
+	"cmp"
+	"container/heap"
+	"math"
+	"slices"
+)
+
+// hnswMaxLevel bounds node levels; with M = 2 it still suits 2^16 nodes.
+const hnswMaxLevel = 16
+
+type hnswNode struct {
+	key     string
+	vec     []float32
+	links   [][]int32 // neighbor IDs per level, level 0 first
+	deleted bool
+}
+
+// hnswIndex is a Hierarchical Navigable Small World graph, an approximate
+// nearest neighbor index. Every node is on level 0 and, with probability
+// 1/M per level, on the levels above it, each a proximity graph of its
+// nodes. A search descends greedily from the single entry node on the top
+// level and widens to the ef nearest candidates on level 0.
+//
+// Removed nodes stay in the graph, as other nodes route through them, until
+// they outnumber the rest and the graph is rebuilt. Levels are drawn from
+// the splitmix64 state in rand, so the same adds build the same graph.
+type hnswIndex struct {
+	dist           vectorDistance
+	m              int
+	efConstruction int
+
+	nodes    []*hnswNode
+	ids      map[string]int32 // key -> live node
+	entry    int32            // -1 while empty
+	maxLevel int
+	deleted  int
+	rand     uint64
+
+	visited []uint32 // epoch each node was last visited in
+	epoch   uint32
+}
+
+func newHNSW(dist vectorDistance, m, efConstruction int) *hnswIndex {
+	return &hnswIndex{dist: dist, m: m, efConstruction: efConstruction, ids: make(map[string]int32), entry: -1, rand: 1}
+}
+
+func (h *hnswIndex) len() int {
+	return len(h.ids)
+}
+
+// maxLinks is how many neighbors a node keeps on level.
+func (h *hnswIndex) maxLinks(level int) int {
+	if level == 0 {
+		return 2 * h.m
+	}
+	return h.m
+}
+
+func (h *hnswIndex) randomLevel() int {
+	level := int(-math.Log(1-splitmix(&h.rand)) / math.Log(float64(h.m)))
+	return min(level, hnswMaxLevel)
+}
+
+// hnswCandidate is a node and its distance from the query.
+type hnswCandidate struct {
+	id   int32
+	dist float32
+}
+
+// candidateHeap is a min-heap of candidates, or a max-heap if far is set.
+type candidateHeap struct {
+	items []hnswCandidate
+	far   bool
+}
+
+func (c *candidateHeap) Len() int { return len(c.items) }
+func (c *candidateHeap) Less(i, j int) bool {
+	return c.items[i].dist < c.items[j].dist != c.far
+}
+func (c *candidateHeap) Swap(i, j int) { c.items[i], c.items[j] = c.items[j], c.items[i] }
+func (c *candidateHeap) Push(x any)    { c.items = append(c.items, x.(hnswCandidate)) }
+func (c *candidateHeap) Pop() any {
+	x := c.items[len(c.items)-1]
+	c.items = c.items[:len(c.items)-1]
+	return x
+}
+
+// searchLevel returns up to ef nodes on level near q, nearest first,
+// starting from entries. Only nodes keep accepts are returned, but the
+// search passes through the others.
+func (h *hnswIndex) searchLevel(q []float32, entries []int32, ef, level int, keep func(*hnswNode) bool) []hnswCandidate {
+	if len(h.visited) < len(h.nodes) {
+		h.visited = append(h.visited, make([]uint32, len(h.nodes)-len(h.visited))...)
+	}
+	if h.epoch++; h.epoch == 0 {
+		clear(h.visited)
+		h.epoch = 1
+	}
+	near := &candidateHeap{}
+	found := &candidateHeap{far: true}
+	for _, id := range entries {
+		h.visited[id] = h.epoch
+		c := hnswCandidate{id, h.dist(q, h.nodes[id].vec)}
+		heap.Push(near, c)
+		if keep(h.nodes[id]) {
+			heap.Push(found, c)
+		}
+	}
+	for near.Len() > 0 {
+		c := heap.Pop(near).(hnswCandidate)
+		if found.Len() >= ef && c.dist > found.items[0].dist {
+			break
+		}
+		for _, id := range h.nodes[c.id].links[level] {
+			if h.visited[id] == h.epoch {
+				continue
+			}
+			h.visited[id] = h.epoch
+			d := h.dist(q, h.nodes[id].vec)
+			if found.Len() >= ef && d >= found.items[0].dist {
+				continue
+			}
+			heap.Push(near, hnswCandidate{id, d})
+			if keep(h.nodes[id]) {
+				heap.Push(found, hnswCandidate{id, d})
+				if found.Len() > ef {
+					heap.Pop(found)
+				}
+			}
+		}
+	}
+	out := found.items
+	slices.SortFunc(out, func(a, b hnswCandidate) int { return cmp.Compare(a.dist, b.dist) })
+	return out
+}
+
+// selectNeighbors picks at most n of candidates, nearest first, skipping
+// any that is nearer to one already picked than to the node they are for,
+// which keeps links spread out in every direction.
+func (h *hnswIndex) selectNeighbors(candidates []hnswCandidate, n int) []int32 {
+	picked := make([]int32, 0, n)
+	for _, c := range candidates {
+		if len(picked) == n {
+			break
+		}
+		good := true
+		for _, p := range picked {
+			if h.dist(h.nodes[c.id].vec, h.nodes[p].vec) < c.dist {
+				good = false
+				break
+			}
+		}
+		if good {
+			picked = append(picked, c.id)
+		}
+	}
+	return picked
+}
+
+func liveNode(n *hnswNode) bool { return !n.deleted }
+
+// descend walks greedily from the entry node down to the level above
+// level, returning the node it ends at.
+func (h *hnswIndex) descend(q []float32, level int) []int32 {
+	entries := []int32{h.entry}
+	for l := h.maxLevel; l > level; l-- {
+		next := h.searchLevel(q, entries, 1, l, func(*hnswNode) bool { return true })
+		entries = []int32{next[0].id}
+	}
+	return entries
+}
+
+// add indexes vec under key, replacing any vector it had.
+func (h *hnswIndex) add(key string, vec []float32) {
+	h.remove(key)
+	id := int32(len(h.nodes))
+	level := h.randomLevel()
+	node := &hnswNode{key: key, vec: vec, links: make([][]int32, level+1)}
+	h.nodes = append(h.nodes, node)
+	h.ids[key] = id
+	if h.entry < 0 {
+		h.entry, h.maxLevel = id, level
+		return
+	}
+	entries := h.descend(vec, level)
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		candidates := h.searchLevel(vec, entries, h.efConstruction, l, liveNode)
+		if len(candidates) == 0 {
+			// Every node found is removed; route through them anyway.
+			candidates = h.searchLevel(vec, entries, h.efConstruction, l, func(*hnswNode) bool { return true })
+		}
+		node.links[l] = h.selectNeighbors(candidates, h.m)
+		for _, nb := range node.links[l] {
+			h.link(nb, id, l)
+		}
+		entries = entries[:0]
+		for _, c := range candidates {
+			entries = append(entries, c.id)
+		}
+	}
+	if level > h.maxLevel {
+		h.entry, h.maxLevel = id, level
+	}
+}
+
+// link adds a link from node from to node to on level, pruning from's links
+// if that makes too many.
+func (h *hnswIndex) link(from, to int32, level int) {
+	n := h.nodes[from]
+	n.links[level] = append(n.links[level], to)
+	if len(n.links[level]) <= h.maxLinks(level) {
+		return
+	}
+	candidates := make([]hnswCandidate, len(n.links[level]))
+	for i, id := range n.links[level] {
+		candidates[i] = hnswCandidate{id, h.dist(n.vec, h.nodes[id].vec)}
+	}
+	slices.SortFunc(candidates, func(a, b hnswCandidate) int { return cmp.Compare(a.dist, b.dist) })
+	n.links[level] = h.selectNeighbors(candidates, h.maxLinks(level))
+}
+
+// remove drops key from the index, rebuilding the graph once removed nodes
+// outnumber live ones.
+func (h *hnswIndex) remove(key string) {
+	id, ok := h.ids[key]
+	if !ok {
+		return
+	}
+	delete(h.ids, key)
+	h.nodes[id].deleted = true
+	if h.deleted++; h.deleted > len(h.ids) {
+		h.rebuild()
+	}
+}
+
+// rebuild builds the graph again from the live nodes, in the order they
+// were added.
+func (h *hnswIndex) rebuild() {
+	nodes := h.nodes
+	h.nodes, h.ids, h.entry, h.maxLevel, h.deleted = nil, make(map[string]int32), -1, 0, 0
+	h.visited = nil
+	for _, n := range nodes {
+		if !n.deleted {
+			h.add(n.key, n.vec)
+		}
+	}
+}
+
+// search returns up to k keys nearest q that keep accepts, nearest first,
+// looking at ef candidates at least.
+func (h *hnswIndex) search(q []float32, k, ef int, keep func(key string) bool) []vectorMatch {
+	if h.entry < 0 {
+		return nil
+	}
+	found := h.searchLevel(q, h.descend(q, 0), max(ef, k), 0, func(n *hnswNode) bool {
+		return !n.deleted && keep(n.key)
+	})
+	out := make([]vectorMatch, 0, min(k, len(found)))
+	for _, c := range found[:min(k, len(found))] {
+		out = append(out, vectorMatch{h.nodes[c.id].key, c.dist})
+	}
+	return out
+}
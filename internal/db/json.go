@@ -0,0 +1,391 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"furr/internal/protocol"
+)
+
+// jsonDoc is the value of a JSON key. The document may be any JSON value, so
+// it is wrapped to tell it apart from other types' values.
+type jsonDoc struct {
+	root any
+}
+
+// getJSON returns the document at key. The caller must hold the write lock.
+func (s *Store) getJSON(key string) (*jsonDoc, bool, error) {
+	exists, err := s.checkType(key, JSONType)
+	if !exists {
+		return nil, false, err
+	}
+	return s.data[key].(*jsonDoc), true, nil
+}
+
+// jsonTarget looks up the document at key and what path selects in it. A
+// legacy path must select something.
+func (s *Store) jsonTarget(key, path string) (*jsonDoc, *jsonPath, []jsonMatch, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	doc, exists, err := s.getJSON(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !exists {
+		return nil, p, nil, nil
+	}
+	matches := p.find(doc.root)
+	if p.legacy && len(matches) == 0 {
+		return nil, nil, nil, fmt.Errorf("path '%s' does not exist", path)
+	}
+	return doc, p, matches, nil
+}
+
+// jsonPathArg returns args[i] if given, or the root path.
+func jsonPathArg(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return "."
+}
+
+// jsonSetHandler implements JSON.SET key path value [NX|XX]. New keys must be
+// set at the root; below it, a path whose last step names a missing member
+// of an object adds that member.
+func jsonSetHandler(args []string) (string, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return "", fmt.Errorf("wrong number of arguments for JSON.SET")
+	}
+	var nx, xx bool
+	if len(args) == 4 {
+		switch strings.ToUpper(args[3]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return "", fmt.Errorf("syntax error")
+		}
+	}
+	value, err := parseJSON(args[2])
+	if err != nil {
+		return "", err
+	}
+	p, err := parseJSONPath(args[1])
+	if err != nil {
+		return "", err
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc, exists, err := s.getJSON(args[0])
+	if err != nil {
+		return "", err
+	}
+	if p.isRoot() {
+		if nx && exists || xx && !exists {
+			return nilReply, nil
+		}
+		s.setValue(args[0], JSONType, &jsonDoc{root: value})
+		s.notify(notifyModule, "json.set", args[0])
+		return "OK", nil
+	}
+	if !exists {
+		return "", fmt.Errorf("new objects must be created at the root")
+	}
+	matches := p.find(doc.root)
+	if len(matches) > 0 {
+		if nx {
+			return nilReply, nil
+		}
+		for i, m := range matches {
+			v := value
+			if i > 0 {
+				v = copyJSON(value)
+			}
+			m.set(v)
+		}
+	} else {
+		last := p.steps[len(p.steps)-1]
+		if xx || last.recursive || last.wildcard || last.index != nil || last.slice != nil {
+			return nilReply, nil
+		}
+		var added bool
+		for _, m := range findSteps(p.steps[:len(p.steps)-1], []jsonMatch{{value: doc.root}}) {
+			if obj, ok := m.value.(*jsonObject); ok {
+				obj.set(last.name, copyJSON(value))
+				added = true
+			}
+		}
+		if !added {
+			if p.legacy {
+				return "", fmt.Errorf("path '%s' does not exist", args[1])
+			}
+			return nilReply, nil
+		}
+	}
+	s.notify(notifyModule, "json.set", args[0])
+	return "OK", nil
+}
+
+// jsonGetHandler implements JSON.GET key [path ...]. A legacy path replies
+// with the value it selects and a $ path with an array of every match; with
+// several paths the reply is an object keyed by path.
+func jsonGetHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for JSON.GET")
+	}
+	paths := args[1:]
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := newJSONObject()
+	for _, path := range paths {
+		doc, p, matches, err := s.jsonTarget(args[0], path)
+		if err != nil {
+			return "", err
+		}
+		if doc == nil {
+			return nilReply, nil
+		}
+		if p.legacy {
+			results.set(path, matches[0].value)
+			continue
+		}
+		arr := &jsonArray{}
+		for _, m := range matches {
+			arr.items = append(arr.items, m.value)
+		}
+		results.set(path, arr)
+	}
+	if len(paths) == 1 {
+		return formatJSON(results.vals[paths[0]]), nil
+	}
+	return formatJSON(results), nil
+}
+
+// jsonDelHandler implements JSON.DEL key [path], replying with how many
+// values were removed. Deleting the root deletes the key.
+func jsonDelHandler(args []string) (string, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return "", fmt.Errorf("wrong number of arguments for JSON.DEL")
+	}
+	p, err := parseJSONPath(jsonPathArg(args, 1))
+	if err != nil {
+		return "", err
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc, exists, err := s.getJSON(args[0])
+	if !exists {
+		return "0", err
+	}
+	if p.isRoot() {
+		s.deleteKey(args[0])
+		s.notify(notifyGeneric, "del", args[0])
+		return "1", nil
+	}
+	matches := p.find(doc.root)
+	// Remove later array elements first so earlier indexes stay valid.
+	slices.SortStableFunc(matches, func(a, b jsonMatch) int { return b.index - a.index })
+	for _, m := range matches {
+		switch parent := m.parent.(type) {
+		case *jsonObject:
+			parent.remove(m.key)
+		case *jsonArray:
+			parent.items = slices.Delete(parent.items, m.index, m.index+1)
+		}
+	}
+	if len(matches) > 0 {
+		s.notify(notifyModule, "json.del", args[0])
+	}
+	return strconv.Itoa(len(matches)), nil
+}
+
+// jsonTypeHandler implements JSON.TYPE key [path].
+func jsonTypeHandler(args []string) (string, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return "", fmt.Errorf("wrong number of arguments for JSON.TYPE")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc, p, matches, err := s.jsonTarget(args[0], jsonPathArg(args, 1))
+	if doc == nil || err != nil {
+		return nilReply, err
+	}
+	if p.legacy {
+		return jsonTypeName(matches[0].value), nil
+	}
+	out := make([]string, len(matches))
+	for i, m := range matches {
+		out[i] = jsonTypeName(m.value)
+	}
+	return strings.Join(out, ","), nil
+}
+
+// jsonArrAppendHandler implements JSON.ARRAPPEND key path value [value ...],
+// replying with each array's new length, or nil for matches that are not
+// arrays.
+func jsonArrAppendHandler(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("wrong number of arguments for JSON.ARRAPPEND")
+	}
+	values := make([]any, len(args)-2)
+	for i, arg := range args[2:] {
+		v, err := parseJSON(arg)
+		if err != nil {
+			return "", err
+		}
+		values[i] = v
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc, p, matches, err := s.jsonTarget(args[0], args[1])
+	if err != nil {
+		return "", err
+	}
+	if doc == nil {
+		return "", fmt.Errorf("no such key")
+	}
+	if p.legacy {
+		matches = matches[:1]
+	}
+	out := make([]string, len(matches))
+	appended := false
+	for i, m := range matches {
+		arr, ok := m.value.(*jsonArray)
+		if !ok {
+			if p.legacy {
+				return "", fmt.Errorf("wrong type of path value - expected array but found %s", jsonTypeName(m.value))
+			}
+			out[i] = nilReply
+			continue
+		}
+		for _, v := range values {
+			arr.items = append(arr.items, copyJSON(v))
+		}
+		out[i] = strconv.Itoa(len(arr.items))
+		appended = true
+	}
+	if appended {
+		s.notify(notifyModule, "json.arrappend", args[0])
+	}
+	return strings.Join(out, ","), nil
+}
+
+// addJSONNumbers adds two JSON numbers, staying with integers when both are
+// integers and the sum fits.
+func addJSONNumbers(a, b json.Number) (json.Number, error) {
+	x, errX := a.Int64()
+	y, errY := b.Int64()
+	if errX == nil && errY == nil {
+		if sum := x + y; (sum > x) == (y > 0) {
+			return json.Number(strconv.FormatInt(sum, 10)), nil
+		}
+	}
+	f, _ := a.Float64()
+	g, _ := b.Float64()
+	sum := f + g
+	if math.IsInf(sum, 0) || math.IsNaN(sum) {
+		return "", fmt.Errorf("result is not a finite number")
+	}
+	return json.Number(strconv.FormatFloat(sum, 'g', -1, 64)), nil
+}
+
+// jsonNumIncrByHandler implements JSON.NUMINCRBY key path n. A legacy path
+// replies with the new value and a $ path with an array of new values, null
+// for matches that are not numbers.
+func jsonNumIncrByHandler(args []string) (string, error) {
+	if len(args) != 3 {
+		return "", fmt.Errorf("wrong number of arguments for JSON.NUMINCRBY")
+	}
+	v, err := parseJSON(args[2])
+	n, ok := v.(json.Number)
+	if err != nil || !ok {
+		return "", fmt.Errorf("expected a number")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc, p, matches, err := s.jsonTarget(args[0], args[1])
+	if err != nil {
+		return "", err
+	}
+	if doc == nil {
+		return "", fmt.Errorf("no such key")
+	}
+	if p.legacy {
+		matches = matches[:1]
+	}
+	results := &jsonArray{items: make([]any, len(matches))}
+	changed := false
+	for i, m := range matches {
+		cur, ok := m.value.(json.Number)
+		if !ok {
+			if p.legacy {
+				return "", fmt.Errorf("wrong type of path value - expected number but found %s", jsonTypeName(m.value))
+			}
+			continue
+		}
+		sum, err := addJSONNumbers(cur, n)
+		if err != nil {
+			return "", err
+		}
+		if m.parent == nil {
+			doc.root = sum
+		} else {
+			m.set(sum)
+		}
+		results.items[i] = sum
+		changed = true
+	}
+	if changed {
+		s.notify(notifyModule, "json.numincrby", args[0])
+	}
+	if p.legacy {
+		return formatJSON(results.items[0]), nil
+	}
+	return formatJSON(results), nil
+}
+
+// jsonObjKeysHandler implements JSON.OBJKEYS key [path]. A legacy path
+// replies with the object's keys; a $ path replies with each match's keys
+// quoted like command arguments, or nil for matches that are not objects.
+func jsonObjKeysHandler(args []string) (string, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return "", fmt.Errorf("wrong number of arguments for JSON.OBJKEYS")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc, p, matches, err := s.jsonTarget(args[0], jsonPathArg(args, 1))
+	if doc == nil || err != nil {
+		return nilReply, err
+	}
+	if p.legacy {
+		obj, ok := matches[0].value.(*jsonObject)
+		if !ok {
+			return "", fmt.Errorf("wrong type of path value - expected object but found %s", jsonTypeName(matches[0].value))
+		}
+		return strings.Join(obj.keys, ","), nil
+	}
+	out := make([]string, len(matches))
+	for i, m := range matches {
+		if obj, ok := m.value.(*jsonObject); ok {
+			out[i] = protocol.QuoteArgs(obj.keys)
+		}
+	}
+	return strings.Join(out, ","), nil
+}
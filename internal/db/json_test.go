@@ -0,0 +1,138 @@
+package db
+
+import (
+	"os"
+	"testing"
+)
+
+const testDoc = `{"name":"fox","age":3,"tags":["a","b"],"den":{"x":1.5,"y":-2},"pups":[{"name":"kit","age":1},{"name":"cub","age":2}]}`
+
+func TestJSONSetGet(t *testing.T) {
+	DefaultStore = NewStore()
+	if out, err := jsonSetHandler([]string{"doc", "$", testDoc}); err != nil || out != "OK" {
+		t.Fatalf("expected OK, got %s (%v)", out, err)
+	}
+	for _, tc := range []struct {
+		paths []string
+		want  string
+	}{
+		{nil, testDoc},
+		{[]string{"$.name"}, `["fox"]`},
+		{[]string{".den.x"}, `1.5`},
+		{[]string{"den"}, `{"x":1.5,"y":-2}`},
+		{[]string{"$.tags[-1]"}, `["b"]`},
+		{[]string{"$.pups[*].name"}, `["kit","cub"]`},
+		{[]string{"$..age"}, `[3,1,2]`},
+		{[]string{"$.pups[0:1]"}, `[{"name":"kit","age":1}]`},
+		{[]string{"$['den'].*"}, `[1.5,-2]`},
+		{[]string{"$.missing"}, `[]`},
+		{[]string{"$.name", ".age"}, `{"$.name":["fox"],".age":3}`},
+	} {
+		out, err := jsonGetHandler(append([]string{"doc"}, tc.paths...))
+		if err != nil || out != tc.want {
+			t.Errorf("JSON.GET %v: expected %s, got %s (%v)", tc.paths, tc.want, out, err)
+		}
+	}
+	if _, err := jsonGetHandler([]string{"doc", ".missing"}); err == nil {
+		t.Error("expected a legacy path that selects nothing to fail")
+	}
+	if out, _ := jsonGetHandler([]string{"none"}); out != nilReply {
+		t.Errorf("expected nil for a missing key, got %s", out)
+	}
+
+	for _, cmd := range [][]string{
+		{"doc", "$.name", `"vixen"`}, {"doc", "$.den.z", `[true,null]`}, {"doc", "$.pups[*].age", "0"},
+	} {
+		if out, err := jsonSetHandler(cmd); err != nil || out != "OK" {
+			t.Errorf("JSON.SET %v: expected OK, got %s (%v)", cmd, out, err)
+		}
+	}
+	if out, _ := jsonSetHandler([]string{"doc", "$.name", `"x"`, "NX"}); out != nilReply {
+		t.Errorf("expected NX to refuse an existing path, got %s", out)
+	}
+	if out, _ := jsonSetHandler([]string{"doc", "$.new", "1", "XX"}); out != nilReply {
+		t.Errorf("expected XX to refuse a missing path, got %s", out)
+	}
+	want := `{"name":"vixen","age":3,"tags":["a","b"],"den":{"x":1.5,"y":-2,"z":[true,null]},"pups":[{"name":"kit","age":0},{"name":"cub","age":0}]}`
+	if out, _ := jsonGetHandler([]string{"doc"}); out != want {
+		t.Errorf("expected %s, got %s", want, out)
+	}
+	for _, bad := range [][]string{
+		{"doc", "$", `{"a":}`}, {"doc", "$", `1 2`}, {"doc", "$", ""}, {"fresh", "$.a", "1"}, {"doc", "$[", "1"},
+	} {
+		if _, err := jsonSetHandler(bad); err == nil {
+			t.Errorf("expected JSON.SET %v to fail", bad)
+		}
+	}
+}
+
+func TestJSONModify(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = jsonSetHandler([]string{"doc", "$", testDoc})
+	check := func(name string, out, want string, err error) {
+		t.Helper()
+		if err != nil || out != want {
+			t.Errorf("%s: expected %s, got %s (%v)", name, want, out, err)
+		}
+	}
+	out, err := jsonTypeHandler([]string{"doc", "$..*"})
+	check("JSON.TYPE", out, "string,integer,array,object,array,string,string,number,integer,object,object,string,integer,string,integer", err)
+	out, err = jsonTypeHandler([]string{"doc"})
+	check("JSON.TYPE root", out, "object", err)
+	out, err = jsonArrAppendHandler([]string{"doc", "$.*", `"c"`, `{"d":1}`})
+	check("JSON.ARRAPPEND", out, ",,4,,4", err)
+	out, err = jsonArrAppendHandler([]string{"doc", ".tags", "5"})
+	check("JSON.ARRAPPEND legacy", out, "5", err)
+	if _, err := jsonArrAppendHandler([]string{"doc", ".name", "1"}); err == nil {
+		t.Error("expected ARRAPPEND on a string to fail with a legacy path")
+	}
+	out, err = jsonNumIncrByHandler([]string{"doc", "$..age", "2"})
+	check("JSON.NUMINCRBY", out, "[5,3,4]", err)
+	out, err = jsonNumIncrByHandler([]string{"doc", ".den.x", "1"})
+	check("JSON.NUMINCRBY float", out, "2.5", err)
+	out, err = jsonNumIncrByHandler([]string{"doc", "$.name", "1"})
+	check("JSON.NUMINCRBY non-number", out, "[null]", err)
+	out, err = jsonNumIncrByHandler([]string{"doc", ".age", "9223372036854775807"})
+	check("JSON.NUMINCRBY overflow", out, "9.223372036854776e+18", err)
+	out, err = jsonObjKeysHandler([]string{"doc"})
+	check("JSON.OBJKEYS", out, "name,age,tags,den,pups", err)
+	out, err = jsonObjKeysHandler([]string{"doc", "$.pups[*]"})
+	check("JSON.OBJKEYS $", out, "name age,name age,,d", err)
+	out, err = jsonDelHandler([]string{"doc", "$.tags[0:2]"})
+	check("JSON.DEL slice", out, "2", err)
+	out, err = jsonDelHandler([]string{"doc", "$..name"})
+	check("JSON.DEL recursive", out, "3", err)
+	out, _ = jsonGetHandler([]string{"doc", "$.tags", "$.pups"})
+	if out != `{"$.tags":[["c",{"d":1},5]],"$.pups":[[{"age":3},{"age":4},"c",{"d":1}]]}` {
+		t.Errorf("unexpected document after deletes: %s", out)
+	}
+	out, err = jsonDelHandler([]string{"doc", "$"})
+	check("JSON.DEL root", out, "1", err)
+	if n, _ := existsHandler([]string{"doc"}); n != "0" {
+		t.Error("expected deleting the root to delete the key")
+	}
+}
+
+func TestJSONSnapshot(t *testing.T) {
+	DefaultStore = NewStore()
+	doc := `{"z":"<tag> & \"quote\"\n","a":[1e3,-0.5,12345678901234567890]}`
+	_, _ = jsonSetHandler([]string{"doc", "$", doc})
+	_, _ = jsonSetHandler([]string{"scalar", ".", `"just a string"`})
+	if err := SaveSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(TEST_FILE)
+	DefaultStore = NewStore()
+	if err := LoadSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := jsonGetHandler([]string{"doc"}); out != doc {
+		t.Errorf("expected %s after reload, got %s", doc, out)
+	}
+	if out, _ := jsonTypeHandler([]string{"scalar"}); out != "string" {
+		t.Errorf("expected a string document after reload, got %s", out)
+	}
+	if typ, _ := typeHandler([]string{"doc"}); typ != "json" {
+		t.Errorf("expected TYPE json, got %s", typ)
+	}
+}
@@ -0,0 +1,402 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxJSONDepth is how deeply documents may nest objects and arrays.
+const maxJSONDepth = 128
+
+// jsonObject is a JSON object that keeps its keys in insertion order.
+type jsonObject struct {
+	keys []string
+	vals map[string]any
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{vals: make(map[string]any)}
+}
+
+func (o *jsonObject) set(key string, v any) {
+	if _, ok := o.vals[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.vals[key] = v
+}
+
+func (o *jsonObject) remove(key string) {
+	delete(o.vals, key)
+	o.keys = slices.DeleteFunc(o.keys, func(k string) bool { return k == key })
+}
+
+// jsonArray is a JSON array, held by pointer so paths can change it in place.
+type jsonArray struct {
+	items []any
+}
+
+// A JSON value is a *jsonObject, a *jsonArray, a string, a json.Number, a
+// bool or nil.
+
+// parseJSON parses a single JSON value, keeping numbers exactly as written.
+func parseJSON(text string) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	v, err := decodeJSON(dec, 0)
+	if err == nil {
+		if _, err = dec.Token(); err == io.EOF {
+			return v, nil
+		} else if err == nil {
+			err = errors.New("unexpected data after the value")
+		}
+	}
+	return nil, fmt.Errorf("invalid JSON: %v", err)
+}
+
+func decodeJSON(dec *json.Decoder, depth int) (any, error) {
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+	if depth == maxJSONDepth {
+		return nil, fmt.Errorf("nested deeper than %d levels", maxJSONDepth)
+	}
+	switch delim {
+	case '{':
+		obj := newJSONObject()
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeJSON(dec, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			obj.set(key.(string), v)
+		}
+		_, err = dec.Token()
+		return obj, err
+	case '[':
+		arr := &jsonArray{}
+		for dec.More() {
+			v, err := decodeJSON(dec, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			arr.items = append(arr.items, v)
+		}
+		_, err = dec.Token()
+		return arr, err
+	}
+	return nil, fmt.Errorf("unexpected %v", delim)
+}
+
+// formatJSON renders v as compact JSON.
+func formatJSON(v any) string {
+	var b bytes.Buffer
+	writeJSON(&b, v)
+	return b.String()
+}
+
+func writeJSON(b *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case *jsonObject:
+		b.WriteByte('{')
+		for i, k := range v.keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeJSONString(b, k)
+			b.WriteByte(':')
+			writeJSON(b, v.vals[k])
+		}
+		b.WriteByte('}')
+	case *jsonArray:
+		b.WriteByte('[')
+		for i, item := range v.items {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeJSON(b, item)
+		}
+		b.WriteByte(']')
+	case string:
+		writeJSONString(b, v)
+	case json.Number:
+		b.WriteString(v.String())
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	default:
+		b.WriteString("null")
+	}
+}
+
+func writeJSONString(b *bytes.Buffer, s string) {
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	b.Truncate(b.Len() - 1) // Encode ends with a newline
+}
+
+// copyJSON returns a deep copy of v.
+func copyJSON(v any) any {
+	switch v := v.(type) {
+	case *jsonObject:
+		cp := &jsonObject{keys: slices.Clone(v.keys), vals: make(map[string]any, len(v.vals))}
+		for k, val := range v.vals {
+			cp.vals[k] = copyJSON(val)
+		}
+		return cp
+	case *jsonArray:
+		cp := &jsonArray{items: make([]any, len(v.items))}
+		for i, item := range v.items {
+			cp.items[i] = copyJSON(item)
+		}
+		return cp
+	}
+	return v
+}
+
+// jsonTypeName names the type of a JSON value the way JSON.TYPE replies.
+func jsonTypeName(v any) string {
+	switch v := v.(type) {
+	case *jsonObject:
+		return "object"
+	case *jsonArray:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+// jsonStep is one step of a path.
+type jsonStep struct {
+	recursive bool   // .. descends into every level first
+	wildcard  bool   // * selects every member or element
+	name      string // a member name, when not an index or slice
+	index     *int   // an array index, negative counting from the end
+	slice     *[2]int
+	hasSlice  [2]bool // whether each end of the slice was given
+}
+
+// jsonPath is a parsed path. Paths starting with $ select every match;
+// legacy paths, such as "." or "a.b", select a single value.
+type jsonPath struct {
+	steps  []jsonStep
+	legacy bool
+}
+
+// parseJSONPath parses the supported JSONPath subset: $, .name, ['name'],
+// [n], [start:end], * and .. for recursive descent.
+func parseJSONPath(path string) (*jsonPath, error) {
+	p := &jsonPath{}
+	rest, ok := strings.CutPrefix(path, "$")
+	if !ok {
+		p.legacy = true
+		rest = path
+		if rest == "." {
+			rest = ""
+		} else if !strings.HasPrefix(rest, ".") && !strings.HasPrefix(rest, "[") {
+			rest = "." + rest
+		}
+	}
+	bad := func() (*jsonPath, error) { return nil, fmt.Errorf("invalid JSON path '%s'", path) }
+	for rest != "" {
+		var step jsonStep
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			step.recursive = true
+			rest = rest[2:]
+			if strings.HasPrefix(rest, "[") {
+				break
+			}
+			fallthrough
+		case strings.HasPrefix(rest, "."):
+			rest = strings.TrimPrefix(rest, ".")
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return bad()
+			}
+			step.name, rest = rest[:end], rest[end:]
+			step.wildcard = step.name == "*"
+			p.steps = append(p.steps, step)
+			continue
+		case !strings.HasPrefix(rest, "["):
+			return bad()
+		}
+		end := strings.IndexByte(rest, ']')
+		if end < 0 {
+			return bad()
+		}
+		inner := rest[1:end]
+		rest = rest[end+1:]
+		switch {
+		case inner == "*":
+			step.wildcard = true
+		case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+			step.name = inner[1 : len(inner)-1]
+		case strings.Contains(inner, ":"):
+			lo, hi, _ := strings.Cut(inner, ":")
+			var bounds [2]int
+			for i, s := range []string{lo, hi} {
+				if s = strings.TrimSpace(s); s == "" {
+					continue
+				}
+				n, err := strconv.Atoi(s)
+				if err != nil {
+					return bad()
+				}
+				bounds[i], step.hasSlice[i] = n, true
+			}
+			step.slice = &bounds
+		default:
+			n, err := strconv.Atoi(strings.TrimSpace(inner))
+			if err != nil {
+				return bad()
+			}
+			step.index = &n
+		}
+		p.steps = append(p.steps, step)
+	}
+	return p, nil
+}
+
+// isRoot reports whether the path selects only the whole document.
+func (p *jsonPath) isRoot() bool {
+	return len(p.steps) == 0
+}
+
+// jsonMatch is a value a path selected and where it sits.
+type jsonMatch struct {
+	value  any
+	parent any // *jsonObject, *jsonArray or nil for the document itself
+	key    string
+	index  int
+}
+
+// set replaces the matched value in its parent.
+func (m jsonMatch) set(v any) {
+	switch p := m.parent.(type) {
+	case *jsonObject:
+		p.set(m.key, v)
+	case *jsonArray:
+		p.items[m.index] = v
+	}
+}
+
+// find returns the values the path selects in doc, in document order.
+func (p *jsonPath) find(doc any) []jsonMatch {
+	return findSteps(p.steps, []jsonMatch{{value: doc}})
+}
+
+func findSteps(steps []jsonStep, matches []jsonMatch) []jsonMatch {
+	for _, step := range steps {
+		var next []jsonMatch
+		for _, m := range matches {
+			if step.recursive {
+				for _, d := range descendants(m) {
+					next = append(next, step.apply(d.value)...)
+				}
+			} else {
+				next = append(next, step.apply(m.value)...)
+			}
+		}
+		matches = next
+	}
+	return matches
+}
+
+// descendants returns m and every value nested in it, parents first.
+func descendants(m jsonMatch) []jsonMatch {
+	out := []jsonMatch{m}
+	switch v := m.value.(type) {
+	case *jsonObject:
+		for _, k := range v.keys {
+			out = append(out, descendants(jsonMatch{v.vals[k], v, k, 0})...)
+		}
+	case *jsonArray:
+		for i, item := range v.items {
+			out = append(out, descendants(jsonMatch{item, v, "", i})...)
+		}
+	}
+	return out
+}
+
+// apply returns the children of v that step selects.
+func (step jsonStep) apply(v any) []jsonMatch {
+	var out []jsonMatch
+	switch v := v.(type) {
+	case *jsonObject:
+		switch {
+		case step.wildcard:
+			for _, k := range v.keys {
+				out = append(out, jsonMatch{v.vals[k], v, k, 0})
+			}
+		case step.index == nil && step.slice == nil:
+			if val, ok := v.vals[step.name]; ok {
+				out = append(out, jsonMatch{val, v, step.name, 0})
+			}
+		}
+	case *jsonArray:
+		n := len(v.items)
+		switch {
+		case step.wildcard:
+			for i, item := range v.items {
+				out = append(out, jsonMatch{item, v, "", i})
+			}
+		case step.index != nil:
+			i := *step.index
+			if i < 0 {
+				i += n
+			}
+			if i >= 0 && i < n {
+				out = append(out, jsonMatch{v.items[i], v, "", i})
+			}
+		case step.slice != nil:
+			lo, hi := 0, n
+			if step.hasSlice[0] {
+				lo = clampSliceBound(step.slice[0], n)
+			}
+			if step.hasSlice[1] {
+				hi = clampSliceBound(step.slice[1], n)
+			}
+			for i := lo; i < hi; i++ {
+				out = append(out, jsonMatch{v.items[i], v, "", i})
+			}
+		}
+	}
+	return out
+}
+
+// clampSliceBound resolves a slice bound against an array of length n, with
+// negative bounds counting from the end.
+func clampSliceBound(i, n int) int {
+	if i < 0 {
+		i += n
+	}
+	return max(0, min(i, n))
+}
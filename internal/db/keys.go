@@ -0,0 +1,274 @@
+package db
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// lazyFreeThreshold is the number of elements above which UNLINK releases a
+// value on the background goroutine instead of inline.
+const lazyFreeThreshold = 64
+
+// lazyFree receives values removed by UNLINK for the background freer.
+var lazyFree = make(chan any, 1024)
+
+func typeHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for TYPE")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	DefaultStore.expireIfNeeded(args[0])
+	if _, ok := DefaultStore.data[args[0]]; !ok {
+		return "none", nil
+	}
+	return typeName(DefaultStore.types[args[0]]), nil
+}
+
+// putValue stores v at key with type t and TTL exp (0 for none), waking
+// clients blocked on the key if it is a list. The caller must hold the write
+// lock.
+func (s *Store) putValue(key string, t valueType, v any, exp int64) {
+	delete(s.ttl, key)
+	if lst, ok := v.(*quicklist); ok {
+		s.setList(key, lst)
+	} else {
+		s.setValue(key, t, v)
+	}
+	if exp > 0 {
+		s.ttl[key] = exp
+	}
+}
+
+func rename(args []string, nx bool) (string, error) {
+	src, dst := args[0], args[1]
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	DefaultStore.expireIfNeeded(src)
+	DefaultStore.expireIfNeeded(dst)
+	val, ok := DefaultStore.data[src]
+	if !ok {
+		return "", fmt.Errorf("no such key")
+	}
+	if _, exists := DefaultStore.data[dst]; nx && exists {
+		return "0", nil
+	}
+	if src != dst {
+		t, exp := DefaultStore.types[src], DefaultStore.ttl[src]
+		DefaultStore.deleteKey(src)
+		DefaultStore.notify(notifyGeneric, "rename_from", src)
+		DefaultStore.putValue(dst, t, val, exp)
+		DefaultStore.notify(notifyGeneric, "rename_to", dst)
+		DefaultStore.serveBlockedLists()
+	}
+	if nx {
+		return "1", nil
+	}
+	return "OK", nil
+}
+
+func renameHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for RENAME")
+	}
+	return rename(args, false)
+}
+
+func renamenxHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for RENAMENX")
+	}
+	return rename(args, true)
+}
+
+// copyValue returns a deep copy of a stored value.
+func copyValue(v any) any {
+	switch v := v.(type) {
+	case []byte:
+		return append([]byte(nil), v...)
+	case *quicklist:
+		return newQuicklist(v.Values()...)
+	case map[string]struct{}:
+		set := make(map[string]struct{}, len(v))
+		for m := range v {
+			set[m] = struct{}{}
+		}
+		return set
+	case *stream:
+		return copyStream(v)
+	case *queue:
+		return copyQueue(v)
+	case *jsonDoc:
+		return &jsonDoc{root: copyJSON(v.root)}
+	case *hll:
+		return copyHLL(v)
+	case *bloomFilter:
+		return copyBloom(v)
+	case *cuckooFilter:
+		return copyCuckoo(v)
+	case *countMinSketch:
+		return copySketch(v)
+	case *topK:
+		return copyTopK(v)
+	case *sortedSet:
+		return copySortedSet(v)
+	case *timeSeries:
+		return copyTimeSeries(v)
+	case *vectorValue:
+		return copyVector(v)
+	}
+	return v
+}
+
+// copyHandler implements COPY source destination [DB 0] [REPLACE].
+func copyHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for COPY")
+	}
+	src, dst := args[0], args[1]
+	replace := false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			// There is a single database.
+			if i+1 >= len(args) || args[i+1] != "0" {
+				return "", fmt.Errorf("DB index is out of range")
+			}
+			i++
+		default:
+			return "", fmt.Errorf("syntax error")
+		}
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	DefaultStore.expireIfNeeded(src)
+	DefaultStore.expireIfNeeded(dst)
+	val, ok := DefaultStore.data[src]
+	if !ok || src == dst {
+		return "0", nil
+	}
+	if _, exists := DefaultStore.data[dst]; exists && !replace {
+		return "0", nil
+	}
+	DefaultStore.putValue(dst, DefaultStore.types[src], copyValue(val), DefaultStore.ttl[src])
+	DefaultStore.notify(notifyGeneric, "copy_to", dst)
+	DefaultStore.serveBlockedLists()
+	return "1", nil
+}
+
+// touchHandler counts the given keys that exist. There is no LRU clock, so
+// touching has no other effect.
+func touchHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for TOUCH")
+	}
+	return existsHandler(args)
+}
+
+func randomkeyHandler(args []string) (string, error) {
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	for len(DefaultStore.data) > 0 {
+		// Pick a random non-empty slot, then a random key within it.
+		slot := DefaultStore.slots[rand.IntN(scanSlots)]
+		if len(slot) == 0 {
+			continue
+		}
+		i := rand.IntN(len(slot))
+		for k := range slot {
+			if i > 0 {
+				i--
+				continue
+			}
+			if !DefaultStore.expireIfNeeded(k) {
+				return k, nil
+			}
+			break
+		}
+	}
+	return nilReply, nil
+}
+
+func dbsizeHandler(args []string) (string, error) {
+	DefaultStore.mu.RLock()
+	defer DefaultStore.mu.RUnlock()
+	return strconv.Itoa(len(DefaultStore.data)), nil
+}
+
+// unlinkHandler removes keys like DEL but hands large values to a background
+// goroutine to be torn down, so the keyspace lock is held only for O(1) work
+// per key.
+func unlinkHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for UNLINK")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	removed := 0
+	for _, key := range args {
+		if DefaultStore.expireIfNeeded(key) {
+			continue
+		}
+		val, ok := DefaultStore.data[key]
+		if !ok {
+			continue
+		}
+		DefaultStore.deleteKey(key)
+		DefaultStore.notify(notifyGeneric, "del", key)
+		removed++
+		if valueLen(val) > lazyFreeThreshold {
+			select {
+			case lazyFree <- val:
+			default:
+				// The freer is backed up; let the GC reclaim it.
+			}
+		}
+	}
+	return strconv.Itoa(removed), nil
+}
+
+// valueLen returns the number of elements in a collection value.
+func valueLen(v any) int {
+	switch v := v.(type) {
+	case *quicklist:
+		return v.Len()
+	case map[string]struct{}:
+		return len(v)
+	case *stream:
+		return len(v.Entries)
+	case *queue:
+		return len(v.Jobs) + len(v.Dead)
+	case *sortedSet:
+		return v.Len()
+	case *timeSeries:
+		return len(v.Samples)
+	}
+	return 1
+}
+
+// freeValue dismantles a value so its memory can be reclaimed piecemeal.
+func freeValue(v any) {
+	switch v := v.(type) {
+	case *quicklist:
+		for n := v.head; n != nil; {
+			next := n.next
+			n.prev, n.next = nil, nil
+			n = next
+		}
+		v.head, v.tail, v.length = nil, nil, 0
+	case map[string]struct{}:
+		clear(v)
+	}
+}
+
+func init() {
+	go func() {
+		for v := range lazyFree {
+			freeValue(v)
+		}
+	}()
+}
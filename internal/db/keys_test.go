@@ -0,0 +1,143 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVariadicDelExists(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = msetHandler([]string{"a", "1", "b", "2"})
+	if n, _ := existsHandler([]string{"a", "b", "a", "missing"}); n != "3" {
+		t.Errorf("expected EXISTS to count 3, got %s", n)
+	}
+	if n, _ := delHandler([]string{"a", "b", "missing"}); n != "2" {
+		t.Errorf("expected DEL to remove 2, got %s", n)
+	}
+	if n, _ := dbsizeHandler(nil); n != "0" {
+		t.Errorf("expected empty database, got DBSIZE %s", n)
+	}
+}
+
+func TestTypeCommand(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = setHandler([]string{"s", "v"})
+	_, _ = rpushHandler([]string{"l", "v"})
+	_, _ = saddHandler([]string{"z", "v"})
+	for key, want := range map[string]string{"s": "string", "l": "list", "z": "set", "missing": "none"} {
+		if got, _ := typeHandler([]string{key}); got != want {
+			t.Errorf("TYPE %s: expected %s, got %s", key, want, got)
+		}
+	}
+}
+
+func TestRename(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = setHandler([]string{"src", "v", "EX", "100"})
+	if _, err := renameHandler([]string{"src", "dst"}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := getHandler([]string{"dst"}); v != "v" {
+		t.Errorf("expected v at dst, got %s", v)
+	}
+	if ttl, _ := ttlHandler([]string{"dst"}); ttl != "100" {
+		t.Errorf("expected RENAME to keep TTL, got %s", ttl)
+	}
+	if n, _ := existsHandler([]string{"src"}); n != "0" {
+		t.Error("expected src to be gone after RENAME")
+	}
+	if _, err := renameHandler([]string{"src", "dst"}); err == nil {
+		t.Error("expected no such key error")
+	}
+	_, _ = setHandler([]string{"other", "x"})
+	if n, _ := renamenxHandler([]string{"dst", "other"}); n != "0" {
+		t.Errorf("expected RENAMENX onto existing key to return 0, got %s", n)
+	}
+	if n, _ := renamenxHandler([]string{"dst", "fresh"}); n != "1" {
+		t.Errorf("expected RENAMENX to return 1, got %s", n)
+	}
+	_, _ = setHandler([]string{"plain", "p"})
+	_, _ = renameHandler([]string{"plain", "fresh"})
+	if ttl, _ := ttlHandler([]string{"fresh"}); ttl != "-1" {
+		t.Errorf("expected RENAME to replace destination TTL, got %s", ttl)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = rpushHandler([]string{"l", "a", "b"})
+	if n, _ := copyHandler([]string{"l", "l2"}); n != "1" {
+		t.Errorf("expected COPY to return 1, got %s", n)
+	}
+	_, _ = rpushHandler([]string{"l2", "c"})
+	if out, _ := lrangeHandler([]string{"l", "0", "-1"}); out != "a,b" {
+		t.Errorf("expected copy to be independent of source, got %s", out)
+	}
+	_, _ = saddHandler([]string{"s", "x"})
+	if n, _ := copyHandler([]string{"s", "l2"}); n != "0" {
+		t.Errorf("expected COPY onto existing key to return 0, got %s", n)
+	}
+	if n, _ := copyHandler([]string{"s", "l2", "REPLACE"}); n != "1" {
+		t.Errorf("expected COPY REPLACE to return 1, got %s", n)
+	}
+	if typ, _ := typeHandler([]string{"l2"}); typ != "set" {
+		t.Errorf("expected replaced destination to be a set, got %s", typ)
+	}
+	_, _ = appendHandler([]string{"str", "ab"})
+	_, _ = copyHandler([]string{"str", "str2"})
+	_, _ = setrangeHandler([]string{"str2", "0", "x"})
+	if v, _ := getHandler([]string{"str"}); v != "ab" {
+		t.Errorf("expected a copied string to be independent of its source, got %s", v)
+	}
+	if _, err := copyHandler([]string{"s", "x", "DB", "1"}); err == nil {
+		t.Error("expected error for unknown database")
+	}
+}
+
+func TestTouchRandomKeyUnlink(t *testing.T) {
+	DefaultStore = NewStore()
+	if k, _ := randomkeyHandler(nil); k != nilReply {
+		t.Errorf("expected nil RANDOMKEY on empty database, got %s", k)
+	}
+	_, _ = msetHandler([]string{"a", "1", "b", "2"})
+	if n, _ := touchHandler([]string{"a", "b", "c"}); n != "2" {
+		t.Errorf("expected TOUCH to count 2, got %s", n)
+	}
+	for i := 0; i < 20; i++ {
+		if k, _ := randomkeyHandler(nil); k != "a" && k != "b" {
+			t.Fatalf("unexpected RANDOMKEY %s", k)
+		}
+	}
+
+	big := []string{"big"}
+	for i := 0; i < 2*lazyFreeThreshold; i++ {
+		big = append(big, string(rune('a'+i%26))+string(rune('a'+i/26)))
+	}
+	_, _ = rpushHandler(big)
+	if n, _ := unlinkHandler([]string{"big", "a", "missing"}); n != "2" {
+		t.Errorf("expected UNLINK to remove 2, got %s", n)
+	}
+	if n, _ := dbsizeHandler(nil); n != "1" {
+		t.Errorf("expected DBSIZE 1 after UNLINK, got %s", n)
+	}
+}
+
+func TestRenameWakesBlockedClient(t *testing.T) {
+	DefaultStore = NewStore()
+	done := make(chan string, 1)
+	go func() {
+		out, _ := blpopHandler(testContext(t), []string{"queue", "5"})
+		done <- out
+	}()
+	waitForWaiters(t, "queue", 1)
+	_, _ = rpushHandler([]string{"staging", "job"})
+	_, _ = renameHandler([]string{"staging", "queue"})
+	select {
+	case out := <-done:
+		if out != "queue,job" {
+			t.Errorf("expected queue,job, got %s", out)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked client was not woken by RENAME")
+	}
+}
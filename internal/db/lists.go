@@ -0,0 +1,461 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// getList returns the list stored at key, expiring it first if needed. A
+// missing key yields an empty list that is not yet stored. The caller must
+// hold the write lock.
+func (s *Store) getList(key string) (*quicklist, bool, error) {
+	exists, err := s.checkType(key, ListType)
+	if !exists {
+		return newQuicklist(), false, err
+	}
+	return s.data[key].(*quicklist), true, nil
+}
+
+// setList stores lst at key, removing the key once the list is empty. Clients
+// blocked on the key are served by the next serveBlockedLists call. The
+// caller must hold the write lock.
+func (s *Store) setList(key string, lst *quicklist) {
+	if lst.Len() == 0 {
+		s.removeEmpty(key)
+		return
+	}
+	s.setValue(key, ListType, lst)
+	if len(s.listWaiters[key]) > 0 {
+		s.readyLists = append(s.readyLists, key)
+	}
+}
+
+// pushList adds vals to the head or tail of the list at key. With onlyIfExists
+// nothing is pushed unless the list already exists.
+func pushList(key string, vals []string, head, onlyIfExists bool) (string, error) {
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	lst, exists, err := DefaultStore.getList(key)
+	if err != nil {
+		return "", err
+	}
+	if onlyIfExists && !exists {
+		return "0", nil
+	}
+	for _, v := range vals {
+		// Each value is pushed onto the head in turn, so they end up reversed.
+		if head {
+			lst.PushFront(v)
+		} else {
+			lst.PushBack(v)
+		}
+	}
+	n := lst.Len()
+	DefaultStore.setList(key, lst)
+	DefaultStore.notify(notifyList, pushEvent(head), key)
+	DefaultStore.serveBlockedLists()
+	return strconv.Itoa(n), nil
+}
+
+// popList implements LPOP/RPOP key [count].
+func popList(args []string, head bool) (string, error) {
+	key := args[0]
+	count, withCount := 1, len(args) > 1
+	if withCount {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("value is out of range, must be positive")
+		}
+		count = n
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	lst, exists, err := DefaultStore.getList(key)
+	if !exists {
+		return nilReply, err
+	}
+	popped := make([]string, min(count, lst.Len()))
+	for i := range popped {
+		if head {
+			popped[i], _ = lst.PopFront()
+		} else {
+			popped[i], _ = lst.PopBack()
+		}
+	}
+	if len(popped) > 0 {
+		DefaultStore.notify(notifyList, popEvent(head), key)
+	}
+	DefaultStore.setList(key, lst)
+	if !withCount {
+		return popped[0], nil
+	}
+	return strings.Join(popped, ","), nil
+}
+
+func lpushxHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for LPUSHX")
+	}
+	return pushList(args[0], args[1:], true, true)
+}
+
+func rpushxHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for RPUSHX")
+	}
+	return pushList(args[0], args[1:], false, true)
+}
+
+func llenHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for LLEN")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	lst, _, err := DefaultStore.getList(args[0])
+	return strconv.Itoa(lst.Len()), err
+}
+
+// listIndex resolves a possibly negative index into lst.
+func listIndex(idx, n int) (int, bool) {
+	if idx < 0 {
+		idx += n
+	}
+	return idx, idx >= 0 && idx < n
+}
+
+func lindexHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for LINDEX")
+	}
+	idx, err := strconv.Atoi(args[1])
+	if err != nil {
+		return "", errNotInteger
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	lst, _, err := DefaultStore.getList(args[0])
+	if err != nil {
+		return "", err
+	}
+	i, ok := listIndex(idx, lst.Len())
+	if !ok {
+		return nilReply, nil
+	}
+	return lst.Index(i), nil
+}
+
+func lsetHandler(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("missing argument for LSET")
+	}
+	idx, err := strconv.Atoi(args[1])
+	if err != nil {
+		return "", errNotInteger
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	lst, exists, err := DefaultStore.getList(args[0])
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("no such key")
+	}
+	i, ok := listIndex(idx, lst.Len())
+	if !ok {
+		return "", fmt.Errorf("index out of range")
+	}
+	lst.Set(i, args[2])
+	DefaultStore.notify(notifyList, "lset", args[0])
+	return "OK", nil
+}
+
+func linsertHandler(args []string) (string, error) {
+	if len(args) < 4 {
+		return "", fmt.Errorf("missing argument for LINSERT")
+	}
+	key, pivot, val := args[0], args[2], args[3]
+	var after bool
+	switch strings.ToUpper(args[1]) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		return "", fmt.Errorf("syntax error")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	lst, exists, err := DefaultStore.getList(key)
+	if !exists {
+		return "0", err
+	}
+	vals := lst.Values()
+	for i, v := range vals {
+		if v != pivot {
+			continue
+		}
+		if after {
+			i++
+		}
+		vals = append(vals[:i], append([]string{val}, vals[i:]...)...)
+		DefaultStore.setList(key, newQuicklist(vals...))
+		DefaultStore.notify(notifyList, "linsert", key)
+		return strconv.Itoa(len(vals)), nil
+	}
+	return "-1", nil
+}
+
+// lremHandler implements LREM key count element: count > 0 removes from the
+// head, count < 0 from the tail and count == 0 removes every occurrence.
+func lremHandler(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("missing argument for LREM")
+	}
+	key, val := args[0], args[2]
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return "", errNotInteger
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	list, _, err := DefaultStore.getList(key)
+	if err != nil {
+		return "", err
+	}
+	lst := list.Values()
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	remove := make([]bool, len(lst))
+	removed := 0
+	for n := 0; n < len(lst) && (limit == 0 || removed < limit); n++ {
+		i := n
+		if count < 0 {
+			i = len(lst) - 1 - n
+		}
+		if lst[i] == val {
+			remove[i] = true
+			removed++
+		}
+	}
+	if removed == 0 {
+		return "0", nil
+	}
+	kept := lst[:0]
+	for i, v := range lst {
+		if !remove[i] {
+			kept = append(kept, v)
+		}
+	}
+	DefaultStore.notify(notifyList, "lrem", key)
+	DefaultStore.setList(key, newQuicklist(kept...))
+	return strconv.Itoa(removed), nil
+}
+
+func ltrimHandler(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("missing argument for LTRIM")
+	}
+	key := args[0]
+	start, err1 := strconv.Atoi(args[1])
+	end, err2 := strconv.Atoi(args[2])
+	if err1 != nil || err2 != nil {
+		return "", errNotInteger
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	lst, exists, err := DefaultStore.getList(key)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "OK", nil
+	}
+	DefaultStore.notify(notifyList, "ltrim", key)
+	start, end, ok := normalizeRange(start, end, lst.Len())
+	if !ok {
+		DefaultStore.removeEmpty(key)
+		return "OK", nil
+	}
+	for n := lst.Len() - 1 - end; n > 0; n-- {
+		lst.PopBack()
+	}
+	for ; start > 0; start-- {
+		lst.PopFront()
+	}
+	return "OK", nil
+}
+
+// lposHandler implements LPOS key element [RANK rank] [COUNT num] [MAXLEN len].
+func lposHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for LPOS")
+	}
+	key, val := args[0], args[1]
+	rank, count, maxLen := 1, -1, 0
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return "", fmt.Errorf("syntax error")
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			return "", errNotInteger
+		}
+		switch strings.ToUpper(args[i]) {
+		case "RANK":
+			if n == 0 {
+				return "", fmt.Errorf("RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				return "", fmt.Errorf("COUNT can't be negative")
+			}
+			count = n
+		case "MAXLEN":
+			if n < 0 {
+				return "", fmt.Errorf("MAXLEN can't be negative")
+			}
+			maxLen = n
+		default:
+			return "", fmt.Errorf("syntax error")
+		}
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	list, _, err := DefaultStore.getList(key)
+	if err != nil {
+		return "", err
+	}
+	lst := list.Values()
+	var found []string
+	skip := rank
+	if skip < 0 {
+		skip = -skip
+	}
+	skip--
+	for n := 0; n < len(lst) && (maxLen == 0 || n < maxLen); n++ {
+		i := n
+		if rank < 0 {
+			i = len(lst) - 1 - n
+		}
+		if lst[i] != val {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		found = append(found, strconv.Itoa(i))
+		if count < 0 || (count > 0 && len(found) == count) {
+			break
+		}
+	}
+	if count < 0 {
+		if len(found) == 0 {
+			return nilReply, nil
+		}
+		return found[0], nil
+	}
+	return strings.Join(found, ","), nil
+}
+
+// pushEvent and popEvent name the keyspace events for the head (left) or
+// tail of a list.
+func pushEvent(head bool) string {
+	if head {
+		return "lpush"
+	}
+	return "rpush"
+}
+
+func popEvent(head bool) string {
+	if head {
+		return "lpop"
+	}
+	return "rpop"
+}
+
+// parseListSide parses a LEFT/RIGHT argument, returning true for LEFT.
+func parseListSide(s string) (bool, error) {
+	switch strings.ToUpper(s) {
+	case "LEFT":
+		return true, nil
+	case "RIGHT":
+		return false, nil
+	}
+	return false, fmt.Errorf("syntax error")
+}
+
+// listSideName is the inverse of parseListSide.
+func listSideName(left bool) string {
+	if left {
+		return "LEFT"
+	}
+	return "RIGHT"
+}
+
+// moveList atomically pops an element from one end of src and pushes it onto
+// one end of dst. ok is false if src is empty. Nothing is moved if either key
+// holds another type. The caller must hold the write lock.
+func (s *Store) moveList(src, dst string, fromLeft, toLeft bool) (string, bool, error) {
+	lst, exists, err := s.getList(src)
+	if !exists {
+		return "", false, err
+	}
+	if _, err := s.checkType(dst, ListType); err != nil {
+		return "", false, err
+	}
+	var val string
+	if fromLeft {
+		val, _ = lst.PopFront()
+	} else {
+		val, _ = lst.PopBack()
+	}
+	s.notify(notifyList, popEvent(fromLeft), src)
+	s.setList(src, lst)
+	dstList, _, _ := s.getList(dst)
+	if toLeft {
+		dstList.PushFront(val)
+	} else {
+		dstList.PushBack(val)
+	}
+	s.setList(dst, dstList)
+	s.notify(notifyList, pushEvent(toLeft), dst)
+	return val, true, nil
+}
+
+func lmoveHandler(args []string) (string, error) {
+	if len(args) < 4 {
+		return "", fmt.Errorf("missing argument for LMOVE")
+	}
+	fromLeft, err := parseListSide(args[2])
+	if err != nil {
+		return "", err
+	}
+	toLeft, err := parseListSide(args[3])
+	if err != nil {
+		return "", err
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	val, ok, err := DefaultStore.moveList(args[0], args[1], fromLeft, toLeft)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return nilReply, nil
+	}
+	DefaultStore.serveBlockedLists()
+	return val, nil
+}
+
+func rpoplpushHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for RPOPLPUSH")
+	}
+	return lmoveHandler([]string{args[0], args[1], "RIGHT", "LEFT"})
+}
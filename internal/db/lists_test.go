@@ -0,0 +1,152 @@
+package db
+
+import "testing"
+
+func TestLRangeNegativeIndices(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = rpushHandler([]string{"l", "a", "b", "c", "d"})
+	cases := map[[2]string]string{
+		{"0", "-1"}:   "a,b,c,d",
+		{"-2", "-1"}:  "c,d",
+		{"1", "-2"}:   "b,c",
+		{"-100", "1"}: "a,b",
+		{"3", "1"}:    "",
+		{"5", "10"}:   "",
+	}
+	for r, want := range cases {
+		if got, _ := lrangeHandler([]string{"l", r[0], r[1]}); got != want {
+			t.Errorf("LRANGE %s %s: expected %q, got %q", r[0], r[1], want, got)
+		}
+	}
+}
+
+func TestListPushPopVariants(t *testing.T) {
+	DefaultStore = NewStore()
+	if n, _ := lpushxHandler([]string{"l", "a"}); n != "0" {
+		t.Errorf("expected LPUSHX on missing key to return 0, got %s", n)
+	}
+	_, _ = rpushHandler([]string{"l", "a", "b", "c", "d", "e"})
+	if n, _ := rpushxHandler([]string{"l", "f"}); n != "6" {
+		t.Errorf("expected 6 from RPUSHX, got %s", n)
+	}
+	if out, _ := lpopHandler([]string{"l", "2"}); out != "a,b" {
+		t.Errorf("expected a,b from LPOP with count, got %s", out)
+	}
+	if out, _ := rpopHandler([]string{"l", "2"}); out != "f,e" {
+		t.Errorf("expected f,e from RPOP with count, got %s", out)
+	}
+	if out, _ := lpopHandler([]string{"l", "10"}); out != "c,d" {
+		t.Errorf("expected c,d from LPOP with large count, got %s", out)
+	}
+	if exists, _ := existsHandler([]string{"l"}); exists != "0" {
+		t.Errorf("expected empty list to be removed, got exists=%s", exists)
+	}
+	if _, err := lpopHandler([]string{"l", "-1"}); err == nil {
+		t.Error("expected error for negative count")
+	}
+}
+
+func TestListIndexCommands(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = rpushHandler([]string{"l", "a", "b", "c"})
+	if n, _ := llenHandler([]string{"l"}); n != "3" {
+		t.Errorf("expected LLEN 3, got %s", n)
+	}
+	if v, _ := lindexHandler([]string{"l", "-1"}); v != "c" {
+		t.Errorf("expected c from LINDEX -1, got %s", v)
+	}
+	if v, _ := lindexHandler([]string{"l", "3"}); v != nilReply {
+		t.Errorf("expected nil from out-of-range LINDEX, got %s", v)
+	}
+	if _, err := lsetHandler([]string{"l", "-3", "A"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lsetHandler([]string{"l", "5", "x"}); err == nil {
+		t.Error("expected index out of range error from LSET")
+	}
+	if _, err := lsetHandler([]string{"missing", "0", "x"}); err == nil {
+		t.Error("expected no such key error from LSET")
+	}
+	if n, _ := linsertHandler([]string{"l", "BEFORE", "b", "x"}); n != "4" {
+		t.Errorf("expected 4 from LINSERT, got %s", n)
+	}
+	if n, _ := linsertHandler([]string{"l", "AFTER", "c", "y"}); n != "5" {
+		t.Errorf("expected 5 from LINSERT, got %s", n)
+	}
+	if n, _ := linsertHandler([]string{"l", "AFTER", "zzz", "y"}); n != "-1" {
+		t.Errorf("expected -1 for missing pivot, got %s", n)
+	}
+	if out, _ := lrangeHandler([]string{"l", "0", "-1"}); out != "A,x,b,c,y" {
+		t.Errorf("expected A,x,b,c,y, got %s", out)
+	}
+}
+
+func TestLRemLTrimLPos(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = rpushHandler([]string{"l", "x", "a", "x", "b", "x", "c", "x"})
+	if n, _ := lremHandler([]string{"l", "2", "x"}); n != "2" {
+		t.Errorf("expected 2 removed, got %s", n)
+	}
+	if out, _ := lrangeHandler([]string{"l", "0", "-1"}); out != "a,b,x,c,x" {
+		t.Errorf("expected a,b,x,c,x, got %s", out)
+	}
+	if n, _ := lremHandler([]string{"l", "-1", "x"}); n != "1" {
+		t.Errorf("expected 1 removed from tail, got %s", n)
+	}
+	if out, _ := lrangeHandler([]string{"l", "0", "-1"}); out != "a,b,x,c" {
+		t.Errorf("expected a,b,x,c, got %s", out)
+	}
+
+	_, _ = rpushHandler([]string{"p", "a", "b", "c", "1", "2", "3", "c", "c"})
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"p", "c"}, "2"},
+		{[]string{"p", "c", "RANK", "2"}, "6"},
+		{[]string{"p", "c", "RANK", "-1"}, "7"},
+		{[]string{"p", "c", "COUNT", "2"}, "2,6"},
+		{[]string{"p", "c", "COUNT", "0"}, "2,6,7"},
+		{[]string{"p", "c", "RANK", "-1", "COUNT", "2"}, "7,6"},
+		{[]string{"p", "c", "COUNT", "0", "MAXLEN", "7"}, "2,6"},
+		{[]string{"p", "zzz"}, nilReply},
+	}
+	for _, c := range cases {
+		if got, _ := lposHandler(c.args); got != c.want {
+			t.Errorf("LPOS %v: expected %q, got %q", c.args, c.want, got)
+		}
+	}
+
+	_, _ = ltrimHandler([]string{"p", "1", "-2"})
+	if out, _ := lrangeHandler([]string{"p", "0", "-1"}); out != "b,c,1,2,3,c" {
+		t.Errorf("expected b,c,1,2,3,c after LTRIM, got %s", out)
+	}
+	_, _ = ltrimHandler([]string{"p", "5", "1"})
+	if exists, _ := existsHandler([]string{"p"}); exists != "0" {
+		t.Errorf("expected empty LTRIM to remove key, got exists=%s", exists)
+	}
+}
+
+func TestLMove(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = rpushHandler([]string{"src", "a", "b", "c"})
+	if v, _ := lmoveHandler([]string{"src", "dst", "RIGHT", "LEFT"}); v != "c" {
+		t.Errorf("expected c, got %s", v)
+	}
+	if v, _ := lmoveHandler([]string{"src", "dst", "LEFT", "RIGHT"}); v != "a" {
+		t.Errorf("expected a, got %s", v)
+	}
+	if out, _ := lrangeHandler([]string{"dst", "0", "-1"}); out != "c,a" {
+		t.Errorf("expected c,a, got %s", out)
+	}
+	if v, _ := rpoplpushHandler([]string{"src", "src"}); v != "b" {
+		t.Errorf("expected b from rotating single-element list, got %s", v)
+	}
+	if out, _ := lrangeHandler([]string{"src", "0", "-1"}); out != "b" {
+		t.Errorf("expected b, got %s", out)
+	}
+	_, _ = lpopHandler([]string{"src"})
+	if v, _ := rpoplpushHandler([]string{"src", "dst"}); v != nilReply {
+		t.Errorf("expected nil from empty source, got %s", v)
+	}
+}
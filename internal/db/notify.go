@@ -0,0 +1,141 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"furr/internal/glob"
+	"furr/internal/pubsub"
+)
+
+// eventClass is a set of keyspace notification classes, configured with the
+// same letters as Redis' notify-keyspace-events.
+type eventClass uint16
+
+const (
+	notifyKeyspace eventClass = 1 << iota // K: publish on __keyspace@0__:<key>
+	notifyKeyevent                        // E: publish on __keyevent@0__:<event>
+	notifyGeneric                         // g: DEL, EXPIRE, RENAME, ...
+	notifyString                          // $
+	notifyList                            // l
+	notifySet                             // s
+	notifyExpired                         // x: a key's TTL ran out
+	notifyStream                          // t
+	notifyModule                          // d
+	notifyNew                             // n: a key was created
+
+	// notifyAll is what "A" stands for; it leaves out n like Redis.
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet |
+		notifyExpired | notifyStream | notifyModule
+)
+
+// eventFlags maps configuration letters to classes, in the order String
+// writes them.
+var eventFlags = []struct {
+	flag  byte
+	class eventClass
+}{
+	{'g', notifyGeneric}, {'$', notifyString}, {'l', notifyList}, {'s', notifySet},
+	{'x', notifyExpired}, {'t', notifyStream}, {'d', notifyModule}, {'n', notifyNew},
+	{'K', notifyKeyspace}, {'E', notifyKeyevent},
+}
+
+// unsupportedFlags are Redis classes whose events never happen here: there
+// are no hashes (h) or sorted sets (z), nothing is evicted (e) and key misses
+// (m) are not reported. They are refused rather than silently never firing.
+const unsupportedFlags = "hzem"
+
+func parseEventClasses(flags string) (eventClass, error) {
+	var c eventClass
+next:
+	for i := 0; i < len(flags); i++ {
+		if flags[i] == 'A' {
+			c |= notifyAll
+			continue
+		}
+		for _, f := range eventFlags {
+			if f.flag == flags[i] {
+				c |= f.class
+				continue next
+			}
+		}
+		if strings.IndexByte(unsupportedFlags, flags[i]) >= 0 {
+			return 0, fmt.Errorf("event class %q is not supported", flags[i])
+		}
+		return 0, fmt.Errorf("invalid event class character %q", flags[i])
+	}
+	return c, nil
+}
+
+func (c eventClass) String() string {
+	var b strings.Builder
+	if c&notifyAll == notifyAll {
+		b.WriteByte('A')
+		c &^= notifyAll
+	}
+	for _, f := range eventFlags {
+		if c&f.class != 0 {
+			b.WriteByte(f.flag)
+		}
+	}
+	return b.String()
+}
+
+// SetNotifyKeyspaceEvents selects which keyspace notifications the store
+// publishes, using Redis' notify-keyspace-events letters. Nothing is published
+// unless K or E is given along with at least one event class.
+func (s *Store) SetNotifyKeyspaceEvents(flags string) error {
+	c, err := parseEventClasses(flags)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = c
+	return nil
+}
+
+// notify publishes event for key if its class is enabled. Every change to the
+// keyspace goes through here, so it also counts changes for the append-only
+// log, drops the SSCAN order of key and sets off triggers. The caller must
+// hold the write lock.
+func (s *Store) notify(class eventClass, event, key string) {
+	s.dirty++
+	delete(s.memberOrders, key)
+	s.queueTriggers(class, event, key)
+	if s.events&class == 0 {
+		return
+	}
+	if s.events&notifyKeyspace != 0 {
+		pubsub.Publish("__keyspace@0__:"+key, event)
+	}
+	if s.events&notifyKeyevent != 0 {
+		pubsub.Publish("__keyevent@0__:"+event, key)
+	}
+}
+
+// configHandler implements CONFIG GET and CONFIG SET for the parameters that
+// can be changed at runtime, currently only notify-keyspace-events.
+func configHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for CONFIG")
+	}
+	switch sub, param := strings.ToUpper(args[0]), strings.ToLower(args[1]); {
+	case sub == "GET" && len(args) == 2:
+		if !glob.Match(param, "notify-keyspace-events") {
+			return "", nil
+		}
+		DefaultStore.mu.RLock()
+		defer DefaultStore.mu.RUnlock()
+		return "notify-keyspace-events," + DefaultStore.events.String(), nil
+	case sub == "SET" && len(args) == 3:
+		if param != "notify-keyspace-events" {
+			return "", fmt.Errorf("unknown option or number of arguments for CONFIG SET - '%s'", args[1])
+		}
+		if err := DefaultStore.SetNotifyKeyspaceEvents(args[2]); err != nil {
+			return "", err
+		}
+		return "OK", nil
+	}
+	return "", fmt.Errorf("unknown subcommand or wrong number of arguments for '%s'", args[0])
+}
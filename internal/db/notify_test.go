@@ -0,0 +1,107 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"furr/internal/pubsub"
+)
+
+// drain returns the messages already queued for s, as "channel:payload".
+func drain(t *testing.T, s *pubsub.Subscriber, n int) []string {
+	t.Helper()
+	var got []string
+	for len(got) < n {
+		done := make(chan pubsub.Message, 1)
+		go func() {
+			m, _ := s.Next()
+			done <- m
+		}()
+		select {
+		case m := <-done:
+			got = append(got, m.Channel+":"+m.Payload)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected %d notifications, got %v", n, got)
+		}
+	}
+	return got
+}
+
+func TestEventClassFlags(t *testing.T) {
+	c, err := parseEventClasses("KEA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.String() != "AKE" {
+		t.Errorf("expected AKE, got %s", c.String())
+	}
+	if c, _ := parseEventClasses("Elg"); c.String() != "glE" {
+		t.Errorf("expected glE, got %s", c.String())
+	}
+	if _, err := parseEventClasses("Kq"); err == nil {
+		t.Error("expected error for unknown class")
+	}
+	for _, flags := range []string{"Em", "Ke", "Kh", "Ez"} {
+		if _, err := parseEventClasses(flags); err == nil {
+			t.Errorf("expected error for %s, whose events are never published", flags)
+		}
+	}
+}
+
+func TestConfigNotifyKeyspaceEvents(t *testing.T) {
+	DefaultStore = NewStore()
+	if out, _ := configHandler([]string{"GET", "notify-keyspace-events"}); out != "notify-keyspace-events," {
+		t.Errorf("expected notifications off by default, got %s", out)
+	}
+	if _, err := configHandler([]string{"SET", "notify-keyspace-events", "K$"}); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := configHandler([]string{"GET", "notify-*"}); out != "notify-keyspace-events,$K" {
+		t.Errorf("expected $K, got %s", out)
+	}
+	if _, err := configHandler([]string{"SET", "maxmemory", "1"}); err == nil {
+		t.Error("expected error for unsupported parameter")
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	DefaultStore = NewStore()
+	s := pubsub.NewSubscriber(0)
+	defer s.Close()
+	s.PSubscribe("__key*@0__:*")
+	_ = DefaultStore.SetNotifyKeyspaceEvents("KEgl")
+
+	_, _ = setHandler([]string{"str", "v"}) // string events are not enabled
+	_, _ = rpushHandler([]string{"q", "a"})
+	_, _ = lpopHandler([]string{"q"})
+	_, _ = renameHandler([]string{"str", "other"})
+	want := []string{
+		"__keyspace@0__:q:rpush", "__keyevent@0__:rpush:q",
+		"__keyspace@0__:q:lpop", "__keyevent@0__:lpop:q",
+		"__keyspace@0__:q:del", "__keyevent@0__:del:q",
+		"__keyspace@0__:str:rename_from", "__keyevent@0__:rename_from:str",
+		"__keyspace@0__:other:rename_to", "__keyevent@0__:rename_to:other",
+	}
+	if got := drain(t, s, len(want)); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("expected\n%v\ngot\n%v", want, got)
+	}
+}
+
+func TestExpiredNotifications(t *testing.T) {
+	DefaultStore = NewStore()
+	s := pubsub.NewSubscriber(0)
+	defer s.Close()
+	s.Subscribe("__keyevent@0__:expired")
+	_ = DefaultStore.SetNotifyKeyspaceEvents("Ex")
+
+	// One key expires on access, the other is left to the background cleaner.
+	_, _ = setHandler([]string{"lazy", "v", "PX", "10"})
+	_, _ = setHandler([]string{"session", "v", "PX", "10"})
+	time.Sleep(20 * time.Millisecond)
+	_, _ = getHandler([]string{"lazy"})
+	got := drain(t, s, 2)
+	if got[0] != "__keyevent@0__:expired:lazy" || got[1] != "__keyevent@0__:expired:session" {
+		t.Errorf("unexpected expired events %v", got)
+	}
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"furr/internal/pubsub"
+)
+
+// publishHandler implements PUBLISH channel message, replying with the number
+// of subscribers that received it. Subscribing needs a connection, so the
+// SUBSCRIBE family lives in the server.
+func publishHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for PUBLISH")
+	}
+	return strconv.Itoa(pubsub.Publish(args[0], args[1])), nil
+}
+
+// pubsubHandler implements PUBSUB CHANNELS [pattern], PUBSUB NUMSUB
+// [channel ...] and PUBSUB NUMPAT.
+func pubsubHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for PUBSUB")
+	}
+	switch sub := strings.ToUpper(args[0]); {
+	case sub == "CHANNELS" && len(args) <= 2:
+		pattern := ""
+		if len(args) == 2 {
+			pattern = args[1]
+		}
+		return strings.Join(pubsub.Default.Channels(pattern), ","), nil
+	case sub == "NUMSUB":
+		counts := pubsub.Default.NumSub(args[1:]...)
+		out := make([]string, 0, 2*len(counts))
+		for i, n := range counts {
+			out = append(out, args[1+i], strconv.Itoa(n))
+		}
+		return strings.Join(out, ","), nil
+	case sub == "NUMPAT" && len(args) == 1:
+		return strconv.Itoa(pubsub.Default.NumPat()), nil
+	}
+	return "", fmt.Errorf("unknown subcommand or wrong number of arguments for '%s'", args[0])
+}
@@ -0,0 +1,32 @@
+package db
+
+import (
+	"testing"
+
+	"furr/internal/pubsub"
+)
+
+func TestPublishAndPubsubIntrospection(t *testing.T) {
+	s := pubsub.NewSubscriber(0)
+	defer s.Close()
+	s.Subscribe("chat")
+	s.PSubscribe("log.*")
+	if n, _ := publishHandler([]string{"chat", "hi"}); n != "1" {
+		t.Errorf("expected 1 receiver, got %s", n)
+	}
+	if m, _ := s.Next(); m.Payload != "hi" {
+		t.Errorf("expected hi, got %+v", m)
+	}
+	if out, _ := pubsubHandler([]string{"CHANNELS", "ch*"}); out != "chat" {
+		t.Errorf("expected chat, got %s", out)
+	}
+	if out, _ := pubsubHandler([]string{"NUMSUB", "chat", "none"}); out != "chat,1,none,0" {
+		t.Errorf("expected chat,1,none,0, got %s", out)
+	}
+	if out, _ := pubsubHandler([]string{"NUMPAT"}); out != "1" {
+		t.Errorf("expected 1, got %s", out)
+	}
+	if _, err := pubsubHandler([]string{"BOGUS"}); err == nil {
+		t.Error("expected error for unknown subcommand")
+	}
+}
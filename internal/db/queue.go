@@ -0,0 +1,550 @@
+package db
+
+import (
+	"container/heap"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"furr/internal/protocol"
+)
+
+// defaultVisibilityTimeout is how long a reserved job stays hidden from other
+// workers unless QRESERVE gives a TIMEOUT.
+const defaultVisibilityTimeout = 30 * time.Second
+
+// queueJob is one job of a queue. Its fields are exported so snapshots can
+// store it as is.
+type queueJob struct {
+	ID          uint64
+	Payload     string
+	Priority    int64
+	MaxAttempts int64 // 0 for no limit
+	Attempts    int64 // times reserved so far
+	ReadyAt     int64 // unix ms a delayed job becomes ready, 0 once ready
+	Deadline    int64 // unix ms a reservation runs out, 0 if not reserved
+
+	index int // position in the heap holding the job
+}
+
+// jobHeap is a heap of jobs ordered by less. Each job is in at most one heap.
+type jobHeap struct {
+	jobs []*queueJob
+	less func(a, b *queueJob) bool
+}
+
+func (h *jobHeap) Len() int           { return len(h.jobs) }
+func (h *jobHeap) Less(i, j int) bool { return h.less(h.jobs[i], h.jobs[j]) }
+func (h *jobHeap) Swap(i, j int) {
+	h.jobs[i], h.jobs[j] = h.jobs[j], h.jobs[i]
+	h.jobs[i].index, h.jobs[j].index = i, j
+}
+func (h *jobHeap) Push(x any) {
+	j := x.(*queueJob)
+	j.index = len(h.jobs)
+	h.jobs = append(h.jobs, j)
+}
+func (h *jobHeap) Pop() any {
+	j := h.jobs[len(h.jobs)-1]
+	h.jobs[len(h.jobs)-1] = nil
+	h.jobs = h.jobs[:len(h.jobs)-1]
+	return j
+}
+
+// peek returns the first job, or nil if h is empty.
+func (h *jobHeap) peek() *queueJob {
+	if len(h.jobs) == 0 {
+		return nil
+	}
+	return h.jobs[0]
+}
+
+// queue is a reliable job queue. A job is ready, delayed until ReadyAt,
+// reserved by a worker until Deadline, or dead after running out of
+// attempts. Jobs move between states lazily, as of the time of each command,
+// the same way keys expire.
+type queue struct {
+	NextID uint64
+	Jobs   map[uint64]*queueJob // ready, delayed and reserved jobs
+	Dead   []*queueJob          // dead-lettered jobs, oldest first
+
+	Enqueued, Acked, Redelivered, DeadLettered int64
+
+	ready    *jobHeap // highest priority first, then oldest
+	delayed  *jobHeap // soonest ReadyAt first
+	reserved *jobHeap // soonest Deadline first
+}
+
+func newQueue() *queue {
+	q := &queue{Jobs: make(map[uint64]*queueJob)}
+	q.initHeaps()
+	return q
+}
+
+// initHeaps sorts the jobs into the state heaps, which snapshots do not
+// store.
+func (q *queue) initHeaps() {
+	q.ready = &jobHeap{less: func(a, b *queueJob) bool {
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		return a.ID < b.ID
+	}}
+	q.delayed = &jobHeap{less: func(a, b *queueJob) bool {
+		return a.ReadyAt < b.ReadyAt || (a.ReadyAt == b.ReadyAt && a.ID < b.ID)
+	}}
+	q.reserved = &jobHeap{less: func(a, b *queueJob) bool {
+		return a.Deadline < b.Deadline || (a.Deadline == b.Deadline && a.ID < b.ID)
+	}}
+	for _, j := range q.Jobs {
+		q.place(j)
+	}
+}
+
+// place pushes j onto the heap for its state.
+func (q *queue) place(j *queueJob) {
+	switch {
+	case j.Deadline > 0:
+		heap.Push(q.reserved, j)
+	case j.ReadyAt > 0:
+		heap.Push(q.delayed, j)
+	default:
+		heap.Push(q.ready, j)
+	}
+}
+
+// release returns a reserved or nacked job to the queue, ready at readyAt,
+// or dead-letters it if it has used up its attempts.
+func (q *queue) release(j *queueJob, readyAt int64) {
+	j.Deadline = 0
+	if j.MaxAttempts > 0 && j.Attempts >= j.MaxAttempts {
+		delete(q.Jobs, j.ID)
+		q.Dead = append(q.Dead, j)
+		q.DeadLettered++
+		return
+	}
+	j.ReadyAt = readyAt
+	q.place(j)
+}
+
+// advance moves delayed jobs that are due to ready and takes back
+// reservations that ran out, as of now. It reports whether any job moved.
+func (q *queue) advance(now int64) bool {
+	moved := false
+	for j := q.delayed.peek(); j != nil && j.ReadyAt <= now; j = q.delayed.peek() {
+		heap.Pop(q.delayed)
+		j.ReadyAt = 0
+		heap.Push(q.ready, j)
+		moved = true
+	}
+	for j := q.reserved.peek(); j != nil && j.Deadline <= now; j = q.reserved.peek() {
+		heap.Pop(q.reserved)
+		q.Redelivered++
+		q.release(j, 0)
+		moved = true
+	}
+	return moved
+}
+
+// getQueue returns the queue at key, or a new empty queue if the key does not
+// exist, brought up to date as of now. Bringing it up to date is a change of
+// its own, which even a read must log with logQueueCommand. The caller must
+// hold the write lock.
+func (s *Store) getQueue(key string, now int64) (*queue, bool, error) {
+	exists, err := s.checkType(key, QueueType)
+	if !exists {
+		return newQueue(), false, err
+	}
+	q := s.data[key].(*queue)
+	if q.advance(now) {
+		s.notify(notifyModule, "qadvance", key)
+	}
+	return q, true, nil
+}
+
+// formatJob renders a job as its ID, attempts so far and payload, quoted as
+// command arguments.
+func formatJob(j *queueJob) string {
+	return protocol.QuoteArgs([]string{strconv.FormatUint(j.ID, 10), strconv.FormatInt(j.Attempts, 10), j.Payload})
+}
+
+// parseQueueNow strips a trailing NOW unix-ms option from args. Queue
+// commands evaluate delays and timeouts as of that time, which is how the
+// append-only log replays them the same way; it defaults to the clock. Only
+// the log may set the time, so NOW is taken only while Replaying.
+func parseQueueNow(args []string) ([]string, int64, error) {
+	if n := len(args); n >= 2 && Replaying.Load() && strings.EqualFold(args[n-2], "NOW") {
+		now, err := strconv.ParseInt(args[n-1], 10, 64)
+		if err != nil {
+			return nil, 0, errNotInteger
+		}
+		return args[:n-2], now, nil
+	}
+	return args, time.Now().UnixMilli(), nil
+}
+
+// logQueueCommand logs the running queue command with the time it was
+// evaluated at, if it changed anything, which includes advancing the queue
+// in getQueue. The caller must hold the write lock.
+func (s *Store) logQueueCommand(name string, args []string, now int64) {
+	if s.dirty == s.log.dirty {
+		return
+	}
+	s.logAs(name, append(append([]string{name}, args...), "NOW", strconv.FormatInt(now, 10)))
+}
+
+// parseNonNegative parses a millisecond or count argument.
+func parseNonNegative(arg string) (int64, error) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("value is out of range, must be positive")
+	}
+	return n, nil
+}
+
+// qaddHandler implements QADD key payload [DELAY ms] [PRIORITY p]
+// [MAXATTEMPTS n], replying with the new job's ID.
+func qaddHandler(args []string) (string, error) {
+	args, now, err := parseQueueNow(args)
+	if err != nil {
+		return "", err
+	}
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for QADD")
+	}
+	job := &queueJob{Payload: args[1]}
+	for i := 2; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return "", fmt.Errorf("syntax error")
+		}
+		switch strings.ToUpper(args[i]) {
+		case "DELAY":
+			delay, err := parseNonNegative(args[i+1])
+			if err != nil {
+				return "", err
+			}
+			if delay > 0 {
+				job.ReadyAt = now + delay
+			}
+		case "PRIORITY":
+			if job.Priority, err = strconv.ParseInt(args[i+1], 10, 64); err != nil {
+				return "", errNotInteger
+			}
+		case "MAXATTEMPTS":
+			if job.MaxAttempts, err = parseNonNegative(args[i+1]); err != nil {
+				return "", err
+			}
+		default:
+			return "", fmt.Errorf("syntax error")
+		}
+		i++
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, exists, err := s.getQueue(args[0], now)
+	if err != nil {
+		return "", err
+	}
+	defer s.logQueueCommand("QADD", args, now)
+	q.NextID++
+	job.ID = q.NextID
+	q.Jobs[job.ID] = job
+	q.place(job)
+	q.Enqueued++
+	if !exists {
+		s.setValue(args[0], QueueType, q)
+	}
+	s.notify(notifyModule, "qadd", args[0])
+	return strconv.FormatUint(job.ID, 10), nil
+}
+
+// qreserveHandler implements QRESERVE key [TIMEOUT ms] [COUNT n]. It hands
+// out up to n ready jobs, which are redelivered unless acknowledged within
+// the visibility timeout.
+func qreserveHandler(args []string) (string, error) {
+	args, now, err := parseQueueNow(args)
+	if err != nil {
+		return "", err
+	}
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for QRESERVE")
+	}
+	timeout, count := defaultVisibilityTimeout.Milliseconds(), int64(1)
+	for i := 1; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return "", fmt.Errorf("syntax error")
+		}
+		switch strings.ToUpper(args[i]) {
+		case "TIMEOUT":
+			if timeout, err = parseNonNegative(args[i+1]); err != nil {
+				return "", err
+			}
+		case "COUNT":
+			if count, err = parseNonNegative(args[i+1]); err != nil {
+				return "", err
+			}
+		default:
+			return "", fmt.Errorf("syntax error")
+		}
+		i++
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, _, err := s.getQueue(args[0], now)
+	if err != nil {
+		return "", err
+	}
+	defer s.logQueueCommand("QRESERVE", args, now)
+	var out []string
+	for ; count > 0 && q.ready.Len() > 0; count-- {
+		j := heap.Pop(q.ready).(*queueJob)
+		j.Attempts++
+		j.Deadline = now + max(timeout, 1)
+		heap.Push(q.reserved, j)
+		out = append(out, formatJob(j))
+	}
+	if len(out) == 0 {
+		return nilReply, nil
+	}
+	s.notify(notifyModule, "qreserve", args[0])
+	return strings.Join(out, ","), nil
+}
+
+// reservedJob returns the job with the given ID if it is reserved.
+func (q *queue) reservedJob(arg string) *queueJob {
+	id, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return nil
+	}
+	if j := q.Jobs[id]; j != nil && j.Deadline > 0 {
+		return j
+	}
+	return nil
+}
+
+// qackHandler implements QACK key id [id ...], completing reserved jobs.
+// Jobs whose reservation ran out can no longer be acknowledged.
+func qackHandler(args []string) (string, error) {
+	args, now, err := parseQueueNow(args)
+	if err != nil {
+		return "", err
+	}
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for QACK")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, exists, err := s.getQueue(args[0], now)
+	if !exists {
+		return "0", err
+	}
+	defer s.logQueueCommand("QACK", args, now)
+	acked := 0
+	for _, arg := range args[1:] {
+		if j := q.reservedJob(arg); j != nil {
+			heap.Remove(q.reserved, j.index)
+			delete(q.Jobs, j.ID)
+			q.Acked++
+			acked++
+		}
+	}
+	if acked > 0 {
+		s.notify(notifyModule, "qack", args[0])
+	}
+	return strconv.Itoa(acked), nil
+}
+
+// qnackHandler implements QNACK key id [DELAY ms], handing a reserved job
+// back for another attempt, or to the dead letters if it has none left.
+func qnackHandler(args []string) (string, error) {
+	args, now, err := parseQueueNow(args)
+	if err != nil {
+		return "", err
+	}
+	if len(args) != 2 && len(args) != 4 {
+		return "", fmt.Errorf("wrong number of arguments for QNACK")
+	}
+	var delay int64
+	if len(args) == 4 {
+		if !strings.EqualFold(args[2], "DELAY") {
+			return "", fmt.Errorf("syntax error")
+		}
+		if delay, err = parseNonNegative(args[3]); err != nil {
+			return "", err
+		}
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, exists, err := s.getQueue(args[0], now)
+	if !exists {
+		return "0", err
+	}
+	defer s.logQueueCommand("QNACK", args, now)
+	j := q.reservedJob(args[1])
+	if j == nil {
+		return "0", nil
+	}
+	heap.Remove(q.reserved, j.index)
+	readyAt := int64(0)
+	if delay > 0 {
+		readyAt = now + delay
+	}
+	q.release(j, readyAt)
+	s.notify(notifyModule, "qnack", args[0])
+	return "1", nil
+}
+
+// qdeadHandler implements QDEAD key [COUNT n], listing dead-lettered jobs
+// oldest first.
+func qdeadHandler(args []string) (string, error) {
+	args, now, err := parseQueueNow(args)
+	if err != nil {
+		return "", err
+	}
+	if len(args) != 1 && len(args) != 3 {
+		return "", fmt.Errorf("wrong number of arguments for QDEAD")
+	}
+	count := int64(-1)
+	if len(args) == 3 {
+		if !strings.EqualFold(args[1], "COUNT") {
+			return "", fmt.Errorf("syntax error")
+		}
+		if count, err = parseNonNegative(args[2]); err != nil {
+			return "", err
+		}
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, _, err := s.getQueue(args[0], now)
+	if err != nil {
+		return "", err
+	}
+	defer s.logQueueCommand("QDEAD", args, now)
+	dead := q.Dead
+	if count >= 0 && count < int64(len(dead)) {
+		dead = dead[:count]
+	}
+	out := make([]string, len(dead))
+	for i, j := range dead {
+		out[i] = formatJob(j)
+	}
+	return strings.Join(out, ","), nil
+}
+
+// qrequeueHandler implements QREQUEUE key [id ...], moving dead-lettered jobs,
+// or all of them, back to ready with their attempts reset.
+func qrequeueHandler(args []string) (string, error) {
+	args, now, err := parseQueueNow(args)
+	if err != nil {
+		return "", err
+	}
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for QREQUEUE")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, exists, err := s.getQueue(args[0], now)
+	if !exists {
+		return "0", err
+	}
+	defer s.logQueueCommand("QREQUEUE", args, now)
+	requeue := func(j *queueJob) bool {
+		if len(args) == 1 {
+			return true
+		}
+		return slices.Contains(args[1:], strconv.FormatUint(j.ID, 10))
+	}
+	moved := 0
+	q.Dead = slices.DeleteFunc(q.Dead, func(j *queueJob) bool {
+		if !requeue(j) {
+			return false
+		}
+		j.Attempts, j.ReadyAt = 0, 0
+		q.Jobs[j.ID] = j
+		q.place(j)
+		moved++
+		return true
+	})
+	if moved > 0 {
+		s.notify(notifyModule, "qrequeue", args[0])
+	}
+	return strconv.Itoa(moved), nil
+}
+
+// qstatsHandler implements QSTATS key, replying with name,value pairs for
+// the jobs in each state and the lifetime counters.
+func qstatsHandler(args []string) (string, error) {
+	args, now, err := parseQueueNow(args)
+	if err != nil {
+		return "", err
+	}
+	if len(args) != 1 {
+		return "", fmt.Errorf("wrong number of arguments for QSTATS")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, _, err := s.getQueue(args[0], now)
+	if err != nil {
+		return "", err
+	}
+	defer s.logQueueCommand("QSTATS", args, now)
+	stats := []struct {
+		name string
+		n    int64
+	}{
+		{"ready", int64(q.ready.Len())},
+		{"delayed", int64(q.delayed.Len())},
+		{"reserved", int64(q.reserved.Len())},
+		{"dead", int64(len(q.Dead))},
+		{"enqueued", q.Enqueued},
+		{"acked", q.Acked},
+		{"redelivered", q.Redelivered},
+		{"deadlettered", q.DeadLettered},
+	}
+	out := make([]string, 0, 2*len(stats))
+	for _, st := range stats {
+		out = append(out, st.name, strconv.FormatInt(st.n, 10))
+	}
+	return strings.Join(out, ","), nil
+}
+
+// copyQueue returns a deep copy of q.
+func copyQueue(q *queue) *queue {
+	cp := &queue{
+		NextID:       q.NextID,
+		Jobs:         make(map[uint64]*queueJob, len(q.Jobs)),
+		Dead:         make([]*queueJob, len(q.Dead)),
+		Enqueued:     q.Enqueued,
+		Acked:        q.Acked,
+		Redelivered:  q.Redelivered,
+		DeadLettered: q.DeadLettered,
+	}
+	for id, j := range q.Jobs {
+		c := *j
+		cp.Jobs[id] = &c
+	}
+	for i, j := range q.Dead {
+		c := *j
+		cp.Dead[i] = &c
+	}
+	cp.initHeaps()
+	return cp
+}
+
+// restoreQueue rebuilds what snapshots leave out of a queue.
+func restoreQueue(q *queue) *queue {
+	if q.Jobs == nil {
+		q.Jobs = make(map[uint64]*queueJob)
+	}
+	q.initHeaps()
+	return q
+}
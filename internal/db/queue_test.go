@@ -0,0 +1,168 @@
+package db
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// queueAt runs a queue command as of the given unix ms time, the way
+// replaying the append-only log does.
+func queueAt(t *testing.T, now string, args ...string) string {
+	t.Helper()
+	Replaying.Store(true)
+	defer Replaying.Store(false)
+	out, err := Commands[args[0]](append(args[1:], "NOW", now))
+	if err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return out
+}
+
+func TestQueuePriorityAndDelay(t *testing.T) {
+	DefaultStore = NewStore()
+	queueAt(t, "1000", "QADD", "q", "low")
+	queueAt(t, "1000", "QADD", "q", "high", "PRIORITY", "5")
+	queueAt(t, "1000", "QADD", "q", "later", "PRIORITY", "9", "DELAY", "500")
+	if out := queueAt(t, "1000", "QRESERVE", "q", "COUNT", "5"); out != "2 1 high,1 1 low" {
+		t.Errorf("expected ready jobs by priority, got %s", out)
+	}
+	if out := queueAt(t, "1499", "QRESERVE", "q"); out != nilReply {
+		t.Errorf("expected the delayed job to stay hidden, got %s", out)
+	}
+	if out := queueAt(t, "1500", "QRESERVE", "q"); out != "3 1 later" {
+		t.Errorf("expected the delayed job once due, got %s", out)
+	}
+	if _, err := qaddHandler([]string{"q", "x", "DELAY", "-1"}); err == nil {
+		t.Error("expected error for a negative delay")
+	}
+}
+
+func TestQueueAckAndRedelivery(t *testing.T) {
+	DefaultStore = NewStore()
+	queueAt(t, "0", "QADD", "q", "a")
+	queueAt(t, "0", "QADD", "q", "b")
+	queueAt(t, "0", "QRESERVE", "q", "TIMEOUT", "100", "COUNT", "2")
+	if out := queueAt(t, "50", "QACK", "q", "1", "7"); out != "1" {
+		t.Errorf("expected one job acked, got %s", out)
+	}
+	if out := queueAt(t, "100", "QACK", "q", "2"); out != "0" {
+		t.Errorf("expected an expired reservation to refuse the ack, got %s", out)
+	}
+	if out := queueAt(t, "100", "QRESERVE", "q"); out != "2 2 b" {
+		t.Errorf("expected b redelivered on its second attempt, got %s", out)
+	}
+	want := "ready,0,delayed,0,reserved,1,dead,0,enqueued,2,acked,1,redelivered,1,deadlettered,0"
+	if out := queueAt(t, "100", "QSTATS", "q"); out != want {
+		t.Errorf("expected %s, got %s", want, out)
+	}
+}
+
+func TestQueueDeadLetters(t *testing.T) {
+	DefaultStore = NewStore()
+	queueAt(t, "0", "QADD", "q", "flaky", "MAXATTEMPTS", "2")
+	queueAt(t, "0", "QRESERVE", "q")
+	if out := queueAt(t, "10", "QNACK", "q", "1", "DELAY", "40"); out != "1" {
+		t.Fatalf("expected the nack to succeed, got %s", out)
+	}
+	if out := queueAt(t, "20", "QRESERVE", "q"); out != nilReply {
+		t.Errorf("expected the nacked job to wait out its delay, got %s", out)
+	}
+	queueAt(t, "50", "QRESERVE", "q", "TIMEOUT", "10")
+	if out := queueAt(t, "60", "QDEAD", "q"); out != "1 2 flaky" {
+		t.Errorf("expected the job dead after two attempts, got %s", out)
+	}
+	if out := queueAt(t, "60", "QREQUEUE", "q"); out != "1" {
+		t.Errorf("expected one job requeued, got %s", out)
+	}
+	if out := queueAt(t, "60", "QRESERVE", "q"); out != "1 1 flaky" {
+		t.Errorf("expected the requeued job with its attempts reset, got %s", out)
+	}
+}
+
+func TestQueuePersistence(t *testing.T) {
+	DefaultStore = NewStore()
+	logged := captureLog(t)
+	exec := func(args ...string) {
+		t.Helper()
+		if _, err := Exec(args[0], args[1:]); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+	exec("QADD", "q", "a", "DELAY", "60000")
+	exec("QADD", "q", "b", "MAXATTEMPTS", "1")
+	exec("QADD", "q", "c", "PRIORITY", "1")
+	exec("QRESERVE", "q", "COUNT", "2", "TIMEOUT", "60000")
+	exec("QACK", "q", "3")
+	exec("QNACK", "q", "2")
+	stats, _ := qstatsHandler([]string{"q"})
+
+	for _, cmd := range *logged {
+		if cmd[len(cmd)-2] != "NOW" {
+			t.Errorf("expected %v to be logged with its time", cmd)
+		}
+	}
+	replay(t, *logged)
+	if out, _ := qstatsHandler([]string{"q"}); out != stats {
+		t.Errorf("expected replayed stats %s, got %s", stats, out)
+	}
+
+	if err := SaveSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(TEST_FILE)
+	before := copyQueue(DefaultStore.data["q"].(*queue))
+	DefaultStore = NewStore()
+	if err := LoadSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	after := DefaultStore.data["q"].(*queue)
+	if !reflect.DeepEqual(before.Jobs, after.Jobs) || !reflect.DeepEqual(before.Dead, after.Dead) {
+		t.Errorf("expected jobs to survive the reload, got %v", after.Jobs)
+	}
+	if out, _ := qstatsHandler([]string{"q"}); out != stats {
+		t.Errorf("expected reloaded stats %s, got %s", stats, out)
+	}
+	if out, _ := qaddHandler([]string{"q", "d"}); out != "4" {
+		t.Errorf("expected IDs to carry on after the reload, got %s", out)
+	}
+}
+
+func TestQueueNowOnlyFromTheLog(t *testing.T) {
+	DefaultStore = NewStore()
+	logged := captureLog(t)
+	_, _ = Exec("QADD", []string{"q", "job"})
+	_, _ = Exec("QRESERVE", []string{"q", "TIMEOUT", "60000"})
+	if _, err := Exec("QSTATS", []string{"q", "NOW", "99999999999999"}); err == nil {
+		t.Error("expected a client's NOW to be refused")
+	}
+	if _, err := Exec("QRESERVE", []string{"q", "NOW", "99999999999999"}); err == nil {
+		t.Error("expected a client's NOW to be refused")
+	}
+	if out, _ := qstatsHandler([]string{"q"}); out != "ready,0,delayed,0,reserved,1,dead,0,enqueued,1,acked,0,redelivered,0,deadlettered,0" {
+		t.Errorf("expected no redelivery, got %s", out)
+	}
+	if len(*logged) != 2 {
+		t.Errorf("expected only QADD and QRESERVE to be logged, got %v", *logged)
+	}
+}
+
+func TestQueueAdvanceIsLogged(t *testing.T) {
+	DefaultStore = NewStore()
+	logged := captureLog(t)
+	_, _ = Exec("QADD", []string{"q", "job"})
+	_, _ = Exec("QRESERVE", []string{"q", "TIMEOUT", "1"})
+	time.Sleep(5 * time.Millisecond)
+	stats, _ := Exec("QSTATS", []string{"q"})
+	if want := "ready,1,delayed,0,reserved,0,dead,0,enqueued,1,acked,0,redelivered,1,deadlettered,0"; stats != want {
+		t.Fatalf("expected the reservation to run out, got %s", stats)
+	}
+	if n := len(*logged); n != 3 || (*logged)[2][0] != "QSTATS" {
+		t.Fatalf("expected the redelivering QSTATS to be logged, got %v", *logged)
+	}
+	replay(t, *logged)
+	if out, _ := qstatsHandler([]string{"q"}); out != stats {
+		t.Errorf("expected replayed stats %s, got %s", stats, out)
+	}
+}
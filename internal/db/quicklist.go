@@ -0,0 +1,191 @@
+package db
+
+// quicklistChunk is the number of elements each quicklist node can hold.
+const quicklistChunk = 128
+
+// quicklistMinNode is how many slots a node starts with; it doubles them as
+// it fills, up to quicklistChunk, so short lists stay small.
+const quicklistMinNode = 4
+
+// quicklist is a deque of strings stored as a doubly linked list of chunks
+// of up to quicklistChunk elements. Pushes and pops at either end are
+// amortized O(1), and a node is released as soon as it is emptied, so memory
+// stays proportional to the current length rather than the list's history.
+type quicklist struct {
+	head, tail *quicklistNode
+	length     int
+}
+
+// quicklistNode holds its elements in items[start : start+count].
+type quicklistNode struct {
+	prev, next   *quicklistNode
+	items        []string
+	start, count int
+}
+
+// grow doubles the slots of n, up to quicklistChunk, adding the new ones at
+// the front or the back.
+func (n *quicklistNode) grow(front bool) {
+	size := min(max(2*len(n.items), quicklistMinNode), quicklistChunk)
+	items := make([]string, size)
+	start := n.start
+	if front {
+		start += size - len(n.items)
+	}
+	copy(items[start:], n.items[n.start:n.start+n.count])
+	n.items, n.start = items, start
+}
+
+func newQuicklist(vals ...string) *quicklist {
+	q := &quicklist{}
+	for _, v := range vals {
+		q.PushBack(v)
+	}
+	return q
+}
+
+func (q *quicklist) Len() int {
+	return q.length
+}
+
+func (q *quicklist) PushFront(v string) {
+	if q.head == nil || q.head.start == 0 && len(q.head.items) == quicklistChunk {
+		n := &quicklistNode{next: q.head}
+		if q.head != nil {
+			q.head.prev = n
+		} else {
+			q.tail = n
+		}
+		q.head = n
+	}
+	if q.head.start == 0 {
+		q.head.grow(true)
+	}
+	q.head.start--
+	q.head.items[q.head.start] = v
+	q.head.count++
+	q.length++
+}
+
+func (q *quicklist) PushBack(v string) {
+	if q.tail == nil || q.tail.start+q.tail.count == quicklistChunk {
+		n := &quicklistNode{prev: q.tail}
+		if q.tail != nil {
+			q.tail.next = n
+		} else {
+			q.head = n
+		}
+		q.tail = n
+	}
+	if q.tail.start+q.tail.count == len(q.tail.items) {
+		q.tail.grow(false)
+	}
+	q.tail.items[q.tail.start+q.tail.count] = v
+	q.tail.count++
+	q.length++
+}
+
+func (q *quicklist) PopFront() (string, bool) {
+	n := q.head
+	if n == nil {
+		return "", false
+	}
+	v := n.items[n.start]
+	n.items[n.start] = ""
+	n.start++
+	n.count--
+	q.length--
+	if n.count == 0 {
+		q.unlink(n)
+	}
+	return v, true
+}
+
+func (q *quicklist) PopBack() (string, bool) {
+	n := q.tail
+	if n == nil {
+		return "", false
+	}
+	n.count--
+	v := n.items[n.start+n.count]
+	n.items[n.start+n.count] = ""
+	q.length--
+	if n.count == 0 {
+		q.unlink(n)
+	}
+	return v, true
+}
+
+func (q *quicklist) unlink(n *quicklistNode) {
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else {
+		q.head = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	} else {
+		q.tail = n.prev
+	}
+}
+
+// locate returns the node and slot holding element i, walking from whichever
+// end is closer.
+func (q *quicklist) locate(i int) (*quicklistNode, int) {
+	if i < q.length/2 {
+		for n := q.head; n != nil; n = n.next {
+			if i < n.count {
+				return n, n.start + i
+			}
+			i -= n.count
+		}
+		return nil, 0
+	}
+	i = q.length - 1 - i
+	for n := q.tail; n != nil; n = n.prev {
+		if i < n.count {
+			return n, n.start + n.count - 1 - i
+		}
+		i -= n.count
+	}
+	return nil, 0
+}
+
+// Index returns element i, which must be in [0, Len()).
+func (q *quicklist) Index(i int) string {
+	n, slot := q.locate(i)
+	return n.items[slot]
+}
+
+// Set replaces element i, which must be in [0, Len()).
+func (q *quicklist) Set(i int, v string) {
+	n, slot := q.locate(i)
+	n.items[slot] = v
+}
+
+// Range returns elements start through end inclusive, which must be a valid
+// range.
+func (q *quicklist) Range(start, end int) []string {
+	out := make([]string, 0, end-start+1)
+	n, slot := q.locate(start)
+	for len(out) < cap(out) {
+		out = append(out, n.items[slot])
+		slot++
+		if slot == n.start+n.count {
+			n = n.next
+			if n == nil {
+				break
+			}
+			slot = n.start
+		}
+	}
+	return out
+}
+
+// Values returns a copy of every element, head first.
+func (q *quicklist) Values() []string {
+	if q.length == 0 {
+		return nil
+	}
+	return q.Range(0, q.length-1)
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"encoding/gob"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestQuicklistDeque(t *testing.T) {
+	q := newQuicklist()
+	var want []string
+	// Cross several chunk boundaries from both ends.
+	for i := 0; i < 3*quicklistChunk; i++ {
+		q.PushBack(strconv.Itoa(i))
+		want = append(want, strconv.Itoa(i))
+		q.PushFront(strconv.Itoa(-i - 1))
+		want = append([]string{strconv.Itoa(-i - 1)}, want...)
+	}
+	if q.Len() != len(want) {
+		t.Fatalf("expected length %d, got %d", len(want), q.Len())
+	}
+	if !reflect.DeepEqual(q.Values(), want) {
+		t.Fatal("values out of order")
+	}
+	for _, i := range []int{0, 1, quicklistChunk - 1, quicklistChunk, len(want) / 2, len(want) - 1} {
+		if got := q.Index(i); got != want[i] {
+			t.Errorf("Index(%d): expected %s, got %s", i, want[i], got)
+		}
+	}
+	q.Set(quicklistChunk, "x")
+	want[quicklistChunk] = "x"
+	if got := q.Range(quicklistChunk-1, quicklistChunk+1); !reflect.DeepEqual(got, want[quicklistChunk-1:quicklistChunk+2]) {
+		t.Errorf("expected %v, got %v", want[quicklistChunk-1:quicklistChunk+2], got)
+	}
+	for len(want) > 0 {
+		v, _ := q.PopFront()
+		if v != want[0] {
+			t.Fatalf("PopFront: expected %s, got %s", want[0], v)
+		}
+		want = want[1:]
+		if len(want) == 0 {
+			break
+		}
+		v, _ = q.PopBack()
+		if v != want[len(want)-1] {
+			t.Fatalf("PopBack: expected %s, got %s", want[len(want)-1], v)
+		}
+		want = want[:len(want)-1]
+	}
+	if _, ok := q.PopFront(); ok || q.head != nil || q.tail != nil {
+		t.Error("expected empty quicklist to release all nodes")
+	}
+}
+
+func TestQuicklistBoundedNodes(t *testing.T) {
+	q := newQuicklist()
+	// A queue that is pushed and popped forever should not accumulate nodes.
+	for i := 0; i < 100*quicklistChunk; i++ {
+		q.PushBack("job")
+		q.PushBack("job")
+		q.PopFront()
+		q.PopFront()
+	}
+	if q.head != nil {
+		t.Error("expected drained queue to hold no nodes")
+	}
+}
+
+func TestQuicklistNodesGrow(t *testing.T) {
+	q := newQuicklist("a", "b")
+	q.PushFront("z")
+	if q.head != q.tail || len(q.head.items) >= quicklistChunk {
+		t.Fatalf("expected one small node for a short list, got %d slots", len(q.head.items))
+	}
+	if got := q.Values(); !reflect.DeepEqual(got, []string{"z", "a", "b"}) {
+		t.Errorf("expected [z a b], got %v", got)
+	}
+	for i := 0; i < quicklistChunk; i++ {
+		q.PushFront("x")
+	}
+	for n := q.head; n != nil; n = n.next {
+		if len(n.items) > quicklistChunk {
+			t.Errorf("expected nodes to stop growing at %d slots, got %d", quicklistChunk, len(n.items))
+		}
+	}
+}
+
+func TestListSnapshotCompatibility(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = rpushHandler([]string{"l", "a", "b", "c"})
+	if err := SaveSnapshot(TEST_FILE); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+	defer os.Remove(TEST_FILE)
+	DefaultStore = NewStore()
+	if err := LoadSnapshot(TEST_FILE); err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+	if out, _ := lrangeHandler([]string{"l", "0", "-1"}); out != "a,b,c" {
+		t.Errorf("expected a,b,c after load, got %s", out)
+	}
+
+	// Dumps written before lists were chunked store them as plain slices.
+	f, err := os.Create(TEST_FILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gob.NewEncoder(f).Encode(struct {
+		Data  map[string]any
+		Types map[string]valueType
+		TTL   map[string]int64
+	}{map[string]any{"old": []string{"x", "y"}}, map[string]valueType{"old": ListType}, map[string]int64{}})
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadSnapshot(TEST_FILE); err != nil {
+		t.Fatalf("LoadSnapshot of legacy dump failed: %v", err)
+	}
+	if out, _ := lpopHandler([]string{"old", "2"}); out != "x,y" {
+		t.Errorf("expected x,y from legacy list, got %s", out)
+	}
+}
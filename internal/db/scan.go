@@ -0,0 +1,243 @@
+package db
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	"furr/internal/glob"
+)
+
+// scanSlots is the number of hash slots SCAN walks. Every key lives in the
+// slot given by its hash, so a stateless cursor naming the next slot returns
+// each key that exists for the whole iteration exactly once per pass.
+const scanSlots = 1 << 12
+
+func keySlot(key string) int {
+	return int(hash64(key) & (scanSlots - 1))
+}
+
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// indexKey records key in its scan slot. The caller must hold the write lock.
+func (s *Store) indexKey(key string) {
+	slot := &s.slots[keySlot(key)]
+	if *slot == nil {
+		*slot = make(map[string]struct{})
+	}
+	(*slot)[key] = struct{}{}
+}
+
+// typeName returns the name TYPE and SCAN ... TYPE use for t.
+func typeName(t valueType) string {
+	switch t {
+	case StringType:
+		return "string"
+	case ListType:
+		return "list"
+	case SetType:
+		return "set"
+	case StreamType:
+		return "stream"
+	case QueueType:
+		return "queue"
+	case JSONType:
+		return "json"
+	case HLLType:
+		return "hyperloglog"
+	case BloomType:
+		return "bloom"
+	case CuckooType:
+		return "cuckoo"
+	case CMSType:
+		return "cms"
+	case TopKType:
+		return "topk"
+	case GeoType:
+		return "geo"
+	case TimeSeriesType:
+		return "timeseries"
+	case VectorType:
+		return "vector"
+	}
+	return "none"
+}
+
+type scanOptions struct {
+	match    string
+	count    int
+	typeName string
+}
+
+// parseScanOptions parses [MATCH pattern] [COUNT count], plus [TYPE type] if
+// allowType is set.
+func parseScanOptions(args []string, allowType bool) (scanOptions, error) {
+	opts := scanOptions{count: 10}
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return opts, fmt.Errorf("syntax error")
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			opts.match = args[i+1]
+		case "COUNT":
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return opts, errNotInteger
+			}
+			if n < 1 {
+				return opts, fmt.Errorf("syntax error")
+			}
+			opts.count = n
+		case "TYPE":
+			if !allowType {
+				return opts, fmt.Errorf("syntax error")
+			}
+			opts.typeName = strings.ToLower(args[i+1])
+		default:
+			return opts, fmt.Errorf("syntax error")
+		}
+	}
+	return opts, nil
+}
+
+func (o scanOptions) matches(name string) bool {
+	return o.match == "" || glob.Match(o.match, name)
+}
+
+func parseCursor(s string) (uint64, error) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	return n, nil
+}
+
+// scanHandler implements SCAN cursor [MATCH pattern] [COUNT count] [TYPE type].
+// The reply is the next cursor followed by the keys found, comma separated; a
+// next cursor of 0 ends the iteration. COUNT is the number of keys to examine
+// and whole slots are always returned, so a reply may hold more or fewer keys.
+func scanHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for SCAN")
+	}
+	cursor, err := parseCursor(args[0])
+	if err != nil {
+		return "", err
+	}
+	opts, err := parseScanOptions(args[1:], true)
+	if err != nil {
+		return "", err
+	}
+	DefaultStore.mu.RLock()
+	defer DefaultStore.mu.RUnlock()
+	reply := []string{}
+	examined := 0
+	slot := cursor
+	for ; slot < scanSlots && examined < opts.count; slot++ {
+		for k := range DefaultStore.slots[slot] {
+			examined++
+			if isExpired(DefaultStore, k) || !opts.matches(k) {
+				continue
+			}
+			if opts.typeName != "" && typeName(DefaultStore.types[k]) != opts.typeName {
+				continue
+			}
+			reply = append(reply, k)
+		}
+	}
+	if slot >= scanSlots {
+		slot = 0
+	}
+	sort.Strings(reply)
+	return strings.Join(append([]string{strconv.FormatUint(slot, 10)}, reply...), ","), nil
+}
+
+// memberOrder is a set's members sorted by hash, then by value. SSCAN pages
+// through it with a stateless cursor, the next hash to visit, which returns
+// every member present for the whole iteration; members sharing the boundary
+// hash are returned together. The store keeps the order between calls, so a
+// page costs a binary search and COUNT members rather than a sort of the set.
+type memberOrder []hashedMember
+
+type hashedMember struct {
+	h uint64
+	m string
+}
+
+func newMemberOrder(set map[string]struct{}) memberOrder {
+	o := make(memberOrder, 0, len(set))
+	for m := range set {
+		o = append(o, hashedMember{hash64(m), m})
+	}
+	sort.Slice(o, func(i, j int) bool {
+		if o[i].h != o[j].h {
+			return o[i].h < o[j].h
+		}
+		return o[i].m < o[j].m
+	})
+	return o
+}
+
+// page returns the reply to a scan from cursor: the next cursor, 0 once the
+// order is exhausted, followed by the members found.
+func (o memberOrder) page(cursor uint64, opts scanOptions) (string, uint64) {
+	i := sort.Search(len(o), func(i int) bool { return o[i].h >= cursor })
+	n := min(i+opts.count, len(o))
+	for n < len(o) && n > i && o[n].h == o[n-1].h {
+		n++
+	}
+	next := uint64(0)
+	if n < len(o) {
+		next = o[n].h
+	}
+	reply := []string{strconv.FormatUint(next, 10)}
+	for _, p := range o[i:n] {
+		if opts.matches(p.m) {
+			reply = append(reply, p.m)
+		}
+	}
+	return strings.Join(reply, ","), next
+}
+
+// sscanHandler implements SSCAN key cursor [MATCH pattern] [COUNT count].
+func sscanHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for SSCAN")
+	}
+	key := args[0]
+	cursor, err := parseCursor(args[1])
+	if err != nil {
+		return "", err
+	}
+	opts, err := parseScanOptions(args[2:], false)
+	if err != nil {
+		return "", err
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	set, exists, err := DefaultStore.getSet(key)
+	if !exists {
+		return "0", err
+	}
+	order, ok := DefaultStore.memberOrders[key]
+	if !ok {
+		order = newMemberOrder(set)
+		if DefaultStore.memberOrders == nil {
+			DefaultStore.memberOrders = make(map[string]memberOrder)
+		}
+		DefaultStore.memberOrders[key] = order
+	}
+	reply, next := order.page(cursor, opts)
+	if next == 0 {
+		// The iteration is over, so the order is not needed until another.
+		delete(DefaultStore.memberOrders, key)
+	}
+	return reply, nil
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// scanAll runs a full cursor iteration of cmd and returns every reported item.
+func scanAll(t *testing.T, cmd func([]string) (string, error), prefix []string, opts ...string) []string {
+	t.Helper()
+	var items []string
+	cursor := "0"
+	for calls := 0; ; calls++ {
+		if calls > 100000 {
+			t.Fatal("scan did not terminate")
+		}
+		args := append(append(append([]string{}, prefix...), cursor), opts...)
+		out, err := cmd(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := strings.Split(out, ",")
+		cursor = parts[0]
+		items = append(items, parts[1:]...)
+		if cursor == "0" {
+			return items
+		}
+	}
+}
+
+func TestScanKeyspace(t *testing.T) {
+	DefaultStore = NewStore()
+	for i := 0; i < 500; i++ {
+		_, _ = setHandler([]string{fmt.Sprintf("user:%d", i), "v"})
+	}
+	_, _ = rpushHandler([]string{"user:list", "a"})
+	_, _ = saddHandler([]string{"other", "a"})
+
+	keys := scanAll(t, scanHandler, nil, "COUNT", "20")
+	if len(keys) != 502 {
+		t.Errorf("expected 502 keys, got %d", len(keys))
+	}
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if seen[k] {
+			t.Errorf("key %s returned twice", k)
+		}
+		seen[k] = true
+	}
+
+	matched := scanAll(t, scanHandler, nil, "MATCH", "user:1?")
+	sort.Strings(matched)
+	if strings.Join(matched, ",") != "user:10,user:11,user:12,user:13,user:14,user:15,user:16,user:17,user:18,user:19" {
+		t.Errorf("unexpected MATCH result %v", matched)
+	}
+	if lists := scanAll(t, scanHandler, nil, "TYPE", "list"); len(lists) != 1 || lists[0] != "user:list" {
+		t.Errorf("expected only user:list for TYPE list, got %v", lists)
+	}
+	if _, err := scanHandler([]string{"abc"}); err == nil {
+		t.Error("expected invalid cursor error")
+	}
+	if _, err := scanHandler([]string{"0", "COUNT", "0"}); err == nil {
+		t.Error("expected error for COUNT 0")
+	}
+}
+
+func TestScanGuaranteeUnderMutation(t *testing.T) {
+	DefaultStore = NewStore()
+	for i := 0; i < 200; i++ {
+		_, _ = setHandler([]string{fmt.Sprintf("stable:%d", i), "v"})
+	}
+	seen := map[string]bool{}
+	cursor := "0"
+	for step := 0; ; step++ {
+		out, _ := scanHandler([]string{cursor, "COUNT", "5"})
+		parts := strings.Split(out, ",")
+		for _, k := range parts[1:] {
+			seen[k] = true
+		}
+		// Churn unrelated keys between calls.
+		_, _ = setHandler([]string{fmt.Sprintf("churn:%d", step), "v"})
+		_, _ = delHandler([]string{fmt.Sprintf("churn:%d", step-1)})
+		if cursor = parts[0]; cursor == "0" {
+			break
+		}
+	}
+	for i := 0; i < 200; i++ {
+		if !seen[fmt.Sprintf("stable:%d", i)] {
+			t.Errorf("stable:%d was never returned", i)
+		}
+	}
+}
+
+func TestSScan(t *testing.T) {
+	DefaultStore = NewStore()
+	args := []string{"s"}
+	for i := 0; i < 100; i++ {
+		args = append(args, fmt.Sprintf("m%d", i))
+	}
+	_, _ = saddHandler(args)
+	got := scanAll(t, sscanHandler, []string{"s"}, "COUNT", "7")
+	if len(got) != 100 {
+		t.Errorf("expected 100 members, got %d", len(got))
+	}
+	if got := scanAll(t, sscanHandler, []string{"s"}, "MATCH", "m9*"); len(got) != 11 {
+		t.Errorf("expected 11 members matching m9*, got %v", got)
+	}
+	if out, _ := sscanHandler([]string{"missing", "0"}); out != "0" {
+		t.Errorf("expected 0 for missing set, got %s", out)
+	}
+}
+
+func TestSScanSeesChangesBetweenPages(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = saddHandler([]string{"s", "a", "b", "c", "d"})
+	first, _ := sscanHandler([]string{"s", "0", "COUNT", "1"})
+	cursor := strings.SplitN(first, ",", 2)[0]
+	if _, ok := DefaultStore.memberOrders["s"]; !ok {
+		t.Fatal("expected SSCAN to keep the order between pages")
+	}
+	_, _ = sremHandler([]string{"s", "a", "b", "c", "d"})
+	_, _ = saddHandler([]string{"s", "x"})
+	if _, ok := DefaultStore.memberOrders["s"]; ok {
+		t.Error("expected changing the set to drop its order")
+	}
+	var rest []string
+	for cursor != "0" {
+		out, _ := sscanHandler([]string{"s", cursor})
+		parts := strings.Split(out, ",")
+		cursor, rest = parts[0], append(rest, parts[1:]...)
+	}
+	for _, m := range rest {
+		if m != "x" {
+			t.Errorf("expected only x after the set changed, got %v", rest)
+		}
+	}
+	if _, ok := DefaultStore.memberOrders["s"]; ok {
+		t.Error("expected a finished SSCAN to drop the order")
+	}
+}
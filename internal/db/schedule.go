@@ -0,0 +1,349 @@
+package db
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"furr/internal/protocol"
+)
+
+// maxCatchUpRuns caps how many missed runs MISSED ALL makes up for at once.
+const maxCatchUpRuns = 1000
+
+// idleScheduleWait is how long the scheduler sleeps with nothing due.
+const idleScheduleWait = time.Minute
+
+// schedule is a command set to run later, once or repeatedly. Its fields are
+// exported so snapshots can store it as is.
+type schedule struct {
+	ID      uint64
+	Kind    string // AT for one-shots, EVERY or CRON
+	Spec    string // the interval or cron expression
+	Missed  string // SKIP, ONCE or ALL
+	Command []string
+	Next    int64 // unix ms of the next run
+
+	every time.Duration
+	cron  *cronSpec
+}
+
+// compile parses Spec for a repeating schedule.
+func (sc *schedule) compile() error {
+	var err error
+	switch sc.Kind {
+	case "EVERY":
+		sc.every, err = time.ParseDuration(sc.Spec)
+		if err == nil && sc.every < time.Millisecond {
+			err = fmt.Errorf("interval must be at least 1ms")
+		}
+	case "CRON":
+		sc.cron, err = parseCron(sc.Spec)
+	}
+	return err
+}
+
+// after returns the first run strictly after t, or 0 if there is none.
+func (sc *schedule) after(t int64) int64 {
+	switch sc.Kind {
+	case "EVERY":
+		every := sc.every.Milliseconds()
+		if t < sc.Next {
+			return sc.Next
+		}
+		return sc.Next + ((t-sc.Next)/every+1)*every
+	case "CRON":
+		if next := sc.cron.next(time.UnixMilli(t)); !next.IsZero() {
+			return next.UnixMilli()
+		}
+	}
+	return 0
+}
+
+// plan decides, for a schedule due at now, how many times to run it and when
+// it runs next (0 for never). A run that came due before the scheduler
+// started was missed while the server was down, and the schedule's MISSED
+// policy applies.
+func (sc *schedule) plan(now, started int64) (runs int, next int64) {
+	if sc.Next >= started {
+		return 1, sc.after(sc.Next)
+	}
+	next = sc.after(now)
+	switch sc.Missed {
+	case "SKIP":
+		return 0, next
+	case "ALL":
+		for t := sc.Next; t != 0 && t <= now && runs < maxCatchUpRuns; t = sc.after(t) {
+			runs++
+		}
+		return runs, next
+	}
+	return 1, next
+}
+
+// format renders the schedule as its ID, next run and the arguments that
+// created it.
+func (sc *schedule) format() string {
+	out := []string{strconv.FormatUint(sc.ID, 10), formatScheduleTime(sc.Next), sc.Kind}
+	if sc.Kind != "AT" {
+		out = append(out, sc.Spec)
+	}
+	out = append(out, "MISSED", sc.Missed)
+	return protocol.QuoteArgs(append(out, sc.Command...))
+}
+
+// formatScheduleTime renders unix ms as an RFC 3339 time in the local zone.
+func formatScheduleTime(ms int64) string {
+	return time.UnixMilli(ms).Format(time.RFC3339Nano)
+}
+
+// parseScheduleTime parses an RFC 3339 time or unix seconds into unix ms.
+func parseScheduleTime(arg string) (int64, error) {
+	if secs, err := strconv.ParseInt(arg, 10, 64); err == nil {
+		return secs * 1000, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, arg)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected unix seconds or RFC 3339", arg)
+	}
+	return t.UnixMilli(), nil
+}
+
+// restoreSchedules replaces the schedules with ones loaded from a snapshot.
+// The caller must hold the write lock.
+func (s *Store) restoreSchedules(schedules map[uint64]*schedule, nextID uint64) {
+	s.schedules, s.nextScheduleID = make(map[uint64]*schedule, len(schedules)), nextID
+	for id, sc := range schedules {
+		if err := sc.compile(); err != nil {
+			fmt.Fprintf(os.Stderr, "[scheduler] dropping schedule %d: %v\n", id, err)
+			continue
+		}
+		s.schedules[id] = sc
+	}
+	s.wakeScheduler()
+}
+
+// wakeScheduler makes the scheduler recheck what is due next. The caller
+// must hold the write lock.
+func (s *Store) wakeScheduler() {
+	select {
+	case s.scheduleWake <- struct{}{}:
+	default:
+	}
+}
+
+// StartScheduler runs due schedules in the background. Runs that came due
+// before it is called count as missed, so call it once persisted data has
+// been loaded.
+func StartScheduler() {
+	s := DefaultStore
+	s.mu.Lock()
+	s.scheduleStarted = time.Now().UnixMilli()
+	s.mu.Unlock()
+	go s.runSchedules()
+}
+
+func (s *Store) runSchedules() {
+	for {
+		s.runDueSchedules(time.Now().UnixMilli())
+		s.mu.Lock()
+		wait := idleScheduleWait
+		for _, sc := range s.schedules {
+			wait = min(wait, time.Until(time.UnixMilli(sc.Next)))
+		}
+		s.mu.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.scheduleWake:
+			timer.Stop()
+		}
+	}
+}
+
+// runDueSchedules runs every schedule due at now, earliest first. Each one
+// is advanced before its command runs, so a crash in between skips the run
+// rather than repeating it.
+func (s *Store) runDueSchedules(now int64) {
+	for {
+		unlock := s.lockLogged(true)
+		var due *schedule
+		for _, sc := range s.schedules {
+			if sc.Next <= now && (due == nil || sc.Next < due.Next || sc.Next == due.Next && sc.ID < due.ID) {
+				due = sc
+			}
+		}
+		if due == nil {
+			unlock()
+			return
+		}
+		runs, next := due.plan(now, s.scheduleStarted)
+		id := strconv.FormatUint(due.ID, 10)
+		if next == 0 {
+			delete(s.schedules, due.ID)
+			s.propagate("SCHEDULE", "CANCEL", id)
+		} else {
+			due.Next = next
+			s.propagate("SCHEDULE", "NEXT", id, formatScheduleTime(next))
+		}
+		cmd := due.Command
+		unlock()
+		for range runs {
+			if _, err := Exec(cmd[0], cmd[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "[scheduler] schedule %s: %s failed: %v\n", id, cmd[0], err)
+			}
+		}
+	}
+}
+
+// scheduleHandler implements SCHEDULE AT time|IN duration|EVERY duration|
+// CRON expr [MISSED SKIP|ONCE|ALL] command [arg ...], which replies with
+// the new schedule's ID, along with SCHEDULE LIST, SCHEDULE CANCEL id
+// [id ...] and SCHEDULE NEXT id time.
+func scheduleHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for SCHEDULE")
+	}
+	switch strings.ToUpper(args[0]) {
+	case "LIST":
+		return scheduleList()
+	case "CANCEL":
+		return scheduleCancel(args[1:])
+	case "NEXT":
+		return scheduleNext(args[1:])
+	}
+	if len(args) < 3 {
+		return "", fmt.Errorf("wrong number of arguments for SCHEDULE")
+	}
+	now := time.Now().UnixMilli()
+	sc := &schedule{Kind: strings.ToUpper(args[0]), Missed: "ONCE"}
+	switch sc.Kind {
+	case "AT":
+		at, err := parseScheduleTime(args[1])
+		if err != nil {
+			return "", err
+		}
+		sc.Next = at
+	case "IN":
+		d, err := time.ParseDuration(args[1])
+		if err != nil || d < 0 {
+			return "", fmt.Errorf("invalid duration %q", args[1])
+		}
+		sc.Kind, sc.Next = "AT", now+d.Milliseconds()
+	case "EVERY", "CRON":
+		sc.Spec = args[1]
+		if err := sc.compile(); err != nil {
+			return "", err
+		}
+		sc.Next = now // intervals count from now
+		if sc.Next = sc.after(now); sc.Next == 0 {
+			return "", fmt.Errorf("cron expression never matches")
+		}
+	default:
+		return "", fmt.Errorf("unknown SCHEDULE subcommand '%s'", args[0])
+	}
+	rest := args[2:]
+	if strings.EqualFold(rest[0], "MISSED") {
+		if len(rest) < 3 {
+			return "", fmt.Errorf("syntax error")
+		}
+		sc.Missed = strings.ToUpper(rest[1])
+		if sc.Missed != "SKIP" && sc.Missed != "ONCE" && sc.Missed != "ALL" {
+			return "", fmt.Errorf("MISSED must be SKIP, ONCE or ALL")
+		}
+		rest = rest[2:]
+	}
+	name := strings.ToUpper(rest[0])
+	if _, ok := Commands[name]; !ok {
+		return "", fmt.Errorf("unknown command '%s'", rest[0])
+	}
+	sc.Command = append([]string{name}, rest[1:]...)
+
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextScheduleID++
+	sc.ID = s.nextScheduleID
+	s.schedules[sc.ID] = sc
+	s.wakeScheduler()
+	id := strconv.FormatUint(sc.ID, 10)
+	s.dirty++
+	s.logAs("SCHEDULE", append([]string{"SCHEDULE"}, args...), []string{"SCHEDULE", "NEXT", id, formatScheduleTime(sc.Next)})
+	return id, nil
+}
+
+// scheduleList replies with every schedule, soonest first.
+func scheduleList() (string, error) {
+	s := DefaultStore
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	all := make([]*schedule, 0, len(s.schedules))
+	for _, sc := range s.schedules {
+		all = append(all, sc)
+	}
+	slices.SortFunc(all, func(a, b *schedule) int {
+		return cmp.Or(cmp.Compare(a.Next, b.Next), cmp.Compare(a.ID, b.ID))
+	})
+	out := make([]string, len(all))
+	for i, sc := range all {
+		out[i] = sc.format()
+	}
+	return strings.Join(out, ","), nil
+}
+
+// scheduleCancel removes schedules, replying with how many existed.
+func scheduleCancel(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for SCHEDULE CANCEL")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if _, ok := s.schedules[id]; err == nil && ok {
+			delete(s.schedules, id)
+			n++
+		}
+	}
+	if n > 0 {
+		s.dirty++
+		s.wakeScheduler()
+	}
+	return strconv.Itoa(n), nil
+}
+
+// scheduleNext moves a schedule's next run, replying 1, or 0 if there is no
+// such schedule. Repeating schedules carry on from the new time.
+func scheduleNext(args []string) (string, error) {
+	if len(args) != 2 {
+		return "", fmt.Errorf("wrong number of arguments for SCHEDULE NEXT")
+	}
+	at, err := parseScheduleTime(args[1])
+	if err != nil {
+		return "", err
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	sc, ok := s.schedules[id]
+	if err != nil || !ok {
+		return "0", nil
+	}
+	sc.Next = at
+	s.dirty++
+	s.wakeScheduler()
+	return "1", nil
+}
+
+func init() {
+	// SCHEDULE checks its command against Commands, so it cannot be part of
+	// the literal.
+	Commands["SCHEDULE"] = scheduleHandler
+}
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2026, 1, 30, 10, 17, 42, 0, time.Local) // a Friday
+	for _, tc := range []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 1, 30, 10, 18, 0, 0, time.Local)},
+		{"*/15 * * * *", time.Date(2026, 1, 30, 10, 30, 0, 0, time.Local)},
+		{"0 2 * * *", time.Date(2026, 1, 31, 2, 0, 0, 0, time.Local)},
+		{"@daily", time.Date(2026, 1, 31, 0, 0, 0, 0, time.Local)},
+		{"30 9 * * 1-5", time.Date(2026, 2, 2, 9, 30, 0, 0, time.Local)},
+		{"0 0 31 2-12 *", time.Date(2026, 3, 31, 0, 0, 0, 0, time.Local)},
+		{"0 0 1 * 7", time.Date(2026, 2, 1, 0, 0, 0, 0, time.Local)},
+		{"0 12 13 * 5", time.Date(2026, 1, 30, 12, 0, 0, 0, time.Local)},
+	} {
+		c, err := parseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expr, err)
+		}
+		if got := c.next(from); !got.Equal(tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.expr, tc.want, got)
+		}
+	}
+	for _, bad := range []string{"* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "x * * * *"} {
+		if _, err := parseCron(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+	if c, _ := parseCron("0 0 30 2 *"); !c.next(from).IsZero() {
+		t.Error("expected February 30th never to match")
+	}
+}
+
+// scheduleOne adds a schedule and returns its ID.
+func scheduleOne(t *testing.T, args ...string) uint64 {
+	t.Helper()
+	out, err := scheduleHandler(args)
+	if err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	id, _ := strconv.ParseUint(out, 10, 64)
+	return id
+}
+
+func TestScheduleRuns(t *testing.T) {
+	DefaultStore = NewStore()
+	s := DefaultStore
+	every := scheduleOne(t, "EVERY", "10m", "INCR", "ticks")
+	once := scheduleOne(t, "IN", "1h", "SET", "once", "1")
+	first, next := s.schedules[every].Next, s.schedules[once].Next
+
+	s.runDueSchedules(next - 1)
+	if out, _ := getHandler([]string{"once"}); out != nilReply {
+		t.Fatalf("expected nothing to run early, got %s", out)
+	}
+	s.runDueSchedules(next)
+	if out, _ := getHandler([]string{"once"}); out != "1" {
+		t.Errorf("expected the one-shot to run, got %s", out)
+	}
+	if out, _ := getHandler([]string{"ticks"}); out != "6" {
+		t.Errorf("expected six ticks in an hour, got %s", out)
+	}
+	if _, ok := s.schedules[once]; ok {
+		t.Error("expected the one-shot to be removed after running")
+	}
+	if got := s.schedules[every].Next; got != first+60*60*1000 {
+		t.Errorf("expected the interval to carry on from its last run, got %d", got)
+	}
+
+	list, _ := scheduleHandler([]string{"LIST"})
+	if !strings.HasPrefix(list, strconv.FormatUint(every, 10)+" ") || !strings.HasSuffix(list, "EVERY 10m MISSED ONCE INCR ticks") {
+		t.Errorf("unexpected listing %s", list)
+	}
+	if out, _ := scheduleHandler([]string{"CANCEL", strconv.FormatUint(every, 10), "99"}); out != "1" {
+		t.Errorf("expected one schedule cancelled, got %s", out)
+	}
+	if out, _ := scheduleHandler([]string{"LIST"}); out != "" {
+		t.Errorf("expected no schedules left, got %s", out)
+	}
+	for _, bad := range [][]string{
+		{"IN", "soon", "SET", "k", "v"}, {"EVERY", "0s", "SET", "k", "v"}, {"CRON", "* *", "SET", "k", "v"},
+		{"IN", "1s", "NOPE"}, {"IN", "1s", "MISSED", "LATER", "SET", "k", "v"},
+	} {
+		if _, err := scheduleHandler(bad); err == nil {
+			t.Errorf("expected %v to be rejected", bad)
+		}
+	}
+}
+
+func TestScheduleMissedRuns(t *testing.T) {
+	for _, tc := range []struct {
+		policy string
+		want   string
+	}{{"SKIP", nilReply}, {"ONCE", "1"}, {"ALL", "3"}} {
+		DefaultStore = NewStore()
+		s := DefaultStore
+		id := scheduleOne(t, "EVERY", "1m", "MISSED", tc.policy, "INCR", "n")
+		first := s.schedules[id].Next
+		// The server was down for the first three runs.
+		s.scheduleStarted = first + 2*60*1000 + 1
+		s.runDueSchedules(s.scheduleStarted)
+		if out, _ := getHandler([]string{"n"}); out != tc.want {
+			t.Errorf("MISSED %s: expected %s, got %s", tc.policy, tc.want, out)
+		}
+		if got := s.schedules[id].Next; got != first+3*60*1000 {
+			t.Errorf("MISSED %s: expected the next run after the outage, got %d", tc.policy, got)
+		}
+	}
+}
+
+func TestSchedulePersistence(t *testing.T) {
+	DefaultStore = NewStore()
+	logged := captureLog(t)
+	exec := func(args ...string) string {
+		t.Helper()
+		out, err := Exec(args[0], args[1:])
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		return out
+	}
+	exec("SCHEDULE", "IN", "10m", "SET", "a", "1")
+	every := exec("SCHEDULE", "EVERY", "1h", "MISSED", "SKIP", "RPUSH", "l", "x")
+	exec("SCHEDULE", "CRON", "0 2 * * *", "INCR", "nightly")
+	id, _ := strconv.ParseUint(every, 10, 64)
+	DefaultStore.runDueSchedules(DefaultStore.schedules[id].Next)
+	exec("SCHEDULE", "CANCEL", "3")
+	list := exec("SCHEDULE", "LIST")
+
+	replay(t, *logged)
+	if out, _ := scheduleHandler([]string{"LIST"}); out != list {
+		t.Errorf("expected replayed schedules %s, got %s", list, out)
+	}
+	if out, _ := lrangeHandler([]string{"l", "0", "-1"}); out != "x" {
+		t.Errorf("expected the run to replay, got %s", out)
+	}
+
+	if err := SaveSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(TEST_FILE)
+	DefaultStore = NewStore()
+	if err := LoadSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := scheduleHandler([]string{"LIST"}); out != list {
+		t.Errorf("expected reloaded schedules %s, got %s", list, out)
+	}
+	if out, _ := scheduleHandler([]string{"IN", "1s", "GET", "k"}); out != "4" {
+		t.Errorf("expected IDs to carry on after the reload, got %s", out)
+	}
+}
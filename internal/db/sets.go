@@ -0,0 +1,360 @@
+package db
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// getSet returns the set stored at key, expiring it first if needed. A missing
+// key yields an empty set that is not yet stored. The caller must hold the
+// write lock.
+func (s *Store) getSet(key string) (map[string]struct{}, bool, error) {
+	exists, err := s.checkType(key, SetType)
+	if !exists {
+		return map[string]struct{}{}, false, err
+	}
+	return s.data[key].(map[string]struct{}), true, nil
+}
+
+// setSet stores set at key, removing the key once the set is empty. The
+// caller must hold the write lock.
+func (s *Store) setSet(key string, set map[string]struct{}) {
+	if len(set) == 0 {
+		s.removeEmpty(key)
+		return
+	}
+	s.setValue(key, SetType, set)
+}
+
+// sortedMembers returns the members of set in lexicographic order.
+func sortedMembers(set map[string]struct{}) []string {
+	members := make([]string, 0, len(set))
+	for v := range set {
+		members = append(members, v)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func sismemberHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for SISMEMBER")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	set, _, err := DefaultStore.getSet(args[0])
+	if err != nil {
+		return "", err
+	}
+	if _, ok := set[args[1]]; ok {
+		return "1", nil
+	}
+	return "0", nil
+}
+
+func smismemberHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for SMISMEMBER")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	set, _, err := DefaultStore.getSet(args[0])
+	if err != nil {
+		return "", err
+	}
+	out := make([]string, len(args)-1)
+	for i, m := range args[1:] {
+		out[i] = "0"
+		if _, ok := set[m]; ok {
+			out[i] = "1"
+		}
+	}
+	return strings.Join(out, ","), nil
+}
+
+func scardHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for SCARD")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	set, _, err := DefaultStore.getSet(args[0])
+	return strconv.Itoa(len(set)), err
+}
+
+// maxRandomMembers bounds how many members SRANDMEMBER may return with a
+// negative count, as each one is allocated up front.
+const maxRandomMembers = 1 << 20
+
+// randomMembers picks count distinct members of set, or all of them if count
+// exceeds its size, in random order. It samples a reservoir of count members
+// in one pass, so the set is neither copied nor sorted.
+func randomMembers(set map[string]struct{}, count int) []string {
+	picked := make([]string, 0, min(count, len(set)))
+	i := 0
+	for m := range set {
+		if len(picked) < count {
+			picked = append(picked, m)
+		} else if j := rand.IntN(i + 1); j < count {
+			picked[j] = m
+		}
+		i++
+	}
+	rand.Shuffle(len(picked), func(i, j int) {
+		picked[i], picked[j] = picked[j], picked[i]
+	})
+	return picked
+}
+
+func spopHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for SPOP")
+	}
+	count, withCount := 1, len(args) > 1
+	if withCount {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("value is out of range, must be positive")
+		}
+		count = n
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	set, exists, err := DefaultStore.getSet(args[0])
+	if !exists {
+		return nilReply, err
+	}
+	popped := randomMembers(set, count)
+	for _, m := range popped {
+		delete(set, m)
+	}
+	if len(popped) > 0 {
+		DefaultStore.notify(notifySet, "spop", args[0])
+		// The members are picked at random, so log which ones went.
+		DefaultStore.logAs("SPOP", append([]string{"SREM", args[0]}, popped...))
+	}
+	DefaultStore.setSet(args[0], set)
+	if !withCount {
+		return popped[0], nil
+	}
+	return strings.Join(popped, ","), nil
+}
+
+// srandmemberHandler implements SRANDMEMBER key [count]. A positive count
+// returns distinct members; a negative count returns exactly -count members
+// that may repeat.
+func srandmemberHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for SRANDMEMBER")
+	}
+	count, withCount := 1, len(args) > 1
+	if withCount {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "", errNotInteger
+		}
+		if n < -maxRandomMembers {
+			return "", fmt.Errorf("value is out of range, must be at least %d", -maxRandomMembers)
+		}
+		count = n
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	set, exists, err := DefaultStore.getSet(args[0])
+	if !exists {
+		return nilReply, err
+	}
+	var picked []string
+	if count >= 0 {
+		picked = randomMembers(set, count)
+	} else {
+		members := make([]string, 0, len(set))
+		for m := range set {
+			members = append(members, m)
+		}
+		picked = make([]string, -count)
+		for i := range picked {
+			picked[i] = members[rand.IntN(len(members))]
+		}
+	}
+	if !withCount {
+		return picked[0], nil
+	}
+	return strings.Join(picked, ","), nil
+}
+
+func smoveHandler(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("missing argument for SMOVE")
+	}
+	src, dst, member := args[0], args[1], args[2]
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	srcSet, _, err := DefaultStore.getSet(src)
+	if err != nil {
+		return "", err
+	}
+	dstSet, _, err := DefaultStore.getSet(dst)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := srcSet[member]; !ok {
+		return "0", nil
+	}
+	delete(srcSet, member)
+	DefaultStore.notify(notifySet, "srem", src)
+	DefaultStore.setSet(src, srcSet)
+	dstSet[member] = struct{}{}
+	DefaultStore.setSet(dst, dstSet)
+	DefaultStore.notify(notifySet, "sadd", dst)
+	return "1", nil
+}
+
+type setOp int
+
+const (
+	setUnion setOp = iota
+	setInter
+	setDiff
+)
+
+// combineSets applies op across the sets at keys, treating missing keys as
+// empty sets. The caller must hold the write lock.
+func (s *Store) combineSets(op setOp, keys []string) (map[string]struct{}, error) {
+	result := map[string]struct{}{}
+	for i, k := range keys {
+		set, _, err := s.getSet(k)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case i == 0 || op == setUnion:
+			for m := range set {
+				result[m] = struct{}{}
+			}
+		case op == setInter:
+			for m := range result {
+				if _, ok := set[m]; !ok {
+					delete(result, m)
+				}
+			}
+		case op == setDiff:
+			for m := range set {
+				delete(result, m)
+			}
+		}
+	}
+	return result, nil
+}
+
+func setAlgebra(name string, op setOp, args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for %s", name)
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	result, err := DefaultStore.combineSets(op, args)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(sortedMembers(result), ","), nil
+}
+
+func setAlgebraStore(name string, op setOp, args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for %s", name)
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	result, err := DefaultStore.combineSets(op, args[1:])
+	if err != nil {
+		return "", err
+	}
+	delete(DefaultStore.ttl, args[0])
+	DefaultStore.setSet(args[0], result)
+	if len(result) > 0 {
+		DefaultStore.notify(notifySet, strings.ToLower(name), args[0])
+	}
+	return strconv.Itoa(len(result)), nil
+}
+
+func sunionHandler(args []string) (string, error) {
+	return setAlgebra("SUNION", setUnion, args)
+}
+
+func sinterHandler(args []string) (string, error) {
+	return setAlgebra("SINTER", setInter, args)
+}
+
+func sdiffHandler(args []string) (string, error) {
+	return setAlgebra("SDIFF", setDiff, args)
+}
+
+func sunionstoreHandler(args []string) (string, error) {
+	return setAlgebraStore("SUNIONSTORE", setUnion, args)
+}
+
+func sinterstoreHandler(args []string) (string, error) {
+	return setAlgebraStore("SINTERSTORE", setInter, args)
+}
+
+func sdiffstoreHandler(args []string) (string, error) {
+	return setAlgebraStore("SDIFFSTORE", setDiff, args)
+}
+
+// sintercardHandler implements SINTERCARD numkeys key [key ...] [LIMIT limit],
+// stopping once limit common members are found.
+func sintercardHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for SINTERCARD")
+	}
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys <= 0 {
+		return "", fmt.Errorf("numkeys should be greater than 0")
+	}
+	if numKeys > len(args)-1 {
+		return "", fmt.Errorf("Number of keys can't be greater than number of args")
+	}
+	keys, rest := args[1:1+numKeys], args[1+numKeys:]
+	limit := 0
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.ToUpper(rest[0]) == "LIMIT":
+		limit, err = strconv.Atoi(rest[1])
+		if err != nil || limit < 0 {
+			return "", fmt.Errorf("LIMIT can't be negative")
+		}
+	default:
+		return "", fmt.Errorf("syntax error")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	sets := make([]map[string]struct{}, len(keys))
+	for i, k := range keys {
+		if sets[i], _, err = DefaultStore.getSet(k); err != nil {
+			return "", err
+		}
+	}
+	// Iterate the smallest set and probe the others.
+	sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+	count := 0
+	for m := range sets[0] {
+		inAll := true
+		for _, other := range sets[1:] {
+			if _, ok := other[m]; !ok {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			count++
+			if limit > 0 && count == limit {
+				break
+			}
+		}
+	}
+	return strconv.Itoa(count), nil
+}
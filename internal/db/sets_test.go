@@ -0,0 +1,129 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetInspection(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = saddHandler([]string{"s", "a", "b", "c"})
+	if v, _ := sismemberHandler([]string{"s", "b"}); v != "1" {
+		t.Errorf("expected b to be a member, got %s", v)
+	}
+	if v, _ := sismemberHandler([]string{"s", "z"}); v != "0" {
+		t.Errorf("expected z not to be a member, got %s", v)
+	}
+	if v, _ := smismemberHandler([]string{"s", "a", "z", "c"}); v != "1,0,1" {
+		t.Errorf("expected 1,0,1, got %s", v)
+	}
+	if v, _ := scardHandler([]string{"s"}); v != "3" {
+		t.Errorf("expected SCARD 3, got %s", v)
+	}
+	if v, _ := scardHandler([]string{"missing"}); v != "0" {
+		t.Errorf("expected SCARD 0 for missing key, got %s", v)
+	}
+	_, _ = sremHandler([]string{"s", "a", "b", "c"})
+	if exists, _ := existsHandler([]string{"s"}); exists != "0" {
+		t.Error("expected empty set to be removed")
+	}
+}
+
+func TestSetRandomMembers(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = saddHandler([]string{"s", "a", "b", "c"})
+	if v, _ := srandmemberHandler([]string{"s"}); !strings.Contains("abc", v) || v == "" {
+		t.Errorf("expected a random member, got %s", v)
+	}
+	if v, _ := srandmemberHandler([]string{"s", "10"}); len(strings.Split(v, ",")) != 3 {
+		t.Errorf("expected all 3 distinct members, got %s", v)
+	}
+	for range 20 {
+		v, _ := srandmemberHandler([]string{"s", "2"})
+		if m := strings.Split(v, ","); len(m) != 2 || m[0] == m[1] {
+			t.Fatalf("expected 2 distinct members, got %s", v)
+		}
+	}
+	if v, _ := srandmemberHandler([]string{"s", "-5"}); len(strings.Split(v, ",")) != 5 {
+		t.Errorf("expected 5 members with repeats, got %s", v)
+	}
+	for _, n := range []string{"-9223372036854775807", "-9223372036854775808"} {
+		if _, err := srandmemberHandler([]string{"s", n}); err == nil {
+			t.Errorf("expected SRANDMEMBER %s to be out of range", n)
+		}
+	}
+	if v, _ := scardHandler([]string{"s"}); v != "3" {
+		t.Errorf("expected SRANDMEMBER not to modify the set, got SCARD %s", v)
+	}
+	popped, _ := spopHandler([]string{"s", "2"})
+	if len(strings.Split(popped, ",")) != 2 {
+		t.Errorf("expected 2 popped members, got %s", popped)
+	}
+	last, _ := spopHandler([]string{"s"})
+	if all := popped + "," + last; len(all) != 5 || !strings.Contains(all, "a") || !strings.Contains(all, "b") || !strings.Contains(all, "c") {
+		t.Errorf("expected a, b and c popped once each, got %s", all)
+	}
+	if v, _ := spopHandler([]string{"s"}); v != nilReply {
+		t.Errorf("expected nil from SPOP on empty set, got %s", v)
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = saddHandler([]string{"k1", "a", "b", "c", "d"})
+	_, _ = saddHandler([]string{"k2", "c"})
+	_, _ = saddHandler([]string{"k3", "a", "c", "e"})
+	if v, _ := sunionHandler([]string{"k1", "k2", "k3"}); v != "a,b,c,d,e" {
+		t.Errorf("expected a,b,c,d,e, got %s", v)
+	}
+	if v, _ := sinterHandler([]string{"k1", "k2", "k3"}); v != "c" {
+		t.Errorf("expected c, got %s", v)
+	}
+	if v, _ := sdiffHandler([]string{"k1", "k2", "k3"}); v != "b,d" {
+		t.Errorf("expected b,d, got %s", v)
+	}
+	if v, _ := sinterHandler([]string{"k1", "missing"}); v != "" {
+		t.Errorf("expected empty intersection with missing key, got %s", v)
+	}
+	if n, _ := sunionstoreHandler([]string{"dst", "k2", "k3"}); n != "3" {
+		t.Errorf("expected 3 from SUNIONSTORE, got %s", n)
+	}
+	if n, _ := sdiffstoreHandler([]string{"dst", "k1", "k3"}); n != "2" {
+		t.Errorf("expected 2 from SDIFFSTORE, got %s", n)
+	}
+	if v, _ := smembersHandler([]string{"dst"}); v != "b,d" {
+		t.Errorf("expected STORE to replace destination, got %s", v)
+	}
+	if n, _ := sinterstoreHandler([]string{"dst", "k1", "missing"}); n != "0" {
+		t.Errorf("expected 0 from empty SINTERSTORE, got %s", n)
+	}
+	if exists, _ := existsHandler([]string{"dst"}); exists != "0" {
+		t.Error("expected empty SINTERSTORE to delete destination")
+	}
+	if n, _ := sintercardHandler([]string{"2", "k1", "k3"}); n != "2" {
+		t.Errorf("expected SINTERCARD 2, got %s", n)
+	}
+	if n, _ := sintercardHandler([]string{"2", "k1", "k3", "LIMIT", "1"}); n != "1" {
+		t.Errorf("expected SINTERCARD with LIMIT 1, got %s", n)
+	}
+	if _, err := sintercardHandler([]string{"5", "k1"}); err == nil {
+		t.Error("expected error when numkeys exceeds keys given")
+	}
+}
+
+func TestSMove(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = saddHandler([]string{"src", "a"})
+	if v, _ := smoveHandler([]string{"src", "dst", "a"}); v != "1" {
+		t.Errorf("expected 1 from SMOVE, got %s", v)
+	}
+	if v, _ := smoveHandler([]string{"src", "dst", "a"}); v != "0" {
+		t.Errorf("expected 0 from SMOVE of missing member, got %s", v)
+	}
+	if v, _ := smembersHandler([]string{"dst"}); v != "a" {
+		t.Errorf("expected a in dst, got %s", v)
+	}
+	if exists, _ := existsHandler([]string{"src"}); exists != "0" {
+		t.Error("expected emptied source set to be removed")
+	}
+}
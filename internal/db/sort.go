@@ -0,0 +1,210 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sortOptions holds the parsed modifiers of SORT.
+type sortOptions struct {
+	by          string // pattern to weigh elements by, "" to use the elements
+	noSort      bool   // BY pattern without '*': keep the stored order
+	offset      int
+	count       int // -1 for no limit
+	get         []string
+	desc, alpha bool
+	store       string
+	hasStore    bool
+}
+
+func parseSortOptions(args []string, readOnly bool) (sortOptions, error) {
+	opts := sortOptions{count: -1}
+	for i := 0; i < len(args); i++ {
+		hasArg := i+1 < len(args)
+		switch strings.ToUpper(args[i]) {
+		case "ASC":
+			opts.desc = false
+		case "DESC":
+			opts.desc = true
+		case "ALPHA":
+			opts.alpha = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return opts, fmt.Errorf("syntax error")
+			}
+			offset, err1 := strconv.Atoi(args[i+1])
+			count, err2 := strconv.Atoi(args[i+2])
+			if err1 != nil || err2 != nil {
+				return opts, errNotInteger
+			}
+			opts.offset, opts.count = max(offset, 0), count
+			i += 2
+		case "BY":
+			if !hasArg {
+				return opts, fmt.Errorf("syntax error")
+			}
+			opts.by = args[i+1]
+			// A pattern that names no element-specific key gives every
+			// element the same weight, so sorting is skipped.
+			opts.noSort = !strings.Contains(opts.by, "*")
+			i++
+		case "GET":
+			if !hasArg {
+				return opts, fmt.Errorf("syntax error")
+			}
+			opts.get = append(opts.get, args[i+1])
+			i++
+		case "STORE":
+			if !hasArg || readOnly {
+				return opts, fmt.Errorf("syntax error")
+			}
+			opts.store, opts.hasStore = args[i+1], true
+			i++
+		default:
+			return opts, fmt.Errorf("syntax error")
+		}
+	}
+	return opts, nil
+}
+
+// lookupPattern resolves a BY or GET pattern for elem: "#" is the element
+// itself, otherwise the first '*' is replaced by elem and the resulting key is
+// read. A "->field" suffix selects a hash field; there is no hash type yet, so
+// such lookups find nothing. Keys holding non-strings also read as missing.
+// The caller must hold the write lock.
+func (s *Store) lookupPattern(pattern, elem string) (string, bool) {
+	if pattern == "#" {
+		return elem, true
+	}
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return "", false
+	}
+	if arrow := strings.Index(pattern[star:], "->"); arrow >= 0 && star+arrow+2 < len(pattern) {
+		return "", false
+	}
+	val, ok, _ := s.getString(pattern[:star] + elem + pattern[star+1:])
+	return val, ok
+}
+
+// sortItem is an element with the value it is ordered by.
+type sortItem struct {
+	elem   string
+	weight string
+	score  float64
+}
+
+// sortElements orders elems per opts. The caller must hold the write lock.
+func (s *Store) sortElements(elems []string, opts sortOptions) error {
+	if opts.noSort {
+		return nil
+	}
+	items := make([]sortItem, len(elems))
+	for i, e := range elems {
+		items[i].elem, items[i].weight = e, e
+		found := true
+		if opts.by != "" {
+			items[i].weight, found = s.lookupPattern(opts.by, e)
+		}
+		if opts.alpha || !found {
+			// Missing weights sort as zero or the empty string.
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(items[i].weight), 64)
+		if err != nil || math.IsNaN(f) {
+			return fmt.Errorf("One or more scores can't be converted into double")
+		}
+		items[i].score = f
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if opts.desc {
+			a, b = b, a
+		}
+		if !opts.alpha && a.score != b.score {
+			return a.score < b.score
+		}
+		if opts.alpha && a.weight != b.weight {
+			return a.weight < b.weight
+		}
+		// Break ties on the element so the output is deterministic.
+		return a.elem < b.elem
+	})
+	for i, it := range items {
+		elems[i] = it.elem
+	}
+	return nil
+}
+
+// sortCommand implements SORT and SORT_RO:
+// key [BY pattern] [LIMIT offset count] [GET pattern ...] [ASC|DESC] [ALPHA]
+// [STORE destination].
+func sortCommand(name string, args []string, readOnly bool) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for %s", name)
+	}
+	opts, err := parseSortOptions(args[1:], readOnly)
+	if err != nil {
+		return "", err
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireIfNeeded(args[0])
+	var elems []string
+	if _, exists := s.data[args[0]]; exists {
+		switch s.types[args[0]] {
+		case ListType:
+			lst, _, _ := s.getList(args[0])
+			elems = lst.Values()
+		case SetType:
+			set, _, _ := s.getSet(args[0])
+			// Sets have no order of their own; start from a stable one.
+			elems = sortedMembers(set)
+		default:
+			return "", ErrWrongType
+		}
+	}
+	if err := s.sortElements(elems, opts); err != nil {
+		return "", err
+	}
+
+	start := min(opts.offset, len(elems))
+	end := len(elems)
+	if opts.count >= 0 && opts.count < end-start {
+		end = start + opts.count
+	}
+	elems = elems[start:end]
+
+	out := elems
+	if len(opts.get) > 0 {
+		out = make([]string, 0, len(elems)*len(opts.get))
+		for _, e := range elems {
+			for _, p := range opts.get {
+				val, _ := s.lookupPattern(p, e)
+				out = append(out, val)
+			}
+		}
+	}
+	if opts.hasStore {
+		s.putValue(opts.store, ListType, newQuicklist(out...), 0)
+		if len(out) > 0 {
+			s.notify(notifyList, "sortstore", opts.store)
+		}
+		s.serveBlockedLists()
+		return strconv.Itoa(len(out)), nil
+	}
+	return strings.Join(out, ","), nil
+}
+
+func sortHandler(args []string) (string, error) {
+	return sortCommand("SORT", args, false)
+}
+
+// sortROHandler is SORT without STORE, so it never writes.
+func sortROHandler(args []string) (string, error) {
+	return sortCommand("SORT_RO", args, true)
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSortNumericAndAlpha(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = rpushHandler([]string{"ids", "10", "2", "33", "1.5"})
+	cases := map[string][]string{
+		"1.5,2,10,33": {"ids"},
+		"33,10,2,1.5": {"ids", "DESC"},
+		"1.5,10,2,33": {"ids", "ALPHA"},
+		"2,10":        {"ids", "LIMIT", "1", "2"},
+		"10,2":        {"ids", "LIMIT", "1", "2", "DESC"},
+		"":            {"missing"},
+	}
+	for want, args := range cases {
+		if got, err := sortHandler(args); err != nil || got != want {
+			t.Errorf("SORT %v: expected %s, got %s (%v)", args, want, got, err)
+		}
+	}
+	_, _ = rpushHandler([]string{"words", "b", "a"})
+	if _, err := sortHandler([]string{"words"}); err == nil {
+		t.Error("expected numeric SORT of non-numbers to fail")
+	}
+	_, _ = saddHandler([]string{"tags", "pear", "apple", "fig"})
+	if got, _ := sortHandler([]string{"tags", "ALPHA"}); got != "apple,fig,pear" {
+		t.Errorf("expected apple,fig,pear, got %s", got)
+	}
+	_, _ = setHandler([]string{"str", "v"})
+	if _, err := sortHandler([]string{"str"}); !errors.Is(err, ErrWrongType) {
+		t.Errorf("expected WRONGTYPE, got %v", err)
+	}
+}
+
+func TestSortByAndGet(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = rpushHandler([]string{"users", "1", "2", "3"})
+	_, _ = msetHandler([]string{
+		"weight_1", "30", "weight_2", "10", "weight_3", "20",
+		"name_1", "ann", "name_2", "bob",
+	})
+	if got, _ := sortHandler([]string{"users", "BY", "weight_*"}); got != "2,3,1" {
+		t.Errorf("expected 2,3,1, got %s", got)
+	}
+	if got, _ := sortHandler([]string{"users", "BY", "weight_*", "GET", "#", "GET", "name_*"}); got != "2,bob,3,,1,ann" {
+		t.Errorf("expected 2,bob,3,,1,ann, got %s", got)
+	}
+	if got, _ := sortHandler([]string{"users", "BY", "nosort", "DESC"}); got != "1,2,3" {
+		t.Errorf("expected BY without * to keep list order, got %s", got)
+	}
+	if got, _ := sortHandler([]string{"users", "BY", "name_*", "ALPHA", "DESC"}); got != "2,1,3" {
+		t.Errorf("expected 2,1,3, got %s", got)
+	}
+	if got, _ := sortHandler([]string{"users", "BY", "user_*->age"}); got != "1,2,3" {
+		t.Errorf("expected hash field weights to read as missing, got %s", got)
+	}
+}
+
+func TestSortStore(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = rpushHandler([]string{"src", "3", "1", "2"})
+	_, _ = setHandler([]string{"dst", "old"})
+	if n, err := sortHandler([]string{"src", "STORE", "dst"}); err != nil || n != "3" {
+		t.Fatalf("expected 3 from SORT STORE, got %s (%v)", n, err)
+	}
+	if got, _ := lrangeHandler([]string{"dst", "0", "-1"}); got != "1,2,3" {
+		t.Errorf("expected stored 1,2,3, got %s", got)
+	}
+	if n, _ := sortHandler([]string{"missing", "STORE", "dst"}); n != "0" {
+		t.Errorf("expected 0 from SORT STORE of missing key, got %s", n)
+	}
+	if n, _ := existsHandler([]string{"dst"}); n != "0" {
+		t.Error("expected empty SORT STORE to delete the destination")
+	}
+	if _, err := sortROHandler([]string{"src", "STORE", "dst"}); err == nil {
+		t.Error("expected SORT_RO to reject STORE")
+	}
+	if got, _ := sortROHandler([]string{"src", "DESC"}); got != "3,2,1" {
+		t.Errorf("expected 3,2,1 from SORT_RO, got %s", got)
+	}
+}
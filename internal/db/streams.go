@@ -0,0 +1,1348 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"furr/internal/protocol"
+)
+
+// streamID identifies a stream entry: a millisecond timestamp and a sequence
+// number among entries added in the same millisecond.
+type streamID struct {
+	MS, Seq uint64
+}
+
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+var errInvalidStreamID = fmt.Errorf("Invalid stream ID specified as stream command argument")
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.MS, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+func (id streamID) less(o streamID) bool {
+	return id.MS < o.MS || (id.MS == o.MS && id.Seq < o.Seq)
+}
+
+// next returns the smallest ID after id, or false if id is the largest.
+func (id streamID) next() (streamID, bool) {
+	switch {
+	case id == maxStreamID:
+		return id, false
+	case id.Seq == math.MaxUint64:
+		return streamID{id.MS + 1, 0}, true
+	}
+	return streamID{id.MS, id.Seq + 1}, true
+}
+
+// prev returns the largest ID before id, or false if id is 0-0.
+func (id streamID) prev() (streamID, bool) {
+	switch {
+	case id == streamID{}:
+		return id, false
+	case id.Seq == 0:
+		return streamID{id.MS - 1, math.MaxUint64}, true
+	}
+	return streamID{id.MS, id.Seq - 1}, true
+}
+
+// parseStreamID parses "ms-seq", or "ms" with seq as the sequence number.
+func parseStreamID(s string, seq uint64) (streamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return streamID{}, errInvalidStreamID
+		}
+	}
+	return streamID{ms, seq}, nil
+}
+
+// parseRangeID parses a range bound: "-" and "+" for the smallest and largest
+// IDs, or an ID whose missing sequence number covers the whole millisecond. A
+// leading "(" excludes the ID itself.
+func parseRangeID(s string, end bool) (streamID, error) {
+	switch s {
+	case "-":
+		return streamID{}, nil
+	case "+":
+		return maxStreamID, nil
+	}
+	exclusive := strings.HasPrefix(s, "(")
+	s = strings.TrimPrefix(s, "(")
+	var seq uint64
+	if end {
+		seq = math.MaxUint64
+	}
+	id, err := parseStreamID(s, seq)
+	if err != nil || !exclusive {
+		return id, err
+	}
+	var ok bool
+	if end {
+		id, ok = id.prev()
+	} else {
+		id, ok = id.next()
+	}
+	if !ok {
+		return id, fmt.Errorf("invalid start or end ID for the interval")
+	}
+	return id, nil
+}
+
+// streamEntry is one record of a stream. Fields alternates field names and
+// values; it is nil for an entry that was trimmed while still pending.
+type streamEntry struct {
+	ID     streamID
+	Fields []string
+}
+
+// stream is an append-only log of entries with consumer groups. Its fields
+// are exported so snapshots can store it as is.
+type stream struct {
+	Entries []streamEntry // ordered by ID
+	LastID  streamID      // largest ID ever added, even if since trimmed
+	Groups  map[string]*streamGroup
+}
+
+// streamGroup tracks what has been delivered to a consumer group and which
+// deliveries are still waiting to be acknowledged.
+type streamGroup struct {
+	LastID    streamID                   // last entry delivered to the group
+	Pending   map[streamID]*pendingEntry // the pending entries list (PEL)
+	Consumers map[string]int64           // consumer -> unix ms it was last active
+}
+
+// pendingEntry is a delivered, unacknowledged entry of a group.
+type pendingEntry struct {
+	Consumer  string
+	Delivered int64 // unix milliseconds of the last delivery
+	Count     int64 // number of times delivered
+}
+
+func newStream() *stream {
+	return &stream{Groups: make(map[string]*streamGroup)}
+}
+
+func newStreamGroup(last streamID) *streamGroup {
+	return &streamGroup{
+		LastID:    last,
+		Pending:   make(map[streamID]*pendingEntry),
+		Consumers: make(map[string]int64),
+	}
+}
+
+// getStream returns the stream at key, or a new empty stream if the key does
+// not exist. The caller must hold the write lock.
+func (s *Store) getStream(key string) (*stream, bool, error) {
+	exists, err := s.checkType(key, StreamType)
+	if !exists {
+		return newStream(), false, err
+	}
+	return s.data[key].(*stream), true, nil
+}
+
+// search returns the index of the first entry with an ID of at least id.
+func (st *stream) search(id streamID) int {
+	return sort.Search(len(st.Entries), func(i int) bool {
+		return !st.Entries[i].ID.less(id)
+	})
+}
+
+// lookup returns the entry with the given ID.
+func (st *stream) lookup(id streamID) (streamEntry, bool) {
+	i := st.search(id)
+	if i < len(st.Entries) && st.Entries[i].ID == id {
+		return st.Entries[i], true
+	}
+	return streamEntry{}, false
+}
+
+// rangeEntries returns up to count entries (all for count < 0) with IDs from
+// start to end inclusive, newest first if rev.
+func (st *stream) rangeEntries(start, end streamID, count int, rev bool) []streamEntry {
+	if end.less(start) || count == 0 {
+		return nil
+	}
+	lo := st.search(start)
+	hi := sort.Search(len(st.Entries), func(i int) bool {
+		return end.less(st.Entries[i].ID)
+	})
+	if lo >= hi {
+		return nil
+	}
+	// Trim to count before copying, from whichever end the reply starts at.
+	if count > 0 && count < hi-lo {
+		if rev {
+			lo = hi - count
+		} else {
+			hi = lo + count
+		}
+	}
+	out := slices.Clone(st.Entries[lo:hi])
+	if rev {
+		slices.Reverse(out)
+	}
+	return out
+}
+
+// after returns up to count entries (all for count <= 0) with IDs above id.
+func (st *stream) after(id streamID, count int) []streamEntry {
+	start, ok := id.next()
+	if !ok {
+		return nil
+	}
+	if count <= 0 {
+		count = -1
+	}
+	return st.rangeEntries(start, maxStreamID, count, false)
+}
+
+// nextID returns the ID XADD assigns for arg: "*" for a fully automatic ID,
+// "ms-*" for an automatic sequence number, or an explicit ID.
+func (st *stream) nextID(arg string) (streamID, error) {
+	errSmaller := fmt.Errorf("The ID specified in XADD is equal or smaller than the target stream top item")
+	last := st.LastID
+	if arg == "*" {
+		if now := uint64(time.Now().UnixMilli()); now > last.MS {
+			return streamID{now, 0}, nil
+		}
+		id, ok := last.next()
+		if !ok {
+			return id, fmt.Errorf("The stream has exhausted the last possible ID, unable to add more items")
+		}
+		return id, nil
+	}
+	if msPart, ok := strings.CutSuffix(arg, "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		switch {
+		case err != nil:
+			return streamID{}, errInvalidStreamID
+		case ms < last.MS, ms == last.MS && last.Seq == math.MaxUint64:
+			return streamID{}, errSmaller
+		case ms == last.MS:
+			return streamID{ms, last.Seq + 1}, nil
+		}
+		return streamID{ms, 0}, nil
+	}
+	id, err := parseStreamID(arg, 0)
+	switch {
+	case err != nil:
+		return id, err
+	case id == streamID{}:
+		return id, fmt.Errorf("The ID specified in XADD must be greater than 0-0")
+	case !last.less(id):
+		return id, errSmaller
+	}
+	return id, nil
+}
+
+// streamTrim holds the MAXLEN, MINID and LIMIT options of XADD and XTRIM.
+type streamTrim struct {
+	strategy string // "MAXLEN", "MINID" or "" for no trimming
+	maxLen   int
+	minID    streamID
+	approx   bool // "~": trimming may stop early, bounded by limit
+	limit    int
+	hasLimit bool
+}
+
+// parseArg consumes the trimming option at args[*i], reporting false if
+// args[*i] is not one.
+func (t *streamTrim) parseArg(args []string, i *int) (bool, error) {
+	switch opt := strings.ToUpper(args[*i]); opt {
+	case "MAXLEN", "MINID":
+		j := *i + 1
+		if j < len(args) && (args[j] == "=" || args[j] == "~") {
+			t.approx = args[j] == "~"
+			j++
+		}
+		if j >= len(args) {
+			return true, fmt.Errorf("syntax error")
+		}
+		if opt == "MAXLEN" {
+			n, err := strconv.Atoi(args[j])
+			if err != nil || n < 0 {
+				return true, fmt.Errorf("The MAXLEN argument must be >= 0.")
+			}
+			t.maxLen = n
+		} else {
+			id, err := parseStreamID(args[j], 0)
+			if err != nil {
+				return true, err
+			}
+			t.minID = id
+		}
+		t.strategy = opt
+		*i = j
+	case "LIMIT":
+		if *i+1 >= len(args) {
+			return true, fmt.Errorf("syntax error")
+		}
+		n, err := strconv.Atoi(args[*i+1])
+		if err != nil || n < 0 {
+			return true, fmt.Errorf("The LIMIT argument must be >= 0.")
+		}
+		t.limit, t.hasLimit = n, true
+		*i++
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+func (t streamTrim) validate() error {
+	if t.hasLimit && !t.approx {
+		return fmt.Errorf("syntax error, LIMIT cannot be used without the special ~ option")
+	}
+	return nil
+}
+
+// trim removes entries from the front of st as t asks and returns how many
+// were removed. Trimming is always exact, except that with "~" at most LIMIT
+// entries go.
+func (st *stream) trim(t streamTrim) int {
+	n := 0
+	switch t.strategy {
+	case "MAXLEN":
+		n = max(len(st.Entries)-t.maxLen, 0)
+	case "MINID":
+		n = st.search(t.minID)
+	}
+	if t.approx && t.hasLimit {
+		n = min(n, t.limit)
+	}
+	if n > 0 {
+		// Reslice rather than copy; append reallocates once the spare
+		// capacity runs out, dropping the trimmed prefix.
+		clear(st.Entries[:n])
+		st.Entries = st.Entries[n:]
+	}
+	return n
+}
+
+// formatEntry renders an entry as its ID followed by its fields and values,
+// quoted as command arguments so values with spaces stay intact.
+func formatEntry(e streamEntry) string {
+	if e.Fields == nil {
+		return e.ID.String()
+	}
+	return protocol.QuoteArgs(append([]string{e.ID.String()}, e.Fields...))
+}
+
+func formatEntries(entries []streamEntry) []string {
+	out := make([]string, len(entries))
+	for i, e := range entries {
+		out[i] = formatEntry(e)
+	}
+	return out
+}
+
+// xaddHandler implements XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold
+// [LIMIT count]] *|id field value [field value ...].
+func xaddHandler(args []string) (string, error) {
+	if len(args) < 4 {
+		return "", fmt.Errorf("missing argument for XADD")
+	}
+	key := args[0]
+	noMkStream := false
+	var trim streamTrim
+	i := 1
+	for ; i < len(args); i++ {
+		if strings.EqualFold(args[i], "NOMKSTREAM") {
+			noMkStream = true
+			continue
+		}
+		ok, err := trim.parseArg(args, &i)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			break
+		}
+	}
+	if err := trim.validate(); err != nil {
+		return "", err
+	}
+	idPos := i
+	fields := args[min(idPos+1, len(args)):]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return "", fmt.Errorf("wrong number of arguments for 'xadd' command")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, exists, err := s.getStream(key)
+	if err != nil {
+		return "", err
+	}
+	if !exists && noMkStream {
+		return nilReply, nil
+	}
+	id, err := st.nextID(args[idPos])
+	if err != nil {
+		return "", err
+	}
+	st.Entries = append(st.Entries, streamEntry{ID: id, Fields: slices.Clone(fields)})
+	st.LastID = id
+	if !exists {
+		s.setValue(key, StreamType, st)
+	}
+	s.notify(notifyStream, "xadd", key)
+	if st.trim(trim) > 0 {
+		s.notify(notifyStream, "xtrim", key)
+	}
+	// Log the ID that was assigned so replaying gives the same one.
+	form := append([]string{"XADD"}, args...)
+	form[idPos+1] = id.String()
+	s.logAs("XADD", form)
+	s.serveBlockedStreams(key)
+	return id.String(), nil
+}
+
+// xtrimHandler implements XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count].
+func xtrimHandler(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("missing argument for XTRIM")
+	}
+	var trim streamTrim
+	for i := 1; i < len(args); i++ {
+		ok, err := trim.parseArg(args, &i)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("syntax error")
+		}
+	}
+	if trim.strategy == "" {
+		return "", fmt.Errorf("syntax error")
+	}
+	if err := trim.validate(); err != nil {
+		return "", err
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, exists, err := s.getStream(args[0])
+	if !exists {
+		return "0", err
+	}
+	n := st.trim(trim)
+	if n > 0 {
+		s.notify(notifyStream, "xtrim", args[0])
+	}
+	return strconv.Itoa(n), nil
+}
+
+func xlenHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for XLEN")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	st, _, err := DefaultStore.getStream(args[0])
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(len(st.Entries)), nil
+}
+
+// xrangeCommand implements XRANGE key start end [COUNT n] and XREVRANGE,
+// which takes end before start and returns newest first.
+func xrangeCommand(name string, args []string, rev bool) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("missing argument for %s", name)
+	}
+	startArg, endArg := args[1], args[2]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, err := parseRangeID(startArg, false)
+	if err != nil {
+		return "", err
+	}
+	end, err := parseRangeID(endArg, true)
+	if err != nil {
+		return "", err
+	}
+	count := -1
+	switch {
+	case len(args) == 5 && strings.EqualFold(args[3], "COUNT"):
+		n, err := strconv.Atoi(args[4])
+		if err != nil {
+			return "", errNotInteger
+		}
+		count = max(n, 0)
+	case len(args) != 3:
+		return "", fmt.Errorf("syntax error")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	st, _, err := DefaultStore.getStream(args[0])
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(formatEntries(st.rangeEntries(start, end, count, rev)), ","), nil
+}
+
+func xrangeHandler(args []string) (string, error) {
+	return xrangeCommand("XRANGE", args, false)
+}
+
+func xrevrangeHandler(args []string) (string, error) {
+	return xrangeCommand("XREVRANGE", args, true)
+}
+
+// streamReadOptions holds the parsed arguments of XREAD and XREADGROUP.
+type streamReadOptions struct {
+	group, consumer string
+	count           int // 0 for no limit
+	block           bool
+	timeout         time.Duration
+	noAck           bool
+	keys, ids       []string
+	nonBlocking     []string // the command without BLOCK, for the log
+}
+
+func parseStreamRead(name string, args []string, group bool) (streamReadOptions, error) {
+	var opts streamReadOptions
+	opts.nonBlocking = []string{name}
+	i := 0
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		if opt == "STREAMS" {
+			break
+		}
+		hasArg := i+1 < len(args)
+		switch {
+		case opt == "COUNT" && hasArg:
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return opts, errNotInteger
+			}
+			opts.count = max(n, 0)
+			opts.nonBlocking = append(opts.nonBlocking, args[i:i+2]...)
+			i++
+		case opt == "BLOCK" && hasArg:
+			ms, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return opts, fmt.Errorf("timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return opts, fmt.Errorf("timeout is negative")
+			}
+			opts.block, opts.timeout = true, time.Duration(ms)*time.Millisecond
+			i++
+		case opt == "GROUP" && group && i+2 < len(args):
+			opts.group, opts.consumer = args[i+1], args[i+2]
+			opts.nonBlocking = append(opts.nonBlocking, args[i:i+3]...)
+			i += 2
+		case opt == "NOACK" && group:
+			opts.noAck = true
+			opts.nonBlocking = append(opts.nonBlocking, args[i])
+		default:
+			return opts, fmt.Errorf("syntax error")
+		}
+	}
+	if group && opts.group == "" {
+		return opts, fmt.Errorf("Missing GROUP option for XREADGROUP")
+	}
+	streams := args[min(i+1, len(args)):]
+	if i == len(args) || len(streams) == 0 || len(streams)%2 != 0 {
+		return opts, fmt.Errorf("Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified.", strings.ToLower(name))
+	}
+	opts.nonBlocking = append(opts.nonBlocking, args[i:]...)
+	n := len(streams) / 2
+	opts.keys, opts.ids = streams[:n], streams[n:]
+	return opts, nil
+}
+
+// streamWaiter is a client parked by XREAD or XREADGROUP until entries are
+// added to one of its streams. Waiters are served in the order they blocked.
+type streamWaiter struct {
+	keys []string
+	// serve reads what the client waits for from the stream at key, which
+	// just received an entry. ok is false if there is still nothing for it.
+	// It runs with the store lock held.
+	serve func(key string) (reply string, ok bool, err error)
+	// effect is the non-blocking command that does what serve(key) did, for
+	// the append-only log, or nil if serving changes nothing.
+	effect func(key string) []string
+	result chan blockedReply
+	served bool
+}
+
+// blockOnStreams registers w on each of its keys. The caller must hold the
+// write lock.
+func (s *Store) blockOnStreams(w *streamWaiter) {
+	for _, k := range w.keys {
+		s.streamWaiters[k] = append(s.streamWaiters[k], w)
+	}
+}
+
+// unblockStreams removes w from every key it waits on. The caller must hold
+// the write lock.
+func (s *Store) unblockStreams(w *streamWaiter) {
+	for _, k := range w.keys {
+		queue := slices.DeleteFunc(s.streamWaiters[k], func(o *streamWaiter) bool { return o == w })
+		if len(queue) == 0 {
+			delete(s.streamWaiters, k)
+		} else {
+			s.streamWaiters[k] = queue
+		}
+	}
+}
+
+// serveBlockedStreams hands the entries just added to key to the clients
+// blocked on it, oldest first. XADD calls it before releasing the write lock.
+func (s *Store) serveBlockedStreams(key string) {
+	for _, w := range slices.Clone(s.streamWaiters[key]) {
+		reply, ok, err := w.serve(key)
+		if !ok && err == nil {
+			continue
+		}
+		s.unblockStreams(w)
+		w.served = true
+		if err == nil && w.effect != nil {
+			s.propagate(w.effect(key)...)
+		}
+		w.result <- blockedReply{reply, err}
+	}
+}
+
+// blockingStreamRead replies with read() right away if it finds anything, or
+// parks the client on w's keys when BLOCK was given. logArgs is logged if the
+// immediate read changed the store, as XREADGROUP does.
+func blockingStreamRead(ctx context.Context, w *streamWaiter, opts streamReadOptions, read func() (string, error)) (string, error) {
+	s := DefaultStore
+	top := ctx.Err() == nil
+	unlock := s.lockLogged(top)
+	dirty := s.dirty
+	reply, err := read()
+	if top && s.dirty != dirty {
+		s.propagate(opts.nonBlocking...)
+	}
+	if err != nil || reply != nilReply || !opts.block || !top || len(w.keys) == 0 {
+		unlock()
+		return reply, err
+	}
+	w.result = make(chan blockedReply, 1)
+	s.blockOnStreams(w)
+	unlock()
+
+	var expired <-chan time.Time
+	if opts.timeout > 0 {
+		timer := time.NewTimer(opts.timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case r := <-w.result:
+		return r.val, r.err
+	case <-expired:
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !w.served {
+		s.unblockStreams(w)
+		return nilReply, nil
+	}
+	// Served while the timeout fired or the client left. Delivered entries
+	// stay pending, as they would if the reply were lost in transit.
+	r := <-w.result
+	return r.val, r.err
+}
+
+// xreadHandler implements XREAD [COUNT n] [BLOCK ms] STREAMS key [key ...]
+// id [id ...], replying with each stream that has new entries followed by
+// those entries.
+func xreadHandler(ctx context.Context, args []string) (string, error) {
+	opts, err := parseStreamRead("XREAD", args, false)
+	if err != nil {
+		return "", err
+	}
+	s := DefaultStore
+	var after map[string]streamID
+	read := func() (string, error) {
+		// Resolve the IDs once, so "$" keeps meaning the last entry at the
+		// time of the call while the client waits.
+		after = make(map[string]streamID, len(opts.keys))
+		var out []string
+		for i, key := range opts.keys {
+			st, _, err := s.getStream(key)
+			if err != nil {
+				return "", err
+			}
+			id := st.LastID
+			if opts.ids[i] != "$" {
+				if id, err = parseStreamID(opts.ids[i], 0); err != nil {
+					return "", err
+				}
+			}
+			after[key] = id
+			if entries := st.after(id, opts.count); len(entries) > 0 {
+				out = append(append(out, key), formatEntries(entries)...)
+			}
+		}
+		if len(out) == 0 {
+			return nilReply, nil
+		}
+		return strings.Join(out, ","), nil
+	}
+	w := &streamWaiter{keys: opts.keys}
+	w.serve = func(key string) (string, bool, error) {
+		st, _, _ := s.getStream(key)
+		entries := st.after(after[key], opts.count)
+		if len(entries) == 0 {
+			return "", false, nil
+		}
+		return strings.Join(append([]string{key}, formatEntries(entries)...), ","), true, nil
+	}
+	return blockingStreamRead(ctx, w, opts, read)
+}
+
+// noGroupError reports a missing stream or consumer group.
+func noGroupError(key, group, cmd string) error {
+	return fmt.Errorf("No such key '%s' or consumer group '%s' in %s", key, group, cmd)
+}
+
+// streamGroupFor returns the stream at key and its group. The caller must
+// hold the write lock.
+func (s *Store) streamGroupFor(key, group, cmd string) (*stream, *streamGroup, error) {
+	st, exists, err := s.getStream(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	g := st.Groups[group]
+	if !exists || g == nil {
+		return nil, nil, noGroupError(key, group, cmd)
+	}
+	return st, g, nil
+}
+
+// touchConsumer records consumer as active in g, creating it if needed. The
+// caller must hold the write lock.
+func (s *Store) touchConsumer(key string, g *streamGroup, consumer string, now int64) (created bool) {
+	_, exists := g.Consumers[consumer]
+	g.Consumers[consumer] = now
+	if !exists {
+		s.notify(notifyStream, "xgroup-createconsumer", key)
+	}
+	return !exists
+}
+
+// readGroup delivers entries of key to consumer: new ones for ">", or its own
+// pending entries after id otherwise. The caller must hold the write lock.
+func (s *Store) readGroup(key string, opts streamReadOptions, id string) ([]streamEntry, error) {
+	st, g, err := s.streamGroupFor(key, opts.group, "XREADGROUP with GROUP option")
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixMilli()
+	s.touchConsumer(key, g, opts.consumer, now)
+	if id != ">" {
+		start, err := parseStreamID(id, 0)
+		if err != nil {
+			return nil, err
+		}
+		var out []streamEntry
+		for _, pid := range g.pendingIDs() {
+			if !start.less(pid) || g.Pending[pid].Consumer != opts.consumer {
+				continue
+			}
+			if opts.count > 0 && len(out) == opts.count {
+				break
+			}
+			e, ok := st.lookup(pid)
+			if !ok {
+				e = streamEntry{ID: pid}
+			}
+			out = append(out, e)
+		}
+		return out, nil
+	}
+	entries := st.after(g.LastID, opts.count)
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	g.LastID = entries[len(entries)-1].ID
+	if !opts.noAck {
+		for _, e := range entries {
+			g.Pending[e.ID] = &pendingEntry{Consumer: opts.consumer, Delivered: now, Count: 1}
+		}
+	}
+	// Deliveries publish no event but still change the store.
+	s.dirty++
+	return entries, nil
+}
+
+// pendingIDs returns the IDs in g's pending entries list in order.
+func (g *streamGroup) pendingIDs() []streamID {
+	ids := make([]streamID, 0, len(g.Pending))
+	for id := range g.Pending {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b streamID) int {
+		switch {
+		case a.less(b):
+			return -1
+		case b.less(a):
+			return 1
+		}
+		return 0
+	})
+	return ids
+}
+
+// xreadgroupHandler implements XREADGROUP GROUP group consumer [COUNT n]
+// [BLOCK ms] [NOACK] STREAMS key [key ...] id [id ...]. Only streams read
+// with ">" block.
+func xreadgroupHandler(ctx context.Context, args []string) (string, error) {
+	opts, err := parseStreamRead("XREADGROUP", args, true)
+	if err != nil {
+		return "", err
+	}
+	s := DefaultStore
+	var newKeys []string
+	for i, key := range opts.keys {
+		if opts.ids[i] == ">" {
+			newKeys = append(newKeys, key)
+		}
+	}
+	read := func() (string, error) {
+		for _, key := range opts.keys {
+			if _, _, err := s.streamGroupFor(key, opts.group, "XREADGROUP with GROUP option"); err != nil {
+				return "", err
+			}
+		}
+		var out []string
+		for i, key := range opts.keys {
+			entries, err := s.readGroup(key, opts, opts.ids[i])
+			if err != nil {
+				return "", err
+			}
+			if len(entries) > 0 {
+				out = append(append(out, key), formatEntries(entries)...)
+			}
+		}
+		if len(out) == 0 {
+			return nilReply, nil
+		}
+		return strings.Join(out, ","), nil
+	}
+	w := &streamWaiter{keys: newKeys}
+	w.serve = func(key string) (string, bool, error) {
+		entries, err := s.readGroup(key, opts, ">")
+		if err != nil || len(entries) == 0 {
+			return "", false, err
+		}
+		return strings.Join(append([]string{key}, formatEntries(entries)...), ","), true, nil
+	}
+	w.effect = func(key string) []string {
+		form := []string{"XREADGROUP", "GROUP", opts.group, opts.consumer}
+		if opts.count > 0 {
+			form = append(form, "COUNT", strconv.Itoa(opts.count))
+		}
+		if opts.noAck {
+			form = append(form, "NOACK")
+		}
+		return append(form, "STREAMS", key, ">")
+	}
+	return blockingStreamRead(ctx, w, opts, read)
+}
+
+// xgroupHandler implements XGROUP CREATE key group id|$ [MKSTREAM]
+// [ENTRIESREAD n], SETID key group id|$, DESTROY key group, and
+// CREATECONSUMER and DELCONSUMER key group consumer.
+func xgroupHandler(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("missing argument for XGROUP")
+	}
+	sub, key, group := strings.ToUpper(args[0]), args[1], args[2]
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, exists, err := s.getStream(key)
+	if err != nil {
+		return "", err
+	}
+	// groupID parses the ID argument of CREATE and SETID.
+	groupID := func(arg string) (streamID, error) {
+		if arg == "$" {
+			return st.LastID, nil
+		}
+		return parseStreamID(arg, 0)
+	}
+	switch {
+	case sub == "CREATE" && len(args) >= 4:
+		mkStream := false
+		for i := 4; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "MKSTREAM":
+				mkStream = true
+			case "ENTRIESREAD":
+				// Accepted for compatibility; lag is not tracked.
+				i++
+			default:
+				return "", fmt.Errorf("syntax error")
+			}
+		}
+		if !exists && !mkStream {
+			return "", fmt.Errorf("The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+		}
+		if _, ok := st.Groups[group]; ok {
+			return "", fmt.Errorf("Consumer Group name already exists")
+		}
+		id, err := groupID(args[3])
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			s.setValue(key, StreamType, st)
+		}
+		st.Groups[group] = newStreamGroup(id)
+		s.notify(notifyStream, "xgroup-create", key)
+		return "OK", nil
+	case !exists:
+		return "", fmt.Errorf("The XGROUP subcommand requires the key to exist.")
+	case sub == "DESTROY" && len(args) == 3:
+		if _, ok := st.Groups[group]; !ok {
+			return "0", nil
+		}
+		delete(st.Groups, group)
+		s.notify(notifyStream, "xgroup-destroy", key)
+		return "1", nil
+	}
+	g := st.Groups[group]
+	if g == nil {
+		return "", noGroupError(key, group, "XGROUP "+sub)
+	}
+	switch {
+	case sub == "SETID" && len(args) >= 4:
+		id, err := groupID(args[3])
+		if err != nil {
+			return "", err
+		}
+		g.LastID = id
+		s.notify(notifyStream, "xgroup-setid", key)
+		return "OK", nil
+	case sub == "CREATECONSUMER" && len(args) == 4:
+		if _, ok := g.Consumers[args[3]]; ok {
+			return "0", nil
+		}
+		s.touchConsumer(key, g, args[3], time.Now().UnixMilli())
+		return "1", nil
+	case sub == "DELCONSUMER" && len(args) == 4:
+		if _, ok := g.Consumers[args[3]]; !ok {
+			return "0", nil
+		}
+		pending := 0
+		for id, pe := range g.Pending {
+			if pe.Consumer == args[3] {
+				delete(g.Pending, id)
+				pending++
+			}
+		}
+		delete(g.Consumers, args[3])
+		s.notify(notifyStream, "xgroup-delconsumer", key)
+		return strconv.Itoa(pending), nil
+	}
+	return "", fmt.Errorf("unknown subcommand or wrong number of arguments for '%s'", args[0])
+}
+
+// xackHandler implements XACK key group id [id ...], removing entries from
+// the group's pending entries list.
+func xackHandler(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("missing argument for XACK")
+	}
+	ids := make([]streamID, len(args)-2)
+	for i, arg := range args[2:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return "", err
+		}
+		ids[i] = id
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, exists, err := s.getStream(args[0])
+	if !exists {
+		return "0", err
+	}
+	g := st.Groups[args[1]]
+	if g == nil {
+		return "0", nil
+	}
+	acked := 0
+	for _, id := range ids {
+		if _, ok := g.Pending[id]; ok {
+			delete(g.Pending, id)
+			acked++
+		}
+	}
+	if acked > 0 {
+		// Acknowledgements publish no event but still change the store.
+		s.dirty++
+	}
+	return strconv.Itoa(acked), nil
+}
+
+// xpendingHandler implements XPENDING key group, which summarises the
+// pending entries as "count,smallest,largest,consumer,count,...", and
+// XPENDING key group [IDLE ms] start end count [consumer], which lists them
+// as "id consumer idle-ms deliveries" items.
+func xpendingHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for XPENDING")
+	}
+	key, group := args[0], args[1]
+	extended := len(args) > 2
+	var minIdle int64
+	var start, end streamID
+	count := 0
+	consumer := ""
+	if extended {
+		rest := args[2:]
+		if strings.EqualFold(rest[0], "IDLE") {
+			if len(rest) < 2 {
+				return "", fmt.Errorf("syntax error")
+			}
+			n, err := strconv.ParseInt(rest[1], 10, 64)
+			if err != nil {
+				return "", errNotInteger
+			}
+			minIdle, rest = n, rest[2:]
+		}
+		if len(rest) < 3 || len(rest) > 4 {
+			return "", fmt.Errorf("syntax error")
+		}
+		var err error
+		if start, err = parseRangeID(rest[0], false); err != nil {
+			return "", err
+		}
+		if end, err = parseRangeID(rest[1], true); err != nil {
+			return "", err
+		}
+		if count, err = strconv.Atoi(rest[2]); err != nil {
+			return "", errNotInteger
+		}
+		if len(rest) == 4 {
+			consumer = rest[3]
+		}
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, g, err := s.streamGroupFor(key, group, "XPENDING")
+	if err != nil {
+		return "", err
+	}
+	ids := g.pendingIDs()
+	if !extended {
+		if len(ids) == 0 {
+			return "0,,,", nil
+		}
+		perConsumer := make(map[string]int)
+		for _, pe := range g.Pending {
+			perConsumer[pe.Consumer]++
+		}
+		out := []string{strconv.Itoa(len(ids)), ids[0].String(), ids[len(ids)-1].String()}
+		for _, c := range slices.Sorted(maps.Keys(perConsumer)) {
+			out = append(out, c, strconv.Itoa(perConsumer[c]))
+		}
+		return strings.Join(out, ","), nil
+	}
+	now := time.Now().UnixMilli()
+	var out []string
+	for _, id := range ids {
+		if len(out) >= count {
+			break
+		}
+		pe := g.Pending[id]
+		idle := now - pe.Delivered
+		if id.less(start) || end.less(id) || idle < minIdle || (consumer != "" && pe.Consumer != consumer) {
+			continue
+		}
+		out = append(out, protocol.QuoteArgs([]string{id.String(), pe.Consumer, strconv.FormatInt(idle, 10), strconv.FormatInt(pe.Count, 10)}))
+	}
+	return strings.Join(out, ","), nil
+}
+
+// claimOptions holds the modifiers of XCLAIM; XAUTOCLAIM only uses JUSTID.
+type claimOptions struct {
+	minIdle    int64
+	delivered  int64 // delivery time to record, unix ms
+	retryCount int64
+	hasRetry   bool
+	force      bool
+	justID     bool
+}
+
+// claim transfers the pending entry id of g to consumer if it has been idle
+// long enough. Entries no longer in the stream are dropped from the PEL and
+// reported as deleted. The caller must hold the write lock.
+func (s *Store) claim(st *stream, g *streamGroup, id streamID, consumer string, opts claimOptions, now int64) (e streamEntry, claimed, deleted bool) {
+	pe, pending := g.Pending[id]
+	e, inStream := st.lookup(id)
+	switch {
+	case pending && !inStream:
+		delete(g.Pending, id)
+		return e, false, true
+	case !inStream:
+		return e, false, false
+	case !pending && !opts.force:
+		return e, false, false
+	case pending && now-pe.Delivered < opts.minIdle:
+		return e, false, false
+	case !pending:
+		pe = &pendingEntry{}
+		g.Pending[id] = pe
+	}
+	pe.Consumer = consumer
+	pe.Delivered = opts.delivered
+	switch {
+	case opts.hasRetry:
+		pe.Count = opts.retryCount
+	case !opts.justID:
+		pe.Count++
+	}
+	return e, true, false
+}
+
+// claimForm is the log form of a claim: a forced XCLAIM that sets the
+// delivery time and count to what they became, so replay does not depend on
+// how long entries had been idle.
+func claimForm(key, group, consumer string, id streamID, pe *pendingEntry) []string {
+	return []string{"XCLAIM", key, group, consumer, "0", id.String(),
+		"TIME", strconv.FormatInt(pe.Delivered, 10), "RETRYCOUNT", strconv.FormatInt(pe.Count, 10),
+		"FORCE", "JUSTID"}
+}
+
+// xclaimHandler implements XCLAIM key group consumer min-idle-time id
+// [id ...] [IDLE ms] [TIME unix-ms] [RETRYCOUNT count] [FORCE] [JUSTID]
+// [LASTID id].
+func xclaimHandler(args []string) (string, error) {
+	if len(args) < 5 {
+		return "", fmt.Errorf("missing argument for XCLAIM")
+	}
+	key, group, consumer := args[0], args[1], args[2]
+	minIdle, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("Invalid min-idle-time argument for XCLAIM")
+	}
+	now := time.Now().UnixMilli()
+	opts := claimOptions{minIdle: minIdle, delivered: now}
+	var ids []streamID
+	i := 4
+	for ; i < len(args); i++ {
+		id, err := parseStreamID(args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return "", errInvalidStreamID
+	}
+	var lastID streamID
+	hasLastID := false
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch {
+		case opt == "FORCE":
+			opts.force = true
+		case opt == "JUSTID":
+			opts.justID = true
+		case i+1 >= len(args):
+			return "", fmt.Errorf("Unrecognized XCLAIM option '%s'", args[i])
+		case opt == "IDLE" || opt == "TIME" || opt == "RETRYCOUNT":
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return "", errNotInteger
+			}
+			switch opt {
+			case "IDLE":
+				opts.delivered = now - n
+			case "TIME":
+				opts.delivered = n
+			default:
+				opts.retryCount, opts.hasRetry = n, true
+			}
+			i++
+		case opt == "LASTID":
+			if lastID, err = parseStreamID(args[i+1], 0); err != nil {
+				return "", err
+			}
+			hasLastID = true
+			i++
+		default:
+			return "", fmt.Errorf("Unrecognized XCLAIM option '%s'", args[i])
+		}
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, g, err := s.streamGroupFor(key, group, "XCLAIM")
+	if err != nil {
+		return "", err
+	}
+	var forms [][]string
+	if hasLastID && g.LastID.less(lastID) {
+		g.LastID = lastID
+		forms = append(forms, []string{"XGROUP", "SETID", key, group, lastID.String()})
+	}
+	if s.touchConsumer(key, g, consumer, now) {
+		forms = append(forms, []string{"XGROUP", "CREATECONSUMER", key, group, consumer})
+	}
+	var out []string
+	for _, id := range ids {
+		e, claimed, deleted := s.claim(st, g, id, consumer, opts, now)
+		switch {
+		case deleted:
+			forms = append(forms, []string{"XACK", key, group, id.String()})
+		case claimed:
+			forms = append(forms, claimForm(key, group, consumer, id, g.Pending[id]))
+			if opts.justID {
+				out = append(out, id.String())
+			} else {
+				out = append(out, formatEntry(e))
+			}
+		}
+	}
+	if len(forms) > 0 {
+		s.dirty++
+	}
+	s.logAs("XCLAIM", forms...)
+	return strings.Join(out, ","), nil
+}
+
+// xautoclaimHandler implements XAUTOCLAIM key group consumer min-idle-time
+// start [COUNT count] [JUSTID]. It replies with the ID to continue from
+// ("0-0" once the whole PEL was scanned) followed by the claimed entries.
+func xautoclaimHandler(args []string) (string, error) {
+	if len(args) < 5 {
+		return "", fmt.Errorf("missing argument for XAUTOCLAIM")
+	}
+	key, group, consumer := args[0], args[1], args[2]
+	minIdle, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	start, err := parseRangeID(args[4], false)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UnixMilli()
+	opts := claimOptions{minIdle: minIdle, delivered: now}
+	count := 100
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "JUSTID":
+			opts.justID = true
+		case "COUNT":
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("syntax error")
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 1 {
+				return "", fmt.Errorf("COUNT must be > 0")
+			}
+			count = n
+			i++
+		default:
+			return "", fmt.Errorf("syntax error")
+		}
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, g, err := s.streamGroupFor(key, group, "XAUTOCLAIM")
+	if err != nil {
+		return "", err
+	}
+	var forms [][]string
+	if s.touchConsumer(key, g, consumer, now) {
+		forms = append(forms, []string{"XGROUP", "CREATECONSUMER", key, group, consumer})
+	}
+	next := streamID{}
+	var out []string
+	for _, id := range g.pendingIDs() {
+		if id.less(start) {
+			continue
+		}
+		if len(out) == count {
+			next = id
+			break
+		}
+		e, claimed, deleted := s.claim(st, g, id, consumer, opts, now)
+		switch {
+		case deleted:
+			forms = append(forms, []string{"XACK", key, group, id.String()})
+		case claimed:
+			forms = append(forms, claimForm(key, group, consumer, id, g.Pending[id]))
+			if opts.justID {
+				out = append(out, id.String())
+			} else {
+				out = append(out, formatEntry(e))
+			}
+		}
+	}
+	if len(forms) > 0 {
+		s.dirty++
+	}
+	s.logAs("XAUTOCLAIM", forms...)
+	return strings.Join(append([]string{next.String()}, out...), ","), nil
+}
+
+// copyStream returns a deep copy of st. Entry fields are never modified in
+// place, so they are shared.
+func copyStream(st *stream) *stream {
+	cp := &stream{
+		Entries: slices.Clone(st.Entries),
+		LastID:  st.LastID,
+		Groups:  make(map[string]*streamGroup, len(st.Groups)),
+	}
+	for name, g := range st.Groups {
+		cg := newStreamGroup(g.LastID)
+		for id, pe := range g.Pending {
+			p := *pe
+			cg.Pending[id] = &p
+		}
+		for c, seen := range g.Consumers {
+			cg.Consumers[c] = seen
+		}
+		cp.Groups[name] = cg
+	}
+	return cp
+}
+
+// restoreStream fills in the maps gob leaves nil when they were empty.
+func restoreStream(st *stream) *stream {
+	if st.Groups == nil {
+		st.Groups = make(map[string]*streamGroup)
+	}
+	for _, g := range st.Groups {
+		if g.Pending == nil {
+			g.Pending = make(map[streamID]*pendingEntry)
+		}
+		if g.Consumers == nil {
+			g.Consumers = make(map[string]int64)
+		}
+	}
+	return st
+}
@@ -0,0 +1,299 @@
+package db
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForStreamWaiters blocks until n clients are parked on key.
+func waitForStreamWaiters(t *testing.T, key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		DefaultStore.mu.Lock()
+		got := len(DefaultStore.streamWaiters[key])
+		DefaultStore.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d waiters on %s", n, key)
+}
+
+func TestXAddIDs(t *testing.T) {
+	DefaultStore = NewStore()
+	if id, err := xaddHandler([]string{"s", "5-1", "f", "v"}); err != nil || id != "5-1" {
+		t.Fatalf("expected 5-1, got %s (%v)", id, err)
+	}
+	if id, _ := xaddHandler([]string{"s", "5-*", "f", "v"}); id != "5-2" {
+		t.Errorf("expected 5-2 from 5-*, got %s", id)
+	}
+	if id, _ := xaddHandler([]string{"s", "7", "f", "v"}); id != "7-0" {
+		t.Errorf("expected 7-0, got %s", id)
+	}
+	if _, err := xaddHandler([]string{"s", "6-0", "f", "v"}); err == nil {
+		t.Error("expected error for an ID below the top item")
+	}
+	if _, err := xaddHandler([]string{"t", "0-0", "f", "v"}); err == nil {
+		t.Error("expected error for 0-0")
+	}
+	if id, _ := xaddHandler([]string{"t", "0-*", "f", "v"}); id != "0-1" {
+		t.Errorf("expected 0-1 from 0-* on a new stream, got %s", id)
+	}
+	before := uint64(time.Now().UnixMilli())
+	id, _ := xaddHandler([]string{"s", "*", "f", "v"})
+	auto, err := parseStreamID(id, 0)
+	if err != nil || auto.MS < before || auto.Seq != 0 {
+		t.Errorf("expected an automatic ID from the clock, got %s", id)
+	}
+	if _, err := xaddHandler([]string{"s", "*", "f"}); err == nil {
+		t.Error("expected error for an odd number of field arguments")
+	}
+	if out, _ := xaddHandler([]string{"none", "NOMKSTREAM", "*", "f", "v"}); out != nilReply {
+		t.Errorf("expected nil with NOMKSTREAM, got %s", out)
+	}
+	if n, _ := xlenHandler([]string{"s"}); n != "4" {
+		t.Errorf("expected XLEN 4, got %s", n)
+	}
+	if typ, _ := typeHandler([]string{"s"}); typ != "stream" {
+		t.Errorf("expected TYPE stream, got %s", typ)
+	}
+}
+
+func TestXRange(t *testing.T) {
+	DefaultStore = NewStore()
+	for _, id := range []string{"1-0", "2-0", "2-1", "3-0"} {
+		_, _ = xaddHandler([]string{"s", id, "n", id})
+	}
+	_, _ = xaddHandler([]string{"s", "4-0", "msg", "hello world"})
+	cases := []struct {
+		cmd  func([]string) (string, error)
+		args []string
+		want string
+	}{
+		{xrangeHandler, []string{"s", "-", "+", "COUNT", "2"}, "1-0 n 1-0,2-0 n 2-0"},
+		{xrangeHandler, []string{"s", "2", "2"}, "2-0 n 2-0,2-1 n 2-1"},
+		{xrangeHandler, []string{"s", "(2-0", "3"}, "2-1 n 2-1,3-0 n 3-0"},
+		{xrangeHandler, []string{"s", "4", "+"}, `4-0 msg "hello world"`},
+		{xrangeHandler, []string{"s", "3", "2"}, ""},
+		{xrevrangeHandler, []string{"s", "+", "-", "COUNT", "2"}, `4-0 msg "hello world",3-0 n 3-0`},
+		{xrevrangeHandler, []string{"s", "2-1", "(1-0"}, "2-1 n 2-1,2-0 n 2-0"},
+	}
+	for _, c := range cases {
+		if got, err := c.cmd(c.args); err != nil || got != c.want {
+			t.Errorf("%v: expected %q, got %q (%v)", c.args, c.want, got, err)
+		}
+	}
+	if _, err := xrangeHandler([]string{"s", "x", "+"}); err == nil {
+		t.Error("expected error for an invalid ID")
+	}
+}
+
+func TestXAddTrim(t *testing.T) {
+	DefaultStore = NewStore()
+	for i := 1; i <= 5; i++ {
+		_, _ = xaddHandler([]string{"s", "MAXLEN", "3", "*", "n", "x"})
+	}
+	if n, _ := xlenHandler([]string{"s"}); n != "3" {
+		t.Errorf("expected MAXLEN to keep 3 entries, got %s", n)
+	}
+	DefaultStore = NewStore()
+	for _, id := range []string{"1-0", "2-0", "3-0", "4-0"} {
+		_, _ = xaddHandler([]string{"s", id, "n", "x"})
+	}
+	if n, _ := xtrimHandler([]string{"s", "MINID", "3"}); n != "2" {
+		t.Errorf("expected MINID to remove 2 entries, got %s", n)
+	}
+	if n, _ := xtrimHandler([]string{"s", "MAXLEN", "~", "0", "LIMIT", "1"}); n != "1" {
+		t.Errorf("expected LIMIT to bound trimming to 1, got %s", n)
+	}
+	if _, err := xtrimHandler([]string{"s", "MAXLEN", "0", "LIMIT", "1"}); err == nil {
+		t.Error("expected error for LIMIT without ~")
+	}
+	if out, _ := xrangeHandler([]string{"s", "-", "+"}); out != "4-0 n x" {
+		t.Errorf("expected only 4-0 left, got %s", out)
+	}
+	// The last ID survives trimming, so IDs never go backwards.
+	if _, err := xaddHandler([]string{"s", "MAXLEN", "0", "5-0", "n", "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xaddHandler([]string{"s", "5-0", "n", "x"}); err == nil {
+		t.Error("expected error reusing a trimmed ID")
+	}
+}
+
+func TestXRead(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = xaddHandler([]string{"a", "1-0", "f", "1"})
+	_, _ = xaddHandler([]string{"a", "2-0", "f", "2"})
+	_, _ = xaddHandler([]string{"b", "1-0", "g", "1"})
+	ctx := context.Background()
+	out, err := xreadHandler(ctx, []string{"COUNT", "1", "STREAMS", "a", "b", "0", "0"})
+	if err != nil || out != "a,1-0 f 1,b,1-0 g 1" {
+		t.Errorf("expected first entry of each stream, got %s (%v)", out, err)
+	}
+	if out, _ := xreadHandler(ctx, []string{"STREAMS", "a", "b", "1-0", "$"}); out != "a,2-0 f 2" {
+		t.Errorf("expected only a's newer entry, got %s", out)
+	}
+	if out, _ := Commands["XREAD"]([]string{"BLOCK", "0", "STREAMS", "a", "$"}); out != nilReply {
+		t.Errorf("expected the non-blocking XREAD to return nil, got %s", out)
+	}
+	if _, err := xreadHandler(ctx, []string{"STREAMS", "a"}); err == nil {
+		t.Error("expected error for unbalanced streams")
+	}
+}
+
+func TestXReadBlock(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = xaddHandler([]string{"s", "1-0", "f", "old"})
+	got := make(chan string, 1)
+	go func() {
+		out, _ := xreadHandler(testContext(t), []string{"BLOCK", "0", "STREAMS", "s", "$"})
+		got <- out
+	}()
+	waitForStreamWaiters(t, "s", 1)
+	_, _ = xaddHandler([]string{"s", "2-0", "f", "new"})
+	if out := <-got; out != "s,2-0 f new" {
+		t.Errorf("expected the new entry, got %s", out)
+	}
+
+	start := time.Now()
+	out, _ := xreadHandler(testContext(t), []string{"BLOCK", "50", "STREAMS", "s", "$"})
+	if out != nilReply || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("expected nil after blocking for the timeout, got %s", out)
+	}
+	if len(DefaultStore.streamWaiters) != 0 {
+		t.Error("expected timed out waiter to be unregistered")
+	}
+}
+
+func TestXGroupReadAck(t *testing.T) {
+	DefaultStore = NewStore()
+	ctx := context.Background()
+	if _, err := xgroupHandler([]string{"CREATE", "s", "g", "$"}); err == nil {
+		t.Error("expected error creating a group on a missing key")
+	}
+	if out, err := xgroupHandler([]string{"CREATE", "s", "g", "$", "MKSTREAM"}); err != nil || out != "OK" {
+		t.Fatalf("expected OK, got %s (%v)", out, err)
+	}
+	if _, err := xgroupHandler([]string{"CREATE", "s", "g", "0"}); err == nil {
+		t.Error("expected error for an existing group")
+	}
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		_, _ = xaddHandler([]string{"s", id, "job", id})
+	}
+	out, err := xreadgroupHandler(ctx, []string{"GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">"})
+	if err != nil || out != "s,1-0 job 1-0,2-0 job 2-0" {
+		t.Fatalf("expected two new entries, got %s (%v)", out, err)
+	}
+	if out, _ := xreadgroupHandler(ctx, []string{"GROUP", "g", "bob", "STREAMS", "s", ">"}); out != "s,3-0 job 3-0" {
+		t.Errorf("expected bob to get the remaining entry, got %s", out)
+	}
+	if out, _ := xreadgroupHandler(ctx, []string{"GROUP", "g", "alice", "STREAMS", "s", "0"}); out != "s,1-0 job 1-0,2-0 job 2-0" {
+		t.Errorf("expected alice's history, got %s", out)
+	}
+	if out, _ := xpendingHandler([]string{"s", "g"}); out != "3,1-0,3-0,alice,2,bob,1" {
+		t.Errorf("unexpected XPENDING summary %s", out)
+	}
+	if n, _ := xackHandler([]string{"s", "g", "1-0", "9-0"}); n != "1" {
+		t.Errorf("expected 1 acknowledged, got %s", n)
+	}
+	out, _ = xpendingHandler([]string{"s", "g", "-", "+", "10", "alice"})
+	if fields := strings.Fields(out); len(fields) != 4 || fields[0] != "2-0" || fields[1] != "alice" || fields[3] != "1" {
+		t.Errorf("unexpected extended XPENDING %s", out)
+	}
+	if _, err := xreadgroupHandler(ctx, []string{"GROUP", "nope", "c", "STREAMS", "s", ">"}); err == nil {
+		t.Error("expected error for a missing group")
+	}
+	if n, _ := xgroupHandler([]string{"DELCONSUMER", "s", "g", "bob"}); n != "1" {
+		t.Errorf("expected bob's 1 pending entry to go, got %s", n)
+	}
+	if n, _ := xgroupHandler([]string{"DESTROY", "s", "g"}); n != "1" {
+		t.Errorf("expected group destroyed, got %s", n)
+	}
+}
+
+func TestXReadGroupBlock(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = xgroupHandler([]string{"CREATE", "s", "g", "$", "MKSTREAM"})
+	got := make(chan string, 1)
+	go func() {
+		out, _ := xreadgroupHandler(testContext(t), []string{"GROUP", "g", "c", "BLOCK", "0", "STREAMS", "s", ">"})
+		got <- out
+	}()
+	waitForStreamWaiters(t, "s", 1)
+	_, _ = xaddHandler([]string{"s", "1-0", "f", "v"})
+	if out := <-got; out != "s,1-0 f v" {
+		t.Errorf("expected the new entry, got %s", out)
+	}
+	if out, _ := xpendingHandler([]string{"s", "g"}); out != "1,1-0,1-0,c,1" {
+		t.Errorf("expected the delivery to be pending, got %s", out)
+	}
+}
+
+func TestXClaim(t *testing.T) {
+	DefaultStore = NewStore()
+	ctx := context.Background()
+	_, _ = xgroupHandler([]string{"CREATE", "s", "g", "0", "MKSTREAM"})
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		_, _ = xaddHandler([]string{"s", id, "f", id})
+	}
+	_, _ = xreadgroupHandler(ctx, []string{"GROUP", "g", "alice", "STREAMS", "s", ">"})
+
+	if out, _ := xclaimHandler([]string{"s", "g", "bob", "60000", "1-0"}); out != "" {
+		t.Errorf("expected nothing claimed before the idle time, got %s", out)
+	}
+	if out, _ := xclaimHandler([]string{"s", "g", "bob", "0", "1-0", "JUSTID"}); out != "1-0" {
+		t.Errorf("expected 1-0 claimed, got %s", out)
+	}
+	if out, _ := xpendingHandler([]string{"s", "g", "1-0", "1-0", "1"}); !strings.HasPrefix(out, "1-0 bob ") || !strings.HasSuffix(out, " 1") {
+		t.Errorf("expected JUSTID to keep the delivery count, got %s", out)
+	}
+	// Entries trimmed away are dropped from the PEL instead of claimed.
+	_, _ = xtrimHandler([]string{"s", "MINID", "3"})
+	out, err := xautoclaimHandler([]string{"s", "g", "carol", "0", "-", "COUNT", "5"})
+	if err != nil || out != "0-0,3-0 f 3-0" {
+		t.Errorf("expected only 3-0 claimed, got %s (%v)", out, err)
+	}
+	if out, _ := xpendingHandler([]string{"s", "g"}); out != "1,3-0,3-0,carol,1" {
+		t.Errorf("expected trimmed entries gone from the PEL, got %s", out)
+	}
+	out, _ = xpendingHandler([]string{"s", "g", "-", "+", "1"})
+	if fields := strings.Fields(out); len(fields) != 4 || fields[3] != "2" {
+		t.Errorf("expected XAUTOCLAIM to count a delivery, got %s", out)
+	}
+}
+
+func TestStreamSnapshot(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = xgroupHandler([]string{"CREATE", "s", "g", "0", "MKSTREAM"})
+	_, _ = xaddHandler([]string{"s", "1-0", "f", "a b"})
+	_, _ = xaddHandler([]string{"s", "2-0", "f", "c"})
+	_, _ = xgroupHandler([]string{"CREATE", "s", "idle", "$"})
+	_, _ = xreadgroupHandler(context.Background(), []string{"GROUP", "g", "c", "COUNT", "1", "STREAMS", "s", ">"})
+	if err := SaveSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(TEST_FILE)
+	DefaultStore = NewStore()
+	if err := LoadSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := xrangeHandler([]string{"s", "-", "+"}); out != `1-0 f "a b",2-0 f c` {
+		t.Errorf("unexpected entries after reload: %s", out)
+	}
+	if out, _ := xpendingHandler([]string{"s", "g"}); out != "1,1-0,1-0,c,1" {
+		t.Errorf("unexpected PEL after reload: %s", out)
+	}
+	// The group with nothing pending comes back usable.
+	if out, _ := xreadgroupHandler(context.Background(), []string{"GROUP", "idle", "c", "STREAMS", "s", ">"}); out != nilReply {
+		t.Errorf("expected nothing new for the idle group, got %s", out)
+	}
+	if _, err := xaddHandler([]string{"s", "2-0", "f", "x"}); err == nil {
+		t.Error("expected the last ID to survive the reload")
+	}
+}
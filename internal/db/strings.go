@@ -0,0 +1,487 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nilReply is what the text protocol sends for a missing value, matching the
+// empty line GET has always returned for absent keys.
+const nilReply = ""
+
+type expiryMode int
+
+const (
+	expiryClear expiryMode = iota // drop any existing TTL
+	expiryKeep                    // leave the existing TTL untouched
+	expiryAt                      // expire at the given unix millisecond
+)
+
+type expiry struct {
+	mode expiryMode
+	at   int64
+}
+
+type setOptions struct {
+	nx, xx, get bool
+	expiry      expiry
+}
+
+// parseExpiry converts an EX/PX/EXAT/PXAT option and its argument into an
+// absolute expiration. ok is false if opt is not an expiry option.
+func parseExpiry(cmd, opt, arg string) (exp expiry, ok bool, err error) {
+	var unit int64
+	absolute := false
+	switch opt {
+	case "EX":
+		unit = 1000
+	case "PX":
+		unit = 1
+	case "EXAT":
+		unit, absolute = 1000, true
+	case "PXAT":
+		unit, absolute = 1, true
+	default:
+		return expiry{}, false, nil
+	}
+	n, perr := strconv.ParseInt(arg, 10, 64)
+	if perr != nil || n <= 0 || n > (1<<62)/unit {
+		return expiry{}, true, fmt.Errorf("invalid expire time in '%s' command", strings.ToLower(cmd))
+	}
+	at := n * unit
+	if !absolute {
+		at += time.Now().UnixMilli()
+	}
+	return expiry{mode: expiryAt, at: at}, true, nil
+}
+
+func parseSetOptions(args []string) (setOptions, error) {
+	var opts setOptions
+	hasExpiry := false
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch opt {
+		case "NX":
+			if opts.xx {
+				return opts, fmt.Errorf("syntax error")
+			}
+			opts.nx = true
+		case "XX":
+			if opts.nx {
+				return opts, fmt.Errorf("syntax error")
+			}
+			opts.xx = true
+		case "GET":
+			opts.get = true
+		case "KEEPTTL":
+			if hasExpiry {
+				return opts, fmt.Errorf("syntax error")
+			}
+			hasExpiry = true
+			opts.expiry = expiry{mode: expiryKeep}
+		default:
+			if hasExpiry || i+1 >= len(args) {
+				return opts, fmt.Errorf("syntax error")
+			}
+			exp, ok, err := parseExpiry("SET", opt, args[i+1])
+			if !ok {
+				return opts, fmt.Errorf("syntax error")
+			}
+			if err != nil {
+				return opts, err
+			}
+			hasExpiry = true
+			opts.expiry = exp
+			i++
+		}
+	}
+	return opts, nil
+}
+
+// String values are stored as a string, or as a []byte once a command edits
+// them in place, such as APPEND or SETRANGE, so that repeated edits change a
+// few bytes instead of copying the whole value.
+
+// getString returns the string stored at key, expiring it first if needed.
+// The caller must hold the write lock.
+func (s *Store) getString(key string) (string, bool, error) {
+	exists, err := s.checkType(key, StringType)
+	if !exists {
+		return "", false, err
+	}
+	if b, ok := s.data[key].([]byte); ok {
+		return string(b), true, nil
+	}
+	return s.data[key].(string), true, nil
+}
+
+// getBytes returns the string stored at key as a []byte the caller may edit
+// in place, converting it once if it is held as a string. A slice that grows
+// must be stored back with setValue. The caller must hold the write lock.
+func (s *Store) getBytes(key string) ([]byte, bool, error) {
+	exists, err := s.checkType(key, StringType)
+	if !exists {
+		return nil, false, err
+	}
+	switch v := s.data[key].(type) {
+	case []byte:
+		return v, true, nil
+	default:
+		b := []byte(v.(string))
+		s.data[key] = b
+		return b, true, nil
+	}
+}
+
+// applyExpiry updates the TTL of key. The caller must hold the write lock.
+func (s *Store) applyExpiry(key string, exp expiry) {
+	switch exp.mode {
+	case expiryClear:
+		delete(s.ttl, key)
+	case expiryAt:
+		s.ttl[key] = exp.at
+	}
+}
+
+func setnxHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for SETNX")
+	}
+	reply, err := setHandler([]string{args[0], args[1], "NX"})
+	if err != nil {
+		return "", err
+	}
+	if reply == "OK" {
+		return "1", nil
+	}
+	return "0", nil
+}
+
+func getsetHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for GETSET")
+	}
+	return setHandler([]string{args[0], args[1], "GET"})
+}
+
+func getdelHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for GETDEL")
+	}
+	key := args[0]
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	val, ok, err := DefaultStore.getString(key)
+	if !ok {
+		return nilReply, err
+	}
+	DefaultStore.deleteKey(key)
+	DefaultStore.notify(notifyGeneric, "del", key)
+	return val, nil
+}
+
+func getexHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for GETEX")
+	}
+	key := args[0]
+	exp := expiry{mode: expiryKeep}
+	switch opts := args[1:]; {
+	case len(opts) == 0:
+	case len(opts) == 1 && strings.ToUpper(opts[0]) == "PERSIST":
+		exp = expiry{mode: expiryClear}
+	case len(opts) == 2:
+		var ok bool
+		var err error
+		exp, ok, err = parseExpiry("GETEX", strings.ToUpper(opts[0]), opts[1])
+		if !ok {
+			return "", fmt.Errorf("syntax error")
+		}
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("syntax error")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	val, ok, err := DefaultStore.getString(key)
+	if !ok {
+		return nilReply, err
+	}
+	_, hadTTL := DefaultStore.ttl[key]
+	DefaultStore.applyExpiry(key, exp)
+	switch {
+	case exp.mode == expiryAt:
+		DefaultStore.notify(notifyGeneric, "expire", key)
+		DefaultStore.logAs("GETEX", []string{"GETEX", key, "PXAT", strconv.FormatInt(exp.at, 10)})
+	case exp.mode == expiryClear && hadTTL:
+		DefaultStore.notify(notifyGeneric, "persist", key)
+	}
+	return val, nil
+}
+
+func msetHandler(args []string) (string, error) {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return "", fmt.Errorf("wrong number of arguments for MSET")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	for i := 0; i < len(args); i += 2 {
+		DefaultStore.setValue(args[i], StringType, args[i+1])
+		delete(DefaultStore.ttl, args[i])
+		DefaultStore.notify(notifyString, "set", args[i])
+	}
+	return "OK", nil
+}
+
+func msetnxHandler(args []string) (string, error) {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return "", fmt.Errorf("wrong number of arguments for MSETNX")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	for i := 0; i < len(args); i += 2 {
+		DefaultStore.expireIfNeeded(args[i])
+		if _, exists := DefaultStore.data[args[i]]; exists {
+			return "0", nil
+		}
+	}
+	for i := 0; i < len(args); i += 2 {
+		DefaultStore.setValue(args[i], StringType, args[i+1])
+		DefaultStore.notify(notifyString, "set", args[i])
+	}
+	return "1", nil
+}
+
+func mgetHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for MGET")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	vals := make([]string, len(args))
+	for i, key := range args {
+		// Keys holding other types read as nil rather than failing the batch.
+		vals[i], _, _ = DefaultStore.getString(key)
+	}
+	return strings.Join(vals, ","), nil
+}
+
+// maxStringSize bounds the size SETRANGE and APPEND may grow a value to.
+const maxStringSize = 512 * 1024 * 1024
+
+func appendHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for APPEND")
+	}
+	key := args[0]
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	buf, _, err := DefaultStore.getBytes(key)
+	if err != nil {
+		return "", err
+	}
+	if len(buf)+len(args[1]) > maxStringSize {
+		return "", fmt.Errorf("string exceeds maximum allowed size")
+	}
+	buf = append(buf, args[1]...)
+	DefaultStore.setValue(key, StringType, buf)
+	DefaultStore.notify(notifyString, "append", key)
+	return strconv.Itoa(len(buf)), nil
+}
+
+func strlenHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for STRLEN")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	buf, _, err := DefaultStore.getBytes(args[0])
+	return strconv.Itoa(len(buf)), err
+}
+
+// normalizeRange resolves Redis-style inclusive indices, where negative values
+// count from the end, against a sequence of length n. ok is false when the
+// resulting range is empty.
+func normalizeRange(start, end, n int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= n {
+		end = n - 1
+	}
+	if start > end || n == 0 {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+func getrangeHandler(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("missing argument for GETRANGE")
+	}
+	start, err1 := strconv.Atoi(args[1])
+	end, err2 := strconv.Atoi(args[2])
+	if err1 != nil || err2 != nil {
+		return "", errNotInteger
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	buf, _, err := DefaultStore.getBytes(args[0])
+	if err != nil {
+		return "", err
+	}
+	start, end, ok := normalizeRange(start, end, len(buf))
+	if !ok {
+		return "", nil
+	}
+	return string(buf[start : end+1]), nil
+}
+
+func setrangeHandler(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("missing argument for SETRANGE")
+	}
+	key, value := args[0], args[2]
+	offset, err := strconv.Atoi(args[1])
+	if err != nil {
+		return "", errNotInteger
+	}
+	if offset < 0 {
+		return "", fmt.Errorf("offset is out of range")
+	}
+	if offset > maxStringSize-len(value) {
+		return "", fmt.Errorf("string exceeds maximum allowed size")
+	}
+	DefaultStore.mu.Lock()
+	defer DefaultStore.mu.Unlock()
+	buf, _, err := DefaultStore.getBytes(key)
+	if err != nil {
+		return "", err
+	}
+	if value == "" {
+		return strconv.Itoa(len(buf)), nil
+	}
+	if need := offset + len(value); need > len(buf) {
+		buf = append(buf, make([]byte, need-len(buf))...)
+	}
+	copy(buf[offset:], value)
+	DefaultStore.setValue(key, StringType, buf)
+	DefaultStore.notify(notifyString, "setrange", key)
+	return strconv.Itoa(len(buf)), nil
+}
+
+// lcsHandler implements LCS key1 key2 [LEN] [IDX [MINMATCHLEN n] [WITHMATCHLEN]].
+// With IDX the reply is "len:<n>" followed by the matching ranges, each as
+// "<start1>-<end1>:<start2>-<end2>[:<matchlen>]", from the end of the strings
+// towards the start.
+func lcsHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for LCS")
+	}
+	var wantLen, wantIdx, withMatchLen bool
+	minMatchLen := 0
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "LEN":
+			wantLen = true
+		case "IDX":
+			wantIdx = true
+		case "WITHMATCHLEN":
+			withMatchLen = true
+		case "MINMATCHLEN":
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("syntax error")
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return "", errNotInteger
+			}
+			minMatchLen = n
+			i++
+		default:
+			return "", fmt.Errorf("syntax error")
+		}
+	}
+	if wantLen && wantIdx {
+		return "", fmt.Errorf("if you want both the length and indexes, please just use IDX")
+	}
+	DefaultStore.mu.Lock()
+	a, _, errA := DefaultStore.getString(args[0])
+	b, _, errB := DefaultStore.getString(args[1])
+	DefaultStore.mu.Unlock()
+	if errA != nil || errB != nil {
+		return "", ErrWrongType
+	}
+
+	// dp[i][j] is the LCS length of a[:i] and b[:j].
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				dp[i][j] = dp[i-1][j-1] + 1
+			} else {
+				dp[i][j] = max(dp[i-1][j], dp[i][j-1])
+			}
+		}
+	}
+	total := dp[len(a)][len(b)]
+	if wantLen {
+		return strconv.Itoa(total), nil
+	}
+
+	var common []byte
+	var matches []string
+	aStart, aEnd, bStart, bEnd := -1, -1, -1, -1
+	flush := func() {
+		if aStart < 0 {
+			return
+		}
+		if n := aEnd - aStart + 1; n >= minMatchLen {
+			m := fmt.Sprintf("%d-%d:%d-%d", aStart, aEnd, bStart, bEnd)
+			if withMatchLen {
+				m += fmt.Sprintf(":%d", n)
+			}
+			matches = append(matches, m)
+		}
+		aStart, aEnd, bStart, bEnd = -1, -1, -1, -1
+	}
+	for i, j := len(a), len(b); i > 0 && j > 0; {
+		switch {
+		case a[i-1] == b[j-1]:
+			common = append(common, a[i-1])
+			if aStart >= 0 && aStart == i && bStart == j {
+				aStart, bStart = i-1, j-1
+			} else {
+				flush()
+				aStart, aEnd, bStart, bEnd = i-1, i-1, j-1, j-1
+			}
+			i--
+			j--
+		case dp[i-1][j] > dp[i][j-1]:
+			i--
+		default:
+			j--
+		}
+	}
+	flush()
+	if wantIdx {
+		return strings.Join(append([]string{fmt.Sprintf("len:%d", total)}, matches...), ","), nil
+	}
+	for i, j := 0, len(common)-1; i < j; i, j = i+1, j-1 {
+		common[i], common[j] = common[j], common[i]
+	}
+	return string(common), nil
+}
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetOptions(t *testing.T) {
+	DefaultStore = NewStore()
+	if resp, _ := setHandler([]string{"lock", "a", "NX"}); resp != "OK" {
+		t.Errorf("expected OK from SET NX on new key, got %s", resp)
+	}
+	if resp, _ := setHandler([]string{"lock", "b", "NX"}); resp != nilReply {
+		t.Errorf("expected nil from SET NX on existing key, got %s", resp)
+	}
+	if resp, _ := setHandler([]string{"missing", "x", "XX"}); resp != nilReply {
+		t.Errorf("expected nil from SET XX on missing key, got %s", resp)
+	}
+	if resp, _ := setHandler([]string{"lock", "c", "XX", "GET"}); resp != "a" {
+		t.Errorf("expected old value a from SET XX GET, got %s", resp)
+	}
+	if val, _ := getHandler([]string{"lock"}); val != "c" {
+		t.Errorf("expected c, got %s", val)
+	}
+	if _, err := setHandler([]string{"lock", "d", "NX", "XX"}); err == nil {
+		t.Error("expected syntax error for NX with XX")
+	}
+	if _, err := setHandler([]string{"lock", "d", "EX", "0"}); err == nil {
+		t.Error("expected error for non-positive EX")
+	}
+
+	_, _ = setHandler([]string{"temp", "v", "PX", "100"})
+	if ttl, _ := ttlHandler([]string{"temp"}); ttl != "0" {
+		t.Errorf("expected TTL 0 for 100ms expiry, got %s", ttl)
+	}
+	_, _ = setHandler([]string{"temp", "v2", "KEEPTTL"})
+	time.Sleep(150 * time.Millisecond)
+	if val, _ := getHandler([]string{"temp"}); val != nilReply {
+		t.Errorf("expected KEEPTTL value to expire, got %s", val)
+	}
+
+	_, _ = setHandler([]string{"temp", "v", "EX", "100"})
+	_, _ = setHandler([]string{"temp", "v"})
+	if ttl, _ := ttlHandler([]string{"temp"}); ttl != "-1" {
+		t.Errorf("expected plain SET to clear TTL, got %s", ttl)
+	}
+}
+
+func TestConditionalStringCommands(t *testing.T) {
+	DefaultStore = NewStore()
+	if resp, _ := setnxHandler([]string{"k", "1"}); resp != "1" {
+		t.Errorf("expected 1 from SETNX, got %s", resp)
+	}
+	if resp, _ := setnxHandler([]string{"k", "2"}); resp != "0" {
+		t.Errorf("expected 0 from SETNX, got %s", resp)
+	}
+	if resp, _ := getsetHandler([]string{"k", "3"}); resp != "1" {
+		t.Errorf("expected 1 from GETSET, got %s", resp)
+	}
+	if resp, _ := getdelHandler([]string{"k"}); resp != "3" {
+		t.Errorf("expected 3 from GETDEL, got %s", resp)
+	}
+	if exists, _ := existsHandler([]string{"k"}); exists != "0" {
+		t.Errorf("expected key removed by GETDEL, got exists=%s", exists)
+	}
+
+	_, _ = setHandler([]string{"g", "v"})
+	if resp, _ := getexHandler([]string{"g", "EX", "100"}); resp != "v" {
+		t.Errorf("expected v from GETEX, got %s", resp)
+	}
+	if ttl, _ := ttlHandler([]string{"g"}); ttl != "100" {
+		t.Errorf("expected TTL 100 after GETEX EX, got %s", ttl)
+	}
+	_, _ = getexHandler([]string{"g", "PERSIST"})
+	if ttl, _ := ttlHandler([]string{"g"}); ttl != "-1" {
+		t.Errorf("expected TTL -1 after GETEX PERSIST, got %s", ttl)
+	}
+}
+
+func TestMultiKeyStringCommands(t *testing.T) {
+	DefaultStore = NewStore()
+	if _, err := msetHandler([]string{"a", "1", "b"}); err == nil {
+		t.Error("expected error for odd MSET arguments")
+	}
+	_, _ = msetHandler([]string{"a", "1", "b", "2"})
+	_, _ = lpushHandler([]string{"l", "x"})
+	if out, _ := mgetHandler([]string{"a", "missing", "b", "l"}); out != "1,,2," {
+		t.Errorf("expected 1,,2, got %s", out)
+	}
+	if resp, _ := msetnxHandler([]string{"c", "3", "a", "9"}); resp != "0" {
+		t.Errorf("expected 0 from MSETNX with existing key, got %s", resp)
+	}
+	if exists, _ := existsHandler([]string{"c"}); exists != "0" {
+		t.Errorf("expected MSETNX to set nothing, got exists=%s", exists)
+	}
+	if resp, _ := msetnxHandler([]string{"c", "3", "d", "4"}); resp != "1" {
+		t.Errorf("expected 1 from MSETNX, got %s", resp)
+	}
+}
+
+func TestStringManipulation(t *testing.T) {
+	DefaultStore = NewStore()
+	if n, _ := appendHandler([]string{"log", "Hello"}); n != "5" {
+		t.Errorf("expected 5 from APPEND, got %s", n)
+	}
+	if n, _ := appendHandler([]string{"log", " World"}); n != "11" {
+		t.Errorf("expected 11 from APPEND, got %s", n)
+	}
+	if n, _ := strlenHandler([]string{"log"}); n != "11" {
+		t.Errorf("expected 11 from STRLEN, got %s", n)
+	}
+	if n, _ := strlenHandler([]string{"missing"}); n != "0" {
+		t.Errorf("expected 0 from STRLEN on missing key, got %s", n)
+	}
+
+	ranges := map[[2]string]string{
+		{"0", "4"}:    "Hello",
+		{"-5", "-1"}:  "World",
+		{"0", "-1"}:   "Hello World",
+		{"6", "100"}:  "World",
+		{"5", "2"}:    "",
+		{"-100", "1"}: "He",
+	}
+	for r, want := range ranges {
+		if got, _ := getrangeHandler([]string{"log", r[0], r[1]}); got != want {
+			t.Errorf("GETRANGE %s %s: expected %q, got %q", r[0], r[1], want, got)
+		}
+	}
+
+	if n, _ := setrangeHandler([]string{"log", "6", "Redis"}); n != "11" {
+		t.Errorf("expected 11 from SETRANGE, got %s", n)
+	}
+	if v, _ := getHandler([]string{"log"}); v != "Hello Redis" {
+		t.Errorf("expected Hello Redis, got %s", v)
+	}
+	if n, _ := setrangeHandler([]string{"pad", "3", "x"}); n != "4" {
+		t.Errorf("expected 4 from SETRANGE on missing key, got %s", n)
+	}
+	if v, _ := getHandler([]string{"pad"}); v != "\x00\x00\x00x" {
+		t.Errorf("expected zero padding, got %q", v)
+	}
+	if _, err := setrangeHandler([]string{"pad", "-1", "x"}); err == nil {
+		t.Error("expected error for negative SETRANGE offset")
+	}
+	if _, err := setrangeHandler([]string{"pad", "9223372036854775807", "x"}); err == nil {
+		t.Error("expected error for SETRANGE offset past the maximum size")
+	}
+}
+
+func TestAppendEditsInPlace(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = setHandler([]string{"log", "a"})
+	for i := 0; i < 100; i++ {
+		_, _ = appendHandler([]string{"log", "b"})
+	}
+	buf, ok := DefaultStore.data["log"].([]byte)
+	if !ok || len(buf) != 101 {
+		t.Fatalf("expected an appended value to be kept as bytes, got %T", DefaultStore.data["log"])
+	}
+	_, _ = setrangeHandler([]string{"log", "0", "z"})
+	if &DefaultStore.data["log"].([]byte)[0] != &buf[0] {
+		t.Error("expected SETRANGE within the value to edit it in place")
+	}
+	if v, _ := getHandler([]string{"log"}); v != "z"+strings.Repeat("b", 100) {
+		t.Errorf("expected GET to read the edited bytes, got %q", v)
+	}
+	if v, _ := getrangeHandler([]string{"log", "0", "1"}); v != "zb" {
+		t.Errorf("expected zb from GETRANGE, got %s", v)
+	}
+}
+
+func TestLCS(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = msetHandler([]string{"key1", "ohmytext", "key2", "mynewtext"})
+	if v, _ := lcsHandler([]string{"key1", "key2"}); v != "mytext" {
+		t.Errorf("expected mytext, got %s", v)
+	}
+	if v, _ := lcsHandler([]string{"key1", "key2", "LEN"}); v != "6" {
+		t.Errorf("expected 6, got %s", v)
+	}
+	if v, _ := lcsHandler([]string{"key1", "key2", "IDX"}); v != "len:6,4-7:5-8,2-3:0-1" {
+		t.Errorf("expected len:6,4-7:5-8,2-3:0-1, got %s", v)
+	}
+	if v, _ := lcsHandler([]string{"key1", "key2", "IDX", "MINMATCHLEN", "4", "WITHMATCHLEN"}); v != "len:6,4-7:5-8:4" {
+		t.Errorf("expected len:6,4-7:5-8:4, got %s", v)
+	}
+}
@@ -0,0 +1,737 @@
+package db
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"furr/internal/protocol"
+)
+
+// Duplicate policies decide what adding a sample at a timestamp that already
+// has one does.
+const (
+	tsBlock = "block" // fail
+	tsFirst = "first" // keep the old value
+	tsLast  = "last"  // take the new value
+	tsMin   = "min"
+	tsMax   = "max"
+	tsSum   = "sum"
+)
+
+var tsPolicies = []string{tsBlock, tsFirst, tsLast, tsMin, tsMax, tsSum}
+
+// tsAggregators reduce the samples of a bucket, which are never empty, to
+// one value.
+var tsAggregators = map[string]func([]tsSample) float64{
+	"avg": func(b []tsSample) float64 { return tsSumOf(b) / float64(len(b)) },
+	"sum": tsSumOf,
+	"min": func(b []tsSample) float64 {
+		return slices.MinFunc(b, func(x, y tsSample) int { return cmp.Compare(x.Value, y.Value) }).Value
+	},
+	"max": func(b []tsSample) float64 {
+		return slices.MaxFunc(b, func(x, y tsSample) int { return cmp.Compare(x.Value, y.Value) }).Value
+	},
+	"count": func(b []tsSample) float64 { return float64(len(b)) },
+	"first": func(b []tsSample) float64 { return b[0].Value },
+	"last":  func(b []tsSample) float64 { return b[len(b)-1].Value },
+}
+
+func tsSumOf(b []tsSample) float64 {
+	var sum float64
+	for _, x := range b {
+		sum += x.Value
+	}
+	return sum
+}
+
+type tsSample struct {
+	Time  int64 // milliseconds since the epoch
+	Value float64
+}
+
+// tsRule downsamples a series into Dest, one sample per Bucket milliseconds
+// holding the Aggregator of the source samples in it. A bucket is written
+// once a sample arrives past it; until then Open is its start, or -1 before
+// the first sample.
+type tsRule struct {
+	Dest       string
+	Aggregator string
+	Bucket     int64
+	Open       int64
+}
+
+// timeSeries holds samples in time order. Samples older than Retention
+// milliseconds before the newest are dropped, unless Retention is 0. Source
+// names the series that compacts into this one, if any. Its fields are
+// exported so snapshots can store it as is.
+type timeSeries struct {
+	Samples   []tsSample
+	Retention int64
+	Duplicate string
+	Labels    map[string]string
+	Rules     []*tsRule
+	Source    string
+}
+
+// add inserts a sample, settling a clash with an existing one by policy.
+func (ts *timeSeries) add(t int64, v float64, policy string) error {
+	n := len(ts.Samples)
+	if ts.Retention > 0 && n > 0 && t < ts.Samples[n-1].Time-ts.Retention {
+		return fmt.Errorf("timestamp is older than the retention period")
+	}
+	i, found := slices.BinarySearchFunc(ts.Samples, t, func(x tsSample, t int64) int {
+		return cmp.Compare(x.Time, t)
+	})
+	if !found {
+		ts.Samples = slices.Insert(ts.Samples, i, tsSample{t, v})
+		if ts.Retention > 0 {
+			last := ts.Samples[len(ts.Samples)-1].Time
+			j := sort.Search(len(ts.Samples), func(j int) bool { return ts.Samples[j].Time >= last-ts.Retention })
+			ts.Samples = slices.Delete(ts.Samples, 0, j)
+		}
+		return nil
+	}
+	old := &ts.Samples[i].Value
+	switch policy {
+	case tsBlock:
+		return fmt.Errorf("a sample already exists at this timestamp")
+	case tsLast:
+		*old = v
+	case tsMin:
+		*old = min(*old, v)
+	case tsMax:
+		*old = max(*old, v)
+	case tsSum:
+		*old += v
+	}
+	return nil
+}
+
+// between returns the samples from from to to, inclusive.
+func (ts *timeSeries) between(from, to int64) []tsSample {
+	i := sort.Search(len(ts.Samples), func(i int) bool { return ts.Samples[i].Time >= from })
+	j := sort.Search(len(ts.Samples), func(j int) bool { return ts.Samples[j].Time > to })
+	if i >= j {
+		return nil
+	}
+	return ts.Samples[i:j]
+}
+
+// tsBucketStart returns the start of the bucket t falls in. Buckets are
+// aligned to the epoch.
+func tsBucketStart(t, bucket int64) int64 {
+	return t - t%bucket
+}
+
+// aggregate reduces samples, in time order, to one per bucket, stamped with
+// the bucket's start.
+func aggregate(samples []tsSample, agg string, bucket int64) []tsSample {
+	var out []tsSample
+	for len(samples) > 0 {
+		start := tsBucketStart(samples[0].Time, bucket)
+		n := sort.Search(len(samples), func(i int) bool { return samples[i].Time >= start+bucket })
+		out = append(out, tsSample{start, tsAggregators[agg](samples[:n])})
+		samples = samples[n:]
+	}
+	return out
+}
+
+func copyTimeSeries(ts *timeSeries) *timeSeries {
+	// A copy keeps its samples and labels but takes no part in compaction.
+	return &timeSeries{
+		Samples:   slices.Clone(ts.Samples),
+		Retention: ts.Retention,
+		Duplicate: ts.Duplicate,
+		Labels:    maps.Clone(ts.Labels),
+	}
+}
+
+// getTimeSeries returns the series at key. The caller must hold the write
+// lock.
+func (s *Store) getTimeSeries(key string) (*timeSeries, bool, error) {
+	exists, err := s.checkType(key, TimeSeriesType)
+	if !exists {
+		return nil, false, err
+	}
+	return s.data[key].(*timeSeries), true, nil
+}
+
+// mustTimeSeries is getTimeSeries for commands that need the key to exist.
+func (s *Store) mustTimeSeries(key string) (*timeSeries, error) {
+	ts, exists, err := s.getTimeSeries(key)
+	if err == nil && !exists {
+		err = fmt.Errorf("key does not exist")
+	}
+	return ts, err
+}
+
+// addSample adds a sample to the series at key and feeds its compaction
+// rules. The caller must hold the write lock.
+func (s *Store) addSample(key string, ts *timeSeries, t int64, v float64, policy string) error {
+	if err := ts.add(t, v, policy); err != nil {
+		return err
+	}
+	s.notify(notifyModule, "ts.add", key)
+	for _, r := range ts.Rules {
+		b := tsBucketStart(t, r.Bucket)
+		switch {
+		case r.Open < 0:
+			r.Open = b
+		case b > r.Open:
+			s.compact(ts, r, r.Open)
+			r.Open = b
+		case b < r.Open:
+			// A late sample changes a bucket that was already written.
+			s.compact(ts, r, b)
+		}
+	}
+	return nil
+}
+
+// compact writes the bucket starting at start to the rule's destination, if
+// it is still a series. The caller must hold the write lock.
+func (s *Store) compact(ts *timeSeries, r *tsRule, start int64) {
+	samples := ts.between(start, start+r.Bucket-1)
+	dest, exists, err := s.getTimeSeries(r.Dest)
+	if len(samples) == 0 || !exists || err != nil {
+		return
+	}
+	if dest.add(start, tsAggregators[r.Aggregator](samples), tsLast) == nil {
+		s.notify(notifyModule, "ts.add", r.Dest)
+	}
+}
+
+// hasSource reports whether the series at key is still compacted into by
+// the series it names as its source.
+func (s *Store) hasSource(key string, ts *timeSeries) bool {
+	if ts.Source == "" {
+		return false
+	}
+	src, exists, err := s.getTimeSeries(ts.Source)
+	if !exists || err != nil {
+		return false
+	}
+	return slices.ContainsFunc(src.Rules, func(r *tsRule) bool { return r.Dest == key })
+}
+
+// tsOptions are the settings TS.CREATE and TS.ADD take.
+type tsOptions struct {
+	retention int64
+	duplicate string
+	labels    map[string]string
+}
+
+// parseTSOptions parses [RETENTION ms] [<policyKeyword> policy] [LABELS
+// label value ...]; LABELS takes the rest of the arguments.
+func parseTSOptions(args []string, policyKeyword string) (tsOptions, error) {
+	opts := tsOptions{}
+	for i := 0; i < len(args); i++ {
+		switch kw := strings.ToUpper(args[i]); {
+		case kw == "RETENTION" && i+1 < len(args):
+			n, err := parseNonNegative(args[i+1])
+			if err != nil {
+				return opts, err
+			}
+			opts.retention = n
+			i++
+		case kw == policyKeyword && i+1 < len(args):
+			p := strings.ToLower(args[i+1])
+			if !slices.Contains(tsPolicies, p) {
+				return opts, fmt.Errorf("unknown duplicate policy")
+			}
+			opts.duplicate = p
+			i++
+		case kw == "LABELS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return opts, fmt.Errorf("wrong number of arguments for LABELS")
+			}
+			opts.labels = make(map[string]string, len(rest)/2)
+			for j := 0; j < len(rest); j += 2 {
+				opts.labels[rest[j]] = rest[j+1]
+			}
+			return opts, nil
+		default:
+			return opts, fmt.Errorf("syntax error")
+		}
+	}
+	return opts, nil
+}
+
+func newTimeSeries(opts tsOptions) *timeSeries {
+	ts := &timeSeries{Retention: opts.retention, Duplicate: opts.duplicate, Labels: opts.labels}
+	if ts.Duplicate == "" {
+		ts.Duplicate = tsBlock
+	}
+	return ts
+}
+
+// parseTimestamp parses a sample's timestamp, where * is the current time.
+func parseTimestamp(arg string) (int64, error) {
+	if arg == "*" {
+		return time.Now().UnixMilli(), nil
+	}
+	t, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || t < 0 {
+		return 0, fmt.Errorf("invalid timestamp")
+	}
+	return t, nil
+}
+
+func parseSampleValue(arg string) (float64, error) {
+	v, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(v) {
+		return 0, fmt.Errorf("invalid value")
+	}
+	return v, nil
+}
+
+// parseTimeBound parses a range bound, where - and + are the earliest and
+// latest times.
+func parseTimeBound(arg string) (int64, error) {
+	switch arg {
+	case "-":
+		return 0, nil
+	case "+":
+		return math.MaxInt64, nil
+	}
+	return parseTimestamp(arg)
+}
+
+// parseAggregation parses an aggregator and a bucket size in milliseconds.
+func parseAggregation(agg, bucket string) (string, int64, error) {
+	agg = strings.ToLower(agg)
+	if _, ok := tsAggregators[agg]; !ok {
+		return "", 0, fmt.Errorf("unknown aggregation type")
+	}
+	n, err := strconv.ParseInt(bucket, 10, 64)
+	if err != nil || n < 1 {
+		return "", 0, fmt.Errorf("bucket duration must be a positive integer")
+	}
+	return agg, n, nil
+}
+
+func formatSample(x tsSample) string {
+	return protocol.QuoteArgs([]string{strconv.FormatInt(x.Time, 10), strconv.FormatFloat(x.Value, 'f', -1, 64)})
+}
+
+func formatSamples(samples []tsSample) []string {
+	out := make([]string, len(samples))
+	for i, x := range samples {
+		out[i] = formatSample(x)
+	}
+	return out
+}
+
+// tsCreateHandler implements TS.CREATE key [RETENTION ms] [DUPLICATE_POLICY
+// policy] [LABELS label value ...].
+func tsCreateHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for TS.CREATE")
+	}
+	opts, err := parseTSOptions(args[1:], "DUPLICATE_POLICY")
+	if err != nil {
+		return "", err
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireIfNeeded(args[0])
+	if _, exists := s.data[args[0]]; exists {
+		return "", fmt.Errorf("key already exists")
+	}
+	s.setValue(args[0], TimeSeriesType, newTimeSeries(opts))
+	s.notify(notifyModule, "ts.create", args[0])
+	return "OK", nil
+}
+
+// tsAddHandler implements TS.ADD key timestamp|* value [RETENTION ms]
+// [ON_DUPLICATE policy] [LABELS label value ...], replying with the
+// timestamp. The options set up the series if it does not exist yet, except
+// ON_DUPLICATE, which overrides its duplicate policy for this sample.
+func tsAddHandler(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("missing argument for TS.ADD")
+	}
+	t, err := parseTimestamp(args[1])
+	if err != nil {
+		return "", err
+	}
+	v, err := parseSampleValue(args[2])
+	if err != nil {
+		return "", err
+	}
+	opts, err := parseTSOptions(args[3:], "ON_DUPLICATE")
+	if err != nil {
+		return "", err
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts, exists, err := s.getTimeSeries(args[0])
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		ts = newTimeSeries(tsOptions{retention: opts.retention, labels: opts.labels})
+		s.setValue(args[0], TimeSeriesType, ts)
+	}
+	policy := opts.duplicate
+	if policy == "" {
+		policy = ts.Duplicate
+	}
+	if err := s.addSample(args[0], ts, t, v, policy); err != nil {
+		return "", err
+	}
+	stamp := strconv.FormatInt(t, 10)
+	s.logAs("TS.ADD", append([]string{"TS.ADD", args[0], stamp}, args[2:]...))
+	return stamp, nil
+}
+
+// tsMaddHandler implements TS.MADD key timestamp|* value [key timestamp|*
+// value ...], replying with each sample's timestamp. The series must exist.
+// Samples are added in order up to the first that fails, whose error is the
+// reply.
+func tsMaddHandler(args []string) (string, error) {
+	if len(args) < 3 || len(args)%3 != 0 {
+		return "", fmt.Errorf("wrong number of arguments for TS.MADD")
+	}
+	type sample struct {
+		key string
+		t   int64
+		v   float64
+	}
+	samples := make([]sample, len(args)/3)
+	for i := range samples {
+		t, err := parseTimestamp(args[3*i+1])
+		if err != nil {
+			return "", err
+		}
+		v, err := parseSampleValue(args[3*i+2])
+		if err != nil {
+			return "", err
+		}
+		samples[i] = sample{args[3*i], t, v}
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]string, len(samples))
+	logged := []string{"TS.MADD"}
+	// Log the timestamps * stood for, even if a later sample fails, so the
+	// log replays to the samples that went in.
+	defer func() {
+		if len(logged) > 1 {
+			s.logAs("TS.MADD", logged)
+		}
+	}()
+	for i, x := range samples {
+		ts, err := s.mustTimeSeries(x.key)
+		if err != nil {
+			return "", err
+		}
+		if err := s.addSample(x.key, ts, x.t, x.v, ts.Duplicate); err != nil {
+			return "", err
+		}
+		out[i] = strconv.FormatInt(x.t, 10)
+		logged = append(logged, x.key, out[i], args[3*i+2])
+	}
+	return strings.Join(out, ","), nil
+}
+
+// tsGetHandler implements TS.GET key, replying with the newest sample.
+func tsGetHandler(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("wrong number of arguments for TS.GET")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts, err := s.mustTimeSeries(args[0])
+	if err != nil {
+		return "", err
+	}
+	if len(ts.Samples) == 0 {
+		return nilReply, nil
+	}
+	return formatSample(ts.Samples[len(ts.Samples)-1]), nil
+}
+
+// tsRangeOptions are the arguments TS.RANGE and TS.MRANGE share.
+type tsRangeOptions struct {
+	from, to int64
+	count    int64 // 0 for no limit
+	agg      string
+	bucket   int64
+	filters  []labelFilter
+}
+
+// parseTSRange parses from to [COUNT n] [AGGREGATION aggregator bucket],
+// followed by FILTER filter ... if filter is set.
+func parseTSRange(args []string, filter bool) (tsRangeOptions, error) {
+	var opts tsRangeOptions
+	if len(args) < 2 {
+		return opts, fmt.Errorf("missing argument")
+	}
+	var err error
+	if opts.from, err = parseTimeBound(args[0]); err != nil {
+		return opts, err
+	}
+	if opts.to, err = parseTimeBound(args[1]); err != nil {
+		return opts, err
+	}
+	for i := 2; i < len(args); i++ {
+		switch kw := strings.ToUpper(args[i]); {
+		case kw == "COUNT" && i+1 < len(args):
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n < 1 {
+				return opts, fmt.Errorf("count must be a positive integer")
+			}
+			opts.count = n
+			i++
+		case kw == "AGGREGATION" && i+2 < len(args):
+			if opts.agg, opts.bucket, err = parseAggregation(args[i+1], args[i+2]); err != nil {
+				return opts, err
+			}
+			i += 2
+		case kw == "FILTER" && filter && i+1 < len(args):
+			for _, expr := range args[i+1:] {
+				f, err := parseLabelFilter(expr)
+				if err != nil {
+					return opts, err
+				}
+				opts.filters = append(opts.filters, f)
+			}
+			if !slices.ContainsFunc(opts.filters, labelFilter.selective) {
+				return opts, fmt.Errorf("filter needs at least one label=value matcher")
+			}
+			return opts, nil
+		default:
+			return opts, fmt.Errorf("syntax error")
+		}
+	}
+	if filter {
+		return opts, fmt.Errorf("missing FILTER")
+	}
+	return opts, nil
+}
+
+// query returns the samples of ts that opts selects.
+func (opts tsRangeOptions) query(ts *timeSeries) []tsSample {
+	samples := ts.between(opts.from, opts.to)
+	if opts.agg != "" {
+		samples = aggregate(samples, opts.agg, opts.bucket)
+	}
+	if opts.count > 0 && int64(len(samples)) > opts.count {
+		samples = samples[:opts.count]
+	}
+	return samples
+}
+
+// tsRangeHandler implements TS.RANGE key from to [COUNT n] [AGGREGATION
+// aggregator bucket], replying with "timestamp value" samples in time order.
+// From and to may be - and + for the earliest and latest.
+func tsRangeHandler(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("missing argument for TS.RANGE")
+	}
+	opts, err := parseTSRange(args[1:], false)
+	if err != nil {
+		return "", err
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts, err := s.mustTimeSeries(args[0])
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(formatSamples(opts.query(ts)), ","), nil
+}
+
+// labelFilter matches series by a label. It matches those whose label is one
+// of values, or, if negate is set, those whose label is not. A label a
+// series lacks counts as empty.
+type labelFilter struct {
+	label  string
+	values []string
+	negate bool
+}
+
+// parseLabelFilter parses label=value, label!=value, or either with a list
+// of values as (a,b,...). An empty value stands for a missing label.
+func parseLabelFilter(expr string) (labelFilter, error) {
+	var f labelFilter
+	label, value, ok := strings.Cut(expr, "=")
+	if !ok || label == "" || label == "!" {
+		return f, fmt.Errorf("invalid filter %q", expr)
+	}
+	if strings.HasSuffix(label, "!") {
+		f.negate = true
+		label = label[:len(label)-1]
+	}
+	f.label = label
+	if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		f.values = strings.Split(value[1:len(value)-1], ",")
+	} else {
+		f.values = []string{value}
+	}
+	return f, nil
+}
+
+// selective reports whether f only matches series that have its label.
+func (f labelFilter) selective() bool {
+	return !f.negate && !slices.Contains(f.values, "")
+}
+
+func (f labelFilter) match(labels map[string]string) bool {
+	return slices.Contains(f.values, labels[f.label]) != f.negate
+}
+
+// tsMrangeHandler implements TS.MRANGE from to [COUNT n] [AGGREGATION
+// aggregator bucket] FILTER filter [filter ...], replying with the name of
+// each series matching all the filters followed by its samples, as TS.RANGE
+// gives them, in key order.
+func tsMrangeHandler(args []string) (string, error) {
+	opts, err := parseTSRange(args, true)
+	if err != nil {
+		return "", err
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key, t := range s.types {
+		if t != TimeSeriesType {
+			continue
+		}
+		ts := s.data[key].(*timeSeries)
+		if !slices.ContainsFunc(opts.filters, func(f labelFilter) bool { return !f.match(ts.Labels) }) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	var out []string
+	for _, key := range keys {
+		// Expiring keys here rather than above leaves the map alone while
+		// it is being walked.
+		ts, exists, _ := s.getTimeSeries(key)
+		if exists {
+			out = append(append(out, key), formatSamples(opts.query(ts))...)
+		}
+	}
+	return strings.Join(out, ","), nil
+}
+
+// tsCreateRuleHandler implements TS.CREATERULE source dest AGGREGATION
+// aggregator bucket. Both series must exist, and a series can either compact
+// into others or be compacted into, not both.
+func tsCreateRuleHandler(args []string) (string, error) {
+	if len(args) != 5 || strings.ToUpper(args[2]) != "AGGREGATION" {
+		return "", fmt.Errorf("wrong number of arguments for TS.CREATERULE")
+	}
+	agg, bucket, err := parseAggregation(args[3], args[4])
+	if err != nil {
+		return "", err
+	}
+	if args[0] == args[1] {
+		return "", fmt.Errorf("source and destination must be different keys")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, err := s.mustTimeSeries(args[0])
+	if err != nil {
+		return "", err
+	}
+	dest, err := s.mustTimeSeries(args[1])
+	if err != nil {
+		return "", err
+	}
+	if s.hasSource(args[0], src) {
+		return "", fmt.Errorf("the source key is a compaction destination")
+	}
+	if s.hasSource(args[1], dest) {
+		return "", fmt.Errorf("the destination key already has a source")
+	}
+	if len(dest.Rules) > 0 {
+		return "", fmt.Errorf("the destination key has compaction rules")
+	}
+	src.Rules = append(src.Rules, &tsRule{Dest: args[1], Aggregator: agg, Bucket: bucket, Open: -1})
+	dest.Source = args[0]
+	s.notify(notifyModule, "ts.createrule", args[0])
+	return "OK", nil
+}
+
+// tsDeleteRuleHandler implements TS.DELETERULE source dest.
+func tsDeleteRuleHandler(args []string) (string, error) {
+	if len(args) != 2 {
+		return "", fmt.Errorf("wrong number of arguments for TS.DELETERULE")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, err := s.mustTimeSeries(args[0])
+	if err != nil {
+		return "", err
+	}
+	i := slices.IndexFunc(src.Rules, func(r *tsRule) bool { return r.Dest == args[1] })
+	if i < 0 {
+		return "", fmt.Errorf("compaction rule does not exist")
+	}
+	src.Rules = slices.Delete(src.Rules, i, i+1)
+	if dest, exists, _ := s.getTimeSeries(args[1]); exists && dest.Source == args[0] {
+		dest.Source = ""
+	}
+	s.notify(notifyModule, "ts.deleterule", args[0])
+	return "OK", nil
+}
+
+// tsInfoHandler implements TS.INFO key, replying with name,value pairs.
+// Labels and rules are lists within their values: label value ... and dest
+// aggregator bucket ... respectively.
+func tsInfoHandler(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("wrong number of arguments for TS.INFO")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts, err := s.mustTimeSeries(args[0])
+	if err != nil {
+		return "", err
+	}
+	var first, last int64
+	if n := len(ts.Samples); n > 0 {
+		first, last = ts.Samples[0].Time, ts.Samples[n-1].Time
+	}
+	var labels []string
+	for _, l := range slices.Sorted(maps.Keys(ts.Labels)) {
+		labels = append(labels, l, ts.Labels[l])
+	}
+	var rules []string
+	for _, r := range ts.Rules {
+		rules = append(rules, r.Dest, r.Aggregator, strconv.FormatInt(r.Bucket, 10))
+	}
+	source := ""
+	if s.hasSource(args[0], ts) {
+		source = ts.Source
+	}
+	return strings.Join([]string{
+		"samples", strconv.Itoa(len(ts.Samples)),
+		"first", strconv.FormatInt(first, 10),
+		"last", strconv.FormatInt(last, 10),
+		"retention", strconv.FormatInt(ts.Retention, 10),
+		"duplicate_policy", ts.Duplicate,
+		"labels", protocol.QuoteArgs(labels),
+		"source", source,
+		"rules", protocol.QuoteArgs(rules),
+	}, ","), nil
+}
@@ -0,0 +1,255 @@
+package db
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTimeSeriesAddRange(t *testing.T) {
+	DefaultStore = NewStore()
+	for _, tc := range []struct {
+		cmd  []string
+		want string
+	}{
+		{[]string{"TS.CREATE", "temp", "RETENTION", "1000", "LABELS", "room", "kitchen"}, "OK"},
+		{[]string{"TS.GET", "temp"}, nilReply},
+		{[]string{"TS.ADD", "temp", "1000", "20"}, "1000"},
+		{[]string{"TS.ADD", "temp", "1500", "22.5"}, "1500"},
+		{[]string{"TS.ADD", "temp", "1200", "21"}, "1200"},
+		{[]string{"TS.ADD", "temp", "1500", "30", "ON_DUPLICATE", "MAX"}, "1500"},
+		{[]string{"TS.ADD", "temp", "1500", "1", "ON_DUPLICATE", "sum"}, "1500"},
+		{[]string{"TS.MADD", "temp", "2000", "25", "temp", "2100", "26"}, "2000,2100"},
+		{[]string{"TS.GET", "temp"}, "2100 26"},
+		// Retention keeps samples within 1000ms of the newest.
+		{[]string{"TS.RANGE", "temp", "-", "+"}, "1200 21,1500 31,2000 25,2100 26"},
+		{[]string{"TS.RANGE", "temp", "1300", "2050"}, "1500 31,2000 25"},
+		{[]string{"TS.RANGE", "temp", "-", "+", "COUNT", "2"}, "1200 21,1500 31"},
+		{[]string{"TS.RANGE", "temp", "-", "+", "AGGREGATION", "avg", "1000"}, "1000 26,2000 25.5"},
+		{[]string{"TS.RANGE", "temp", "-", "+", "AGGREGATION", "MIN", "1000"}, "1000 21,2000 25"},
+		{[]string{"TS.RANGE", "temp", "-", "+", "AGGREGATION", "max", "500"}, "1000 21,1500 31,2000 26"},
+		{[]string{"TS.RANGE", "temp", "-", "+", "AGGREGATION", "sum", "1000"}, "1000 52,2000 51"},
+		{[]string{"TS.RANGE", "temp", "-", "+", "AGGREGATION", "count", "10000"}, "0 4"},
+		{[]string{"TS.RANGE", "temp", "3000", "+"}, ""},
+		{[]string{"TS.INFO", "temp"}, "samples,4,first,1200,last,2100,retention,1000,duplicate_policy,block,labels,room kitchen,source,,rules,"},
+		// A series made by TS.ADD takes its settings from the options.
+		{[]string{"TS.ADD", "hum", "5", "40", "RETENTION", "0", "LABELS", "room", "hall", "unit", "%"}, "5"},
+		{[]string{"TS.INFO", "hum"}, "samples,1,first,5,last,5,retention,0,duplicate_policy,block,labels,room hall unit %,source,,rules,"},
+	} {
+		if out, err := Commands[tc.cmd[0]](tc.cmd[1:]); err != nil || out != tc.want {
+			t.Errorf("%v: expected %q, got %q (%v)", tc.cmd, tc.want, out, err)
+		}
+	}
+	for _, bad := range [][]string{
+		{"TS.ADD", "temp", "2000", "1"},                         // duplicate under BLOCK
+		{"TS.ADD", "temp", "100", "1"},                          // older than the retention
+		{"TS.ADD", "temp", "-5", "1"},                           // negative timestamp
+		{"TS.ADD", "temp", "3000", "x"},                         // not a number
+		{"TS.ADD", "temp", "3000", "1", "ON_DUPLICATE", "most"}, // unknown policy
+		{"TS.ADD", "temp", "3000", "1", "LABELS", "a"},
+		{"TS.CREATE", "temp"},
+		{"TS.CREATE", "x", "RETENTION"},
+		{"TS.MADD", "missing", "1", "1"},
+		{"TS.GET", "missing"},
+		{"TS.RANGE", "temp", "-", "+", "AGGREGATION", "median", "10"},
+		{"TS.RANGE", "temp", "-", "+", "AGGREGATION", "avg", "0"},
+		{"TS.RANGE", "temp", "-", "+", "COUNT", "0"},
+	} {
+		if _, err := Commands[bad[0]](bad[1:]); err == nil {
+			t.Errorf("expected %v to fail", bad)
+		}
+	}
+	if typ, _ := typeHandler([]string{"temp"}); typ != "timeseries" {
+		t.Errorf("expected TYPE timeseries, got %s", typ)
+	}
+}
+
+func TestTimeSeriesDuplicatePolicy(t *testing.T) {
+	DefaultStore = NewStore()
+	for policy, want := range map[string]string{
+		"block": "10 1", "first": "10 1", "last": "10 3", "min": "10 1", "max": "10 3", "sum": "10 4",
+	} {
+		key := "s:" + policy
+		_, _ = tsCreateHandler([]string{key, "DUPLICATE_POLICY", policy})
+		_, _ = tsAddHandler([]string{key, "10", "1"})
+		_, _ = tsAddHandler([]string{key, "10", "3"})
+		if out, _ := tsRangeHandler([]string{key, "-", "+"}); out != want {
+			t.Errorf("%s: expected %q, got %q", policy, want, out)
+		}
+	}
+}
+
+func TestTimeSeriesCompaction(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = tsCreateHandler([]string{"raw"})
+	_, _ = tsCreateHandler([]string{"avg"})
+	_, _ = tsCreateHandler([]string{"max"})
+	for _, cmd := range [][]string{
+		{"raw", "avg", "AGGREGATION", "avg", "100"},
+		{"raw", "max", "AGGREGATION", "max", "100"},
+	} {
+		if out, err := tsCreateRuleHandler(cmd); err != nil || out != "OK" {
+			t.Fatalf("TS.CREATERULE %v: %q (%v)", cmd, out, err)
+		}
+	}
+	for _, sample := range [][]string{{"10", "1"}, {"50", "3"}, {"120", "5"}, {"150", "9"}, {"230", "2"}} {
+		if _, err := tsAddHandler([]string{"raw", sample[0], sample[1]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The bucket at 200 is still open.
+	if out, _ := tsRangeHandler([]string{"avg", "-", "+"}); out != "0 2,100 7" {
+		t.Errorf("expected avg buckets 0 2,100 7, got %q", out)
+	}
+	if out, _ := tsRangeHandler([]string{"max", "-", "+"}); out != "0 3,100 9" {
+		t.Errorf("expected max buckets 0 3,100 9, got %q", out)
+	}
+	// A late sample rewrites its closed bucket.
+	_, _ = tsAddHandler([]string{"raw", "90", "8"})
+	if out, _ := tsRangeHandler([]string{"avg", "-", "+"}); out != "0 4,100 7" {
+		t.Errorf("expected the late sample to update bucket 0, got %q", out)
+	}
+	if out, _ := tsInfoHandler([]string{"raw"}); !strings.HasSuffix(out, ",source,,rules,avg avg 100 max max 100") {
+		t.Errorf("unexpected TS.INFO raw: %s", out)
+	}
+	if out, _ := tsInfoHandler([]string{"avg"}); !strings.Contains(out, ",source,raw,") {
+		t.Errorf("unexpected TS.INFO avg: %s", out)
+	}
+
+	_, _ = tsCreateHandler([]string{"other"})
+	for _, bad := range [][]string{
+		{"raw", "raw", "AGGREGATION", "avg", "10"},
+		{"other", "avg", "AGGREGATION", "avg", "10"}, // avg already has a source
+		{"avg", "other", "AGGREGATION", "avg", "10"}, // avg is a destination
+		{"other", "raw", "AGGREGATION", "avg", "10"}, // raw has rules
+		{"raw", "missing", "AGGREGATION", "avg", "10"},
+		{"raw", "other", "AGGREGATION", "avg"},
+	} {
+		if _, err := tsCreateRuleHandler(bad); err == nil {
+			t.Errorf("expected TS.CREATERULE %v to fail", bad)
+		}
+	}
+
+	if out, err := tsDeleteRuleHandler([]string{"raw", "avg"}); err != nil || out != "OK" {
+		t.Fatalf("TS.DELETERULE: %q (%v)", out, err)
+	}
+	if _, err := tsDeleteRuleHandler([]string{"raw", "avg"}); err == nil {
+		t.Error("expected deleting a missing rule to fail")
+	}
+	_, _ = tsAddHandler([]string{"raw", "330", "1"})
+	if out, _ := tsRangeHandler([]string{"avg", "-", "+"}); out != "0 4,100 7" {
+		t.Errorf("expected avg to stop receiving buckets, got %q", out)
+	}
+	if out, _ := tsRangeHandler([]string{"max", "-", "+"}); out != "0 8,100 9,200 2" {
+		t.Errorf("expected max to keep receiving buckets, got %q", out)
+	}
+	// Once free of its source, avg may take a new one.
+	if _, err := tsCreateRuleHandler([]string{"other", "avg", "AGGREGATION", "sum", "10"}); err != nil {
+		t.Errorf("expected a new rule into avg to succeed, got %v", err)
+	}
+}
+
+func TestTimeSeriesMrange(t *testing.T) {
+	DefaultStore = NewStore()
+	for _, cmd := range [][]string{
+		{"cpu:1", "LABELS", "metric", "cpu", "host", "a"},
+		{"cpu:2", "LABELS", "metric", "cpu", "host", "b", "env", "test"},
+		{"mem:1", "LABELS", "metric", "mem", "host", "a"},
+		{"bare"},
+	} {
+		_, _ = tsCreateHandler(cmd)
+	}
+	_, _ = tsMaddHandler([]string{"cpu:1", "10", "1", "cpu:1", "20", "3", "cpu:2", "10", "5", "mem:1", "10", "7"})
+	_, _ = setHandler([]string{"str", "x"})
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-", "+", "FILTER", "metric=cpu"}, "cpu:1,10 1,20 3,cpu:2,10 5"},
+		{[]string{"-", "+", "FILTER", "metric=cpu", "env="}, "cpu:1,10 1,20 3"},
+		{[]string{"-", "+", "FILTER", "metric=cpu", "env!="}, "cpu:2,10 5"},
+		{[]string{"-", "+", "FILTER", "host=a", "metric!=cpu"}, "mem:1,10 7"},
+		{[]string{"-", "+", "FILTER", "metric=(cpu,mem)", "host=a"}, "cpu:1,10 1,20 3,mem:1,10 7"},
+		{[]string{"-", "+", "AGGREGATION", "sum", "100", "FILTER", "host=a"}, "cpu:1,0 4,mem:1,0 7"},
+		{[]string{"15", "+", "FILTER", "metric=cpu"}, "cpu:1,20 3,cpu:2"},
+		{[]string{"-", "+", "FILTER", "metric=disk"}, ""},
+	} {
+		if out, err := tsMrangeHandler(tc.args); err != nil || out != tc.want {
+			t.Errorf("TS.MRANGE %v: expected %q, got %q (%v)", tc.args, tc.want, out, err)
+		}
+	}
+	for _, bad := range [][]string{
+		{"-", "+"},
+		{"-", "+", "FILTER", "env="},
+		{"-", "+", "FILTER", "metric!=cpu"},
+		{"-", "+", "FILTER", "metric"},
+	} {
+		if _, err := tsMrangeHandler(bad); err == nil {
+			t.Errorf("expected TS.MRANGE %v to fail", bad)
+		}
+	}
+}
+
+func TestTimeSeriesPersistence(t *testing.T) {
+	DefaultStore = NewStore()
+	logged := captureLog(t)
+	for _, cmd := range [][]string{
+		{"TS.CREATE", "raw", "LABELS", "k", "v"},
+		{"TS.CREATE", "down"},
+		{"TS.CREATE", "other", "RETENTION", "60000"},
+		{"TS.CREATERULE", "raw", "down", "AGGREGATION", "count", "1"},
+		{"TS.ADD", "raw", "*", "1"},
+		{"TS.MADD", "other", "*", "2"},
+	} {
+		if _, err := Exec(cmd[0], cmd[1:]); err != nil {
+			t.Fatalf("%v: %v", cmd, err)
+		}
+	}
+	// A failing sample leaves the ones before it in place, and in the log.
+	if _, err := Exec("TS.MADD", []string{"raw", "1", "5", "raw", "1", "6"}); err == nil {
+		t.Fatal("expected a duplicate sample to fail")
+	}
+	want, _ := tsRangeHandler([]string{"raw", "-", "+"})
+	wantDown, _ := tsRangeHandler([]string{"down", "-", "+"})
+	if strings.Count(want, ",") != 1 || wantDown != "1 1" {
+		t.Fatalf("expected 2 samples and 1 bucket, got %q and %q", want, wantDown)
+	}
+	for _, cmd := range *logged {
+		for _, arg := range cmd {
+			if arg == "*" {
+				t.Fatalf("expected * to be logged as a timestamp, got %v", cmd)
+			}
+		}
+	}
+	replay(t, *logged)
+	if out, _ := tsRangeHandler([]string{"raw", "-", "+"}); out != want {
+		t.Errorf("expected replay to give %q, got %q", want, out)
+	}
+	if out, _ := tsRangeHandler([]string{"down", "-", "+"}); out != wantDown {
+		t.Errorf("expected replay to give %q, got %q", wantDown, out)
+	}
+	if out, _ := tsGetHandler([]string{"other"}); !strings.HasSuffix(out, " 2") {
+		t.Errorf("expected replay to restore TS.MADD, got %q", out)
+	}
+
+	if err := SaveSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(TEST_FILE)
+	DefaultStore = NewStore()
+	if err := LoadSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := tsRangeHandler([]string{"raw", "-", "+"}); out != want {
+		t.Errorf("expected %q after reload, got %q", want, out)
+	}
+	if out, _ := tsInfoHandler([]string{"down"}); !strings.Contains(out, ",source,raw,") {
+		t.Errorf("expected the rule to survive a reload, got %s", out)
+	}
+	if _, err := copyHandler([]string{"raw", "copy"}); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := tsInfoHandler([]string{"copy"}); !strings.HasSuffix(out, ",labels,k v,source,,rules,") {
+		t.Errorf("expected a copy without rules, got %s", out)
+	}
+}
@@ -0,0 +1,319 @@
+package db
+
+import (
+	"cmp"
+	"container/heap"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TOPK.RESERVE uses these settings unless given others.
+const (
+	defaultTopKWidth = 8
+	defaultTopKDepth = 7
+	defaultTopKDecay = 0.9
+)
+
+// topkEntry is an item of a top-k list with its estimated count.
+type topkEntry struct {
+	Item  string
+	Count uint32
+
+	index int // position in the heap
+}
+
+// topkHeap is a min-heap of entries, so the least frequent is the one to
+// expel.
+type topkHeap []*topkEntry
+
+func (h topkHeap) Len() int { return len(h) }
+func (h topkHeap) Less(i, j int) bool {
+	if h[i].Count != h[j].Count {
+		return h[i].Count < h[j].Count
+	}
+	return h[i].Item > h[j].Item
+}
+func (h topkHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *topkHeap) Push(x any) {
+	e := x.(*topkEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *topkHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+// topK tracks the K most frequent items with HeavyKeeper: Depth rows of
+// Width buckets each hold a fingerprint and a count. An item bumps the count
+// of its bucket in each row if the bucket is empty or already its own, and
+// otherwise decays the other item's count with probability Decay^count,
+// taking the bucket over once that reaches zero. Large counts are therefore
+// all but permanent, while rare items wash out. The heap keeps the K items
+// with the highest counts seen.
+//
+// Decay is random, but drawn from Rand, a generator whose state is part of
+// the value, so replaying the same adds gives the same list. Its fields are
+// exported so snapshots can store it as is.
+type topK struct {
+	K, Width, Depth int64
+	Decay           float64
+	Fingerprints    []uint32
+	Counts          []uint32
+	Entries         topkHeap
+	Rand            uint64
+
+	items map[string]*topkEntry
+}
+
+func newTopK(k, width, depth int64, decay float64) *topK {
+	t := &topK{
+		K: k, Width: width, Depth: depth, Decay: decay,
+		Fingerprints: make([]uint32, width*depth),
+		Counts:       make([]uint32, width*depth),
+		Rand:         1,
+	}
+	return restoreTopK(t)
+}
+
+// restoreTopK rebuilds what snapshots leave out of a top-k list.
+func restoreTopK(t *topK) *topK {
+	t.items = make(map[string]*topkEntry, len(t.Entries))
+	for i, e := range t.Entries {
+		e.index = i
+		t.items[e.Item] = e
+	}
+	heap.Init(&t.Entries)
+	return t
+}
+
+// random returns a number in [0, 1) from the splitmix64 sequence in Rand.
+func (t *topK) random() float64 {
+	return splitmix(&t.Rand)
+}
+
+// splitmix advances the splitmix64 generator with the given state and
+// returns a number in [0, 1). Values that make random choices keep the state
+// themselves, so replaying the log makes the same choices.
+func splitmix(state *uint64) float64 {
+	*state += 0x9e3779b97f4a7c15
+	z := *state
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	z ^= z >> 31
+	return float64(z>>11) / (1 << 53)
+}
+
+// add counts item once, replying with the item it pushed out of the list,
+// if any.
+func (t *topK) add(item string) (string, bool) {
+	fp := uint32(murmurHash64A(item, 0x70b))
+	var count uint32
+	sketchColumns(item, t.Width, t.Depth, func(row, col int64) {
+		i := row*t.Width + col
+		switch {
+		case t.Counts[i] == 0:
+			t.Fingerprints[i], t.Counts[i] = fp, 1
+		case t.Fingerprints[i] == fp:
+			if t.Counts[i] < math.MaxUint32 {
+				t.Counts[i]++
+			}
+		case t.random() < math.Pow(t.Decay, float64(t.Counts[i])):
+			if t.Counts[i]--; t.Counts[i] == 0 {
+				t.Fingerprints[i], t.Counts[i] = fp, 1
+			}
+		}
+		if t.Fingerprints[i] == fp {
+			count = max(count, t.Counts[i])
+		}
+	})
+	if e, ok := t.items[item]; ok {
+		e.Count = max(e.Count, count)
+		heap.Fix(&t.Entries, e.index)
+		return "", false
+	}
+	if int64(len(t.Entries)) < t.K {
+		e := &topkEntry{Item: item, Count: count}
+		heap.Push(&t.Entries, e)
+		t.items[item] = e
+		return "", false
+	}
+	least := t.Entries[0]
+	if count <= least.Count {
+		return "", false
+	}
+	delete(t.items, least.Item)
+	expelled := least.Item
+	least.Item, least.Count = item, count
+	t.items[item] = least
+	heap.Fix(&t.Entries, 0)
+	return expelled, true
+}
+
+// list returns the entries, most frequent first.
+func (t *topK) list() []*topkEntry {
+	out := slices.Clone(t.Entries)
+	slices.SortFunc(out, func(a, b *topkEntry) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Item, b.Item)
+	})
+	return out
+}
+
+func copyTopK(t *topK) *topK {
+	cp := *t
+	cp.Fingerprints = slices.Clone(t.Fingerprints)
+	cp.Counts = slices.Clone(t.Counts)
+	cp.Entries = make(topkHeap, len(t.Entries))
+	for i, e := range t.Entries {
+		c := *e
+		cp.Entries[i] = &c
+	}
+	return restoreTopK(&cp)
+}
+
+// getTopK returns the top-k list at key. The caller must hold the write lock.
+func (s *Store) getTopK(key string) (*topK, error) {
+	exists, err := s.checkType(key, TopKType)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("key does not exist")
+	}
+	return s.data[key].(*topK), nil
+}
+
+// topkReserveHandler implements TOPK.RESERVE key topk [width depth decay].
+func topkReserveHandler(args []string) (string, error) {
+	if len(args) != 2 && len(args) != 5 {
+		return "", fmt.Errorf("wrong number of arguments for TOPK.RESERVE")
+	}
+	k, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || k < 1 || k > 100000 {
+		return "", fmt.Errorf("topk must be between 1 and 100000")
+	}
+	width, depth, decay := int64(defaultTopKWidth), int64(defaultTopKDepth), defaultTopKDecay
+	if len(args) == 5 {
+		var err1, err2, err3 error
+		width, err1 = strconv.ParseInt(args[2], 10, 64)
+		depth, err2 = strconv.ParseInt(args[3], 10, 64)
+		decay, err3 = strconv.ParseFloat(args[4], 64)
+		if err1 != nil || err2 != nil || width < 1 || depth < 1 {
+			return "", fmt.Errorf("width and depth must be positive integers")
+		}
+		if err3 != nil || !(decay > 0 && decay <= 1) {
+			return "", fmt.Errorf("decay must be above 0 and at most 1")
+		}
+		if width > maxSketchCounters/depth {
+			return "", fmt.Errorf("sketch is too large")
+		}
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireIfNeeded(args[0])
+	if _, exists := s.data[args[0]]; exists {
+		return "", fmt.Errorf("key already exists")
+	}
+	s.setValue(args[0], TopKType, newTopK(k, width, depth, decay))
+	s.notify(notifyModule, "topk.reserve", args[0])
+	return "OK", nil
+}
+
+// topkAddHandler implements TOPK.ADD key item [item ...], replying with the
+// item each one pushed out of the list, or nil.
+func topkAddHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for TOPK.ADD")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.getTopK(args[0])
+	if err != nil {
+		return "", err
+	}
+	out := make([]string, len(args)-1)
+	for i, item := range args[1:] {
+		if expelled, ok := t.add(item); ok {
+			out[i] = expelled
+		} else {
+			out[i] = nilReply
+		}
+	}
+	s.notify(notifyModule, "topk.add", args[0])
+	return strings.Join(out, ","), nil
+}
+
+// topkQueryHandler implements TOPK.QUERY key item [item ...], replying with
+// 1 for each item in the list.
+func topkQueryHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for TOPK.QUERY")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.getTopK(args[0])
+	if err != nil {
+		return "", err
+	}
+	out := make([]string, len(args)-1)
+	for i, item := range args[1:] {
+		out[i] = "0"
+		if _, ok := t.items[item]; ok {
+			out[i] = "1"
+		}
+	}
+	return strings.Join(out, ","), nil
+}
+
+// topkListHandler implements TOPK.LIST key [WITHCOUNT], most frequent first.
+func topkListHandler(args []string) (string, error) {
+	if len(args) != 1 && (len(args) != 2 || strings.ToUpper(args[1]) != "WITHCOUNT") {
+		return "", fmt.Errorf("syntax error")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.getTopK(args[0])
+	if err != nil {
+		return "", err
+	}
+	var out []string
+	for _, e := range t.list() {
+		out = append(out, e.Item)
+		if len(args) == 2 {
+			out = append(out, strconv.FormatUint(uint64(e.Count), 10))
+		}
+	}
+	return strings.Join(out, ","), nil
+}
+
+// topkInfoHandler implements TOPK.INFO key, replying with name,value pairs.
+func topkInfoHandler(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("wrong number of arguments for TOPK.INFO")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.getTopK(args[0])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("k,%d,width,%d,depth,%d,decay,%s", t.K, t.Width, t.Depth,
+		strconv.FormatFloat(t.Decay, 'f', -1, 64)), nil
+}
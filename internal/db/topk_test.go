@@ -0,0 +1,110 @@
+package db
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTopKHeavyHitters(t *testing.T) {
+	DefaultStore = NewStore()
+	if out, err := topkReserveHandler([]string{"hot", "3", "50", "4", "0.9"}); err != nil || out != "OK" {
+		t.Fatalf("expected OK, got %s (%v)", out, err)
+	}
+	// Three keys take most of the traffic, among many rare ones.
+	var stream []string
+	for i := range 3000 {
+		switch {
+		case i%3 == 0:
+			stream = append(stream, "key:a")
+		case i%5 == 0:
+			stream = append(stream, "key:b")
+		case i%7 == 0:
+			stream = append(stream, "key:c")
+		default:
+			stream = append(stream, "rare:"+strconv.Itoa(i))
+		}
+	}
+	for i := 0; i < len(stream); i += 100 {
+		if _, err := topkAddHandler(append([]string{"hot"}, stream[i:i+100]...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out, _ := topkListHandler([]string{"hot"}); out != "key:a,key:b,key:c" {
+		t.Errorf("expected key:a,key:b,key:c, got %s", out)
+	}
+	out, _ := topkListHandler([]string{"hot", "WITHCOUNT"})
+	fields := strings.Split(out, ",")
+	if len(fields) != 6 || fields[0] != "key:a" {
+		t.Fatalf("unexpected list %s", out)
+	}
+	if n, _ := strconv.Atoi(fields[1]); n < 900 || n > 1000 {
+		t.Errorf("expected key:a to be counted about 1000 times, got %d", n)
+	}
+	if out, _ := topkQueryHandler([]string{"hot", "key:b", "rare:1"}); out != "1,0" {
+		t.Errorf("expected 1,0, got %s", out)
+	}
+	if out, _ := topkInfoHandler([]string{"hot"}); out != "k,3,width,50,depth,4,decay,0.9" {
+		t.Errorf("unexpected info %s", out)
+	}
+}
+
+func TestTopKExpels(t *testing.T) {
+	DefaultStore = NewStore()
+	_, _ = topkReserveHandler([]string{"top", "1"})
+	if out, _ := topkAddHandler([]string{"top", "a", "b", "b", "b"}); out != ",,a," {
+		t.Errorf("expected the second b to push a out, got %q", out)
+	}
+	if out, _ := topkListHandler([]string{"top"}); out != "b" {
+		t.Errorf("expected b, got %s", out)
+	}
+	for _, bad := range [][]string{
+		{"TOPK.RESERVE", "top", "1"}, {"TOPK.RESERVE", "x", "0"}, {"TOPK.RESERVE", "x", "1", "8", "7"},
+		{"TOPK.RESERVE", "x", "1", "8", "7", "2"}, {"TOPK.ADD", "missing", "a"}, {"TOPK.LIST", "top", "BOGUS"},
+	} {
+		if _, err := Commands[bad[0]](bad[1:]); err == nil {
+			t.Errorf("expected %v to fail", bad)
+		}
+	}
+}
+
+func TestTopKPersistence(t *testing.T) {
+	DefaultStore = NewStore()
+	logged := captureLog(t)
+	_, _ = Exec("TOPK.RESERVE", []string{"hot", "2", "4", "2", "0.5"})
+	for i := range 200 {
+		_, _ = Exec("TOPK.ADD", []string{"hot", "item:" + strconv.Itoa(i%7*i%5)})
+	}
+	want, _ := topkListHandler([]string{"hot", "WITHCOUNT"})
+	before := DefaultStore.data["hot"].(*topK)
+	// Decay draws from state kept in the value, so replay ends up the same.
+	replay(t, *logged)
+	if out, _ := topkListHandler([]string{"hot", "WITHCOUNT"}); out != want {
+		t.Errorf("expected %s after replay, got %s", want, out)
+	}
+	if after := DefaultStore.data["hot"].(*topK); !reflect.DeepEqual(after.Counts, before.Counts) {
+		t.Error("expected replay to rebuild the same buckets")
+	}
+
+	if err := SaveSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(TEST_FILE)
+	DefaultStore = NewStore()
+	if err := LoadSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := topkListHandler([]string{"hot", "WITHCOUNT"}); out != want {
+		t.Errorf("expected %s after reload, got %s", want, out)
+	}
+	// The reloaded heap and index keep working.
+	_, _ = topkAddHandler([]string{"hot", "item:0", "item:0"})
+	if out, _ := topkQueryHandler([]string{"hot", "item:0"}); out != "1" {
+		t.Errorf("expected item:0 in the list, got %s", out)
+	}
+	if typ, _ := typeHandler([]string{"hot"}); typ != "topk" {
+		t.Errorf("expected TYPE topk, got %s", typ)
+	}
+}
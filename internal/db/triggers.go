@@ -0,0 +1,173 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"furr/internal/glob"
+	"furr/internal/protocol"
+)
+
+// maxTriggerDepth caps how many rounds of triggers setting off further
+// triggers run after one command.
+const maxTriggerDepth = 8
+
+// trigger runs a registered script after changes to keys matching Pattern.
+// Its fields are exported so snapshots can store it as is.
+type trigger struct {
+	Name    string
+	Pattern string
+	Hash    string
+	Events  []string
+}
+
+// lookupCommand returns the handler for name. It is set in init, as
+// Commands refers to the store, which runs triggers from its TTL sweep.
+var lookupCommand func(name string) (HandlerFunc, bool)
+
+// triggerRun is a trigger set off by an event on a key.
+type triggerRun struct {
+	name, hash, key, event string
+}
+
+// triggerEvents maps the event names triggers take besides keyspace event
+// names to whether an event matches them.
+var triggerEvents = map[string]func(class eventClass, event string) bool{
+	"*":      func(class eventClass, event string) bool { return class != notifyNew },
+	"set":    func(class eventClass, event string) bool { return class == notifyString },
+	"del":    func(class eventClass, event string) bool { return event == "del" },
+	"expire": func(class eventClass, event string) bool { return event == "expired" },
+	"push":   func(class eventClass, event string) bool { return event == "lpush" || event == "rpush" },
+}
+
+// matches reports whether the trigger fires for event on key.
+func (t *trigger) matches(class eventClass, event, key string) bool {
+	if !glob.Match(t.Pattern, key) {
+		return false
+	}
+	for _, e := range t.Events {
+		if match, ok := triggerEvents[e]; ok {
+			if match(class, event) {
+				return true
+			}
+		} else if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// queueTriggers records the triggers event on key sets off, to run once the
+// command that caused it is done. Only top-level commands set off triggers,
+// so replaying the log, which already holds what they did, does not. The
+// caller must hold the write lock.
+func (s *Store) queueTriggers(class eventClass, event, key string) {
+	if !s.log.active || len(s.triggers) == 0 {
+		return
+	}
+	var runs []triggerRun
+	for _, t := range s.triggers {
+		if t.Name != s.log.trigger && t.matches(class, event, key) {
+			runs = append(runs, triggerRun{t.Name, t.Hash, key, event})
+		}
+	}
+	slices.SortFunc(runs, func(a, b triggerRun) int { return strings.Compare(a.name, b.name) })
+	s.log.triggers = append(s.log.triggers, runs...)
+}
+
+// runTriggers runs each trigger's script as RUNSCRIPT hash key event, logged
+// like a command of its own. Triggers those scripts set off run in turn, up
+// to maxTriggerDepth rounds deep. Failures are reported on stderr. The
+// caller must hold aofMu but not the write lock.
+func (s *Store) runTriggers(runs []triggerRun) {
+	for depth := 0; len(runs) > 0; depth++ {
+		if depth == maxTriggerDepth {
+			fmt.Fprintf(os.Stderr, "[trigger] %s: recursion limit reached, dropping %d runs\n", runs[0].name, len(runs))
+			return
+		}
+		var next []triggerRun
+		for _, r := range runs {
+			args := []string{r.hash, r.key, r.event}
+			s.mu.Lock()
+			s.beginLog(append([]string{"RUNSCRIPT"}, args...))
+			s.log.trigger = r.name
+			s.mu.Unlock()
+			var err error
+			if h, ok := lookupCommand("RUNSCRIPT"); ok {
+				_, err = h(args)
+			} else {
+				err = fmt.Errorf("scripting is not available")
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[trigger] %s on %s %s: %v\n", r.name, r.event, r.key, err)
+			}
+			s.mu.Lock()
+			next = append(next, s.endLog()...)
+			s.mu.Unlock()
+		}
+		runs = next
+	}
+}
+
+// triggerHandler implements TRIGGER ADD name pattern hash event [event ...],
+// TRIGGER LIST and TRIGGER DROP name [name ...]. Events are keyspace event
+// names or set (any string write), del, expire (a key expired), push or *.
+func triggerHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for TRIGGER")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "ADD":
+		if len(args) < 5 {
+			return "", fmt.Errorf("wrong number of arguments for TRIGGER ADD")
+		}
+		events := make([]string, len(args)-4)
+		for i, e := range args[4:] {
+			events[i] = strings.ToLower(e)
+		}
+		s.triggers[args[1]] = &trigger{Name: args[1], Pattern: args[2], Hash: args[3], Events: events}
+		s.dirty++
+		return "OK", nil
+	case "DROP":
+		if len(args) < 2 {
+			return "", fmt.Errorf("wrong number of arguments for TRIGGER DROP")
+		}
+		n := 0
+		for _, name := range args[1:] {
+			if _, ok := s.triggers[name]; ok {
+				delete(s.triggers, name)
+				n++
+			}
+		}
+		if n > 0 {
+			s.dirty++
+		}
+		return strconv.Itoa(n), nil
+	case "LIST":
+		names := make([]string, 0, len(s.triggers))
+		for name := range s.triggers {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		out := make([]string, len(names))
+		for i, name := range names {
+			t := s.triggers[name]
+			out[i] = protocol.QuoteArgs(append([]string{t.Name, t.Pattern, t.Hash}, t.Events...))
+		}
+		return strings.Join(out, ","), nil
+	}
+	return "", fmt.Errorf("unknown TRIGGER subcommand '%s'", args[0])
+}
+
+func init() {
+	lookupCommand = func(name string) (HandlerFunc, bool) {
+		h, ok := Commands[name]
+		return h, ok
+	}
+}
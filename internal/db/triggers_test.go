@@ -0,0 +1,120 @@
+package db
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeScripts stands in for the script package, which imports this one:
+// RUNSCRIPT records its arguments and runs script, if set, with them.
+func fakeScripts(t *testing.T, script func(args []string) error) *[][]string {
+	var ran [][]string
+	Commands["RUNSCRIPT"] = func(args []string) (string, error) {
+		ran = append(ran, args)
+		if script != nil {
+			return "", script(args)
+		}
+		return "", nil
+	}
+	t.Cleanup(func() { delete(Commands, "RUNSCRIPT") })
+	return &ran
+}
+
+func TestTriggersRun(t *testing.T) {
+	DefaultStore = NewStore()
+	ran := fakeScripts(t, nil)
+	for _, cmd := range [][]string{
+		{"TRIGGER", "ADD", "audit", "user:*", "h1", "set", "del", "push", "expire"},
+		{"SET", "user:1", "a"}, {"INCR", "user:2"}, {"DEL", "user:1", "user:9"},
+		{"RPUSH", "user:3", "x"}, {"SADD", "user:4", "x"}, {"SET", "other", "b"},
+		{"SET", "user:5", "v", "PX", "1"},
+	} {
+		if _, err := Exec(cmd[0], cmd[1:]); err != nil {
+			t.Fatalf("%v: %v", cmd, err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	_, _ = Exec("GET", []string{"user:5"})
+	want := [][]string{
+		{"h1", "user:1", "set"}, {"h1", "user:2", "incrby"}, {"h1", "user:1", "del"},
+		{"h1", "user:3", "rpush"}, {"h1", "user:5", "set"}, {"h1", "user:5", "expired"},
+	}
+	if !reflect.DeepEqual(*ran, want) {
+		t.Errorf("expected runs %v, got %v", want, *ran)
+	}
+	// Handlers called directly, as when replaying the log, set off nothing.
+	*ran = nil
+	_, _ = setHandler([]string{"user:1", "a"})
+	if len(*ran) != 0 {
+		t.Errorf("expected no runs outside Exec, got %v", *ran)
+	}
+}
+
+func TestTriggerRecursionGuards(t *testing.T) {
+	DefaultStore = NewStore()
+	ran := fakeScripts(t, func(args []string) error {
+		_, err := setHandler([]string{map[string]string{"self": "self", "ping": "pong", "pong": "ping"}[args[1]], "x"})
+		return err
+	})
+	_, _ = Exec("TRIGGER", []string{"ADD", "self", "self", "h", "set"})
+	_, _ = Exec("SET", []string{"self", "1"})
+	if len(*ran) != 1 {
+		t.Errorf("expected a trigger not to set itself off, got %d runs", len(*ran))
+	}
+	*ran = nil
+	_, _ = Exec("TRIGGER", []string{"ADD", "ping", "ping", "h", "set"})
+	_, _ = Exec("TRIGGER", []string{"ADD", "pong", "pong", "h", "set"})
+	_, _ = Exec("SET", []string{"ping", "1"})
+	if len(*ran) != maxTriggerDepth {
+		t.Errorf("expected triggers setting each other off to stop after %d runs, got %d", maxTriggerDepth, len(*ran))
+	}
+}
+
+func TestTriggerPersistence(t *testing.T) {
+	DefaultStore = NewStore()
+	fakeScripts(t, func(args []string) error {
+		_, err := incrHandler([]string{"count"})
+		return err
+	})
+	logged := captureLog(t)
+	for _, cmd := range [][]string{
+		{"TRIGGER", "ADD", "counter", "*", "h", "push"},
+		{"TRIGGER", "ADD", "gone", "*", "h", "set"},
+		{"TRIGGER", "DROP", "gone", "missing"},
+		{"LPUSH", "l", "a"},
+	} {
+		_, _ = Exec(cmd[0], cmd[1:])
+	}
+	want := [][]string{
+		{"TRIGGER", "ADD", "counter", "*", "h", "push"},
+		{"TRIGGER", "ADD", "gone", "*", "h", "set"},
+		{"TRIGGER", "DROP", "gone", "missing"},
+		{"LPUSH", "l", "a"},
+		{"RUNSCRIPT", "h", "l", "lpush"},
+	}
+	if !reflect.DeepEqual(*logged, want) {
+		t.Errorf("expected %v, got %v", want, *logged)
+	}
+	replay(t, *logged)
+	if out, _ := getHandler([]string{"count"}); out != "1" {
+		t.Errorf("expected the trigger's run to replay once, got %s", out)
+	}
+	list, _ := triggerHandler([]string{"LIST"})
+	if list != "counter * h push" {
+		t.Errorf("unexpected triggers %s", list)
+	}
+
+	if err := SaveSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(TEST_FILE)
+	DefaultStore = NewStore()
+	if err := LoadSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := triggerHandler([]string{"LIST"}); out != list {
+		t.Errorf("expected reloaded triggers %s, got %s", list, out)
+	}
+}
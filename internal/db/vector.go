@@ -0,0 +1,467 @@
+package db
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"furr/internal/protocol"
+)
+
+// VEC.CREATE uses these settings unless given others.
+const (
+	defaultVectorM              = 16
+	defaultVectorEFConstruction = 200
+	defaultVectorEFRuntime      = 10
+	maxVectorDim                = 32768
+)
+
+// vectorValue is a vector with tags to filter searches by. Its fields are
+// exported so snapshots can store it as is.
+type vectorValue struct {
+	Values []float32
+	Tags   map[string]string
+}
+
+func copyVector(v *vectorValue) *vectorValue {
+	return &vectorValue{Values: slices.Clone(v.Values), Tags: maps.Clone(v.Tags)}
+}
+
+// vectorDistance measures how far apart two vectors are; smaller is nearer.
+type vectorDistance func(a, b []float32) float32
+
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// vectorMetrics map metric names to distances. Cosine and inner product
+// give 1 minus the similarity, cosine on vectors normalized when indexed.
+// L2 gives the squared distance, which sorts the same; replies take its
+// square root.
+var vectorMetrics = map[string]vectorDistance{
+	"cosine": func(a, b []float32) float32 { return 1 - dot(a, b) },
+	"ip":     func(a, b []float32) float32 { return 1 - dot(a, b) },
+	"l2": func(a, b []float32) float32 {
+		var sum float32
+		for i := range a {
+			d := a[i] - b[i]
+			sum += d * d
+		}
+		return sum
+	},
+}
+
+// vectorMatch is a search result.
+type vectorMatch struct {
+	key  string
+	dist float32
+}
+
+// vectorSearcher is what an index searches with.
+type vectorSearcher interface {
+	// add indexes vec under key, replacing any vector it had.
+	add(key string, vec []float32)
+	remove(key string)
+	// search returns up to k keys nearest q that keep accepts, nearest
+	// first. Approximate searchers look at ef candidates at least.
+	search(q []float32, k, ef int, keep func(key string) bool) []vectorMatch
+	len() int
+}
+
+// flatIndex searches by measuring the distance to every vector, so it is
+// exact.
+type flatIndex struct {
+	dist vectorDistance
+	vecs map[string][]float32
+}
+
+func (f *flatIndex) add(key string, vec []float32) { f.vecs[key] = vec }
+func (f *flatIndex) remove(key string)             { delete(f.vecs, key) }
+func (f *flatIndex) len() int                      { return len(f.vecs) }
+
+func (f *flatIndex) search(q []float32, k, ef int, keep func(key string) bool) []vectorMatch {
+	var out []vectorMatch
+	for key, vec := range f.vecs {
+		if keep(key) {
+			out = append(out, vectorMatch{key, f.dist(q, vec)})
+		}
+	}
+	slices.SortFunc(out, func(a, b vectorMatch) int {
+		if c := cmp.Compare(a.dist, b.dist); c != 0 {
+			return c
+		}
+		return strings.Compare(a.key, b.key)
+	})
+	return out[:min(k, len(out))]
+}
+
+// vectorIndex indexes the vectors of Dim dimensions at keys starting with
+// Prefix. Its exported fields are its definition, which snapshots store; the
+// index itself is rebuilt from the keyspace.
+type vectorIndex struct {
+	Name, Prefix      string
+	Dim               int
+	Metric, Algorithm string
+	M                 int
+	EFConstruction    int
+	EFRuntime         int
+
+	searcher vectorSearcher
+}
+
+// build makes an empty searcher for the definition.
+func (x *vectorIndex) build() {
+	dist := vectorMetrics[x.Metric]
+	if x.Algorithm == "hnsw" {
+		x.searcher = newHNSW(dist, x.M, x.EFConstruction)
+	} else {
+		x.searcher = &flatIndex{dist: dist, vecs: make(map[string][]float32)}
+	}
+}
+
+// prepare returns the form of vec the index stores and searches with.
+func (x *vectorIndex) prepare(vec []float32) []float32 {
+	if x.Metric != "cosine" {
+		return vec
+	}
+	norm := float32(math.Sqrt(float64(dot(vec, vec))))
+	out := make([]float32, len(vec))
+	if norm > 0 {
+		for i, f := range vec {
+			out[i] = f / norm
+		}
+	}
+	return out
+}
+
+// update indexes or unindexes key after it changed to v. The caller must
+// hold the write lock.
+func (x *vectorIndex) update(key string, v any) {
+	if !strings.HasPrefix(key, x.Prefix) {
+		return
+	}
+	if vec, ok := v.(*vectorValue); ok && len(vec.Values) == x.Dim {
+		x.searcher.add(key, x.prepare(vec.Values))
+	} else {
+		x.searcher.remove(key)
+	}
+}
+
+// indexVectors updates the indexes covering key after it changed to v, or
+// was deleted if v is nil. The caller must hold the write lock.
+func (s *Store) indexVectors(key string, v any) {
+	for _, x := range s.vectorIndexes {
+		x.update(key, v)
+	}
+}
+
+// fill indexes the keys the index covers, in key order so HNSW graphs come
+// out the same each time. The caller must hold the write lock.
+func (s *Store) fill(x *vectorIndex) {
+	x.build()
+	var keys []string
+	for key, t := range s.types {
+		if t == VectorType && strings.HasPrefix(key, x.Prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		x.update(key, s.data[key])
+	}
+}
+
+// restoreVectorIndexes replaces the index definitions with ones loaded from
+// a snapshot. resetKeyspace fills them. The caller must hold the write lock.
+func (s *Store) restoreVectorIndexes(indexes map[string]*vectorIndex) {
+	if s.vectorIndexes = indexes; indexes == nil {
+		s.vectorIndexes = make(map[string]*vectorIndex)
+	}
+}
+
+// getVector returns the vector at key. The caller must hold the write lock.
+func (s *Store) getVector(key string) (*vectorValue, bool, error) {
+	exists, err := s.checkType(key, VectorType)
+	if !exists {
+		return nil, false, err
+	}
+	return s.data[key].(*vectorValue), true, nil
+}
+
+// parseVector parses VALUES n x1 .. xn at the start of args, returning the
+// vector and the arguments after it.
+func parseVector(args []string) ([]float32, []string, error) {
+	if len(args) < 2 || strings.ToUpper(args[0]) != "VALUES" {
+		return nil, nil, fmt.Errorf("expected VALUES n x1 .. xn")
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 1 || n > maxVectorDim {
+		return nil, nil, fmt.Errorf("vector dimension must be between 1 and %d", maxVectorDim)
+	}
+	if len(args)-2 < n {
+		return nil, nil, fmt.Errorf("expected %d vector values", n)
+	}
+	vec := make([]float32, n)
+	for i, arg := range args[2 : 2+n] {
+		f, err := strconv.ParseFloat(arg, 32)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, nil, fmt.Errorf("invalid vector value %q", arg)
+		}
+		vec[i] = float32(f)
+	}
+	return vec, args[2+n:], nil
+}
+
+func formatFloat32(f float32) string {
+	return strconv.FormatFloat(float64(f), 'f', -1, 32)
+}
+
+// vecCreateHandler implements VEC.CREATE index PREFIX prefix DIM n [METRIC
+// COSINE|L2|IP] [ALGORITHM FLAT|HNSW] [M m] [EF_CONSTRUCTION n] [EF_RUNTIME
+// n], indexing the vectors of n dimensions at keys starting with prefix.
+func vecCreateHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for VEC.CREATE")
+	}
+	x := &vectorIndex{
+		Name: args[0], Metric: "cosine", Algorithm: "flat",
+		M: defaultVectorM, EFConstruction: defaultVectorEFConstruction, EFRuntime: defaultVectorEFRuntime,
+	}
+	hasPrefix := false
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return "", fmt.Errorf("syntax error")
+		}
+		arg := args[i+1]
+		var n int
+		var err error
+		switch kw := strings.ToUpper(args[i]); kw {
+		case "PREFIX":
+			x.Prefix, hasPrefix = arg, true
+		case "METRIC":
+			if x.Metric = strings.ToLower(arg); vectorMetrics[x.Metric] == nil {
+				return "", fmt.Errorf("metric must be COSINE, L2 or IP")
+			}
+		case "ALGORITHM":
+			if x.Algorithm = strings.ToLower(arg); x.Algorithm != "flat" && x.Algorithm != "hnsw" {
+				return "", fmt.Errorf("algorithm must be FLAT or HNSW")
+			}
+		case "DIM", "M", "EF_CONSTRUCTION", "EF_RUNTIME":
+			if n, err = strconv.Atoi(arg); err != nil || n < 1 || kw == "M" && n < 2 || n > maxVectorDim {
+				return "", fmt.Errorf("invalid %s", kw)
+			}
+			switch kw {
+			case "DIM":
+				x.Dim = n
+			case "M":
+				x.M = n
+			case "EF_CONSTRUCTION":
+				x.EFConstruction = n
+			case "EF_RUNTIME":
+				x.EFRuntime = n
+			}
+		default:
+			return "", fmt.Errorf("syntax error")
+		}
+	}
+	if !hasPrefix || x.Dim == 0 {
+		return "", fmt.Errorf("PREFIX and DIM are required")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.vectorIndexes[x.Name]; exists {
+		return "", fmt.Errorf("index already exists")
+	}
+	s.fill(x)
+	s.vectorIndexes[x.Name] = x
+	s.dirty++
+	return "OK", nil
+}
+
+// vecDropHandler implements VEC.DROP index. The vectors stay.
+func vecDropHandler(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("wrong number of arguments for VEC.DROP")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.vectorIndexes[args[0]]; !exists {
+		return "", fmt.Errorf("no such index")
+	}
+	delete(s.vectorIndexes, args[0])
+	s.dirty++
+	return "OK", nil
+}
+
+// vecListHandler implements VEC.LIST, replying with the index names.
+func vecListHandler(args []string) (string, error) {
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strings.Join(slices.Sorted(maps.Keys(s.vectorIndexes)), ","), nil
+}
+
+// vecInfoHandler implements VEC.INFO index, replying with name,value pairs.
+func vecInfoHandler(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("wrong number of arguments for VEC.INFO")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	x, exists := s.vectorIndexes[args[0]]
+	if !exists {
+		return "", fmt.Errorf("no such index")
+	}
+	return fmt.Sprintf("prefix,%s,dim,%d,metric,%s,algorithm,%s,m,%d,ef_construction,%d,ef_runtime,%d,vectors,%d",
+		x.Prefix, x.Dim, x.Metric, x.Algorithm, x.M, x.EFConstruction, x.EFRuntime, x.searcher.len()), nil
+}
+
+// vecSetHandler implements VEC.SET key VALUES n x1 .. xn [TAGS tag value
+// ...], replacing the vector at key and its tags.
+func vecSetHandler(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("missing argument for VEC.SET")
+	}
+	vec, rest, err := parseVector(args[1:])
+	if err != nil {
+		return "", err
+	}
+	v := &vectorValue{Values: vec}
+	if len(rest) > 0 {
+		if strings.ToUpper(rest[0]) != "TAGS" || len(rest) < 3 || len(rest)%2 != 1 {
+			return "", fmt.Errorf("syntax error")
+		}
+		v.Tags = make(map[string]string, len(rest)/2)
+		for i := 1; i < len(rest); i += 2 {
+			v.Tags[rest[i]] = rest[i+1]
+		}
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, _, err := s.getVector(args[0]); err != nil {
+		return "", err
+	}
+	s.setValue(args[0], VectorType, v)
+	s.notify(notifyModule, "vec.set", args[0])
+	return "OK", nil
+}
+
+// vecGetHandler implements VEC.GET key, replying with the vector's values.
+func vecGetHandler(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("wrong number of arguments for VEC.GET")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, exists, err := s.getVector(args[0])
+	if !exists {
+		return nilReply, err
+	}
+	out := make([]string, len(v.Values))
+	for i, f := range v.Values {
+		out[i] = formatFloat32(f)
+	}
+	return strings.Join(out, ","), nil
+}
+
+// vecTagsHandler implements VEC.TAGS key, replying with tag,value pairs.
+func vecTagsHandler(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("wrong number of arguments for VEC.TAGS")
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, exists, err := s.getVector(args[0])
+	if !exists {
+		return nilReply, err
+	}
+	var out []string
+	for _, tag := range slices.Sorted(maps.Keys(v.Tags)) {
+		out = append(out, tag, v.Tags[tag])
+	}
+	return strings.Join(out, ","), nil
+}
+
+// vecSearchHandler implements VEC.SEARCH index k VALUES n x1 .. xn [EF n]
+// [FILTER filter ...], replying with the k nearest keys matching all the
+// filters, nearest first, each followed by its distance.
+func vecSearchHandler(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("missing argument for VEC.SEARCH")
+	}
+	k, err := strconv.Atoi(args[1])
+	if err != nil || k < 1 {
+		return "", fmt.Errorf("k must be a positive integer")
+	}
+	q, rest, err := parseVector(args[2:])
+	if err != nil {
+		return "", err
+	}
+	ef := 0
+	var filters []labelFilter
+	for i := 0; i < len(rest); i++ {
+		switch kw := strings.ToUpper(rest[i]); {
+		case kw == "EF" && i+1 < len(rest):
+			if ef, err = strconv.Atoi(rest[i+1]); err != nil || ef < 1 {
+				return "", fmt.Errorf("invalid EF")
+			}
+			i++
+		case kw == "FILTER" && i+1 < len(rest):
+			for _, expr := range rest[i+1:] {
+				f, err := parseLabelFilter(expr)
+				if err != nil {
+					return "", err
+				}
+				filters = append(filters, f)
+			}
+			i = len(rest)
+		default:
+			return "", fmt.Errorf("syntax error")
+		}
+	}
+	s := DefaultStore
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	x, exists := s.vectorIndexes[args[0]]
+	if !exists {
+		return "", fmt.Errorf("no such index")
+	}
+	if len(q) != x.Dim {
+		return "", fmt.Errorf("query vector must have %d dimensions", x.Dim)
+	}
+	if ef == 0 {
+		ef = x.EFRuntime
+	}
+	// Expired keys are skipped rather than removed, which would change the
+	// index mid-search.
+	keep := func(key string) bool {
+		if isExpired(s, key) {
+			return false
+		}
+		tags := s.data[key].(*vectorValue).Tags
+		return !slices.ContainsFunc(filters, func(f labelFilter) bool { return !f.match(tags) })
+	}
+	var out []string
+	for _, m := range x.searcher.search(x.prepare(q), k, ef, keep) {
+		dist := m.dist
+		if x.Metric == "l2" {
+			dist = float32(math.Sqrt(float64(dist)))
+		}
+		out = append(out, protocol.QuoteArgs([]string{m.key, formatFloat32(dist)}))
+	}
+	return strings.Join(out, ","), nil
+}
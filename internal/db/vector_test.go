@@ -0,0 +1,252 @@
+package db
+
+import (
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestVectorSetGet(t *testing.T) {
+	DefaultStore = NewStore()
+	for _, tc := range []struct {
+		cmd  []string
+		want string
+	}{
+		{[]string{"VEC.SET", "doc:1", "VALUES", "3", "0.5", "-1", "2", "TAGS", "lang", "go", "kind", "post"}, "OK"},
+		{[]string{"VEC.GET", "doc:1"}, "0.5,-1,2"},
+		{[]string{"VEC.TAGS", "doc:1"}, "kind,post,lang,go"},
+		{[]string{"VEC.SET", "doc:1", "values", "2", "1", "1e-3"}, "OK"},
+		{[]string{"VEC.GET", "doc:1"}, "1,0.001"},
+		{[]string{"VEC.TAGS", "doc:1"}, ""},
+		{[]string{"VEC.GET", "missing"}, nilReply},
+	} {
+		if out, err := Commands[tc.cmd[0]](tc.cmd[1:]); err != nil || out != tc.want {
+			t.Errorf("%v: expected %q, got %q (%v)", tc.cmd, tc.want, out, err)
+		}
+	}
+	for _, bad := range [][]string{
+		{"k", "VALUES", "2", "1"},
+		{"k", "VALUES", "0"},
+		{"k", "VALUES", "1", "x"},
+		{"k", "VALUES", "1", "NaN"},
+		{"k", "VALUES", "1", "1e50"},
+		{"k", "1", "2"},
+		{"k", "VALUES", "1", "1", "TAGS", "a"},
+		{"k", "VALUES", "1", "1", "EXTRA"},
+	} {
+		if _, err := vecSetHandler(bad); err == nil {
+			t.Errorf("expected VEC.SET %v to fail", bad)
+		}
+	}
+	if typ, _ := typeHandler([]string{"doc:1"}); typ != "vector" {
+		t.Errorf("expected TYPE vector, got %s", typ)
+	}
+}
+
+func TestVectorSearchFlat(t *testing.T) {
+	DefaultStore = NewStore()
+	for _, cmd := range [][]string{
+		{"doc:a", "VALUES", "2", "1", "0", "TAGS", "lang", "go"},
+		{"doc:b", "VALUES", "2", "0", "2", "TAGS", "lang", "rust"},
+		{"doc:c", "VALUES", "2", "3", "3", "TAGS", "lang", "go", "draft", "yes"},
+		{"doc:d", "VALUES", "3", "1", "0", "0"}, // wrong dimension, not indexed
+		{"other:a", "VALUES", "2", "1", "0"},    // outside the prefix
+	} {
+		if _, err := vecSetHandler(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, cmd := range [][]string{
+		{"cos", "PREFIX", "doc:", "DIM", "2"},
+		{"l2", "PREFIX", "doc:", "DIM", "2", "METRIC", "L2"},
+		{"ip", "PREFIX", "doc:", "DIM", "2", "METRIC", "ip", "ALGORITHM", "FLAT"},
+	} {
+		if out, err := vecCreateHandler(cmd); err != nil || out != "OK" {
+			t.Fatalf("VEC.CREATE %v: %q (%v)", cmd, out, err)
+		}
+	}
+	query := []string{"VALUES", "2", "1", "0"}
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"cos", "3"}, "doc:a 0,doc:c 0.29289317,doc:b 1"},
+		{[]string{"l2", "2"}, "doc:a 0,doc:b 2.236068"},
+		{[]string{"ip", "3"}, "doc:c -2,doc:a 0,doc:b 1"},
+		{[]string{"cos", "10", "FILTER", "lang=go"}, "doc:a 0,doc:c 0.29289317"},
+		{[]string{"cos", "10", "FILTER", "lang=go", "draft="}, "doc:a 0"},
+		{[]string{"cos", "10", "FILTER", "lang!=go"}, "doc:b 1"},
+		{[]string{"cos", "10", "FILTER", "lang=(rust,c)"}, "doc:b 1"},
+		{[]string{"cos", "10", "FILTER", "lang=zig"}, ""},
+	} {
+		args := append(append(tc.args[:2:2], query...), tc.args[2:]...)
+		if out, err := vecSearchHandler(args); err != nil || out != tc.want {
+			t.Errorf("VEC.SEARCH %v: expected %q, got %q (%v)", args, tc.want, out, err)
+		}
+	}
+
+	// The indexes follow changes to the keys.
+	_, _ = Commands["DEL"]([]string{"doc:a"})
+	_, _ = renameHandler([]string{"other:a", "doc:e"})
+	_, _ = setHandler([]string{"doc:b", "text"})
+	_, _ = vecSetHandler([]string{"doc:c", "VALUES", "2", "0", "-1"})
+	_, _ = vecSetHandler([]string{"doc:d", "VALUES", "2", "2", "0"})
+	if out, _ := vecSearchHandler(append([]string{"l2", "10"}, query...)); out != "doc:e 0,doc:d 1,doc:c 1.4142135" {
+		t.Errorf("expected the index to follow the keyspace, got %q", out)
+	}
+	if out, _ := vecInfoHandler([]string{"l2"}); out != "prefix,doc:,dim,2,metric,l2,algorithm,flat,m,16,ef_construction,200,ef_runtime,10,vectors,3" {
+		t.Errorf("unexpected VEC.INFO: %s", out)
+	}
+	_, _ = flushdbHandler(nil)
+	_, _ = vecSetHandler([]string{"doc:z", "VALUES", "2", "1", "1"})
+	if out, _ := vecSearchHandler(append([]string{"cos", "10"}, query...)); out != "doc:z 0.29289323" {
+		t.Errorf("expected FLUSHDB to keep the index and empty it, got %q", out)
+	}
+
+	for _, bad := range [][]string{
+		{"cos", "PREFIX", "x", "DIM", "2"},
+		{"new", "PREFIX", "x"},
+		{"new", "DIM", "2"},
+		{"new", "PREFIX", "x", "DIM", "2", "METRIC", "hamming"},
+		{"new", "PREFIX", "x", "DIM", "2", "ALGORITHM", "ivf"},
+		{"new", "PREFIX", "x", "DIM", "2", "M", "1"},
+		{"new", "PREFIX", "x", "DIM"},
+	} {
+		if _, err := vecCreateHandler(bad); err == nil {
+			t.Errorf("expected VEC.CREATE %v to fail", bad)
+		}
+	}
+	for _, bad := range [][]string{
+		{"missing", "1", "VALUES", "2", "1", "0"},
+		{"cos", "0", "VALUES", "2", "1", "0"},
+		{"cos", "1", "VALUES", "3", "1", "0", "0"},
+		{"cos", "1", "VALUES", "2", "1", "0", "EF", "0"},
+		{"cos", "1", "VALUES", "2", "1", "0", "FILTER", "lang"},
+	} {
+		if _, err := vecSearchHandler(bad); err == nil {
+			t.Errorf("expected VEC.SEARCH %v to fail", bad)
+		}
+	}
+	if out, _ := vecListHandler(nil); out != "cos,ip,l2" {
+		t.Errorf("expected cos,ip,l2, got %q", out)
+	}
+	if _, err := vecDropHandler([]string{"ip"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vecDropHandler([]string{"ip"}); err == nil {
+		t.Error("expected dropping a missing index to fail")
+	}
+	if n, _ := existsHandler([]string{"doc:z"}); n != "1" {
+		t.Error("expected VEC.DROP to keep the vectors")
+	}
+}
+
+func randomVector(r *rand.Rand, dim int) []string {
+	args := []string{"VALUES", strconv.Itoa(dim)}
+	for range dim {
+		args = append(args, strconv.FormatFloat(r.NormFloat64(), 'f', 4, 32))
+	}
+	return args
+}
+
+func TestVectorHNSWMatchesFlat(t *testing.T) {
+	const n, dim, k = 2000, 16, 10
+	DefaultStore = NewStore()
+	r := rand.New(rand.NewPCG(1, 2))
+	for i := range n {
+		args := append([]string{"v:" + strconv.Itoa(i)}, randomVector(r, dim)...)
+		args = append(args, "TAGS", "parity", strconv.Itoa(i%2))
+		if _, err := vecSetHandler(args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, metric := range []string{"cosine", "l2", "ip"} {
+		for _, algo := range []string{"flat", "hnsw"} {
+			_, _ = vecCreateHandler([]string{metric + algo, "PREFIX", "v:", "DIM", strconv.Itoa(dim), "METRIC", metric, "ALGORITHM", algo})
+		}
+	}
+	// Remove a third of the vectors so searches pass through removed nodes.
+	for i := 0; i < n; i += 3 {
+		_, _ = Commands["DEL"]([]string{"v:" + strconv.Itoa(i)})
+	}
+	keys := func(reply string) map[string]bool {
+		out := make(map[string]bool)
+		for _, m := range strings.Split(reply, ",") {
+			key, _, _ := strings.Cut(m, " ")
+			out[key] = true
+		}
+		return out
+	}
+	for _, metric := range []string{"cosine", "l2", "ip"} {
+		hits, total := 0, 0
+		for range 50 {
+			q := randomVector(r, dim)
+			for _, filter := range [][]string{nil, {"FILTER", "parity=1"}} {
+				exact, _ := vecSearchHandler(append(append([]string{metric + "flat", strconv.Itoa(k)}, q...), filter...))
+				approx, err := vecSearchHandler(append(append(append([]string{metric + "hnsw", strconv.Itoa(k)}, q...), "EF", "50"), filter...))
+				if err != nil {
+					t.Fatal(err)
+				}
+				want, got := keys(exact), keys(approx)
+				if len(want) != k {
+					t.Fatalf("expected %d exact matches, got %q", k, exact)
+				}
+				for key := range got {
+					if i, _ := strconv.Atoi(strings.TrimPrefix(key, "v:")); filter != nil && i%2 != 1 {
+						t.Fatalf("%s: filtered search returned %s", metric, key)
+					}
+					if want[key] {
+						hits++
+					}
+				}
+				total += k
+			}
+		}
+		if recall := float64(hits) / float64(total); recall < 0.9 {
+			t.Errorf("%s: expected recall of at least 0.9, got %.3f", metric, recall)
+		}
+	}
+}
+
+func TestVectorPersistence(t *testing.T) {
+	DefaultStore = NewStore()
+	logged := captureLog(t)
+	for _, cmd := range [][]string{
+		{"VEC.CREATE", "idx", "PREFIX", "p:", "DIM", "2", "ALGORITHM", "HNSW", "METRIC", "L2"},
+		{"VEC.SET", "p:1", "VALUES", "2", "0", "0", "TAGS", "t", "x"},
+		{"VEC.SET", "p:2", "VALUES", "2", "3", "4"},
+	} {
+		if _, err := Exec(cmd[0], cmd[1:]); err != nil {
+			t.Fatalf("%v: %v", cmd, err)
+		}
+	}
+	want := "p:1 0,p:2 5"
+	query := []string{"idx", "5", "VALUES", "2", "0", "0"}
+	replay(t, *logged)
+	if out, _ := vecSearchHandler(query); out != want {
+		t.Errorf("expected replay to give %q, got %q", want, out)
+	}
+
+	if err := SaveSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(TEST_FILE)
+	DefaultStore = NewStore()
+	if err := LoadSnapshot(TEST_FILE); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := vecSearchHandler(query); out != want {
+		t.Errorf("expected %q after reload, got %q", want, out)
+	}
+	if out, _ := vecTagsHandler([]string{"p:1"}); out != "t,x" {
+		t.Errorf("expected tags to survive a reload, got %q", out)
+	}
+	if _, err := copyHandler([]string{"p:2", "p:3"}); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := vecSearchHandler(query); out != want+",p:3 5" {
+		t.Errorf("expected the copy to be indexed, got %q", out)
+	}
+}
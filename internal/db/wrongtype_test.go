@@ -0,0 +1,167 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+// typedCommands lists, per value type, commands that must reject keys of any
+// other type. Each call runs against the key "k".
+var typedCommands = map[valueType][][]string{
+	StringType: {
+		{"GET", "k"}, {"GETDEL", "k"}, {"GETEX", "k", "PERSIST"}, {"GETSET", "k", "v"},
+		{"SET", "k", "v", "GET"}, {"APPEND", "k", "v"}, {"STRLEN", "k"},
+		{"GETRANGE", "k", "0", "-1"}, {"SETRANGE", "k", "0", "v"}, {"LCS", "k", "k"},
+		{"INCR", "k"}, {"DECR", "k"}, {"INCRBY", "k", "2"}, {"DECRBY", "k", "2"},
+		{"INCRBYFLOAT", "k", "1.5"}, {"SETBIT", "k", "0", "1"}, {"GETBIT", "k", "0"},
+		{"BITCOUNT", "k"}, {"BITPOS", "k", "1"}, {"BITOP", "NOT", "dest", "k"},
+		{"BITFIELD", "k", "GET", "u8", "0"},
+	},
+	ListType: {
+		{"LPUSH", "k", "v"}, {"RPUSH", "k", "v"}, {"LPUSHX", "k", "v"}, {"RPUSHX", "k", "v"},
+		{"LPOP", "k"}, {"RPOP", "k"}, {"LRANGE", "k", "0", "-1"}, {"LLEN", "k"},
+		{"LINDEX", "k", "0"}, {"LSET", "k", "0", "v"}, {"LINSERT", "k", "BEFORE", "a", "v"},
+		{"LREM", "k", "0", "v"}, {"LTRIM", "k", "0", "1"}, {"LPOS", "k", "v"},
+		{"LMOVE", "k", "other", "LEFT", "LEFT"}, {"LMOVE", "src", "k", "LEFT", "LEFT"},
+		{"RPOPLPUSH", "k", "other"}, {"BLPOP", "k", "0"}, {"BRPOP", "k", "0"},
+		{"BLMOVE", "src", "k", "LEFT", "LEFT", "0"}, {"BRPOPLPUSH", "k", "other", "0"},
+	},
+	SetType: {
+		{"SADD", "k", "v"}, {"SREM", "k", "v"}, {"SMEMBERS", "k"}, {"SISMEMBER", "k", "v"},
+		{"SMISMEMBER", "k", "v"}, {"SCARD", "k"}, {"SPOP", "k"}, {"SRANDMEMBER", "k"},
+		{"SMOVE", "k", "other", "v"}, {"SMOVE", "other", "k", "v"}, {"SUNION", "k"},
+		{"SINTER", "k"}, {"SDIFF", "k"}, {"SUNIONSTORE", "dest", "k"},
+		{"SINTERSTORE", "dest", "k"}, {"SDIFFSTORE", "dest", "k"},
+		{"SINTERCARD", "1", "k"}, {"SSCAN", "k", "0"},
+	},
+	StreamType: {
+		{"XADD", "k", "*", "f", "v"}, {"XLEN", "k"}, {"XRANGE", "k", "-", "+"},
+		{"XREVRANGE", "k", "+", "-"}, {"XTRIM", "k", "MAXLEN", "0"},
+		{"XREAD", "STREAMS", "k", "0"}, {"XREADGROUP", "GROUP", "g", "c", "STREAMS", "k", ">"},
+		{"XGROUP", "CREATE", "k", "g", "$"}, {"XACK", "k", "g", "1-0"},
+		{"XPENDING", "k", "g"}, {"XCLAIM", "k", "g", "c", "0", "1-0"},
+		{"XAUTOCLAIM", "k", "g", "c", "0", "0"},
+	},
+	QueueType: {
+		{"QADD", "k", "job"}, {"QRESERVE", "k"}, {"QACK", "k", "1"}, {"QNACK", "k", "1"},
+		{"QDEAD", "k"}, {"QREQUEUE", "k"}, {"QSTATS", "k"},
+	},
+	JSONType: {
+		{"JSON.SET", "k", "$", "1"}, {"JSON.GET", "k"}, {"JSON.DEL", "k"}, {"JSON.TYPE", "k"},
+		{"JSON.ARRAPPEND", "k", "$", "1"}, {"JSON.NUMINCRBY", "k", "$", "1"}, {"JSON.OBJKEYS", "k"},
+	},
+	HLLType: {
+		{"PFADD", "k", "a"}, {"PFCOUNT", "k"}, {"PFCOUNT", "other", "k"},
+		{"PFMERGE", "k", "other"}, {"PFMERGE", "other", "k"},
+	},
+	BloomType: {
+		{"BF.ADD", "k", "a"}, {"BF.MADD", "k", "a"}, {"BF.EXISTS", "k", "a"},
+		{"BF.MEXISTS", "k", "a"}, {"BF.INFO", "k"},
+	},
+	CuckooType: {
+		{"CF.ADD", "k", "a"}, {"CF.ADDNX", "k", "a"}, {"CF.EXISTS", "k", "a"},
+		{"CF.MEXISTS", "k", "a"}, {"CF.DEL", "k", "a"}, {"CF.COUNT", "k", "a"}, {"CF.INFO", "k"},
+	},
+	CMSType: {
+		{"CMS.INCRBY", "k", "a", "1"}, {"CMS.QUERY", "k", "a"}, {"CMS.INFO", "k"},
+		{"CMS.MERGE", "k", "1", "other"}, {"CMS.MERGE", "other", "1", "k"},
+	},
+	TopKType: {
+		{"TOPK.ADD", "k", "a"}, {"TOPK.QUERY", "k", "a"}, {"TOPK.LIST", "k"}, {"TOPK.INFO", "k"},
+	},
+	GeoType: {
+		{"GEOADD", "k", "1", "2", "a"}, {"GEOPOS", "k", "a"}, {"GEODIST", "k", "a", "b"},
+		{"GEOHASH", "k", "a"}, {"GEOSEARCH", "k", "FROMLONLAT", "1", "2", "BYRADIUS", "1", "km"},
+		{"GEOSEARCHSTORE", "other", "k", "FROMLONLAT", "1", "2", "BYRADIUS", "1", "km"},
+	},
+	TimeSeriesType: {
+		{"TS.ADD", "k", "1", "1"}, {"TS.MADD", "k", "1", "1"}, {"TS.GET", "k"}, {"TS.RANGE", "k", "-", "+"},
+		{"TS.CREATERULE", "k", "other", "AGGREGATION", "avg", "10"},
+		{"TS.CREATERULE", "other", "k", "AGGREGATION", "avg", "10"},
+		{"TS.DELETERULE", "k", "other"}, {"TS.INFO", "k"},
+	},
+	VectorType: {
+		{"VEC.SET", "k", "VALUES", "1", "1"}, {"VEC.GET", "k"}, {"VEC.TAGS", "k"},
+	},
+}
+
+// seedKey stores a value of type t at key.
+func seedKey(key string, t valueType) {
+	switch t {
+	case StringType:
+		_, _ = setHandler([]string{key, "a"})
+	case ListType:
+		_, _ = rpushHandler([]string{key, "a"})
+	case SetType:
+		_, _ = saddHandler([]string{key, "a"})
+	case StreamType:
+		_, _ = xaddHandler([]string{key, "*", "a", "b"})
+	case QueueType:
+		_, _ = qaddHandler([]string{key, "a"})
+	case JSONType:
+		_, _ = jsonSetHandler([]string{key, "$", `{"a":1}`})
+	case HLLType:
+		_, _ = pfaddHandler([]string{key, "a"})
+	case BloomType:
+		_, _ = bfAddHandler([]string{key, "a"})
+	case CuckooType:
+		_, _ = cfAddHandler([]string{key, "a"})
+	case CMSType:
+		_, _ = cmsInitByDimHandler([]string{key, "10", "2"})
+	case TopKType:
+		_, _ = topkReserveHandler([]string{key, "3"})
+	case GeoType:
+		_, _ = geoaddHandler([]string{key, "1", "2", "a"})
+	case TimeSeriesType:
+		_, _ = tsAddHandler([]string{key, "1", "1"})
+	case VectorType:
+		_, _ = vecSetHandler([]string{key, "VALUES", "1", "1"})
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	for want, cmds := range typedCommands {
+		for _, held := range []valueType{StringType, ListType, SetType, StreamType, QueueType, JSONType, HLLType, BloomType, CuckooType, CMSType, TopKType, GeoType, TimeSeriesType, VectorType} {
+			if held == want {
+				continue
+			}
+			for _, cmd := range cmds {
+				DefaultStore = NewStore()
+				seedKey("k", held)
+				// Give the other operand of two-key commands the right type so
+				// only "k" can trigger the error.
+				seedKey("src", want)
+				seedKey("other", want)
+				_, err := Commands[cmd[0]](cmd[1:])
+				if !errors.Is(err, ErrWrongType) {
+					t.Errorf("%v against a %s: expected WRONGTYPE, got %v", cmd, typeName(held), err)
+				}
+				if typ, _ := typeHandler([]string{"k"}); typ != typeName(held) {
+					t.Errorf("%v against a %s: key became %s", cmd, typeName(held), typ)
+				}
+			}
+		}
+	}
+}
+
+func TestWrongTypeMessage(t *testing.T) {
+	want := "WRONGTYPE Operation against a key holding the wrong kind of value"
+	if ErrWrongType.Error() != want {
+		t.Errorf("expected %q, got %q", want, ErrWrongType.Error())
+	}
+}
+
+func TestOverwritingCommandsIgnoreType(t *testing.T) {
+	DefaultStore = NewStore()
+	seedKey("k", ListType)
+	if resp, err := setHandler([]string{"k", "v"}); err != nil || resp != "OK" {
+		t.Errorf("expected SET to replace a list, got %s, %v", resp, err)
+	}
+	seedKey("s", SetType)
+	if out, err := mgetHandler([]string{"k", "s"}); err != nil || out != "v," {
+		t.Errorf("expected MGET to read non-strings as nil, got %s, %v", out, err)
+	}
+	if n, err := delHandler([]string{"s"}); err != nil || n != "1" {
+		t.Errorf("expected DEL to remove a set, got %s, %v", n, err)
+	}
+}
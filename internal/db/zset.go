@@ -0,0 +1,131 @@
+package db
+
+import "math/rand/v2"
+
+// zsetMaxLevel bounds skiplist towers; with p = 1/4 it suits 4^32 members.
+const zsetMaxLevel = 32
+
+type zsetNode struct {
+	member string
+	score  float64
+	next   []*zsetNode // one per level
+}
+
+// sortedSet keeps members ordered by score, then by member, in a skiplist,
+// with a map for looking up a member's score.
+type sortedSet struct {
+	head   *zsetNode
+	level  int
+	scores map[string]float64
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{
+		head:   &zsetNode{next: make([]*zsetNode, zsetMaxLevel)},
+		level:  1,
+		scores: make(map[string]float64),
+	}
+}
+
+func (z *sortedSet) Len() int {
+	return len(z.scores)
+}
+
+// score returns member's score. A nil set, as for a missing key, has no
+// members.
+func (z *sortedSet) score(member string) (float64, bool) {
+	if z == nil {
+		return 0, false
+	}
+	score, ok := z.scores[member]
+	return score, ok
+}
+
+// zsetBefore reports whether node n sorts before (score, member).
+func zsetBefore(n *zsetNode, score float64, member string) bool {
+	return n.score < score || n.score == score && n.member < member
+}
+
+// add sets member's score, reporting whether member is new.
+func (z *sortedSet) add(member string, score float64) bool {
+	old, exists := z.scores[member]
+	if exists {
+		if old == score {
+			return false
+		}
+		z.unlink(member, old)
+	}
+	var update [zsetMaxLevel]*zsetNode
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && zsetBefore(x.next[i], score, member) {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+	level := 1
+	for level < zsetMaxLevel && rand.IntN(4) == 0 {
+		level++
+	}
+	for i := z.level; i < level; i++ {
+		update[i] = z.head
+	}
+	z.level = max(z.level, level)
+	n := &zsetNode{member: member, score: score, next: make([]*zsetNode, level)}
+	for i := range level {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+	z.scores[member] = score
+	return !exists
+}
+
+// remove deletes member, reporting whether it was there.
+func (z *sortedSet) remove(member string) bool {
+	score, ok := z.scores[member]
+	if ok {
+		z.unlink(member, score)
+		delete(z.scores, member)
+	}
+	return ok
+}
+
+func (z *sortedSet) unlink(member string, score float64) {
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && zsetBefore(x.next[i], score, member) {
+			x = x.next[i]
+		}
+		if n := x.next[i]; n != nil && n.member == member {
+			x.next[i] = n.next[i]
+		}
+	}
+	for z.level > 1 && z.head.next[z.level-1] == nil {
+		z.level--
+	}
+}
+
+// scanRange calls f with each member whose score is in [min, max), in
+// order, stopping if f returns false.
+func (z *sortedSet) scanRange(min, max float64, f func(member string, score float64) bool) bool {
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].score < min {
+			x = x.next[i]
+		}
+	}
+	for n := x.next[0]; n != nil && n.score < max; n = n.next[0] {
+		if !f(n.member, n.score) {
+			return false
+		}
+	}
+	return true
+}
+
+func copySortedSet(z *sortedSet) *sortedSet {
+	cp := newSortedSet()
+	for n := z.head.next[0]; n != nil; n = n.next[0] {
+		cp.add(n.member, n.score)
+	}
+	return cp
+}
@@ -2,7 +2,9 @@ package engine
 
 import (
 	"bufio"
+	"fmt"
 	"furr/internal/db"
+	"furr/internal/protocol"
 	"os"
 	"strings"
 )
@@ -23,6 +25,15 @@ func Append(cmd string) error {
 	return err
 }
 
+// Log appends a command given as arguments, quoted so Load splits it back
+// the same way. It has the signature of db.AppendOnly; the command has
+// already been applied, so a failed write is only reported.
+func Log(args []string) {
+	if err := Append(protocol.QuoteArgs(args)); err != nil {
+		fmt.Fprintln(os.Stderr, "[aof] write error:", err)
+	}
+}
+
 // Load loads the AOF log and replays commands (stub)
 func Load() error {
 	f, err := os.Open(aofPath)
@@ -30,14 +41,16 @@ func Load() error {
 		return err
 	}
 	defer f.Close()
+	db.Replaying.Store(true)
+	defer db.Replaying.Store(false)
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" {
 			continue
 		}
-		tokens := strings.Fields(line)
-		if len(tokens) == 0 {
+		tokens, err := protocol.Split(line)
+		if err != nil || len(tokens) == 0 {
 			continue
 		}
 		cmd := strings.ToUpper(tokens[0])
@@ -2,16 +2,26 @@ package engine
 
 import (
 	"furr/internal/db"
-	"os"
+	"path/filepath"
 	"testing"
 )
 
+// useTempAOF points the log at a fresh file for the rest of the test, so
+// tests leave the tracked test_aof.log fixture alone.
+func useTempAOF(t *testing.T) {
+	aofPath = filepath.Join(t.TempDir(), "test_aof.log")
+	aofFile = nil
+	t.Cleanup(func() {
+		if aofFile != nil {
+			aofFile.Close()
+			aofFile = nil
+		}
+	})
+}
+
 func TestAppendLoadFlush(t *testing.T) {
 	// Use a temp file for AOF
-	tmp := "test_aof.log"
-	aofPath = tmp
-	defer os.Remove(tmp)
-	aofFile = nil
+	useTempAOF(t)
 
 	// Clear DB
 	db.DefaultStore = db.NewStore()
@@ -37,3 +47,22 @@ func TestAppendLoadFlush(t *testing.T) {
 		t.Errorf("expected testval, got %s", val)
 	}
 }
+
+func TestLogQuotesArguments(t *testing.T) {
+	useTempAOF(t)
+
+	db.DefaultStore = db.NewStore()
+	Log([]string{"XADD", "events", "1-0", "msg", "hello world", "empty", ""})
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	db.DefaultStore = db.NewStore()
+	if err := Load(); err != nil {
+		t.Fatal(err)
+	}
+	val, _ := db.Commands["XRANGE"]([]string{"events", "-", "+"})
+	if val != `1-0 msg "hello world" empty ""` {
+		t.Errorf("unexpected replayed entry %s", val)
+	}
+}
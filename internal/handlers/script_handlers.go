@@ -14,6 +14,8 @@ func regscriptHandler(args []string) (string, error) {
 	}
 	scriptStr := strings.Join(args, " ")
 	hash := script.RegisterScript(scriptStr)
+	// Logged so that replaying the append-only log can run the script.
+	db.Changed()
 	return hash, nil
 }
 
@@ -0,0 +1,159 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Split tokenizes a command line. Tokens are separated by whitespace; a token
+// wrapped in double quotes may contain spaces and the escapes \n, \r, \t, \",
+// \\ and \xHH, so arbitrary binary values can be sent on one line.
+func Split(line string) ([]string, error) {
+	var tokens []string
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return tokens, nil
+		}
+		if line[i] != '"' {
+			start := i
+			for i < len(line) && !isSpace(line[i]) {
+				i++
+			}
+			tokens = append(tokens, line[start:i])
+			continue
+		}
+		var sb strings.Builder
+		i++
+		for {
+			if i >= len(line) {
+				return nil, fmt.Errorf("unbalanced quotes in request")
+			}
+			c := line[i]
+			if c == '"' {
+				i++
+				break
+			}
+			if c != '\\' {
+				sb.WriteByte(c)
+				i++
+				continue
+			}
+			if i+1 >= len(line) {
+				return nil, fmt.Errorf("unbalanced quotes in request")
+			}
+			switch e := line[i+1]; e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'x':
+				if i+3 < len(line) && isHex(line[i+2]) && isHex(line[i+3]) {
+					sb.WriteByte(unhex(line[i+2])<<4 | unhex(line[i+3]))
+					i += 2
+				} else {
+					sb.WriteByte('x')
+				}
+			default:
+				sb.WriteByte(e)
+			}
+			i += 2
+		}
+		if i < len(line) && !isSpace(line[i]) {
+			return nil, fmt.Errorf("closing quote must be followed by a space")
+		}
+		tokens = append(tokens, sb.String())
+	}
+}
+
+// Quote returns s unchanged if it is safe to send as a bare reply, and
+// otherwise as a double-quoted string using the escapes Split accepts. Valid
+// UTF-8 text is left as is; control characters and invalid bytes are escaped.
+func Quote(s string) string {
+	if !needsQuoting(s) {
+		return s
+	}
+	return quote(s)
+}
+
+// QuoteArgs joins args into a line that Split turns back into args.
+func QuoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		// Empty and space-containing tokens are fine as replies but would
+		// be lost between tokens, so they are always quoted here.
+		if a == "" || strings.Contains(a, " ") || needsQuoting(a) {
+			quoted[i] = quote(a)
+		} else {
+			quoted[i] = a
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			if r, size := utf8.DecodeRuneInString(s[i:]); r != utf8.RuneError {
+				sb.WriteString(s[i : i+size])
+				i += size - 1
+				continue
+			}
+		}
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\r':
+			sb.WriteString(`\r`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&sb, `\x%02x`, c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func needsQuoting(s string) bool {
+	if strings.HasPrefix(s, `"`) {
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c == 0x7f {
+			return true
+		}
+	}
+	return !utf8.ValidString(s)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
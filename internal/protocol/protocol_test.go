@@ -0,0 +1,40 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tokens, err := Split(`SET  key "hello world" "\x00\xffa\"b\\" plain`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"SET", "key", "hello world", "\x00\xffa\"b\\", "plain"}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("expected %q, got %q", want, tokens)
+	}
+	if _, err := Split(`SET key "open`); err == nil {
+		t.Error("expected error for unbalanced quotes")
+	}
+	if _, err := Split(`SET key "a"b`); err == nil {
+		t.Error("expected error for text after closing quote")
+	}
+}
+
+func TestQuoteRoundTrip(t *testing.T) {
+	if q := Quote("plain text,café"); q != "plain text,café" {
+		t.Errorf("expected printable reply unchanged, got %s", q)
+	}
+	if q := Quote("a\nb\x00"); q != `"a\nb\x00"` {
+		t.Errorf("expected escaped reply, got %s", q)
+	}
+	args := []string{"SET", "k", "", "with space", "\x00\x01\xfe", `"quoted"`}
+	tokens, err := Split(QuoteArgs(args))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tokens, args) {
+		t.Errorf("expected %q, got %q", args, tokens)
+	}
+}
@@ -0,0 +1,305 @@
+// Package pubsub routes published messages to subscribers of channels and
+// channel patterns. It is used by the server for SUBSCRIBE and friends, and
+// can be used directly by code embedding FurrDB.
+package pubsub
+
+import (
+	"sort"
+	"sync"
+
+	"furr/internal/glob"
+)
+
+// DefaultOutputLimit is the number of bytes of undelivered messages a
+// subscriber may have queued before it is dropped as too slow.
+const DefaultOutputLimit = 32 << 20
+
+// Message is a published message as seen by one subscriber.
+type Message struct {
+	Pattern string // pattern that matched, "" for a channel subscription
+	Channel string
+	Payload string
+}
+
+func (m Message) size() int {
+	return len(m.Pattern) + len(m.Channel) + len(m.Payload)
+}
+
+// Broker tracks subscriptions and fans published messages out to them.
+type Broker struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Subscriber]struct{}
+	patterns map[string]map[*Subscriber]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		channels: make(map[string]map[*Subscriber]struct{}),
+		patterns: make(map[string]map[*Subscriber]struct{}),
+	}
+}
+
+// Default is the broker used by the server and the PUBLISH command.
+var Default = NewBroker()
+
+// Publish sends payload to subscribers of channel on the default broker.
+func Publish(channel, payload string) int {
+	return Default.Publish(channel, payload)
+}
+
+// Publish sends payload to every subscriber of channel and of each pattern
+// matching it, returning the number of deliveries. Subscribers that exceed
+// their output limit are dropped.
+func (b *Broker) Publish(channel, payload string) int {
+	var slow []*Subscriber
+	receivers := 0
+	b.mu.RLock()
+	for s := range b.channels[channel] {
+		receivers++
+		if !s.push(Message{Channel: channel, Payload: payload}) {
+			slow = append(slow, s)
+		}
+	}
+	for pattern, subs := range b.patterns {
+		if !glob.Match(pattern, channel) {
+			continue
+		}
+		for s := range subs {
+			receivers++
+			if !s.push(Message{Pattern: pattern, Channel: channel, Payload: payload}) {
+				slow = append(slow, s)
+			}
+		}
+	}
+	b.mu.RUnlock()
+	for _, s := range slow {
+		s.drop()
+	}
+	return receivers
+}
+
+// Channels returns the channels with at least one subscriber, optionally
+// filtered by a glob pattern, in sorted order.
+func (b *Broker) Channels(pattern string) []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var out []string
+	for ch := range b.channels {
+		if pattern == "" || glob.Match(pattern, ch) {
+			out = append(out, ch)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// NumSub returns the number of subscribers of each channel, not counting
+// pattern subscribers.
+func (b *Broker) NumSub(channels ...string) []int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	out := make([]int, len(channels))
+	for i, ch := range channels {
+		out[i] = len(b.channels[ch])
+	}
+	return out
+}
+
+// NumPat returns the number of distinct patterns subscribed to.
+func (b *Broker) NumPat() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.patterns)
+}
+
+// Subscriber is one client's set of subscriptions and its queue of messages
+// waiting to be delivered.
+type Subscriber struct {
+	broker *Broker
+	limit  int
+
+	// Guarded by broker.mu.
+	channels map[string]struct{}
+	patterns map[string]struct{}
+
+	mu      sync.Mutex
+	queue   []Message
+	pending int // bytes queued
+	wake    chan struct{}
+	done    chan struct{}
+	closed  bool
+	dropped bool
+}
+
+// NewSubscriber returns a subscriber with no subscriptions that is dropped
+// once more than limit bytes of messages are waiting for it. A limit of 0
+// means no limit.
+func (b *Broker) NewSubscriber(limit int) *Subscriber {
+	return &Subscriber{
+		broker:   b,
+		limit:    limit,
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// NewSubscriber returns a subscriber on the default broker.
+func NewSubscriber(limit int) *Subscriber {
+	return Default.NewSubscriber(limit)
+}
+
+// Count returns the number of channels and patterns s is subscribed to.
+func (s *Subscriber) Count() int {
+	s.broker.mu.RLock()
+	defer s.broker.mu.RUnlock()
+	return len(s.channels) + len(s.patterns)
+}
+
+// Subscribe adds channel subscriptions and returns the subscription count
+// after each one.
+func (s *Subscriber) Subscribe(channels ...string) []int {
+	return s.add(s.broker.channels, s.channels, channels)
+}
+
+// PSubscribe adds pattern subscriptions and returns the subscription count
+// after each one.
+func (s *Subscriber) PSubscribe(patterns ...string) []int {
+	return s.add(s.broker.patterns, s.patterns, patterns)
+}
+
+// Unsubscribe removes channel subscriptions, or all of them if none are
+// named. It returns the channels removed and the count after each.
+func (s *Subscriber) Unsubscribe(channels ...string) ([]string, []int) {
+	return s.remove(s.broker.channels, s.channels, channels)
+}
+
+// PUnsubscribe removes pattern subscriptions, or all of them if none are
+// named. It returns the patterns removed and the count after each.
+func (s *Subscriber) PUnsubscribe(patterns ...string) ([]string, []int) {
+	return s.remove(s.broker.patterns, s.patterns, patterns)
+}
+
+func (s *Subscriber) add(index map[string]map[*Subscriber]struct{}, own map[string]struct{}, names []string) []int {
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	counts := make([]int, len(names))
+	for i, name := range names {
+		if !s.isClosed() {
+			if index[name] == nil {
+				index[name] = make(map[*Subscriber]struct{})
+			}
+			index[name][s] = struct{}{}
+			own[name] = struct{}{}
+		}
+		counts[i] = len(s.channels) + len(s.patterns)
+	}
+	return counts
+}
+
+func (s *Subscriber) remove(index map[string]map[*Subscriber]struct{}, own map[string]struct{}, names []string) ([]string, []int) {
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(names) == 0 {
+		for name := range own {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	counts := make([]int, len(names))
+	for i, name := range names {
+		delete(own, name)
+		delete(index[name], s)
+		if len(index[name]) == 0 {
+			delete(index, name)
+		}
+		counts[i] = len(s.channels) + len(s.patterns)
+	}
+	return names, counts
+}
+
+// push queues m, reporting false if that takes s over its output limit.
+func (s *Subscriber) push(m Message) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return true
+	}
+	s.queue = append(s.queue, m)
+	s.pending += m.size()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return s.limit == 0 || s.pending <= s.limit
+}
+
+// Next blocks until a message is available and returns it. ok is false once
+// s has been closed or dropped.
+func (s *Subscriber) Next() (m Message, ok bool) {
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return Message{}, false
+		}
+		if len(s.queue) > 0 {
+			m = s.queue[0]
+			s.queue[0] = Message{}
+			s.queue = s.queue[1:]
+			s.pending -= m.size()
+			s.mu.Unlock()
+			return m, true
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.wake:
+		case <-s.done:
+		}
+	}
+}
+
+// Done returns a channel that is closed when s is closed or dropped.
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+// Dropped reports whether s was disconnected for exceeding its output limit.
+func (s *Subscriber) Dropped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+func (s *Subscriber) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Close removes all of s's subscriptions, discards undelivered messages and
+// wakes any pending Next.
+func (s *Subscriber) Close() {
+	s.close(false)
+}
+
+func (s *Subscriber) drop() {
+	s.close(true)
+}
+
+func (s *Subscriber) close(dropped bool) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed, s.dropped = true, dropped
+	s.queue, s.pending = nil, 0
+	close(s.done)
+	s.mu.Unlock()
+	s.Unsubscribe()
+	s.PUnsubscribe()
+}
@@ -0,0 +1,97 @@
+package pubsub
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func next(t *testing.T, s *Subscriber) Message {
+	t.Helper()
+	got := make(chan Message, 1)
+	go func() {
+		m, _ := s.Next()
+		got <- m
+	}()
+	select {
+	case m := <-got:
+		return m
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message delivered")
+		return Message{}
+	}
+}
+
+func TestPublishChannelsAndPatterns(t *testing.T) {
+	b := NewBroker()
+	a, p := b.NewSubscriber(0), b.NewSubscriber(0)
+	if counts := a.Subscribe("news", "sport"); counts[0] != 1 || counts[1] != 2 {
+		t.Errorf("expected counts 1,2, got %v", counts)
+	}
+	p.PSubscribe("n*")
+	if n := b.Publish("news", "hello"); n != 2 {
+		t.Errorf("expected 2 receivers, got %d", n)
+	}
+	if m := next(t, a); m != (Message{Channel: "news", Payload: "hello"}) {
+		t.Errorf("unexpected message %+v", m)
+	}
+	if m := next(t, p); m != (Message{Pattern: "n*", Channel: "news", Payload: "hello"}) {
+		t.Errorf("unexpected pattern message %+v", m)
+	}
+	if n := b.Publish("weather", "rain"); n != 0 {
+		t.Errorf("expected no receivers, got %d", n)
+	}
+}
+
+func TestIntrospection(t *testing.T) {
+	b := NewBroker()
+	a, c := b.NewSubscriber(0), b.NewSubscriber(0)
+	a.Subscribe("news", "sport")
+	c.Subscribe("news")
+	c.PSubscribe("x*", "y*")
+	if got := b.Channels(""); strings.Join(got, ",") != "news,sport" {
+		t.Errorf("expected news,sport, got %v", got)
+	}
+	if got := b.Channels("s*"); strings.Join(got, ",") != "sport" {
+		t.Errorf("expected sport, got %v", got)
+	}
+	if got := b.NumSub("news", "sport", "none"); got[0] != 2 || got[1] != 1 || got[2] != 0 {
+		t.Errorf("expected 2,1,0, got %v", got)
+	}
+	if n := b.NumPat(); n != 2 {
+		t.Errorf("expected 2 patterns, got %d", n)
+	}
+	names, counts := c.Unsubscribe()
+	if strings.Join(names, ",") != "news" || counts[0] != 2 {
+		t.Errorf("expected news with count 2, got %v %v", names, counts)
+	}
+	c.Close()
+	if n := b.NumPat(); n != 0 {
+		t.Errorf("expected Close to drop patterns, got %d", n)
+	}
+	if _, ok := c.Next(); ok {
+		t.Error("expected Next to fail after Close")
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	b := NewBroker()
+	s := b.NewSubscriber(10)
+	s.Subscribe("c")
+	b.Publish("c", "12345")
+	if s.Dropped() {
+		t.Fatal("dropped before reaching the limit")
+	}
+	b.Publish("c", "67890")
+	if !s.Dropped() {
+		t.Fatal("expected subscriber over its output limit to be dropped")
+	}
+	select {
+	case <-s.Done():
+	default:
+		t.Error("expected Done to be closed")
+	}
+	if n := b.Publish("c", "x"); n != 0 {
+		t.Errorf("expected dropped subscriber to be unsubscribed, got %d receivers", n)
+	}
+}
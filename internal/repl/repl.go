@@ -2,11 +2,13 @@ package repl
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 
 	"furr/internal/db"
+	"furr/internal/protocol"
 )
 
 func Start() {
@@ -23,7 +25,11 @@ func Start() {
 		if line == "" {
 			continue
 		}
-		tokens := strings.Fields(line)
+		tokens, err := protocol.Split(line)
+		if err != nil {
+			fmt.Println("ERR", err)
+			continue
+		}
 		if len(tokens) == 0 {
 			continue
 		}
@@ -38,17 +44,16 @@ func Start() {
 		case "CLEAR":
 			clearScreen()
 		default:
-			handler, ok := db.Commands[cmd]
-			if !ok {
-				fmt.Println("ERR unknown command")
+			result, err := db.Exec(cmd, args)
+			if errors.Is(err, db.ErrWrongType) {
+				fmt.Println(err)
 				continue
 			}
-			result, err := handler(args)
 			if err != nil {
 				fmt.Println("ERR", err)
 				continue
 			}
-			fmt.Println(result)
+			fmt.Println(protocol.Quote(result))
 		}
 	}
 }
@@ -59,24 +64,137 @@ func clearScreen() {
 
 func printHelp() {
 	fmt.Println(`Available commands:
-	SET key value      - Set key to value
+	SET key value [NX|XX] [GET] [EX s|PX ms|EXAT ts|PXAT ts|KEEPTTL]
+	                   - Set key to value
 	GET key            - Get value of key
-	DEL key            - Delete key
-	EXISTS key         - Check if key exists
+	SETNX key value    - Set key only if it does not exist
+	GETSET key value   - Set key and return its old value
+	GETDEL key         - Get value of key and delete it
+	GETEX key [opts]   - Get value of key and update its TTL
+	MSET k v [k v..]   - Set multiple keys
+	MSETNX k v [k v..] - Set multiple keys only if none exist
+	MGET k [k..]       - Get values of multiple keys
+	INCR key           - Increment integer value by 1
+	DECR key           - Decrement integer value by 1
+	INCRBY key n       - Increment integer value by n
+	DECRBY key n       - Decrement integer value by n
+	INCRBYFLOAT key f  - Increment float value by f
+	APPEND key value   - Append value to string
+	STRLEN key         - Length of string value
+	GETRANGE key s e   - Substring from s to e (negative counts from end)
+	SETRANGE key o v   - Overwrite string at offset o, zero-padding
+	LCS k1 k2 [LEN|IDX] - Longest common subsequence of two keys
+	SETBIT key off 0|1 - Set bit at offset, returns old bit
+	GETBIT key off     - Get bit at offset
+	BITCOUNT key [s e [BYTE|BIT]] - Count set bits
+	BITPOS key 0|1 [s [e [BYTE|BIT]]] - Find first set/clear bit
+	BITOP op dest k [k..] - AND/OR/XOR/NOT keys into dest
+	BITFIELD key [GET t o] [SET t o v] [INCRBY t o n] [OVERFLOW WRAP|SAT|FAIL]
+	DEL k [k..]        - Delete key(s)
+	EXISTS k [k..]     - Count how many keys exist
+	UNLINK k [k..]     - Delete key(s), freeing large values in the background
+	TYPE k             - Type of value stored at key
+	RENAME src dst     - Rename key, keeping its TTL
+	RENAMENX src dst   - Rename only if dst does not exist
+	COPY src dst [REPLACE] - Copy value and TTL to dst
+	TOUCH k [k..]      - Count how many keys exist
+	RANDOMKEY          - Return a random key
+	DBSIZE             - Number of keys
 	LPUSH k v [v..]    - Push value(s) to head of list
 	RPUSH k v [v..]    - Push value(s) to tail of list
-	LPOP k             - Pop value from head of list
-	RPOP k             - Pop value from tail of list
-	LRANGE k s e       - Get list elements from s to e
+	LPUSHX k v [v..]   - Push to head only if list exists
+	RPUSHX k v [v..]   - Push to tail only if list exists
+	LPOP k [count]     - Pop value(s) from head of list
+	RPOP k [count]     - Pop value(s) from tail of list
+	LRANGE k s e       - Get list elements from s to e (negative counts from end)
+	LLEN k             - Length of list
+	LINDEX k i         - Get element at index i
+	LSET k i v         - Set element at index i
+	LINSERT k BEFORE|AFTER pivot v - Insert v next to pivot
+	LREM k count v     - Remove count occurrences of v
+	LTRIM k s e        - Keep only elements from s to e
+	LPOS k v [RANK r] [COUNT n] [MAXLEN m] - Find index of v
+	LMOVE src dst LEFT|RIGHT LEFT|RIGHT - Move element between lists
+	RPOPLPUSH src dst  - Move tail of src to head of dst
+	BLPOP k [k..] t    - Pop from head of first non-empty list
+	BRPOP k [k..] t    - Pop from tail of first non-empty list
+	BLMOVE src dst LEFT|RIGHT LEFT|RIGHT t - LMOVE that waits for an element
+	BRPOPLPUSH src dst t - RPOPLPUSH that waits for an element
+	                     (blocking commands never block in the REPL)
 	SADD k v [v..]     - Add value(s) to set
 	SREM k v [v..]     - Remove value(s) from set
 	SMEMBERS k         - List all set members
+	SISMEMBER k v      - Check set membership
+	SMISMEMBER k v [v..] - Check membership of several values
+	SCARD k            - Number of set members
+	SPOP k [count]     - Remove and return random member(s)
+	SRANDMEMBER k [count] - Random member(s); negative count allows repeats
+	SMOVE src dst v    - Move member between sets
+	SUNION k [k..]     - Union of sets
+	SINTER k [k..]     - Intersection of sets
+	SDIFF k [k..]      - Members of first set not in the others
+	SUNIONSTORE dst k [k..] - Store union in dst
+	SINTERSTORE dst k [k..] - Store intersection in dst
+	SDIFFSTORE dst k [k..]  - Store difference in dst
+	SINTERCARD n k [k..] [LIMIT l] - Size of intersection
+	SORT k [BY p] [LIMIT o n] [GET p..] [ASC|DESC] [ALPHA] [STORE dst] - Sort list or set
+	SORT_RO k ...      - SORT without STORE
+	XADD k [MAXLEN|MINID [~] n] *|id f v [f v..] - Append a stream entry
+	XLEN k             - Number of stream entries
+	XRANGE k s e [COUNT n] / XREVRANGE k e s [COUNT n] - Entries between IDs
+	XTRIM k MAXLEN|MINID [~] n - Trim a stream
+	XREAD [COUNT n] [BLOCK ms] STREAMS k [k..] id [id..] - Read entries after IDs
+	XGROUP CREATE k g id|$ [MKSTREAM] | SETID | DESTROY | CREATECONSUMER | DELCONSUMER
+	XREADGROUP GROUP g c [COUNT n] [NOACK] STREAMS k [k..] >|id - Read as a consumer
+	XACK k g id [id..] - Acknowledge pending entries
+	XPENDING k g [[IDLE ms] s e n [c]] - Inspect pending entries
+	XCLAIM k g c min-idle id [id..] [JUSTID] ... - Take over pending entries
+	XAUTOCLAIM k g c min-idle start [COUNT n] [JUSTID] - Claim by scanning
+	QADD k payload [DELAY ms] [PRIORITY p] [MAXATTEMPTS n] - Enqueue a job
+	QRESERVE k [TIMEOUT ms] [COUNT n] - Reserve jobs until acked or timed out
+	QACK k id [id..] / QNACK k id [DELAY ms] - Complete or retry reserved jobs
+	QDEAD k [COUNT n] / QREQUEUE k [id..] - Inspect or retry dead-lettered jobs
+	QSTATS k           - Queue counters
+	JSON.SET k path value [NX|XX] - Set a JSON document or values in it
+	JSON.GET k [path..] / JSON.DEL k [path] / JSON.TYPE k [path] - Read or delete JSON values
+	JSON.ARRAPPEND k path v [v..] / JSON.NUMINCRBY k path n / JSON.OBJKEYS k [path]
+	PFADD k [elem..] / PFCOUNT k [k..] / PFMERGE dest [k..] - Estimate distinct counts
+	BF.RESERVE k rate capacity [EXPANSION n] [NONSCALING] - Create a Bloom filter
+	BF.ADD k item / BF.MADD k item.. / BF.EXISTS k item / BF.MEXISTS k item.. / BF.INFO k
+	CF.RESERVE k capacity [BUCKETSIZE n] [MAXITERATIONS n] [EXPANSION n] - Create a cuckoo filter
+	CF.ADD k item / CF.ADDNX k item / CF.EXISTS k item / CF.MEXISTS k item.. / CF.DEL k item / CF.COUNT k item / CF.INFO k
+	CMS.INITBYDIM k width depth / CMS.INITBYPROB k error prob - Create a Count-Min Sketch
+	CMS.INCRBY k item n [item n..] / CMS.QUERY k item.. / CMS.MERGE dest numkeys k.. [WEIGHTS w..] / CMS.INFO k
+	TOPK.RESERVE k topk [width depth decay] - Create a top-k list
+	TOPK.ADD k item.. / TOPK.QUERY k item.. / TOPK.LIST k [WITHCOUNT] / TOPK.INFO k
+	GEOADD k [NX|XX] [CH] lon lat member.. - Add positions
+	GEOPOS k member.. / GEODIST k m1 m2 [unit] / GEOHASH k member..
+	GEOSEARCH k FROMMEMBER m|FROMLONLAT lon lat BYRADIUS r unit|BYBOX w h unit [ASC|DESC] [COUNT n [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+	GEOSEARCHSTORE dest k ... - Store what GEOSEARCH finds
+	TS.CREATE k [RETENTION ms] [DUPLICATE_POLICY p] [LABELS l v..] - Create a time series
+	TS.ADD k t|* v [RETENTION ms] [ON_DUPLICATE p] [LABELS l v..] / TS.MADD k t v [k t v..] / TS.GET k
+	TS.RANGE k from to [COUNT n] [AGGREGATION agg bucket] - Samples in a range
+	TS.MRANGE from to [COUNT n] [AGGREGATION agg bucket] FILTER l=v.. - Range over matching series
+	TS.CREATERULE src dest AGGREGATION agg bucket / TS.DELETERULE src dest / TS.INFO k
+	VEC.CREATE idx PREFIX p DIM n [METRIC COSINE|L2|IP] [ALGORITHM FLAT|HNSW] [M m] [EF_CONSTRUCTION n] [EF_RUNTIME n]
+	VEC.DROP idx / VEC.LIST / VEC.INFO idx - Manage vector indexes
+	VEC.SET k VALUES n x1..xn [TAGS tag v..] / VEC.GET k / VEC.TAGS k
+	VEC.SEARCH idx k VALUES n x1..xn [EF n] [FILTER tag=v..] - Nearest vectors
+	SCHEDULE AT t|IN d|EVERY d|CRON expr [MISSED SKIP|ONCE|ALL] cmd [args..] - Run a command later
+	SCHEDULE LIST | CANCEL id [id..] | NEXT id t - Manage schedules
+	TRIGGER ADD name pattern hash event [event..] - Run a script on key changes
+	TRIGGER LIST | DROP name [name..] - Manage triggers
+	PUBLISH ch msg     - Publish msg to channel ch
+	PUBSUB CHANNELS [p] | NUMSUB [ch..] | NUMPAT - Inspect subscriptions
+	CONFIG GET p | SET notify-keyspace-events flags - Runtime settings
 	KEYS               - List all keys
+	SCAN cur [MATCH p] [COUNT n] [TYPE t] - Iterate keys with a cursor
+	SSCAN k cur [MATCH p] [COUNT n] - Iterate set members with a cursor
 	FLUSHDB            - Clear the database
 	INFO               - Show server info
 	PING               - Responds with PONG
 	REGSCRIPT script   - Register script, returns hash
-	RUNSCRIPT hash     - Run registered script by hash
+	RUNSCRIPT hash [arg..] - Run registered script by hash
 	EVAL script        - Evaluate script string
 	SAVE               - Force persistence flush
 	CLEAR              - Clear the screen
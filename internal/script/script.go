@@ -4,39 +4,86 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"maps"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 
 	"furr/internal/db"
 )
 
-var scripts = make(map[string]string) // hash -> script
+// scriptsMu guards scripts, which triggers and schedules reach from their
+// own goroutines.
+var (
+	scriptsMu sync.RWMutex
+	scripts   = make(map[string]string) // hash -> script
+)
 
 // RegisterScript stores a script and returns its hash
 func RegisterScript(script string) string {
 	h := sha256.Sum256([]byte(script))
 	hash := hex.EncodeToString(h[:])
+	scriptsMu.Lock()
 	scripts[hash] = script
+	scriptsMu.Unlock()
 	return hash
 }
 
-// RunScript executes a registered script by hash (stub)
+// Scripts returns a copy of the registered scripts by hash.
+func Scripts() map[string]string {
+	scriptsMu.RLock()
+	defer scriptsMu.RUnlock()
+	return maps.Clone(scripts)
+}
+
+// RestoreScripts registers scripts, keyed by hash, alongside those already
+// registered.
+func RestoreScripts(restored map[string]string) {
+	scriptsMu.Lock()
+	defer scriptsMu.Unlock()
+	maps.Copy(scripts, restored)
+}
+
+// RunScript executes a registered script by hash. The script sees args as
+// $1, $2, ...
 func RunScript(hash string, args []string) (string, error) {
+	scriptsMu.RLock()
 	script, ok := scripts[hash]
+	scriptsMu.RUnlock()
 	if !ok {
-		return "", nil
+		return "", fmt.Errorf("ERR no script with hash %s", hash)
 	}
-	return evalScriptLines(script)
+	return evalScriptLines(script, args)
 }
 
 // EvalScript evaluates a script string without storing (stub)
 func EvalScript(script string) (string, error) {
-	return evalScriptLines(script)
+	return evalScriptLines(script, nil)
+}
+
+var varRef = regexp.MustCompile(`\$\w+`)
+
+// expandVars replaces $name in tokens with the value of variable name,
+// leaving references to variables that do not exist as they are.
+func expandVars(tokens []string, vars map[string]string) []string {
+	out := make([]string, len(tokens))
+	for i, tok := range tokens {
+		out[i] = varRef.ReplaceAllStringFunc(tok, func(ref string) string {
+			if v, ok := vars[ref[1:]]; ok {
+				return v
+			}
+			return ref
+		})
+	}
+	return out
 }
 
 // getWhitelist returns the allowed commands
 func getWhitelist() map[string]bool {
 	return map[string]bool{
 		"SET": true, "GET": true, "DEL": true, "EXISTS": true,
+		"INCR": true, "DECR": true, "INCRBY": true, "DECRBY": true, "INCRBYFLOAT": true,
 		"LPUSH": true, "RPUSH": true, "LPOP": true, "RPOP": true, "LRANGE": true,
 		"SADD": true, "SREM": true, "SMEMBERS": true,
 	}
@@ -61,7 +108,7 @@ func handleLetStatement(line string, lineNum int, whitelist map[string]bool, var
 
 	varName := parts[1]
 	cmd := strings.ToUpper(parts[3])
-	cmdArgs := parts[4:]
+	cmdArgs := expandVars(parts[4:], vars)
 
 	if !whitelist[cmd] {
 		return "", fmt.Errorf("ERR command %s not allowed in LET on line %d", cmd, lineNum)
@@ -88,7 +135,7 @@ func handleIfStatement(line string, lineNum int, vars map[string]string) (int, e
 		return 0, fmt.Errorf("ERR invalid IF syntax on line %d", lineNum)
 	}
 
-	varName := parts[1]
+	varName := strings.TrimPrefix(parts[1], "$")
 	expected := parts[3]
 
 	if vars[varName] != expected {
@@ -98,8 +145,8 @@ func handleIfStatement(line string, lineNum int, vars map[string]string) (int, e
 }
 
 // executeCommand executes a normal DB command
-func executeCommand(line string, lineNum int, whitelist map[string]bool) (string, error) {
-	tokens := strings.Fields(line)
+func executeCommand(line string, lineNum int, whitelist map[string]bool, vars map[string]string) (string, error) {
+	tokens := expandVars(strings.Fields(line), vars)
 	if len(tokens) == 0 {
 		return "", nil
 	}
@@ -124,9 +171,12 @@ func executeCommand(line string, lineNum int, whitelist map[string]bool) (string
 	return result, nil
 }
 
-func evalScriptLines(script string) (string, error) {
+func evalScriptLines(script string, args []string) (string, error) {
 	lines := strings.Split(script, ";")
 	vars := make(map[string]string)
+	for i, arg := range args {
+		vars[strconv.Itoa(i+1)] = arg
+	}
 	var last string
 
 	const maxLines = 100
@@ -170,7 +220,13 @@ func evalScriptLine(line string, lineNum int, whitelist map[string]bool, vars ma
 	case line == "END":
 		// nothing to do
 	default:
-		result, err = executeCommand(line, lineNum, whitelist)
+		result, err = executeCommand(line, lineNum, whitelist, vars)
 	}
 	return
 }
+
+func init() {
+	// Snapshots save scripts with the triggers and schedules that run them.
+	db.SnapshotScripts = Scripts
+	db.RestoreScripts = RestoreScripts
+}
@@ -57,3 +57,54 @@ func TestScriptDSLLetSyntaxError(t *testing.T) {
 		t.Errorf("expected LET syntax error, got %v", err)
 	}
 }
+
+func TestScriptCounters(t *testing.T) {
+	_, _ = db.Commands["SET"]([]string{"counter", "5"})
+	res, err := EvalScript("INCR counter; INCRBY counter 10; DECR counter")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res != "15" {
+		t.Errorf("expected 15, got %s", res)
+	}
+}
+
+func TestRunScriptArgs(t *testing.T) {
+	hash := RegisterScript("LET n = INCR count:$2; IF $2 == del; SET last:del $1; END; GET count:$2")
+	res, err := RunScript(hash, []string{"user:1", "del"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "1" {
+		t.Errorf("expected 1, got %s", res)
+	}
+	if v, _ := db.Commands["GET"]([]string{"count:del"}); v != "1" {
+		t.Errorf("expected count:del to be 1, got %s", v)
+	}
+	if v, _ := db.Commands["GET"]([]string{"last:del"}); v != "user:1" {
+		t.Errorf("expected last:del to be user:1, got %s", v)
+	}
+	if res, _ := EvalScript("SET price $5; GET price"); res != "$5" {
+		t.Errorf("expected an unknown variable to stay as is, got %s", res)
+	}
+	if _, err := RunScript("missing", nil); err == nil {
+		t.Error("expected error for an unregistered hash")
+	}
+}
+
+func TestSnapshotKeepsScripts(t *testing.T) {
+	hash := RegisterScript("SET snap kept; GET snap")
+	file := t.TempDir() + "/dump.rdb"
+	if err := db.SaveSnapshot(file); err != nil {
+		t.Fatal(err)
+	}
+	scriptsMu.Lock()
+	clear(scripts)
+	scriptsMu.Unlock()
+	if err := db.LoadSnapshot(file); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := RunScript(hash, nil); err != nil || res != "kept" {
+		t.Errorf("expected the script to survive a snapshot, got %q, %v", res, err)
+	}
+}
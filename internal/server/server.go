@@ -2,11 +2,18 @@ package server
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"furr/internal/db"
+	"furr/internal/protocol"
+	"furr/internal/pubsub"
 )
 
 func Start() error {
@@ -26,17 +33,62 @@ func Start() error {
 	}
 }
 
+// SubscriberOutputLimit is how many bytes of undelivered messages a
+// subscribed client may fall behind by before it is disconnected.
+var SubscriberOutputLimit = pubsub.DefaultOutputLimit
+
+// client is the state of one connection.
+type client struct {
+	conn  net.Conn
+	r     *bufio.Reader // reads from the client itself
+	ahead []byte        // input read ahead by watchDisconnect, not yet in r
+
+	wmu sync.Mutex // serialises replies with pushed messages
+	w   *bufio.Writer
+
+	sub *pubsub.Subscriber // nil until the first (P)SUBSCRIBE
+}
+
+// send writes each line as a separate reply.
+func (c *client) send(lines ...string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	for _, l := range lines {
+		c.w.WriteString(protocol.Quote(l) + "\n")
+	}
+	return c.w.Flush()
+}
+
+// Read reads the input watchDisconnect read ahead, then the connection.
+func (c *client) Read(p []byte) (int, error) {
+	if len(c.ahead) > 0 {
+		n := copy(p, c.ahead)
+		c.ahead = c.ahead[n:]
+		return n, nil
+	}
+	return c.conn.Read(p)
+}
+
 func handleConn(conn net.Conn) {
+	c := &client{conn: conn, w: bufio.NewWriter(conn)}
+	c.r = bufio.NewReader(c)
 	defer conn.Close()
-	r := bufio.NewReader(conn)
-	w := bufio.NewWriter(conn)
+	defer func() {
+		if c.sub != nil {
+			c.sub.Close()
+		}
+	}()
 	for {
-		line, err := r.ReadString('\n')
+		line, err := c.r.ReadString('\n')
 		if err != nil {
 			return
 		}
 
-		tokens := parseInput(line)
+		tokens, err := parseInput(line)
+		if err != nil {
+			c.send("ERR " + err.Error())
+			continue
+		}
 		if len(tokens) == 0 {
 			continue
 		}
@@ -45,23 +97,97 @@ func handleConn(conn net.Conn) {
 		args := tokens[1:]
 
 		if cmd == "EXIT" {
-			w.WriteString("BYE\n")
-			w.Flush()
+			c.send("BYE")
 			return
 		}
 
-		resp := processCommand(cmd, args)
-		w.WriteString(resp + "\n")
-		w.Flush()
+		if lines, ok := c.processSubscription(cmd, args); ok {
+			c.send(lines...)
+			continue
+		}
+		if c.sub != nil && c.sub.Count() > 0 && cmd != "PING" {
+			c.send(fmt.Sprintf("ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / EXIT are allowed in this context", strings.ToLower(cmd)))
+			continue
+		}
+
+		if handler, ok := db.BlockingCommands[cmd]; ok {
+			c.processBlockingCommand(handler, args)
+			continue
+		}
+		c.send(processCommand(cmd, args))
 	}
 }
 
-func parseInput(line string) []string {
-	line = strings.TrimSpace(line)
-	if line == "" {
-		return nil
+// processSubscription handles the SUBSCRIBE family, which changes the state
+// of the connection rather than the database. Each (un)subscription gets its
+// own reply line, "kind,name,count". ok is false for other commands.
+func (c *client) processSubscription(cmd string, args []string) (lines []string, ok bool) {
+	var names []string
+	var counts []int
+	switch cmd {
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		if len(args) < 1 {
+			return []string{"ERR missing argument for " + cmd}, true
+		}
+		if c.sub == nil {
+			c.sub = pubsub.NewSubscriber(SubscriberOutputLimit)
+			go c.deliver(c.sub)
+		}
+		names = args
+		if cmd == "SUBSCRIBE" {
+			counts = c.sub.Subscribe(args...)
+		} else {
+			counts = c.sub.PSubscribe(args...)
+		}
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		if c.sub != nil && cmd == "UNSUBSCRIBE" {
+			names, counts = c.sub.Unsubscribe(args...)
+		} else if c.sub != nil {
+			names, counts = c.sub.PUnsubscribe(args...)
+		}
+		if len(names) == 0 {
+			// Nothing to remove still gets a reply.
+			n := 0
+			if c.sub != nil {
+				n = c.sub.Count()
+			}
+			return []string{strings.ToLower(cmd) + ",," + strconv.Itoa(n)}, true
+		}
+	default:
+		return nil, false
+	}
+	for i, name := range names {
+		lines = append(lines, strings.ToLower(cmd)+","+name+","+strconv.Itoa(counts[i]))
 	}
-	return strings.Fields(line)
+	return lines, true
+}
+
+// deliver pushes messages for sub to the client as "message,channel,payload"
+// or "pmessage,pattern,channel,payload" lines until sub is closed. A client
+// dropped for falling too far behind is disconnected, which also unblocks a
+// write stuck on a client that stopped reading.
+func (c *client) deliver(sub *pubsub.Subscriber) {
+	go func() {
+		<-sub.Done()
+		if sub.Dropped() {
+			c.conn.Close()
+		}
+	}()
+	for {
+		m, ok := sub.Next()
+		if !ok {
+			return
+		}
+		if m.Pattern != "" {
+			c.send("pmessage," + m.Pattern + "," + m.Channel + "," + m.Payload)
+		} else {
+			c.send("message," + m.Channel + "," + m.Payload)
+		}
+	}
+}
+
+func parseInput(line string) ([]string, error) {
+	return protocol.Split(strings.TrimSpace(line))
 }
 
 func processCommand(cmd string, args []string) string {
@@ -69,14 +195,83 @@ func processCommand(cmd string, args []string) string {
 		return "PONG"
 	}
 
-	handler, ok := db.Commands[cmd]
-	if !ok {
-		return "ERR unknown command"
+	result, err := db.Exec(cmd, args)
+	if err != nil {
+		return errorReply(err)
 	}
+	return result
+}
+
+// errorReply formats a command error. Errors that carry their own code, such
+// as WRONGTYPE, are sent as is; everything else gets the generic ERR prefix.
+func errorReply(err error) string {
+	if errors.Is(err, db.ErrWrongType) {
+		return err.Error()
+	}
+	return "ERR " + err.Error()
+}
 
-	result, err := handler(args)
+// processBlockingCommand runs a command that may park the connection, such as
+// BLPOP, and sends its reply. The command is cancelled if the client
+// disconnects while it waits, and what it served is put back if the reply
+// cannot be written.
+func (c *client) processBlockingCommand(handler db.BlockingHandlerFunc, args []string) {
+	ctx, stop := c.watchDisconnect()
+	ctx, undo := db.WithUndo(ctx)
+	result, err := handler(ctx, args)
+	stop()
 	if err != nil {
-		return "ERR " + err.Error()
+		c.send(errorReply(err))
+		return
+	}
+	if c.send(result) != nil {
+		undo()
+	}
+}
+
+// maxReadAhead is how much input a client may pipeline behind a blocked
+// command before it is disconnected.
+const maxReadAhead = 1 << 20
+
+// watchDisconnect returns a context that is cancelled if the peer closes the
+// connection. Input that arrives meanwhile, such as pipelined commands, is
+// read ahead so that the connection stays watched, and handed back to c.r by
+// stop, which must be called before reading c.r again.
+func (c *client) watchDisconnect() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var ahead []byte
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 4096)
+		for {
+			n, err := c.r.Read(buf)
+			ahead = append(ahead, buf[:n]...)
+			if err != nil {
+				var ne net.Error
+				if !errors.As(err, &ne) || !ne.Timeout() {
+					cancel()
+				}
+				return
+			}
+			if len(ahead) > maxReadAhead {
+				cancel()
+				c.conn.Close()
+				return
+			}
+		}
+	}()
+	return ctx, func() {
+		// Unblock the pending read, then restore blocking reads.
+		c.conn.SetReadDeadline(time.Now())
+		<-done
+		c.conn.SetReadDeadline(time.Time{})
+		cancel()
+		// Whatever r still buffers, and what it has not taken from c.ahead
+		// yet, came after what was read ahead.
+		buffered, _ := c.r.Peek(c.r.Buffered())
+		ahead = append(append(ahead, buffered...), c.ahead...)
+		c.ahead = ahead
+		c.r.Reset(c)
 	}
-	return result
 }